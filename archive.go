@@ -0,0 +1,147 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// archiveStore lists outputs added to zip archives uncompressed; deflating already compressed
+// media costs time and saves next to nothing
+var archiveStore = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".heic": true, ".webp": true, ".gif": true,
+	".mp4": true, ".mov": true, ".m4v": true, ".webm": true, ".mkv": true,
+}
+
+// archiveWriter is the output backend of -archive: it streams finished outputs into a zip or
+// tar file. Directories are stored by whichever worker finishes them, so writes are serialized.
+type archiveWriter struct {
+	mu   sync.Mutex
+	file *os.File // Temp file next to -archive, renamed over it once complete
+	zip  *zip.Writer
+	tar  *tar.Writer
+}
+
+// setupArchive checks -archive and stages outputs in a local temp directory. Workers write
+// there as usual; finished directories are moved into the archive, so at most the directories
+// in flight exist as loose files.
+func setupArchive() error {
+	switch strings.ToLower(filepath.Ext(config.Archive)) {
+	case ".zip", ".tar":
+	default:
+		return fmt.Errorf("--archive must be a .zip or .tar file")
+	}
+	if config.OutputDir != "" {
+		return fmt.Errorf("--archive replaces --out; give only one of them")
+	}
+	if err := checkStagedOutput("--archive"); err != nil {
+		return err
+	}
+
+	staging, err := os.MkdirTemp(config.TmpDir, "batchMedia-archive-*")
+	if err != nil {
+		return fmt.Errorf("failed to create archive staging directory: %v", err)
+	}
+	config.OutputDir = staging
+	stagingDir = staging
+	return nil
+}
+
+// openOutputArchive starts writing -archive. The archive is built in a temp file beside it and
+// only replaces an existing archive once every output and report is in.
+func openOutputArchive() error {
+	file, err := os.CreateTemp(filepath.Dir(config.Archive), "."+filepath.Base(config.Archive)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %v", err)
+	}
+	archive := &archiveWriter{file: file}
+	if strings.ToLower(filepath.Ext(config.Archive)) == ".zip" {
+		archive.zip = zip.NewWriter(file)
+	} else {
+		archive.tar = tar.NewWriter(file)
+	}
+	outputStore = archive
+	return nil
+}
+
+// Write appends one output to the archive. Its errors lose their cause so they are never
+// retried: a half-written entry can't be taken back.
+func (a *archiveWriter) Write(name string, r io.Reader, info os.FileInfo) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.writeEntry(name, r, info); err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	return nil
+}
+
+func (a *archiveWriter) writeEntry(name string, r io.Reader, info os.FileInfo) error {
+	var dst io.Writer
+	if a.zip != nil {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		header.Method = zip.Deflate
+		if archiveStore[strings.ToLower(filepath.Ext(name))] {
+			header.Method = zip.Store
+		}
+		if dst, err = a.zip.CreateHeader(header); err != nil {
+			return err
+		}
+	} else {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if err := a.tar.WriteHeader(header); err != nil {
+			return err
+		}
+		dst = a.tar
+	}
+	_, err := io.Copy(dst, r)
+	return err
+}
+
+// Exists is always false: every run writes a complete new archive
+func (a *archiveWriter) Exists(name string) (bool, error) {
+	return false, nil
+}
+
+// finish writes the archive's index and moves it to -archive, or drops it when outputs are
+// missing so an earlier complete archive isn't replaced
+func (a *archiveWriter) finish(complete bool) error {
+	var err error
+	if a.zip != nil {
+		err = a.zip.Close()
+	} else {
+		err = a.tar.Close()
+	}
+	if err == nil {
+		// CreateTemp makes the file private; archives are shared like any other output
+		err = a.file.Chmod(0644)
+	}
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && complete {
+		err = os.Rename(a.file.Name(), config.Archive)
+	}
+	if err != nil || !complete {
+		os.Remove(a.file.Name())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write archive %s: %v", config.Archive, err)
+	}
+	if complete {
+		logInfo("Archive written: %s", config.Archive)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import "batchMedia/pkg/media"
+
+// outputWriter is the media.Writer every output goes through: into -tmp-dir when set, with
+// transient write failures retried
+func outputWriter() media.Writer {
+	return media.Writer{TmpDir: config.TmpDir, WriteFile: writeFileWithRetry}
+}
+
+// tempOutputPath returns where an output is written before being renamed into place
+func tempOutputPath(outputPath string) string {
+	return outputWriter().TempPath(outputPath)
+}
+
+// commitTempOutput moves a finished temp file to its final path, so outputs are always complete
+// or absent
+func commitTempOutput(tmpPath, outputPath string) error {
+	return outputWriter().Commit(tmpPath, outputPath)
+}
+
+// writeFileAtomic writes data to a temp file and renames it to path once complete
+func writeFileAtomic(path string, data []byte) error {
+	return outputWriter().Write(path, data)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputBackend stores outputs under slash-separated names relative to the output root. The
+// local backend writes the output directory itself; the others (-archive, -out s3://...) get
+// their files from a local staging directory as each input directory finishes. info gives the
+// size and the modification time to keep.
+type outputBackend interface {
+	Write(name string, r io.Reader, info os.FileInfo) error
+	Exists(name string) (bool, error)
+}
+
+// outputFinisher is implemented by backends that must be completed once every output is in;
+// complete is false when some outputs couldn't be stored
+type outputFinisher interface {
+	finish(complete bool) error
+}
+
+// outputStore is where outputs end up
+var outputStore outputBackend = localBackend{}
+
+// stagingDir is the local directory workers write into when outputStore isn't the output
+// directory itself; empty for loose local outputs
+var stagingDir string
+
+// keepStagingDir leaves the staging directory behind after the run, e.g. because it holds the
+// progress file the next run resumes from
+var keepStagingDir bool
+
+// localBackend is the output directory (-out) on the local filesystem
+type localBackend struct{}
+
+func (localBackend) Write(name string, r io.Reader, info os.FileInfo) error {
+	path := filepath.Join(config.OutputDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := tempOutputPath(path)
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := commitTempOutput(tmpPath, path); err != nil {
+		return err
+	}
+	return preserveFileTimes(path, info)
+}
+
+func (localBackend) Exists(name string) (bool, error) {
+	_, err := os.Stat(filepath.Join(config.OutputDir, filepath.FromSlash(name)))
+	return err == nil, nil
+}
+
+// outputExists reports whether the output at path (inside the output directory) is already in
+// the output store, so reruns can skip it
+func outputExists(path string) bool {
+	rel, err := filepath.Rel(config.OutputDir, path)
+	if err != nil {
+		return false
+	}
+	exists, err := outputStore.Exists(filepath.ToSlash(rel))
+	if err != nil {
+		logWarn("failed to check for existing output %s: %v", rel, err)
+		return false
+	}
+	return exists
+}
+
+// storeDirectory moves the staged outputs of one finished input directory to the output store.
+// Its subdirectories belong to other directories, and its HTML report may still be rewritten,
+// so both are left for closeOutputStore.
+func storeDirectory(inputDir string) {
+	if stagingDir == "" {
+		return
+	}
+	relDir, err := filepath.Rel(config.InputDir, inputDir)
+	if err != nil {
+		return
+	}
+	if err := storeStaged(filepath.Join(stagingDir, relDir), false); err != nil {
+		logWarn("failed to store outputs of %s, retrying at the end: %v", inputDir, err)
+	}
+}
+
+// closeOutputStore moves the remaining staged outputs and reports to the output store and
+// finishes it (e.g. writes the archive's index)
+func closeOutputStore() error {
+	if stagingDir == "" {
+		return nil
+	}
+	err := storeStaged(stagingDir, true)
+	if finisher, ok := outputStore.(outputFinisher); ok {
+		if finishErr := finisher.finish(err == nil); err == nil {
+			err = finishErr
+		}
+	}
+	if err != nil {
+		// Whatever wasn't stored is still in the staging directory
+		return fmt.Errorf("%v (unstored outputs are left in %s)", err, stagingDir)
+	}
+	if !keepStagingDir {
+		os.RemoveAll(stagingDir)
+	}
+	stagingDir = ""
+	return nil
+}
+
+// storeStaged writes the files of a staging directory to the output store under their path
+// relative to the staging root and deletes them; with recursive, subdirectories and HTML
+// reports are included too. The run lock and progress files are run state, not outputs.
+func storeStaged(dir string, recursive bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if recursive {
+				if err := storeStaged(path, true); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		name := entry.Name()
+		if !entry.Type().IsRegular() || name == runLockName || (dir == stagingDir && isProgressFileName(name)) ||
+			(!recursive && (name == "processing_report.html" || (dir == stagingDir && name == manifestName))) {
+			continue
+		}
+		if err := storeStagedFile(path); err != nil {
+			return err
+		}
+		os.Remove(path)
+	}
+	if recursive && dir != stagingDir {
+		// Fails harmlessly while something is left in it
+		os.Remove(dir)
+	}
+	return nil
+}
+
+// storeStagedFile writes one staged file to the output store
+func storeStagedFile(path string) error {
+	rel, err := filepath.Rel(stagingDir, path)
+	if err != nil {
+		return err
+	}
+	name := filepath.ToSlash(rel)
+	return withRetry("storing "+name, func() error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if err := outputStore.Write(name, file, info); err != nil {
+			return fmt.Errorf("failed to store %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// checkStagedOutput rejects options that need the outputs as loose local files
+func checkStagedOutput(option string) error {
+	switch {
+	case config.OutputSameAsInput:
+		return fmt.Errorf("%s cannot be combined with --in-place", option)
+	case config.Dedupe:
+		return fmt.Errorf("%s cannot be combined with --dedupe, whose hard links need loose files", option)
+	case config.FakeScan:
+		return fmt.Errorf("%s cannot be combined with --fake-scan, which writes no outputs", option)
+	}
+	if fi, err := os.Stat(config.InputDir); err == nil && fi.Mode().IsRegular() {
+		return fmt.Errorf("%s needs an input directory, not a single file", option)
+	}
+	return nil
+}
+
+// isProgressFileName reports whether name is progress.json or an -ext specific progress file
+func isProgressFileName(name string) bool {
+	return name == "progress.json" || (strings.HasPrefix(name, "progress_") && strings.HasSuffix(name, ".json"))
+}
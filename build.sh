@@ -10,9 +10,10 @@ mkdir -p bin
 
 echo "Building batchMedia for current platform..."
 
-# Build for current platform (with HEIF support)
+# Build for current platform (with HEIF support); BUILD_TAGS=libjpeg adds the progressive
+# JPEG encoder, which needs libjpeg (e.g. libjpeg-turbo) development files
 echo "Building with HEIF/HEIC support..."
-go build -o bin/batchMedia
+go build ${BUILD_TAGS:+-tags "$BUILD_TAGS"} -o bin/batchMedia
 
 echo "Build completed successfully!"
 echo ""
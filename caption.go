@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"batchMedia/pkg/media"
+	"github.com/rwcarlsen/goexif/exif"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// captionColor is the parsed -caption-color
+var captionColor = color.RGBA{255, 255, 255, 255}
+
+// captionDateLayout formats -caption date stamps
+const captionDateLayout = "2006-01-02 15:04:05"
+
+// captionFont is the bundled Go Regular font, parsed on first use
+var (
+	captionFontOnce sync.Once
+	captionFont     *opentype.Font
+	captionFontErr  error
+)
+
+// captionEnabled reports whether -caption burns text into outputs
+func captionEnabled() bool {
+	return config.Caption != "none"
+}
+
+// captionText returns the -caption text for an image: the fixed -caption-text, the file name,
+// or the EXIF capture time, falling back to the modification time (moved by -shift-time like
+// the EXIF dates) when there is none
+func captionText(info os.FileInfo, exifData []byte) string {
+	switch config.Caption {
+	case "text":
+		return config.CaptionText
+	case "filename":
+		return info.Name()
+	}
+	if taken, ok := exifDateTime(exifData); ok {
+		return taken.Format(captionDateLayout)
+	}
+	return info.ModTime().Add(timeShift).Format(captionDateLayout)
+}
+
+// exifDateTime reads the capture time from an APP1 segment or HEIC Exif item
+func exifDateTime(exifData []byte) (time.Time, bool) {
+	start := bytes.Index(exifData, []byte("Exif\x00\x00"))
+	if start < 0 {
+		return time.Time{}, false
+	}
+	x, err := exif.Decode(bytes.NewReader(exifData[start+6:]))
+	if err != nil {
+		return time.Time{}, false
+	}
+	taken, err := x.DateTime()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return taken, true
+}
+
+// captionFontSize returns -caption-size, or about 3% of the output height when it is 0
+func captionFontSize(height int) int {
+	if config.CaptionSize > 0 {
+		return config.CaptionSize
+	}
+	return max(height*3/100, 12)
+}
+
+// applyCaption draws text in the -caption-position corner on a translucent box. It runs after
+// resizing so the text keeps its size whatever the scale; a font failure leaves img unchanged.
+func applyCaption(img image.Image, text string) image.Image {
+	if text == "" {
+		return img
+	}
+	captionFontOnce.Do(func() {
+		captionFont, captionFontErr = opentype.Parse(goregular.TTF)
+	})
+	if captionFontErr != nil {
+		logWarn("caption font unavailable: %v", captionFontErr)
+		return img
+	}
+
+	bounds := img.Bounds()
+	size := captionFontSize(bounds.Dy())
+	// Faces cache glyphs and aren't safe for concurrent use, so each image gets its own
+	face, err := opentype.NewFace(captionFont, &opentype.FaceOptions{Size: float64(size), DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		logWarn("caption font unavailable: %v", err)
+		return img
+	}
+	defer face.Close()
+
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	metrics := face.Metrics()
+	textWidth := font.MeasureString(face, text).Ceil()
+	textHeight := (metrics.Ascent + metrics.Descent).Ceil()
+	padding, margin := size/4, size/2
+	boxWidth, boxHeight := textWidth+2*padding, textHeight+2*padding
+
+	x, y := margin, margin
+	if strings.HasSuffix(config.CaptionPosition, "right") {
+		x = bounds.Dx() - margin - boxWidth
+	}
+	if strings.HasPrefix(config.CaptionPosition, "bottom") {
+		y = bounds.Dy() - margin - boxHeight
+	}
+	box := image.Rect(x, y, x+boxWidth, y+boxHeight)
+	draw.Draw(dst, box, &image.Uniform{color.RGBA{0, 0, 0, 128}}, image.Point{}, draw.Over)
+
+	drawer := font.Drawer{
+		Dst:  dst,
+		Src:  &image.Uniform{captionColor},
+		Face: face,
+		Dot:  fixed.P(x+padding, y+padding+metrics.Ascent.Ceil()),
+	}
+	drawer.DrawString(text)
+	return dst
+}
+
+// videoCaptionText returns the -caption text for a video; the date comes from the container's
+// creation_time tag, falling back to the modification time
+func videoCaptionText(info os.FileInfo, probe *media.Probe) string {
+	if config.Caption != "date" {
+		return captionText(info, nil)
+	}
+	if probe != nil {
+		if created, err := time.Parse(time.RFC3339Nano, probe.Format.Tags["creation_time"]); err == nil {
+			return created.Local().Format(captionDateLayout)
+		}
+	}
+	return info.ModTime().Format(captionDateLayout)
+}
+
+// writeCaptionFont writes the bundled font to a temp file for ffmpeg's drawtext filter; the
+// caller removes it once ffmpeg is done
+func writeCaptionFont() (string, error) {
+	file, err := os.CreateTemp("", "batchMedia-caption-*.ttf")
+	if err != nil {
+		return "", fmt.Errorf("failed to write caption font: %v", err)
+	}
+	_, err = file.Write(goregular.TTF)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write caption font: %v", err)
+	}
+	return file.Name(), nil
+}
+
+// drawtextKwargs builds ffmpeg drawtext options matching applyCaption for a video of the given
+// output height
+func drawtextKwargs(text, fontPath string, height int) ffmpeg.KwArgs {
+	size := captionFontSize(height)
+	// drawtext grows the box outward from the text, so the margin includes the padding
+	padding := size / 4
+	margin := size/2 + padding
+	x, y := fmt.Sprintf("%d", margin), fmt.Sprintf("%d", margin)
+	if strings.HasSuffix(config.CaptionPosition, "right") {
+		x = fmt.Sprintf("w-tw-%d", margin)
+	}
+	if strings.HasPrefix(config.CaptionPosition, "bottom") {
+		y = fmt.Sprintf("h-th-%d", margin)
+	}
+	return ffmpeg.KwArgs{
+		"text":       drawtextEscape(text),
+		"fontfile":   drawtextEscape(fontPath),
+		"expansion":  "none",
+		"fontsize":   fmt.Sprintf("%d", size),
+		"fontcolor":  fmt.Sprintf("0x%02X%02X%02X", captionColor.R, captionColor.G, captionColor.B),
+		"box":        "1",
+		"boxcolor":   "black@0.5",
+		"boxborderw": fmt.Sprintf("%d", padding),
+		"x":          x,
+		"y":          y,
+	}
+}
+
+// drawtextEscape escapes an option value for ffmpeg's filter option parser; ffmpeg-go adds the
+// filtergraph-level escaping on top
+var drawtextEscape = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace
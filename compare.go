@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// comparedFile pairs a file's results in the previous and the current report; Old or New is
+// nil for files only one run has
+type comparedFile struct {
+	Path string
+	Old  *FileInfo
+	New  *FileInfo
+}
+
+// sizeChange is the relative change of the output size, 0 when there was no earlier output
+func (c comparedFile) sizeChange() float64 {
+	if c.Old == nil || c.New == nil || c.Old.OutputSize == 0 {
+		return 0
+	}
+	return float64(c.New.OutputSize-c.Old.OutputSize) / float64(c.Old.OutputSize)
+}
+
+// loadJSONReport reads a report.json written by -json-report; a directory stands for its report.json
+func loadJSONReport(path string) (jsonReport, error) {
+	var report jsonReport
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, "report.json")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return report, fmt.Errorf("failed to read report: %v (reports are written by --json-report)", err)
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return report, fmt.Errorf("failed to parse report %s: %v", path, err)
+	}
+	return report, nil
+}
+
+// runCompare diffs a previous run's report.json (-compare) against the one in -out and writes
+// compare_report.html next to it. Nothing is processed; only the two reports are read.
+func runCompare() error {
+	if config.OutputDir == "" {
+		return fmt.Errorf("--compare needs --out, the output directory whose report.json is compared")
+	}
+	previous, err := loadJSONReport(config.Compare)
+	if err != nil {
+		return err
+	}
+	current, err := loadJSONReport(config.OutputDir)
+	if err != nil {
+		return err
+	}
+
+	reportPath := filepath.Join(config.OutputDir, "compare_report.html")
+	if err := os.WriteFile(reportPath, []byte(compareReportHTML(previous, current)), 0644); err != nil {
+		return fmt.Errorf("failed to write comparison report: %v", err)
+	}
+	logInfo("Comparison report written: %s", reportPath)
+	return nil
+}
+
+// compareFiles matches the files of two reports by input path. Files in both come first, the
+// largest relative size change leading, then the new and the removed files by path.
+func compareFiles(previous, current []FileInfo) []comparedFile {
+	byPath := make(map[string]*comparedFile)
+	var files []*comparedFile
+	for i := range previous {
+		file := &comparedFile{Path: previous[i].Path, Old: &previous[i]}
+		byPath[file.Path] = file
+		files = append(files, file)
+	}
+	for i := range current {
+		if file, ok := byPath[current[i].Path]; ok {
+			file.New = &current[i]
+			continue
+		}
+		files = append(files, &comparedFile{Path: current[i].Path, New: &current[i]})
+	}
+
+	rank := func(c *comparedFile) int {
+		switch {
+		case c.Old != nil && c.New != nil:
+			return 0
+		case c.New != nil:
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if rank(files[i]) != rank(files[j]) {
+			return rank(files[i]) < rank(files[j])
+		}
+		if ci, cj := math.Abs(files[i].sizeChange()), math.Abs(files[j].sizeChange()); ci != cj {
+			return ci > cj
+		}
+		return files[i].Path < files[j].Path
+	})
+
+	compared := make([]comparedFile, len(files))
+	for i, file := range files {
+		compared[i] = *file
+	}
+	return compared
+}
+
+// reportSavings returns the input and output bytes of a report and the share of space saved
+func reportSavings(files []FileInfo) (int64, int64, float64) {
+	var input, output int64
+	for _, file := range files {
+		input += file.InputSize
+		output += file.OutputSize
+	}
+	if input == 0 {
+		return input, output, 0
+	}
+	return input, output, (1 - float64(output)/float64(input)) * 100
+}
+
+// formatSizeChange formats a byte delta with its relative change, e.g. "-12.3 KB (-8.1%)"
+func formatSizeChange(from, to int64) string {
+	delta := fmt.Sprintf("%+.1f KB", float64(to-from)/1024)
+	if from == 0 {
+		return delta
+	}
+	return fmt.Sprintf("%s (%+.1f%%)", delta, float64(to-from)/float64(from)*100)
+}
+
+// describeChange formats an attribute of both runs as "old → new", or once if unchanged
+func describeChange(old, new string) string {
+	if old == new {
+		return old
+	}
+	if old == "" {
+		old = "-"
+	}
+	if new == "" {
+		new = "-"
+	}
+	return old + " → " + new
+}
+
+// comparedQuality formats -quality-analysis results, empty when the run didn't measure them
+func comparedQuality(file *FileInfo) string {
+	if file.SSIM == 0 {
+		return ""
+	}
+	return describeQuality(*file)
+}
+
+// compareReportHTML renders the comparison of two runs
+func compareReportHTML(previous, current jsonReport) string {
+	files := compareFiles(previous.Files, current.Files)
+	var common, changed, added, removed int
+	var oldCommon, newCommon int64
+	for _, file := range files {
+		switch {
+		case file.Old != nil && file.New != nil:
+			common++
+			oldCommon += file.Old.OutputSize
+			newCommon += file.New.OutputSize
+			if file.Old.OutputSize != file.New.OutputSize {
+				changed++
+			}
+		case file.New != nil:
+			added++
+		default:
+			removed++
+		}
+	}
+	_, _, oldSaved := reportSavings(previous.Files)
+	_, _, newSaved := reportSavings(current.Files)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Batch Media Comparison Report</title>
+    <style>
+        body { font-family: Arial, sans-serif; margin: 20px; background-color: #f5f5f5; }
+        .container { max-width: 1400px; margin: 0 auto; background: white; padding: 20px; border-radius: 8px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); }
+        h1 { color: #333; text-align: center; }
+        h2 { color: #333; margin-top: 30px; }
+        .summary { display: grid; grid-template-columns: repeat(auto-fit, minmax(200px, 1fr)); gap: 15px; margin: 20px 0; }
+        .stat-card { background: #f8f9fa; padding: 15px; border-radius: 5px; text-align: center; }
+        .stat-number { font-size: 24px; font-weight: bold; color: #007bff; }
+        .stat-label { color: #666; margin-top: 5px; }
+        table { width: 100%%; border-collapse: collapse; font-size: 14px; color: #333; }
+        th, td { text-align: left; padding: 6px 8px; border-bottom: 1px solid #eee; }
+        th { background: #f8f9fa; }
+        .smaller { color: #28a745; font-weight: bold; }
+        .larger { color: #dc3545; font-weight: bold; }
+        .settings { font-size: 14px; color: #666; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>Batch Media Comparison Report</h1>
+        <div class="summary">
+            <div class="stat-card">
+                <div class="stat-number">%d</div>
+                <div class="stat-label">Files in Both Runs</div>
+            </div>
+            <div class="stat-card">
+                <div class="stat-number">%d</div>
+                <div class="stat-label">Changed Size</div>
+            </div>
+            <div class="stat-card">
+                <div class="stat-number">%d</div>
+                <div class="stat-label">New Files</div>
+            </div>
+            <div class="stat-card">
+                <div class="stat-number">%d</div>
+                <div class="stat-label">Removed Files</div>
+            </div>
+            <div class="stat-card">
+                <div class="stat-number">%s</div>
+                <div class="stat-label">Output Change (Files in Both)</div>
+            </div>
+            <div class="stat-card">
+                <div class="stat-number">%.1f%% → %.1f%%</div>
+                <div class="stat-label">Space Saved</div>
+            </div>
+        </div>
+        <h2>Settings</h2>
+        <div class="settings">Scaling: %s</div>
+        <h2>Files in Both Runs</h2>
+        <table>
+            <tr><th>File</th><th>Previous</th><th>Current</th><th>Change</th><th>Dimensions</th><th>Encoding</th><th>Quality</th></tr>`,
+		common, changed, added, removed,
+		html.EscapeString(formatSizeChange(oldCommon, newCommon)),
+		oldSaved, newSaved,
+		html.EscapeString(describeChange(previous.Settings.Scaling, current.Settings.Scaling)))
+
+	for _, file := range files {
+		if file.Old == nil || file.New == nil {
+			continue
+		}
+		class := ""
+		if file.New.OutputSize < file.Old.OutputSize {
+			class = "smaller"
+		} else if file.New.OutputSize > file.Old.OutputSize {
+			class = "larger"
+		}
+		fmt.Fprintf(&b, `
+            <tr><td>%s</td><td>%.1f KB</td><td>%.1f KB</td><td class="%s">%s</td><td>%s</td><td>%s</td><td>%s</td></tr>`,
+			html.EscapeString(file.Path),
+			float64(file.Old.OutputSize)/1024, float64(file.New.OutputSize)/1024,
+			class, html.EscapeString(formatSizeChange(file.Old.OutputSize, file.New.OutputSize)),
+			html.EscapeString(describeChange(file.Old.NewDim, file.New.NewDim)),
+			html.EscapeString(describeChange(file.Old.Encoding, file.New.Encoding)),
+			html.EscapeString(describeChange(comparedQuality(file.Old), comparedQuality(file.New))))
+	}
+	b.WriteString(`
+        </table>`)
+
+	for _, section := range []struct {
+		title   string
+		current bool
+	}{{"New Files", true}, {"Removed Files", false}} {
+		var rows strings.Builder
+		for _, file := range files {
+			result := file.New
+			if !section.current {
+				result = file.Old
+			}
+			if result == nil || (file.Old != nil && file.New != nil) {
+				continue
+			}
+			fmt.Fprintf(&rows, `
+            <tr><td>%s</td><td>%s</td><td>%.1f KB</td></tr>`,
+				html.EscapeString(file.Path), html.EscapeString(result.Type), float64(result.OutputSize)/1024)
+		}
+		if rows.Len() == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, `
+        <h2>%s</h2>
+        <table>
+            <tr><th>File</th><th>Type</th><th>Output</th></tr>%s
+        </table>`, section.title, rows.String())
+	}
+	b.WriteString(`
+    </div>
+</body>
+</html>
+`)
+	return b.String()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// loadConfigFile fills config from a JSON or YAML file keyed by Config field names
+// (e.g. "ScalingRatio", "KeepFormat"). Flags given on the command line override file values.
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	// Remember the flags that were set explicitly so they can be re-applied over the file;
+	// values filled in by -preset are defaults the file may override
+	explicit := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if !presetApplied[f.Name] {
+			explicit[f.Name] = f.Value.String()
+		}
+	})
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		data, err = yamlToJSON(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse config file %s: %v", path, err)
+		}
+	}
+
+	// Sizing in the file replaces the preset's, since --size, --width/--height and --sizes are exclusive
+	var keys map[string]json.RawMessage
+	if json.Unmarshal(data, &keys) == nil {
+		for _, key := range []string{"ScalingRatio", "Width", "Height", "Sizes"} {
+			if _, ok := keys[key]; ok {
+				clearPresetSizing()
+				break
+			}
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	for name, value := range explicit {
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("failed to apply -%s over config file: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// yamlToJSON converts a flat YAML mapping ("Key: value" lines, # comments) to JSON,
+// typing each value by its Config field. Nested YAML is not supported since Config is flat.
+func yamlToJSON(data []byte) ([]byte, error) {
+	configType := reflect.TypeOf(Config{})
+	values := make(map[string]interface{})
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+
+		key, raw, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected \"Key: value\"", lineNumber)
+		}
+		key = strings.TrimSpace(key)
+		raw = strings.TrimSpace(raw)
+
+		field, ok := configType.FieldByName(key)
+		if !ok || field.Tag.Get("json") == "-" {
+			return nil, fmt.Errorf("line %d: unknown field %q", lineNumber, key)
+		}
+
+		var value string
+		if len(raw) >= 2 && (raw[0] == '"' || raw[0] == '\'') && raw[len(raw)-1] == raw[0] {
+			value = raw[1 : len(raw)-1]
+		} else {
+			// Unquoted values may carry a trailing comment
+			if i := strings.Index(raw, " #"); i >= 0 {
+				raw = strings.TrimSpace(raw[:i])
+			}
+			value = raw
+		}
+
+		var err error
+		switch field.Type.Kind() {
+		case reflect.String:
+			values[key] = value
+		case reflect.Bool:
+			values[key], err = strconv.ParseBool(value)
+		case reflect.Int:
+			values[key], err = strconv.Atoi(value)
+		case reflect.Float64:
+			values[key], err = strconv.ParseFloat(value, 64)
+		default:
+			err = fmt.Errorf("unsupported field type %s", field.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value for %s: %v", lineNumber, key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(values)
+}
+
+// logEffectiveConfig logs the merged configuration at debug level
+func logEffectiveConfig() {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return
+	}
+	logDebug("Effective configuration:\n%s", data)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// reportFiles accumulates per-file results across directories for -csv-report and
+// -json-report, since stats is reset after each directory's HTML report
+var reportFiles []FileInfo
+
+// collectReportFiles saves the current stats.Files before stats is reset, caller must hold statsMutex
+func collectReportFiles() {
+	if config.CSVReport || config.JSONReport {
+		reportFiles = append(reportFiles, stats.Files...)
+	}
+}
+
+// writeCSVReport writes one row per file to OutputDir/report.csv
+func writeCSVReport(files []FileInfo) error {
+	reportPath := filepath.Join(config.OutputDir, "report.csv")
+	file, err := os.Create(reportPath)
+	if err != nil {
+		return fmt.Errorf("failed to create CSV report: %v", err)
+	}
+	defer file.Close()
+
+	// encoding/csv quotes fields containing commas, quotes or newlines
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"path", "type", "input_size", "output_size", "original_dim", "new_dim", "compression_ratio"})
+	for _, f := range files {
+		writer.Write([]string{
+			f.Path,
+			f.Type,
+			fmt.Sprintf("%d", f.InputSize),
+			fmt.Sprintf("%d", f.OutputSize),
+			f.OriginalDim,
+			f.NewDim,
+			fmt.Sprintf("%.4f", f.CompressionRatio),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV report: %v", err)
+	}
+	logInfo("CSV report written: %s", reportPath)
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// dedupeEntry tracks the first file seen with a given content hash. Later files with the
+// same hash wait for done and then link to its outputs instead of being processed again.
+type dedupeEntry struct {
+	done       chan struct{}
+	once       sync.Once
+	source     string   // Input path of the first file, relative to the input directory
+	outputPath string   // Output path the first file was given before processing
+	outputs    []string // Files it actually produced; empty if processing failed
+	outputSize int64
+}
+
+// dedupeEntries maps sha256 hex digests to the first file with that content, across all workers
+var dedupeEntries = make(map[string]*dedupeEntry)
+var dedupeMutex sync.Mutex
+
+// Run-wide duplicate totals, kept outside stats because stats is reset per directory
+var dedupeCount int64
+var dedupeBytesSaved int64
+
+// claimDedupe hashes an input file and looks it up. It returns the entry and whether the
+// caller is the first with this content; first callers must call finish when done.
+func claimDedupe(path, relPath, outputPath string) (*dedupeEntry, bool, error) {
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return nil, false, err
+	}
+	key := hex.EncodeToString(sum)
+
+	dedupeMutex.Lock()
+	defer dedupeMutex.Unlock()
+	if entry, exists := dedupeEntries[key]; exists {
+		return entry, false, nil
+	}
+	entry := &dedupeEntry{
+		done:       make(chan struct{}),
+		source:     relPath,
+		outputPath: outputPath,
+	}
+	dedupeEntries[key] = entry
+	return entry, true, nil
+}
+
+// finish records the outputs the first file produced and releases any waiting duplicates.
+// Only the first call has an effect, so it is also safe to defer as a fallback.
+func (e *dedupeEntry) finish(inputPath string) {
+	e.once.Do(func() {
+		if inputPath != "" {
+			// Every place processing may have written to: the planned path, an untouched copy
+			// under the input's extension, or the -sizes variants
+			candidates := []string{e.outputPath, copiedOutputPath(inputPath, e.outputPath)}
+			for _, width := range sizeWidths {
+				candidates = append(candidates, sizeVariantPath(e.outputPath, width))
+			}
+			seen := make(map[string]bool)
+			for _, candidate := range candidates {
+				if seen[candidate] {
+					continue
+				}
+				seen[candidate] = true
+				if fi, err := os.Stat(candidate); err == nil {
+					e.outputs = append(e.outputs, candidate)
+					e.outputSize += fi.Size()
+				}
+			}
+		}
+		close(e.done)
+	})
+}
+
+// linkDuplicate waits for the first file with the same content and links its outputs to the
+// duplicate's output location. It returns false when there is nothing to link to.
+func linkDuplicate(entry *dedupeEntry, outputPath string) ([]string, bool, error) {
+	<-entry.done
+	if len(entry.outputs) == 0 {
+		return nil, false, nil
+	}
+
+	// Outputs share the first file's base name; swap it for the duplicate's
+	firstBase := strings.TrimSuffix(entry.outputPath, filepath.Ext(entry.outputPath))
+	dupBase := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+	var linked []string
+	for _, target := range entry.outputs {
+		dst := dupBase + strings.TrimPrefix(target, firstBase)
+		if err := linkOutput(target, dst); err != nil {
+			return linked, true, err
+		}
+		linked = append(linked, dst)
+	}
+	return linked, true, nil
+}
+
+// linkOutput links dst to target with the -dedupe-link method, falling back to a copy
+// when the filesystem doesn't support links
+func linkOutput(target, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	os.Remove(dst)
+
+	var err error
+	if config.DedupeLink == "symlink" {
+		// Relative links keep working if the output tree is moved
+		rel, relErr := filepath.Rel(filepath.Dir(dst), target)
+		if relErr != nil {
+			rel = target
+		}
+		err = os.Symlink(rel, dst)
+	} else {
+		err = os.Link(target, dst)
+	}
+	if err == nil {
+		return nil
+	}
+
+	logWarn("failed to %s %s -> %s, copying instead: %v", config.DedupeLink, dst, target, err)
+	info, statErr := os.Stat(target)
+	if statErr != nil {
+		return fmt.Errorf("failed to stat dedupe target: %v", statErr)
+	}
+	return copyFile(target, dst, info)
+}
+
+// recordDuplicate adds a linked duplicate to the statistics and report
+func recordDuplicate(entry *dedupeEntry, linked []string, relPath string, info os.FileInfo, dirStats *DirectoryStats) {
+	atomic.AddInt64(&dedupeCount, 1)
+	atomic.AddInt64(&dedupeBytesSaved, entry.outputSize)
+
+	fileInfo := FileInfo{
+		Path:             relPath,
+		Type:             "duplicate",
+		InputSize:        info.Size(),
+		OutputSize:       entry.outputSize,
+		CompressionRatio: float64(entry.outputSize) / float64(info.Size()),
+		DuplicateOf:      entry.source,
+	}
+	// Linked responsive sets are listed like the original so the report can preview them
+	if len(sizeWidths) > 0 {
+		for _, path := range linked {
+			if rel, err := filepath.Rel(config.OutputDir, path); err == nil {
+				fileInfo.Variants = append(fileInfo.Variants, rel)
+			}
+		}
+	}
+	statsMutex.Lock()
+	stats.DuplicateFiles++
+	stats.TotalInputSize += info.Size()
+	dirStats.DuplicateFiles++
+	dirStats.TotalInputSize += info.Size()
+	stats.Files = append(stats.Files, fileInfo)
+	dirStats.Files = append(dirStats.Files, fileInfo)
+	recordManifest(fileInfo)
+	statsMutex.Unlock()
+}
+
+// logDedupeSummary reports the run-wide duplicate count and the output bytes not rewritten
+func logDedupeSummary() {
+	count := atomic.LoadInt64(&dedupeCount)
+	saved := atomic.LoadInt64(&dedupeBytesSaved)
+	logInfo("Duplicates detected: %d (%.2f MB of output linked instead of reprocessed)", count, float64(saved)/(1024*1024))
+}
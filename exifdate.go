@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"time"
+)
+
+// EXIF date tags rewritten by -shift-time; DateTime sits in IFD0, the others in the EXIF IFD
+const (
+	tagDateTime          = 0x0132
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+)
+
+// exifDateLayout is the EXIF date format, which carries no time zone
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// timeShift is the parsed -shift-time, 0 when dates are kept
+var timeShift time.Duration
+
+// shiftEXIFDates moves the DateTime, DateTimeOriginal and DateTimeDigitized tags of an EXIF
+// block (an APP1 segment or a HEIC Exif item) by -shift-time. The values are fixed-length
+// ASCII, so they are rewritten in a copy without changing the TIFF layout. EXIF dates have no
+// time zone and are shifted as plain wall-clock values: a shift across a daylight saving change
+// still moves them by exactly the offset. Blank or malformed dates, and blocks that aren't
+// valid TIFF, are left as they are.
+func shiftEXIFDates(exifData []byte) []byte {
+	if timeShift == 0 {
+		return exifData
+	}
+	start := bytes.Index(exifData, []byte("Exif\x00\x00"))
+	if start < 0 {
+		return exifData
+	}
+	result := append([]byte{}, exifData...)
+	tiff := result[start+6:]
+	order, ifd0, ok := exifTIFFHeader(tiff)
+	if !ok {
+		return exifData
+	}
+	shiftIFDDates(tiff, order, ifd0, 0)
+	return result
+}
+
+// shiftIFDDates shifts the date tags of one IFD and of the EXIF IFD it points to
+func shiftIFDDates(tiff []byte, order exifByteOrder, ifd uint32, depth int) {
+	nextPos, ok := exifNextIFDPosition(tiff, order, ifd)
+	if !ok || depth > 1 {
+		return
+	}
+	for pos := int(ifd) + 2; pos < nextPos; pos += 12 {
+		entry := tiff[pos : pos+12]
+		switch order.Uint16(entry[0:2]) {
+		case tagExifIFD:
+			shiftIFDDates(tiff, order, order.Uint32(entry[8:12]), depth+1)
+		case tagDateTime, tagDateTimeOriginal, tagDateTimeDigitized:
+			// ASCII, 19 characters plus NUL, so always stored at an offset
+			count, offset := order.Uint32(entry[4:8]), order.Uint32(entry[8:12])
+			if order.Uint16(entry[2:4]) != 2 || count < uint32(len(exifDateLayout)) ||
+				uint64(offset)+uint64(len(exifDateLayout)) > uint64(len(tiff)) {
+				continue
+			}
+			value := tiff[offset : int(offset)+len(exifDateLayout)]
+			taken, err := time.Parse(exifDateLayout, string(value))
+			if err != nil {
+				continue
+			}
+			if shifted := taken.Add(timeShift).Format(exifDateLayout); len(shifted) == len(value) {
+				copy(value, shifted)
+			}
+		}
+	}
+}
+
+// replaceEXIFDates returns the bytes of an original image kept as the output with its EXIF
+// block swapped for the shifted copy. shiftEXIFDates keeps the layout and length, so the block
+// is found verbatim and overwritten without moving anything else in the file. Files whose block
+// can't be found are returned unchanged.
+func replaceEXIFDates(data, exifData, shiftedEXIF []byte) []byte {
+	if len(exifData) == 0 || bytes.Equal(exifData, shiftedEXIF) {
+		return data
+	}
+	start := bytes.Index(data, exifData)
+	if start < 0 {
+		return data
+	}
+	result := append([]byte{}, data...)
+	copy(result[start:], shiftedEXIF)
+	return result
+}
+
+// preserveImageTimes is preserveFileTimes for re-encoded images, whose modification time moves
+// along with their EXIF dates under -shift-time. Copied files keep their original times.
+func preserveImageTimes(dst string, info os.FileInfo) error {
+	return os.Chtimes(dst, fileAccessTime(info), info.ModTime().Add(timeShift))
+}
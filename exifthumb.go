@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+
+	"batchMedia/pkg/media"
+)
+
+// EXIF thumbnail settings for -exif-thumbnail regenerate
+const (
+	exifThumbnailMaxSize = 160
+	exifThumbnailQuality = 75
+	maxAPP1PayloadSize   = 65533 // APP1 length field covers itself, so 0xFFFF - 2
+)
+
+// TIFF tags and values of the IFD1 thumbnail and the IFDs it may sit behind
+const (
+	tagJPEGOffset      = 0x0201
+	tagJPEGLength      = 0x0202
+	tagExifIFD         = 0x8769
+	compressionOldJPEG = 6
+)
+
+// exifByteOrder reads, writes and appends in a TIFF block's byte order
+type exifByteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// exifTypeSizes maps TIFF field types to their size in bytes
+var exifTypeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// updateEXIFThumbnail applies -exif-thumbnail to an EXIF block before it is written to the output.
+// exifData is either a complete APP1 segment (from JPEG sources) or "Exif\0\0" + TIFF (from HEIC).
+// Anything that can't be parsed is returned unchanged.
+func updateEXIFThumbnail(exifData []byte, img image.Image) []byte {
+	if config.EXIFThumbnail == "keep" {
+		return exifData
+	}
+
+	hasAPP1 := len(exifData) >= 4 && exifData[0] == 0xFF && exifData[1] == 0xE1
+	body := exifData
+	if hasAPP1 {
+		body = exifData[4:]
+	}
+	if !bytes.HasPrefix(body, []byte("Exif\x00\x00")) {
+		return exifData
+	}
+	tiff := stripEXIFThumbnail(body[6:])
+	if tiff == nil {
+		return exifData
+	}
+
+	if config.EXIFThumbnail == "regenerate" {
+		if withThumb := appendEXIFThumbnail(tiff, img); withThumb != nil && len(withThumb)+8 <= maxAPP1PayloadSize {
+			tiff = withThumb
+		}
+	}
+
+	result := make([]byte, 0, len(tiff)+10)
+	if hasAPP1 {
+		result = append(result, 0xFF, 0xE1)
+		result = binary.BigEndian.AppendUint16(result, uint16(len(tiff)+8))
+	}
+	result = append(result, "Exif\x00\x00"...)
+	return append(result, tiff...)
+}
+
+// stripEXIFThumbnail unlinks IFD1 (the thumbnail IFD) from IFD0 and drops its bytes when they sit
+// after everything IFD0 and its sub-IFDs reference, which is how cameras lay it out.
+// It returns a new TIFF block, or nil if the structure is not valid TIFF.
+func stripEXIFThumbnail(tiff []byte) []byte {
+	order, ifd0, ok := exifTIFFHeader(tiff)
+	if !ok {
+		return nil
+	}
+	nextPos, ok := exifNextIFDPosition(tiff, order, ifd0)
+	if !ok {
+		return nil
+	}
+
+	result := append([]byte{}, tiff...)
+	ifd1 := order.Uint32(result[nextPos:])
+	order.PutUint32(result[nextPos:], 0)
+	if ifd1 == 0 || int(ifd1) >= len(result) {
+		return result
+	}
+
+	// Only truncate when no IFD0/EXIF/GPS/Interop data lives past IFD1
+	if exifReferencedEnd(result, order, ifd0, 0) <= ifd1 {
+		result = result[:ifd1]
+	}
+	return result
+}
+
+// appendEXIFThumbnail appends a new IFD1 with a JPEG thumbnail of img and links it from IFD0
+func appendEXIFThumbnail(tiff []byte, img image.Image) []byte {
+	order, ifd0, ok := exifTIFFHeader(tiff)
+	if !ok {
+		return nil
+	}
+	nextPos, ok := exifNextIFDPosition(tiff, order, ifd0)
+	if !ok {
+		return nil
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil
+	}
+	thumbWidth, thumbHeight := exifThumbnailMaxSize, exifThumbnailMaxSize*height/width
+	if height > width {
+		thumbWidth, thumbHeight = exifThumbnailMaxSize*width/height, exifThumbnailMaxSize
+	}
+	if thumbWidth < 1 {
+		thumbWidth = 1
+	}
+	if thumbHeight < 1 {
+		thumbHeight = 1
+	}
+	var thumb bytes.Buffer
+	if err := jpeg.Encode(&thumb, media.Resize(img, thumbWidth, thumbHeight), &jpeg.Options{Quality: exifThumbnailQuality}); err != nil {
+		return nil
+	}
+
+	result := append([]byte{}, tiff...)
+	if len(result)%2 != 0 {
+		result = append(result, 0) // IFDs start on a word boundary
+	}
+	ifd1 := uint32(len(result))
+	const entryCount = 3
+	thumbOffset := ifd1 + 2 + entryCount*12 + 4
+
+	result = order.AppendUint16(result, entryCount)
+	result = appendEXIFEntry(result, order, 0x0103, 3, 1, compressionOldJPEG) // Compression: JPEG
+	result = appendEXIFEntry(result, order, tagJPEGOffset, 4, 1, thumbOffset)
+	result = appendEXIFEntry(result, order, tagJPEGLength, 4, 1, uint32(thumb.Len()))
+	result = order.AppendUint32(result, 0) // no IFD2
+	result = append(result, thumb.Bytes()...)
+
+	order.PutUint32(result[nextPos:], ifd1)
+	return result
+}
+
+// appendEXIFEntry appends one IFD entry with an inline value
+func appendEXIFEntry(data []byte, order exifByteOrder, tag, fieldType uint16, count, value uint32) []byte {
+	data = order.AppendUint16(data, tag)
+	data = order.AppendUint16(data, fieldType)
+	data = order.AppendUint32(data, count)
+	if fieldType == 3 {
+		data = order.AppendUint16(data, uint16(value))
+		return order.AppendUint16(data, 0)
+	}
+	return order.AppendUint32(data, value)
+}
+
+// exifTIFFHeader returns the byte order and IFD0 offset of a TIFF block
+func exifTIFFHeader(tiff []byte) (exifByteOrder, uint32, bool) {
+	if len(tiff) < 8 {
+		return nil, 0, false
+	}
+	var order exifByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, false
+	}
+	if order.Uint16(tiff[2:4]) != 42 {
+		return nil, 0, false
+	}
+	return order, order.Uint32(tiff[4:8]), true
+}
+
+// exifNextIFDPosition returns where an IFD stores the offset of the next IFD
+func exifNextIFDPosition(tiff []byte, order exifByteOrder, ifd uint32) (int, bool) {
+	if int(ifd)+2 > len(tiff) {
+		return 0, false
+	}
+	pos := int(ifd) + 2 + int(order.Uint16(tiff[ifd:]))*12
+	if pos+4 > len(tiff) {
+		return 0, false
+	}
+	return pos, true
+}
+
+// exifReferencedEnd returns the end of the furthest data referenced by an IFD and the
+// EXIF, GPS and Interop sub-IFDs it points to
+func exifReferencedEnd(tiff []byte, order exifByteOrder, ifd uint32, depth int) uint32 {
+	nextPos, ok := exifNextIFDPosition(tiff, order, ifd)
+	if !ok || depth > 4 {
+		return 0
+	}
+	end := uint32(nextPos + 4)
+	for pos := int(ifd) + 2; pos < nextPos; pos += 12 {
+		entry := tiff[pos : pos+12]
+		tag := order.Uint16(entry[0:2])
+		size := exifTypeSizes[order.Uint16(entry[2:4])] * order.Uint32(entry[4:8])
+		value := order.Uint32(entry[8:12])
+		if size > 4 && value+size > end {
+			end = value + size
+		}
+		switch tag {
+		case tagExifIFD, 0x8825, 0xA005: // EXIF, GPS, Interop
+			if sub := exifReferencedEnd(tiff, order, value, depth+1); sub > end {
+				end = sub
+			}
+		}
+	}
+	return end
+}
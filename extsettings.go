@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"batchMedia/pkg/media"
+)
+
+// extSetting overrides the encoding of the images with one input extension (-ext-settings)
+type extSetting struct {
+	Quality int    // JPEG/HEIC quality, 0 = the global choice
+	Format  string // "jpeg" or "keep", empty = the global choice (-keep-format)
+}
+
+// extSettings holds the parsed -ext-settings by lowercase extension without the dot, with
+// .jpeg folded into jpg
+var extSettings = make(map[string]extSetting)
+
+// parseExtSettings parses -ext-settings, a comma-separated list of ext:key=value entries such
+// as "heic:quality=90,png:format=keep". Keys are quality (1-100) and format (jpeg or keep; the
+// extension's own format, e.g. png for png, means keep). Repeat the extension to set both.
+func parseExtSettings(spec string) (map[string]extSetting, error) {
+	settings := make(map[string]extSetting)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		ext, assignment, ok := strings.Cut(entry, ":")
+		key, value, hasValue := strings.Cut(assignment, "=")
+		if !ok || !hasValue {
+			return nil, fmt.Errorf("--ext-settings entry %q must look like ext:key=value, e.g. heic:quality=90", entry)
+		}
+		ext = extSettingKey(ext)
+		if ext != "jpg" && ext != "png" && ext != "heic" && !media.IsRawFile("x."+ext) {
+			return nil, fmt.Errorf("--ext-settings: %q is not an image extension (jpg, jpeg, png, heic or camera RAW)", ext)
+		}
+		setting := settings[ext]
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "quality":
+			quality, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || quality < 1 || quality > 100 {
+				return nil, fmt.Errorf("--ext-settings %s:quality must be between 1 and 100", ext)
+			}
+			setting.Quality = quality
+		case "format":
+			format := strings.ToLower(strings.TrimSpace(value))
+			switch {
+			case format == "jpeg" || format == "jpg":
+				setting.Format = "jpeg"
+			case format == "keep" || format == ext:
+				if media.IsRawFile("x." + ext) {
+					return nil, fmt.Errorf("--ext-settings %s:format can only be jpeg, RAW previews are written as JPEG", ext)
+				}
+				setting.Format = "keep"
+			default:
+				return nil, fmt.Errorf("--ext-settings %s:format must be jpeg or keep (or %s)", ext, ext)
+			}
+		default:
+			return nil, fmt.Errorf("--ext-settings: unknown key %q in %q (quality, format)", key, entry)
+		}
+		settings[ext] = setting
+	}
+	return settings, nil
+}
+
+// extSettingKey normalizes an extension or path extension for extSettings
+func extSettingKey(ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+	if ext == "jpeg" {
+		return "jpg"
+	}
+	return ext
+}
+
+// extSettingFor returns the -ext-settings override for a path's extension
+func extSettingFor(path string) extSetting {
+	return extSettings[extSettingKey(filepath.Ext(path))]
+}
+
+// keepsFormat reports whether images with extension ext are re-encoded in their own format:
+// a per-extension format wins over -keep-format
+func keepsFormat(ext string) bool {
+	switch extSettings[extSettingKey(ext)].Format {
+	case "keep":
+		return true
+	case "jpeg":
+		return false
+	}
+	return config.KeepFormat
+}
+
+// writesJPEGName reports whether a processed image with extension ext is written under a .jpg
+// name: HEIC converted to JPEG, or a PNG with format=jpeg. Other PNGs keep their name.
+func writesJPEGName(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".heic":
+		return convertsHEICToJPEG()
+	case ".png":
+		return extSettings["png"].Format == "jpeg"
+	}
+	return false
+}
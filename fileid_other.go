@@ -0,0 +1,24 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// fileID identifies a file by its absolute path with every link resolved, where the platform
+// has no device and inode to go by
+type fileID struct {
+	path string
+}
+
+// fileIdentity returns the identity of the file at path
+func fileIdentity(path string, info os.FileInfo) (fileID, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fileID{}, err
+	}
+	resolved, err = filepath.Abs(resolved)
+	return fileID{path: resolved}, err
+}
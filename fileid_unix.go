@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file whatever path it is reached by: its device and inode
+type fileID struct {
+	dev, ino uint64
+}
+
+// fileIdentity returns the identity of the file at path from its stat info
+func fileIdentity(path string, info os.FileInfo) (fileID, error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, fmt.Errorf("no device and inode for %s", path)
+	}
+	return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, nil
+}
@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file whatever path it is reached by: its volume serial number and file
+// index
+type fileID struct {
+	volume uint32
+	index  uint64
+}
+
+// fileIdentity returns the identity of the file at path. The stat info doesn't carry the file
+// index on Windows, so the file is opened (directories need FILE_FLAG_BACKUP_SEMANTICS) and
+// asked for it.
+func fileIdentity(path string, info os.FileInfo) (fileID, error) {
+	name, err := syscall.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return fileID{}, err
+	}
+	handle, err := syscall.CreateFile(name, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return fileID{}, err
+	}
+	defer syscall.CloseHandle(handle)
+	var data syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(handle, &data); err != nil {
+		return fileID{}, err
+	}
+	return fileID{volume: data.VolumeSerialNumber, index: uint64(data.FileIndexHigh)<<32 | uint64(data.FileIndexLow)}, nil
+}
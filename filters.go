@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// toRGBA returns the image as *image.RGBA, copying only when needed
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// clampChannel rounds and limits a channel value to 0-255
+func clampChannel(v float64) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// gaussianKernel builds a normalized 1D Gaussian kernel covering +/- 3 sigma
+func gaussianKernel(sigma float64) []float64 {
+	radius := int(math.Ceil(sigma * 3))
+	kernel := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range kernel {
+		x := float64(i - radius)
+		kernel[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+	return kernel
+}
+
+// gaussianBlur applies a separable Gaussian blur to the RGB channels, clamping at the edges
+func gaussianBlur(src *image.RGBA, sigma float64) []float64 {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	kernel := gaussianKernel(sigma)
+	radius := len(kernel) / 2
+
+	clamp := func(v, max int) int {
+		if v < 0 {
+			return 0
+		}
+		if v >= max {
+			return max - 1
+		}
+		return v
+	}
+
+	// Horizontal pass into a float buffer, then vertical pass back into another
+	tmp := make([]float64, w*h*3)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r, g, b float64
+			for k, weight := range kernel {
+				i := src.PixOffset(clamp(x+k-radius, w), y)
+				r += float64(src.Pix[i]) * weight
+				g += float64(src.Pix[i+1]) * weight
+				b += float64(src.Pix[i+2]) * weight
+			}
+			o := (y*w + x) * 3
+			tmp[o], tmp[o+1], tmp[o+2] = r, g, b
+		}
+	}
+
+	out := make([]float64, w*h*3)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r, g, b float64
+			for k, weight := range kernel {
+				o := (clamp(y+k-radius, h)*w + x) * 3
+				r += tmp[o] * weight
+				g += tmp[o+1] * weight
+				b += tmp[o+2] * weight
+			}
+			o := (y*w + x) * 3
+			out[o], out[o+1], out[o+2] = r, g, b
+		}
+	}
+	return out
+}
+
+// unsharpMask sharpens by adding back the difference between the image and a blurred copy:
+// out = src + amount * (src - blur)
+func unsharpMask(img image.Image, amount float64) image.Image {
+	src := toRGBA(img)
+	blurred := gaussianBlur(src, 1.0)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	dst := image.NewRGBA(src.Bounds())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := src.PixOffset(x, y)
+			o := (y*w + x) * 3
+			alpha := src.Pix[i+3]
+			for c := 0; c < 3; c++ {
+				orig := float64(src.Pix[i+c])
+				v := clampChannel(orig + amount*(orig-blurred[o+c]))
+				// Keep premultiplied color within the alpha channel
+				if v > alpha {
+					v = alpha
+				}
+				dst.Pix[i+c] = v
+			}
+			dst.Pix[i+3] = alpha
+		}
+	}
+	return dst
+}
+
+// applySharpen runs the -sharpen unsharp mask on a resized image.
+// Only downscaled images are sharpened unless -sharpen-all is set.
+func applySharpen(img image.Image, originalWidth, newWidth int) image.Image {
+	if config.Sharpen <= 0 {
+		return img
+	}
+	if newWidth >= originalWidth && !config.SharpenAll {
+		return img
+	}
+	return unsharpMask(img, config.Sharpen)
+}
+
+// colorAdjustmentsEnabled reports whether any of -brightness, -contrast or -saturation is set
+func colorAdjustmentsEnabled() bool {
+	return config.Brightness != 0 || config.Contrast != 0 || config.Saturation != 0
+}
+
+// describeColorAdjustments summarizes the active color adjustments for the report
+func describeColorAdjustments() string {
+	var parts []string
+	if config.Brightness != 0 {
+		parts = append(parts, fmt.Sprintf("brightness %+.2f", config.Brightness))
+	}
+	if config.Contrast != 0 {
+		parts = append(parts, fmt.Sprintf("contrast %+.2f", config.Contrast))
+	}
+	if config.Saturation != 0 {
+		parts = append(parts, fmt.Sprintf("saturation %+.2f", config.Saturation))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// applyColorAdjustments applies brightness, contrast and saturation per pixel.
+// All three are offsets from neutral 0 in the range -1 to 1:
+// brightness shifts channels by up to +/-255, contrast scales around mid-gray by (1+contrast),
+// saturation scales the distance from the pixel's luminance by (1+saturation).
+func applyColorAdjustments(img image.Image) image.Image {
+	if !colorAdjustmentsEnabled() {
+		return img
+	}
+
+	// Work on non-premultiplied values so transparent pixels aren't distorted
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	offset := config.Brightness * 255
+	contrast := 1 + config.Contrast
+	saturation := 1 + config.Saturation
+	for i := 0; i < len(dst.Pix); i += 4 {
+		r := float64(dst.Pix[i])
+		g := float64(dst.Pix[i+1])
+		b := float64(dst.Pix[i+2])
+
+		// Saturation: blend with Rec. 601 luminance
+		luma := 0.299*r + 0.587*g + 0.114*b
+		r = luma + (r-luma)*saturation
+		g = luma + (g-luma)*saturation
+		b = luma + (b-luma)*saturation
+
+		// Contrast around mid-gray, then brightness offset
+		r = (r-128)*contrast + 128 + offset
+		g = (g-128)*contrast + 128 + offset
+		b = (b-128)*contrast + 128 + offset
+
+		dst.Pix[i] = clampChannel(r)
+		dst.Pix[i+1] = clampChannel(g)
+		dst.Pix[i+2] = clampChannel(b)
+	}
+	return dst
+}
+
+// Border settings parsed from -border and -border-color at startup
+var borderPixels int
+var borderPercent float64
+var borderColor = color.RGBA{255, 255, 255, 255}
+
+// parseBorder parses -border as a pixel count ("20") or a percentage of the shorter side ("2%")
+func parseBorder(spec string) (int, float64, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasSuffix(spec, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(spec, "%"), 64)
+		if err != nil || pct < 0 || pct > 50 {
+			return 0, 0, fmt.Errorf("--border percentage must be between 0%% and 50%%")
+		}
+		return 0, pct, nil
+	}
+	px, err := strconv.Atoi(spec)
+	if err != nil || px < 0 {
+		return 0, 0, fmt.Errorf("--border must be a non-negative pixel count or a percentage (e.g. 20 or 2%%)")
+	}
+	return px, 0, nil
+}
+
+// parseColor parses a #RRGGBB hex color or one of a few common names
+func parseColor(spec string) (color.RGBA, error) {
+	switch strings.ToLower(spec) {
+	case "white":
+		return color.RGBA{255, 255, 255, 255}, nil
+	case "black":
+		return color.RGBA{0, 0, 0, 255}, nil
+	case "gray", "grey":
+		return color.RGBA{128, 128, 128, 255}, nil
+	}
+	hex := strings.TrimPrefix(spec, "#")
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: use #RRGGBB, white, black or gray", spec)
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: use #RRGGBB, white, black or gray", spec)
+	}
+	return color.RGBA{uint8(value >> 16), uint8(value >> 8), uint8(value), 255}, nil
+}
+
+// applyBorder expands the canvas with a solid frame; it runs after resizing so the
+// final size is the resized size plus twice the border
+func applyBorder(img image.Image) image.Image {
+	bounds := img.Bounds()
+	width := borderPixels
+	if borderPercent > 0 {
+		shorter := bounds.Dx()
+		if bounds.Dy() < shorter {
+			shorter = bounds.Dy()
+		}
+		width = int(math.Round(float64(shorter) * borderPercent / 100))
+	}
+	if width <= 0 {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx()+2*width, bounds.Dy()+2*width))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{borderColor}, image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rect(width, width, width+bounds.Dx(), width+bounds.Dy()), img, bounds.Min, draw.Over)
+	return dst
+}
+
+// Crop settings parsed from -crop-aspect at startup (0 = no crop)
+var cropAspectWidth, cropAspectHeight int
+
+// parseAspect parses an aspect ratio like "16:9" or "1:1"
+func parseAspect(spec string) (int, int, error) {
+	w, h, found := strings.Cut(spec, ":")
+	if !found {
+		return 0, 0, fmt.Errorf("--crop-aspect must look like W:H (e.g. 1:1 or 16:9)")
+	}
+	width, err1 := strconv.Atoi(strings.TrimSpace(w))
+	height, err2 := strconv.Atoi(strings.TrimSpace(h))
+	if err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("--crop-aspect must look like W:H with positive integers (e.g. 1:1 or 16:9)")
+	}
+	return width, height, nil
+}
+
+// applyCrop crops the image to -crop-aspect, choosing the window with -crop-mode.
+// It returns the image unchanged when no crop is configured or the ratio already matches.
+func applyCrop(img image.Image) image.Image {
+	if cropAspectWidth == 0 {
+		return img
+	}
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	cropW, cropH := cropSize(w, h)
+	if cropW <= 0 || cropH <= 0 || (cropW == w && cropH == h) {
+		return img
+	}
+
+	// Center by default; smart mode slides the window along the axis being cropped
+	offsetX, offsetY := (w-cropW)/2, (h-cropH)/2
+	if config.CropMode == "smart" {
+		offsetX, offsetY = entropyCropOffset(img, cropW, cropH)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, cropW, cropH))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min.Add(image.Pt(offsetX, offsetY)), draw.Src)
+	return dst
+}
+
+// cropSize returns the largest window of the -crop-aspect ratio that fits inside w x h
+func cropSize(w, h int) (int, int) {
+	if cropAspectWidth == 0 {
+		return w, h
+	}
+	cropW, cropH := w, w*cropAspectHeight/cropAspectWidth
+	if cropH > h {
+		cropW, cropH = h*cropAspectWidth/cropAspectHeight, h
+	}
+	return cropW, cropH
+}
+
+// entropyCropOffset picks the crop window with the highest luminance entropy, i.e. the most
+// detailed region, by sampling candidate positions along the axis being cropped
+func entropyCropOffset(img image.Image, cropW, cropH int) (int, int) {
+	const candidates = 16
+	const sampleStep = 4 // Sample every 4th pixel, plenty for a histogram
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	slackX, slackY := w-cropW, h-cropH
+
+	bestX, bestY, bestEntropy := slackX/2, slackY/2, -1.0
+	for i := 0; i <= candidates; i++ {
+		x, y := slackX*i/candidates, slackY*i/candidates
+
+		var histogram [256]int
+		total := 0
+		for py := y; py < y+cropH; py += sampleStep {
+			for px := x; px < x+cropW; px += sampleStep {
+				luma := color.GrayModel.Convert(img.At(bounds.Min.X+px, bounds.Min.Y+py)).(color.Gray).Y
+				histogram[luma]++
+				total++
+			}
+		}
+
+		var entropy float64
+		for _, count := range histogram {
+			if count > 0 {
+				p := float64(count) / float64(total)
+				entropy -= p * math.Log2(p)
+			}
+		}
+		if entropy > bestEntropy {
+			bestX, bestY, bestEntropy = x, y, entropy
+		}
+	}
+	return bestX, bestY
+}
+
+// Minimum image size parsed from -min-resolution (0x0 = no minimum)
+var minResolutionWidth, minResolutionHeight int
+
+// parseResolution parses a size like "1920x1080"
+func parseResolution(spec string) (int, int, error) {
+	w, h, found := strings.Cut(strings.ToLower(spec), "x")
+	width, err1 := strconv.Atoi(strings.TrimSpace(w))
+	height, err2 := strconv.Atoi(strings.TrimSpace(h))
+	if !found || err1 != nil || err2 != nil || width < 0 || height < 0 {
+		return 0, 0, fmt.Errorf("invalid resolution %q: use WxH, e.g. 1920x1080", spec)
+	}
+	return width, height, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"batchMedia/pkg/media"
+)
+
+// imageExtensions are the image inputs that are decoded, resized and re-encoded
+var imageExtensions = []string{".jpg", ".jpeg", ".png", ".heic"}
+
+// toolStatus describes whether an external tool was found on PATH
+func toolStatus(name string) string {
+	if path, err := exec.LookPath(name); err == nil {
+		return "found at " + path
+	}
+	return "not found"
+}
+
+// listFormats prints the recognized input extensions and available output formats
+// (-list-formats), for working out why a file was copied instead of processed
+func listFormats() {
+	heicSupport := "not available"
+	if isHEICSupported() {
+		heicSupport = "built in"
+	}
+
+	fmt.Println("Input formats:")
+	fmt.Printf("  Images: %s (HEIC decoding %s)\n", strings.Join(imageExtensions, ", "), heicSupport)
+	fmt.Printf("  Camera RAW: %s (resized from the embedded preview with -raw-mode preview, otherwise copied or skipped)\n", strings.Join(media.RawExtensions, ", "))
+	fmt.Printf("  Videos: %s (ffmpeg %s)\n", strings.Join(videoExtensions, ", "), toolStatus("ffmpeg"))
+	fmt.Println("  Files with any other extension are copied unchanged.")
+
+	fmt.Println("Output formats:")
+	fmt.Println("  Images: JPEG; PNG and HEIC stay in their format with -keep-format")
+	fmt.Printf("  HEIC encoding: heif-enc %s (without it HEIC is written as JPEG)\n", toolStatus("heif-enc"))
+	fmt.Printf("  HEIC bursts and motion photos (-heic-all-images): heif-convert %s\n", toolStatus("heif-convert"))
+	fmt.Println("  Videos: the input container, encoded with -video-codec")
+	fmt.Printf("  JPEG optimization (-jpeg-optimize): jpegtran %s\n", toolStatus("jpegtran"))
+}
@@ -1,17 +1,21 @@
 module batchMedia
 
-go 1.23
+go 1.23.0
 
 require github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
 
 require (
+	github.com/aws/aws-sdk-go v1.38.20
+	github.com/fsnotify/fsnotify v1.8.0
 	github.com/jdeng/goheif v0.0.0-20250911003654-7dc867c5b886
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/u2takey/ffmpeg-go v0.5.0
+	golang.org/x/image v0.25.0
 )
 
 require (
-	github.com/aws/aws-sdk-go v1.38.20 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/u2takey/go-utils v0.3.1 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
 )
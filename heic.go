@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"batchMedia/pkg/media"
+)
+
+// heicFramePattern matches the numbered files heif-convert writes for multi-image containers
+var heicFramePattern = regexp.MustCompile(`^frame-(\d+)\.jpg$`)
+
+// exportHEICExtras writes every image of a multi-image HEIC (bursts) with a numbered suffix,
+// plus the embedded motion video if the container carries one. The primary image output
+// is left untouched. It returns the written paths relative to the output directory.
+func exportHEICExtras(ctx context.Context, inputPath, outputPath string, fileData, exifData []byte, info os.FileInfo) ([]string, error) {
+	var extracted []string
+	ext := filepath.Ext(outputPath)
+	base := strings.TrimSuffix(outputPath, ext)
+
+	frames, cleanup, err := splitHEICImages(ctx, inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	// Single-image files have nothing extra to export
+	if len(frames) > 1 {
+		for i, frame := range frames {
+			framePath := fmt.Sprintf("%s_%d%s", base, i+1, ext)
+			if err := writeHEICFrame(ctx, inputPath, frame, framePath, exifData, info); err != nil {
+				return extracted, err
+			}
+			extracted = append(extracted, framePath)
+		}
+	}
+
+	// Samsung motion photos embed an MP4 in a top-level mpvd box
+	if video := findMotionVideo(fileData); video != nil {
+		videoPath := base + "_motion.mp4"
+		if err := writeFileAtomic(videoPath, video); err != nil {
+			return extracted, fmt.Errorf("failed to write motion video: %v", err)
+		}
+		if err := preserveFileTimes(videoPath, info); err != nil {
+			return extracted, fmt.Errorf("failed to set file time: %v", err)
+		}
+		extracted = append(extracted, videoPath)
+	}
+
+	// Account for the extra outputs and report them relative to the output directory
+	for i, path := range extracted {
+		if fi, err := os.Stat(path); err == nil {
+			statsMutex.Lock()
+			stats.TotalOutputSize += fi.Size()
+			statsMutex.Unlock()
+		}
+		if rel, err := filepath.Rel(config.OutputDir, path); err == nil {
+			extracted[i] = rel
+		}
+	}
+	return extracted, nil
+}
+
+// splitHEICImages decodes every top-level image with libheif's heif-convert.
+// It returns the frame files in container order and a cleanup function for the temp dir.
+func splitHEICImages(ctx context.Context, inputPath string) ([]string, func(), error) {
+	tmpDir, err := os.MkdirTemp("", "batchMedia-heic-frames-")
+	if err != nil {
+		return nil, func() {}, fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	cmd := exec.CommandContext(ctx, "heif-convert", "-q", "100", inputPath, filepath.Join(tmpDir, "frame.jpg"))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		cleanup()
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			return nil, func() {}, ctxErr
+		}
+		return nil, func() {}, fmt.Errorf("heif-convert failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		cleanup()
+		return nil, func() {}, err
+	}
+	indexes := make(map[string]int)
+	var frames []string
+	for _, entry := range entries {
+		m := heicFramePattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(m[1])
+		path := filepath.Join(tmpDir, entry.Name())
+		indexes[path] = index
+		frames = append(frames, path)
+	}
+	sort.Slice(frames, func(i, j int) bool { return indexes[frames[i]] < indexes[frames[j]] })
+	return frames, cleanup, nil
+}
+
+// writeHEICFrame resizes one extracted frame of inputPath like the primary image and writes it
+func writeHEICFrame(ctx context.Context, inputPath, framePath, outputPath string, exifData []byte, info os.FileInfo) error {
+	file, err := os.Open(framePath)
+	if err != nil {
+		return err
+	}
+	img, err := jpeg.Decode(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode HEIC frame: %v", err)
+	}
+
+	img = applyCrop(applyRotation(img))
+	bounds := img.Bounds()
+	opts := imageOptions()
+	newWidth, newHeight := opts.TargetSize(bounds.Dx(), bounds.Dy())
+	img = applyColorAdjustments(img)
+	resized := applySharpen(opts.FitCrop(media.Resize(img, newWidth, newHeight)), bounds.Dx(), newWidth)
+	if captionEnabled() {
+		resized = applyCaption(resized, captionText(info, exifData))
+	}
+	resized = applyBorder(resized)
+	data, err := encodeOutputImage(ctx, resized, outputPath, exifData, encodeQuality(inputPath, bounds.Dx(), newWidth))
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(outputPath, data); err != nil {
+		return fmt.Errorf("failed to write output file: %v", err)
+	}
+	if err := verifyImageOutput(outputPath, resized.Bounds().Dx(), resized.Bounds().Dy()); err != nil {
+		return err
+	}
+	return preserveImageTimes(outputPath, info)
+}
+
+// findMotionVideo returns the payload of a top-level mpvd box, or nil if there is none
+func findMotionVideo(data []byte) []byte {
+	for offset := 0; offset+8 <= len(data); {
+		size := uint64(binary.BigEndian.Uint32(data[offset:]))
+		boxType := string(data[offset+4 : offset+8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			// Box extends to the end of the file
+			size = uint64(len(data) - offset)
+		case 1:
+			if offset+16 > len(data) {
+				return nil
+			}
+			size = binary.BigEndian.Uint64(data[offset+8:])
+			header = 16
+		}
+		if size < header || uint64(offset)+size > uint64(len(data)) {
+			return nil
+		}
+		if boxType == "mpvd" {
+			return data[uint64(offset)+header : uint64(offset)+size]
+		}
+		offset += int(size)
+	}
+	return nil
+}
+
+// checkHEICConverter disables -heic-all-images when libheif's heif-convert is missing
+func checkHEICConverter() {
+	if _, err := exec.LookPath("heif-convert"); err != nil {
+		logWarn("heif-convert (libheif) not found, -heic-all-images is disabled")
+		config.HEICAllImages = false
+	}
+}
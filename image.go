@@ -2,144 +2,219 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"image"
-	"image/jpeg"
-	"image/png"
 	"io"
+	"math"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 
-	"github.com/jdeng/goheif"
-	"github.com/nfnt/resize"
-	"github.com/rwcarlsen/goexif/exif"
+	"batchMedia/pkg/media"
 )
 
-// processImage processes a single image file
-func processImage(inputPath, outputPath, relPath string, info os.FileInfo, dirStats *DirectoryStats) error {
-	// Read entire file into memory
-	fileData, err := os.ReadFile(inputPath)
-	if err != nil {
-		return fmt.Errorf("failed to read input file: %v", err)
+// errImageSkipped, errSizeVariants and errKeepOriginal stop media.ProcessImage from writing
+// the output when a hook hands the image to another branch of processImage
+var (
+	errImageSkipped = errors.New("image skipped")
+	errSizeVariants = errors.New("image written as -sizes variants")
+	errKeepOriginal = errors.New("original kept")
+)
+
+// imageOptions returns the media.ImageOptions of the size flags, with outputs written through
+// outputWriter
+func imageOptions() media.ImageOptions {
+	return media.ImageOptions{
+		Width:        config.Width,
+		Height:       config.Height,
+		Fit:          config.Fit,
+		ScalingRatio: config.ScalingRatio,
+		AllowUpscale: config.AllowUpscale,
+		Writer:       outputWriter(),
 	}
+}
 
-	// Extract EXIF information
-	var exifData []byte
-	ext := strings.ToLower(filepath.Ext(inputPath))
-	if ext == ".jpg" || ext == ".jpeg" {
-		// Extract EXIF from JPEG files
+// processImage processes a single image file with media.ProcessImage, whose hooks add the
+// skip checks, transforms and encoders of the command line flags
+func processImage(ctx context.Context, inputPath, outputPath, relPath string, info os.FileInfo, dirStats *DirectoryStats) error {
+	var (
+		src                                *media.Source
+		in                                 *media.Input
+		exifData                           []byte
+		skipReason                         string
+		skippedImg, resizedImg             image.Image
+		originalWidth, originalHeight      int
+		sourceWidth, sourceHeight, quality int
+		croppedDim, keptNote, preferNote   string
+		finalImageData                     []byte
+	)
+
+	opts := imageOptions()
+	// Apply EXIF orientation correction if needed (RAW files carry it in their TIFF IFD0),
+	// unless -orientation-mode leaves the pixels as stored
+	opts.KeepOrientation = !orientationRotatesPixels(outputPath)
+
+	// Open the input; decoders stream from the file, only the metadata header is read up front
+	opts.Open = func(path string) (*media.Source, error) {
 		var err error
-		exifData, err = extractEXIF(fileData)
-		if err != nil {
-			// EXIF extraction failure is not fatal, continue processing
-			fmt.Printf("Warning: unable to extract EXIF information from %s: %v\n", inputPath, err)
+		src, err = openImageSource(path, bufferImageSource(path))
+		return src, err
+	}
+
+	opts.Inspect = func(input *media.Input) error {
+		in = input
+		if in.Format != in.Ext {
+			logWarn("%s is a %s image despite its extension, decoding it as one", inputPath, strings.ToUpper(in.Format[1:]))
 		}
-	} else if ext == ".heic" {
-		// Extract EXIF from HEIC files
-		var err error
-		exifData, err = extractHEICExifData(fileData)
-		if err != nil {
+		if in.EXIFErr != nil {
 			// EXIF extraction failure is not fatal, continue processing
-			fmt.Printf("Warning: unable to extract EXIF information from %s: %v\n", inputPath, err)
+			logWarn("unable to extract EXIF information from %s: %v", inputPath, in.EXIFErr)
 		}
+
+		// Correct the capture dates of cameras with a wrong clock (-shift-time)
+		exifData = shiftEXIFDates(in.EXIF)
+
+		// Check the declared dimensions before decoding so one huge image can't exhaust memory
+		if config.MaxPixels > 0 {
+			// Unreadable headers are left for the decoder to report
+			if cfg, err := in.Config(); err == nil && int64(cfg.Width)*int64(cfg.Height) > int64(config.MaxPixels) {
+				logWarn("Skipping %s: %dx%d exceeds --max-pixels %d, copying it unchanged", inputPath, cfg.Width, cfg.Height, config.MaxPixels)
+				skipReason = fmt.Sprintf("%dx%d exceeds -max-pixels %d", cfg.Width, cfg.Height, config.MaxPixels)
+				return errImageSkipped
+			}
+		}
+		return nil
 	}
-	// Note: PNG files typically don't contain EXIF data, so no extraction needed
 
-	// Decode image based on file extension
-	var img image.Image
-	if ext == ".heic" {
-		// Decode HEIC image
-		img, err = decodeHEIC(fileData)
-		if err != nil {
-			return fmt.Errorf("failed to decode HEIC image: %v", err)
+	opts.Prepare = func(img image.Image) (image.Image, error) {
+		// Forced rotation (-rotate) on top of the EXIF correction, before any size decisions
+		img = applyRotation(img)
+		bounds := img.Bounds()
+		originalWidth, originalHeight = bounds.Dx(), bounds.Dy()
+
+		// Check if image should be skipped based on resolution thresholds
+		if reason := shouldSkipImage(originalWidth, originalHeight); reason != "" {
+			logInfo("Skipping %s: resolution %dx%d is outside threshold range, %s (size: %d bytes)", inputPath, originalWidth, originalHeight, reason, info.Size())
+			skipReason, skippedImg = reason, img
+			return nil, errImageSkipped
 		}
-	} else if ext == ".png" {
-		// Decode PNG image
-		img, err = png.Decode(bytes.NewReader(fileData))
-		if err != nil {
-			return fmt.Errorf("failed to decode PNG image: %v", err)
+
+		// Keep only one shape (-orientation-filter), judged on the upright dimensions so sideways
+		// stored phone photos are classified as they are viewed
+		if !matchesOrientationFilter(originalWidth, originalHeight) {
+			logInfo("Skipping %s: %dx%d is not %s (-orientation-filter)", inputPath, originalWidth, originalHeight, config.OrientationFilter)
+			skipReason, skippedImg = fmt.Sprintf("not %s (-orientation-filter)", config.OrientationFilter), img
+			return nil, errImageSkipped
 		}
-	} else {
-		// Decode JPEG image
-		img, err = jpeg.Decode(bytes.NewReader(fileData))
-		if err != nil {
-			return fmt.Errorf("failed to decode JPEG image: %v", err)
+
+		// Plain size gate (-min-resolution), independent of the scaling direction and thresholds
+		if originalWidth < minResolutionWidth || originalHeight < minResolutionHeight {
+			logInfo("Skipping %s: %dx%d is smaller than %s (-min-resolution)", inputPath, originalWidth, originalHeight, config.MinResolution)
+			skipReason, skippedImg = fmt.Sprintf("%dx%d smaller than -min-resolution %s", originalWidth, originalHeight, config.MinResolution), img
+			return nil, errImageSkipped
 		}
-	}
 
-	// Apply EXIF orientation correction if needed
-	img = applyEXIFOrientation(img, fileData)
-
-	// Get original dimensions
-	bounds := img.Bounds()
-	originalWidth := bounds.Dx()
-	originalHeight := bounds.Dy()
-
-	// Check if image should be skipped based on resolution thresholds
-	if shouldSkipImage(originalWidth, originalHeight) {
-		fmt.Printf("Skipping %s: resolution %dx%d is outside threshold range (size: %d bytes)\n", inputPath, originalWidth, originalHeight, info.Size())
-
-		// Record statistics for skipped image
-		statsMutex.Lock()
-		stats.SkippedImages++
-		stats.TotalOutputSize += info.Size()
-		dirStats.SkippedImages++
-		dirStats.TotalOutputSize += info.Size()
-		statsMutex.Unlock()
-
-		// Record file info
-		fileInfo := FileInfo{
-			Path:             relPath,
-			Type:             "skipped",
-			InputSize:        info.Size(),
-			OutputSize:       info.Size(),
-			CompressionRatio: 1.0,
+		// Optional crop to -crop-aspect; sizes below are computed from the cropped image
+		img = applyCrop(img)
+		sourceWidth, sourceHeight = img.Bounds().Dx(), img.Bounds().Dy()
+		if sourceWidth != originalWidth || sourceHeight != originalHeight {
+			croppedDim = fmt.Sprintf("%dx%d", sourceWidth, sourceHeight)
 		}
-		statsMutex.Lock()
-		stats.Files = append(stats.Files, fileInfo)
-		dirStats.Files = append(dirStats.Files, fileInfo)
-		statsMutex.Unlock()
 
-		// Copy original file without processing
-		return copyFile(inputPath, outputPath, info)
-	}
+		// Color correction runs on the full-resolution image before resizing
+		img = applyColorAdjustments(img)
 
-	// Calculate new dimensions
-	newWidth, newHeight := calculateNewSize(originalWidth, originalHeight)
+		// Responsive image sets (-sizes) write one output per width from this single decode
+		if len(sizeWidths) > 0 {
+			resizedImg = img
+			return nil, errSizeVariants
+		}
+		return img, nil
+	}
 
-	// Resize image
-	resizedImg := resizeImage(img, newWidth, newHeight)
+	opts.Finish = func(img image.Image) (image.Image, error) {
+		// Optionally sharpen to counter resampling softness
+		newWidth, _ := opts.TargetSize(sourceWidth, sourceHeight)
+		img = applySharpen(img, sourceWidth, newWidth)
+		quality = encodeQuality(inputPath, sourceWidth, newWidth)
 
-	// Encode image to buffer
-	// Note: Currently all images are encoded as JPEG for compatibility
-	// HEIC encoding is not supported by the goheif library
-	var buf bytes.Buffer
-	options := &jpeg.Options{Quality: 85} // Higher quality for better compatibility
-	if err := jpeg.Encode(&buf, resizedImg, options); err != nil {
-		return fmt.Errorf("failed to encode image: %v", err)
+		// Optional caption, then a matte around the resized image
+		if captionEnabled() {
+			img = applyCaption(img, captionText(info, exifData))
+		}
+		resizedImg = applyBorder(img)
+		return resizedImg, nil
 	}
 
-	// Get final image data and insert EXIF if available
-	finalImageData := buf.Bytes()
-	if exifData != nil {
-		// Clear orientation tag from EXIF data since we've already applied the correction
-		cleanedExifData := clearOrientationTag(exifData)
-		finalImageData = insertEXIFCorrectly(finalImageData, cleanedExifData)
+	opts.Encode = func(img image.Image, _ *media.Input) ([]byte, error) {
+		// Encode image in the output format (JPEG unless -keep-format preserves PNG/HEIC)
+		data, err := encodeOutputImage(ctx, img, outputPath, exifData, quality)
+		if err != nil {
+			return nil, err
+		}
+		finalImageData = data
+		newWidth, newHeight := img.Bounds().Dx(), img.Bounds().Dy()
+
+		// Keep the original when re-encoding doesn't save enough space (-min-savings), or when it
+		// is already the output size and smaller than the re-encoded image (-prefer-smaller)
+		if shouldKeepOriginal(inputPath, outputPath, int64(len(data)), info.Size()) {
+			logInfo("Keeping original %s: re-encoded size %d bytes is above %.2f of %d bytes",
+				inputPath, len(data), config.MinSavings, info.Size())
+			return nil, errKeepOriginal
+		}
+		if config.PreferSmaller && originalFitsOutput(inputPath, outputPath, originalWidth, originalHeight, newWidth, newHeight, croppedDim) {
+			if info.Size() <= int64(len(data)) {
+				logInfo("Keeping original %s: %d bytes, re-encoded would be %d bytes (-prefer-smaller)",
+					inputPath, info.Size(), len(data))
+				keptNote = fmt.Sprintf("original kept, re-encoded was %d bytes (-prefer-smaller)", len(data))
+				return nil, errKeepOriginal
+			}
+			preferNote = fmt.Sprintf("re-encoded kept, original was %d bytes (-prefer-smaller)", info.Size())
+		}
+		return data, nil
 	}
 
-	// Write output file
-	if err := os.WriteFile(outputPath, finalImageData, 0644); err != nil {
-		return fmt.Errorf("failed to write output file: %v", err)
+	result, err := media.ProcessImage(ctx, opts, inputPath, outputPath)
+	switch {
+	case errors.Is(err, errImageSkipped):
+		return copySkippedImage(inputPath, outputPath, relPath, info, in.RawPreview, skippedImg, dirStats, skipReason)
+	case errors.Is(err, errSizeVariants):
+		return writeSizeVariants(ctx, resizedImg, inputPath, outputPath, relPath, exifData, info, dirStats,
+			fmt.Sprintf("%dx%d", originalWidth, originalHeight), croppedDim)
+	case errors.Is(err, errKeepOriginal):
+		return keepOriginalImage(inputPath, outputPath, relPath, info, in.EXIF, exifData, resizedImg, dirStats,
+			fmt.Sprintf("%dx%d", originalWidth, originalHeight), keptNote)
+	case err != nil:
+		return mediaError(ctx, err)
+	}
+	newWidth, newHeight := result.Width, result.Height
+	if err := verifyImageOutput(outputPath, newWidth, newHeight); err != nil {
+		return err
 	}
 
-	// Preserve original file modification time
-	if err := os.Chtimes(outputPath, info.ModTime(), info.ModTime()); err != nil {
+	// Preserve original file access and modification times (shifted by -shift-time)
+	if err := preserveImageTimes(outputPath, info); err != nil {
 		return fmt.Errorf("failed to set file time: %v", err)
 	}
 
+	// The report preview comes from the resized image rather than decoding the output again
+	thumbnail, embedded := writePreviews(resizedImg, outputPath)
+
+	// Optionally measure how much the encoding changed the resized image
+	var ssim, psnr float64
+	if config.QualityAnalysis {
+		ssim, psnr, err = analyzeOutputQuality(outputPath, resizedImg, finalImageData)
+		if err != nil {
+			logWarn("%v", err)
+		}
+	}
+
 	// Record statistics
-	outputSize := int64(len(finalImageData))
+	outputSize := result.OutputSize
 	statsMutex.Lock()
 	stats.ProcessedImages++
 	stats.TotalOutputSize += outputSize
@@ -150,341 +225,547 @@ func processImage(inputPath, outputPath, relPath string, info os.FileInfo, dirSt
 	// Calculate compression ratio
 	compressionRatio := float64(outputSize) / float64(info.Size())
 
+	// Optionally export burst frames and motion video from multi-image HEICs
+	var extracted []string
+	if in.Format == ".heic" && config.HEICAllImages {
+		extracted, err = exportHEICExtras(ctx, inputPath, outputPath, src.Data, exifData, info)
+		if err != nil {
+			logWarn("failed to export extra HEIC images from %s: %v", inputPath, err)
+		}
+	}
+
 	// Record file info
 	fileInfo := FileInfo{
-		Path:             relPath,
-		Type:             "processed",
-		InputSize:        info.Size(),
-		OutputSize:       outputSize,
-		CompressionRatio: compressionRatio,
+		Path:              relPath,
+		Type:              "processed",
+		InputSize:         info.Size(),
+		OutputSize:        outputSize,
+		CompressionRatio:  compressionRatio,
+		Extracted:         extracted,
+		OriginalDim:       fmt.Sprintf("%dx%d", originalWidth, originalHeight),
+		NewDim:            fmt.Sprintf("%dx%d", newWidth, newHeight),
+		CroppedDim:        croppedDim,
+		RawHandling:       rawHandling(inputPath),
+		Adjustments:       describeColorAdjustments(),
+		Encoding:          describeEncoding(inputPath, outputPath, quality),
+		SSIM:              ssim,
+		PSNR:              psnr,
+		Note:              preferNote,
+		ThumbnailPath:     thumbnail,
+		EmbeddedThumbnail: embedded,
 	}
 	statsMutex.Lock()
 	stats.Files = append(stats.Files, fileInfo)
 	dirStats.Files = append(dirStats.Files, fileInfo)
+	recordManifest(fileInfo)
 	statsMutex.Unlock()
 
-	fmt.Printf("Processing completed: %s (%dx%d -> %dx%d, %d bytes -> %d bytes, ratio: %.2f)\n",
+	logInfo("Processing completed: %s (%dx%d -> %dx%d, %d bytes -> %d bytes, ratio: %.2f)",
 		inputPath, originalWidth, originalHeight, newWidth, newHeight, info.Size(), outputSize, compressionRatio)
+	if fileInfo.Encoding != "" {
+		logInfo("Encoded %s: %d bytes with %s", outputPath, outputSize, fileInfo.Encoding)
+	}
 	return nil
 }
 
-// calculateNewSize calculates new image dimensions based on configuration
-func calculateNewSize(originalWidth, originalHeight int) (int, int) {
-	if config.Width > 0 {
-		// Scale by width, maintain aspect ratio
-		ratio := float64(config.Width) / float64(originalWidth)
-		newHeight := int(float64(originalHeight) * ratio)
-		return config.Width, newHeight
+// keepOriginalImage copies the original in place of a re-encoded image that was no smaller
+// (-min-savings, -prefer-smaller) and records it as copied
+func keepOriginalImage(inputPath, outputPath, relPath string, info os.FileInfo, originalEXIF, exifData []byte, img image.Image, dirStats *DirectoryStats, originalDim, note string) error {
+	verification, err := copyKeptOriginal(inputPath, outputPath, info, originalEXIF, exifData)
+	if err != nil {
+		return err
 	}
+	thumbnail, embedded := writePreviews(img, outputPath)
 
-	if config.ScalingRatio > 0 {
-		// Scale by ratio
-		newWidth := int(float64(originalWidth) * config.ScalingRatio)
-		newHeight := int(float64(originalHeight) * config.ScalingRatio)
-		return newWidth, newHeight
+	statsMutex.Lock()
+	stats.CopiedFiles++
+	stats.TotalOutputSize += info.Size()
+	dirStats.CopiedFiles++
+	dirStats.TotalOutputSize += info.Size()
+	fileInfo := FileInfo{
+		Path:              relPath,
+		Type:              "copied",
+		InputSize:         info.Size(),
+		OutputSize:        info.Size(),
+		OriginalDim:       originalDim,
+		NewDim:            originalDim,
+		CompressionRatio:  1.0,
+		Verification:      verification,
+		Note:              note,
+		ThumbnailPath:     thumbnail,
+		EmbeddedThumbnail: embedded,
 	}
-
-	// Default return original dimensions
-	return originalWidth, originalHeight
-}
-
-// resizeImage resizes image using high-quality algorithm
-func resizeImage(src image.Image, newWidth, newHeight int) image.Image {
-	// Use Lanczos3 algorithm for high-quality scaling
-	// Lanczos3 provides the best image quality, especially suitable for photo scaling
-	return resize.Resize(uint(newWidth), uint(newHeight), src, resize.Lanczos3)
+	stats.Files = append(stats.Files, fileInfo)
+	dirStats.Files = append(dirStats.Files, fileInfo)
+	recordManifest(fileInfo)
+	statsMutex.Unlock()
+	return nil
 }
 
-// shouldSkipImage checks if image should be skipped based on resolution thresholds
-func shouldSkipImage(width, height int) bool {
-	// Apply threshold logic based on scaling type
-	if config.ScalingRatio > 1.0 {
-		// Upscaling: skip images above threshold (too large to upscale)
-		if config.ThresholdWidth > 0 && width > config.ThresholdWidth {
-			return true
-		}
-		if config.ThresholdHeight > 0 && height > config.ThresholdHeight {
-			return true
+// copySkippedImage copies an image that is left unprocessed to the output and records it as
+// skipped for the given reason. For RAW files the untouched embedded preview is written instead.
+// img is the decoded image for -thumbnails, nil when the image was skipped before decoding.
+func copySkippedImage(inputPath, outputPath, relPath string, info os.FileInfo, rawPreviewData []byte, img image.Image, dirStats *DirectoryStats, reason string) error {
+	// Copy original file without processing (for RAW files, the untouched preview)
+	if rawPreviewData != nil {
+		if err := writeFileAtomic(outputPath, rawPreviewData); err != nil {
+			return fmt.Errorf("failed to write output file: %v", err)
 		}
-	} else if config.ScalingRatio < 1.0 {
-		// Downscaling: skip images below threshold (too small to downscale)
-		if config.ThresholdWidth > 0 && width < config.ThresholdWidth {
-			return true
-		}
-		if config.ThresholdHeight > 0 && height < config.ThresholdHeight {
-			return true
+		if err := preserveFileTimes(outputPath, info); err != nil {
+			return fmt.Errorf("failed to set file time: %v", err)
 		}
+	} else if err := copyFile(inputPath, copiedOutputPath(inputPath, outputPath), info); err != nil {
+		// An untouched HEIC keeps its .heic name rather than the transcoded .jpg one
+		return err
 	}
+	thumbnail, embedded := writePreviews(img, copiedOutputPath(inputPath, outputPath))
 
-	return false
-}
+	// Record statistics for skipped image
+	statsMutex.Lock()
+	stats.SkippedImages++
+	stats.TotalOutputSize += info.Size()
+	dirStats.SkippedImages++
+	dirStats.TotalOutputSize += info.Size()
+	statsMutex.Unlock()
 
-// copyFile copies a file from source to destination while preserving file info
-func copyFile(src, dst string, info os.FileInfo) error {
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return fmt.Errorf("failed to open source file: %v", err)
+	// Record file info
+	fileInfo := FileInfo{
+		Path:              relPath,
+		Type:              "skipped",
+		InputSize:         info.Size(),
+		OutputSize:        info.Size(),
+		CompressionRatio:  1.0,
+		Verification:      copyVerification(),
+		RawHandling:       rawHandling(inputPath),
+		SkipReason:        reason,
+		ThumbnailPath:     thumbnail,
+		EmbeddedThumbnail: embedded,
 	}
-	defer sourceFile.Close()
+	statsMutex.Lock()
+	stats.Files = append(stats.Files, fileInfo)
+	dirStats.Files = append(dirStats.Files, fileInfo)
+	recordManifest(fileInfo)
+	statsMutex.Unlock()
+	return nil
+}
 
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return fmt.Errorf("failed to create destination file: %v", err)
+// copyUndecodableImage copies an image that failed to decode unchanged
+// (-keep-going-on-decode-error), so the output tree still mirrors the input. It is reported
+// as copied, with the decode error as a note.
+func copyUndecodableImage(inputPath, outputPath, relPath string, info os.FileInfo, dirStats *DirectoryStats, decodeErr error) error {
+	logWarn("Copying %s unchanged, it can't be decoded: %v", inputPath, decodeErr)
+	if err := copyFile(inputPath, copiedOutputPath(inputPath, outputPath), info); err != nil {
+		return err
 	}
-	defer destFile.Close()
 
-	_, err = io.Copy(destFile, sourceFile)
-	if err != nil {
-		return fmt.Errorf("failed to copy file: %v", err)
+	statsMutex.Lock()
+	stats.CopiedFiles++
+	stats.TotalOutputSize += info.Size()
+	dirStats.CopiedFiles++
+	dirStats.TotalOutputSize += info.Size()
+	fileInfo := FileInfo{
+		Path:             relPath,
+		Type:             "copied",
+		InputSize:        info.Size(),
+		OutputSize:       info.Size(),
+		CompressionRatio: 1.0,
+		Verification:     copyVerification(),
+		RawHandling:      rawHandling(inputPath),
+		Note:             fmt.Sprintf("not decodable, copied unchanged (%v)", decodeErr),
 	}
+	stats.Files = append(stats.Files, fileInfo)
+	dirStats.Files = append(dirStats.Files, fileInfo)
+	recordManifest(fileInfo)
+	statsMutex.Unlock()
+	return nil
+}
 
-	// Preserve file modification time
-	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+// copiedOutputPath returns where an unmodified copy of inputPath is written: outputPath with
+// the input's own extension, since the HEIC to JPEG rename only applies to transcoded files
+func copiedOutputPath(inputPath, outputPath string) string {
+	inExt := filepath.Ext(inputPath)
+	outExt := filepath.Ext(outputPath)
+	if strings.EqualFold(inExt, outExt) {
+		return outputPath
+	}
+	return strings.TrimSuffix(outputPath, outExt) + inExt
 }
 
-// extractEXIF extracts EXIF information from image file data
-func extractEXIF(data []byte) ([]byte, error) {
-	reader := bytes.NewReader(data)
+// shouldKeepOriginal reports whether the re-encoded output is not enough smaller than the input.
+// Only applies when the output keeps the input's format, so the original bytes fit the output path.
+func shouldKeepOriginal(inputPath, outputPath string, outputSize, inputSize int64) bool {
+	if config.MinSavings <= 0 || inputSize == 0 || !sameImageFormat(inputPath, outputPath) {
+		return false
+	}
+	return float64(outputSize)/float64(inputSize) > config.MinSavings
+}
 
-	// Check if it's a JPEG file by looking at the header
-	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
-		// Not a JPEG file (likely HEIC), skip EXIF extraction
-		return nil, fmt.Errorf("EXIF extraction only supported for JPEG files")
+// copyKeptOriginal writes the original in place of its re-encoded output (-min-savings,
+// -prefer-smaller) and returns the report's verification note. Under -shift-time it gets the
+// shifted EXIF dates and modification time a re-encoded image would have, so it is written
+// rather than copied and there is no copy to verify.
+func copyKeptOriginal(inputPath, outputPath string, info os.FileInfo, exifData, shiftedEXIF []byte) (string, error) {
+	if timeShift == 0 {
+		return copyVerification(), copyFile(inputPath, outputPath, info)
+	}
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read original: %v", err)
+	}
+	if err := writeFileAtomic(outputPath, replaceEXIFDates(data, exifData, shiftedEXIF)); err != nil {
+		return "", fmt.Errorf("failed to write output file: %v", err)
 	}
+	if err := preserveImageTimes(outputPath, info); err != nil {
+		return "", fmt.Errorf("failed to set file time: %v", err)
+	}
+	return "", nil
+}
 
-	// Find APP1 segment (EXIF data) directly without calling exif.Decode
-	// This avoids TIFF byte order errors from corrupted EXIF data
-	buf := make([]byte, 2)
+// originalFitsOutput reports whether the original can stand in for the output under
+// -prefer-smaller: same format and the same pixels, so neither resized, cropped or framed,
+// nor rotated, captioned, color adjusted or sharpened
+func originalFitsOutput(inputPath, outputPath string, originalWidth, originalHeight, newWidth, newHeight int, croppedDim string) bool {
+	if !sameImageFormat(inputPath, outputPath) || croppedDim != "" {
+		return false
+	}
+	if newWidth != originalWidth || newHeight != originalHeight {
+		return false
+	}
+	return config.Rotate == 0 && !captionEnabled() && !colorAdjustmentsEnabled() && !(config.Sharpen > 0 && config.SharpenAll)
+}
 
-	// Check JPEG file header
-	if _, err := reader.Read(buf); err != nil {
-		return nil, err
+// sameImageFormat reports whether outputPath keeps the format of inputPath, treating .jpeg as .jpg
+func sameImageFormat(inputPath, outputPath string) bool {
+	inExt := strings.ToLower(filepath.Ext(inputPath))
+	outExt := strings.ToLower(filepath.Ext(outputPath))
+	if inExt == ".jpeg" {
+		inExt = ".jpg"
 	}
-	if buf[0] != 0xFF || buf[1] != 0xD8 {
-		return nil, fmt.Errorf("not a valid JPEG file")
+	if outExt == ".jpeg" {
+		outExt = ".jpg"
 	}
+	return inExt == outExt
+}
 
-	// Find APP1 segment
-	for {
-		if _, err := reader.Read(buf); err != nil {
+// encodeOutputImage encodes the image in the format chosen for outputPath;
+// quality applies to JPEG and HEIC output
+func encodeOutputImage(ctx context.Context, img image.Image, outputPath string, exifData []byte, quality int) ([]byte, error) {
+	switch outputImageFormat(outputPath) {
+	case "png":
+		// PNG carries no EXIF here, orientation has already been applied to the pixels
+		return encodePNG(img)
+	case "heic":
+		// heif-enc picks up EXIF from a JPEG source, so hand it a near-lossless JPEG
+		jpegData, err := encodeJPEGWithEXIF(img, 100, exifData)
+		if err != nil {
 			return nil, err
 		}
-
-		if buf[0] != 0xFF {
-			continue
+		heicData, err := encodeHEIC(ctx, jpegData, quality)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode HEIC image: %v", err)
 		}
+		return heicData, nil
+	default:
+		data, err := encodeJPEGWithEXIF(img, quality, exifData)
+		if err != nil {
+			return nil, err
+		}
+		return optimizeJPEG(data), nil
+	}
+}
 
-		// Found APP1 segment
-		if buf[1] == 0xE1 {
-			// Read segment length
-			if _, err := reader.Read(buf); err != nil {
-				return nil, err
-			}
-			length := int(buf[0])<<8 | int(buf[1])
-
-			// Read entire APP1 segment
-			exifSegment := make([]byte, length+2) // +2 for marker
-			exifSegment[0] = 0xFF
-			exifSegment[1] = 0xE1
-			exifSegment[2] = buf[0]
-			exifSegment[3] = buf[1]
+// encodeJPEGWithEXIF encodes the image as JPEG (progressive with -jpeg-progressive) and
+// inserts the EXIF segment if available
+func encodeJPEGWithEXIF(img image.Image, quality int, exifData []byte) ([]byte, error) {
+	if exifData != nil {
+		// Clear orientation tag from EXIF data since we've already applied the correction
+		// (or, with -orientation-mode keep, chose to ignore it); tag-only leaves it for the viewer
+		if config.OrientationMode != "tag-only" {
+			exifData = media.ClearOrientationTag(exifData)
+		}
+		// Drop or rebuild the embedded thumbnail, which still shows the original image (-exif-thumbnail)
+		exifData = updateEXIFThumbnail(exifData, img)
+	}
+	if !progressiveJPEG() {
+		return media.EncodeJPEG(img, quality, exifData)
+	}
 
-			if _, err := reader.Read(exifSegment[4:]); err != nil {
-				return nil, err
-			}
+	data, err := encodeProgressiveJPEG(img, quality)
+	if err != nil {
+		return nil, err
+	}
+	if exifData != nil {
+		data = media.InsertEXIF(data, exifData)
+	}
+	return data, nil
+}
 
-			return exifSegment, nil
-		}
+// progressiveJPEG reports whether JPEGs are written progressive: -jpeg-progressive in a build
+// with the libjpeg encoder
+func progressiveJPEG() bool {
+	return config.JPEGProgressive && progressiveJPEGAvailable
+}
 
-		// If it's another segment, skip it
-		if buf[1] >= 0xE0 && buf[1] <= 0xEF {
-			if _, err := reader.Read(buf); err != nil {
-				return nil, err
-			}
-			length := int(buf[0])<<8 | int(buf[1])
-			reader.Seek(int64(length-2), io.SeekCurrent)
-		} else {
-			break
-		}
+// outputImageFormat returns the encoder to use for an output path: "jpeg", "png" or "heic"
+func outputImageFormat(outputPath string) string {
+	if !keepsFormat(filepath.Ext(outputPath)) {
+		return "jpeg"
+	}
+	switch strings.ToLower(filepath.Ext(outputPath)) {
+	case ".png":
+		return "png"
+	case ".heic":
+		return "heic"
+	default:
+		return "jpeg"
 	}
+}
+
+// heicEncoderAvailable is set at startup when -keep-format finds libheif's heif-enc
+var heicEncoderAvailable bool
 
-	return nil, fmt.Errorf("EXIF data not found")
+// convertsHEICToJPEG reports whether HEIC inputs are written as .jpg outputs
+func convertsHEICToJPEG() bool {
+	return !keepsFormat(".heic") || !heicEncoderAvailable
 }
 
-// insertEXIFCorrectly inserts EXIF data into JPEG file with proper APP1 segment structure
-// applyEXIFOrientation applies EXIF orientation correction to the image
-func applyEXIFOrientation(img image.Image, fileData []byte) image.Image {
-	// Try to extract EXIF orientation
-	reader := bytes.NewReader(fileData)
-	x, err := exif.Decode(reader)
-	if err != nil {
-		// No EXIF data or unable to decode (e.g., TIFF byte order errors), return original image
-		// This is not an error condition, just means we can't apply orientation correction
-		return img
+// checkHEICEncoder looks for libheif's heif-enc, warning and falling back to JPEG if missing
+func checkHEICEncoder() {
+	if _, err := exec.LookPath("heif-enc"); err != nil {
+		logWarn("HEIC encoder (heif-enc from libheif) not found, HEIC files will be converted to JPEG")
+		heicEncoderAvailable = false
+		return
 	}
+	heicEncoderAvailable = true
+}
 
-	// Get orientation tag
-	orientationTag, err := x.Get(exif.Orientation)
+// encodeHEIC re-encodes JPEG data as HEIC using libheif's heif-enc, killed when ctx is done
+func encodeHEIC(ctx context.Context, jpegData []byte, quality int) ([]byte, error) {
+	tmpDir, err := os.MkdirTemp("", "batchMedia-heic-")
 	if err != nil {
-		// No orientation tag, return original image
-		return img
+		return nil, err
 	}
+	defer os.RemoveAll(tmpDir)
 
-	// Get orientation value
-	orientation, err := orientationTag.Int(0)
-	if err != nil {
-		return img
+	inputPath := filepath.Join(tmpDir, "input.jpg")
+	outputPath := filepath.Join(tmpDir, "output.heic")
+	if err := os.WriteFile(inputPath, jpegData, 0644); err != nil {
+		return nil, err
 	}
 
-	// Apply transformation based on orientation value
-	switch orientation {
-	case 1:
-		// Normal orientation, no transformation needed
-		return img
-	case 2:
-		// Flip horizontal
-		return flipHorizontal(img)
-	case 3:
-		// Rotate 180 degrees
-		return rotate180(img)
-	case 4:
-		// Flip vertical
-		return flipVertical(img)
-	case 5:
-		// Rotate 90 degrees clockwise and flip horizontal
-		return flipHorizontal(rotate90CW(img))
-	case 6:
-		// Rotate 90 degrees clockwise
-		return rotate90CW(img)
-	case 7:
-		// Rotate 90 degrees counter-clockwise and flip horizontal
-		return flipHorizontal(rotate90CCW(img))
-	case 8:
-		// Rotate 90 degrees counter-clockwise
-		return rotate90CCW(img)
-	default:
-		// Unknown orientation, return original
-		return img
+	cmd := exec.CommandContext(ctx, "heif-enc", "-q", fmt.Sprintf("%d", quality), "-o", outputPath, inputPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("heif-enc failed: %v: %s", err, strings.TrimSpace(string(out)))
 	}
+	return os.ReadFile(outputPath)
 }
 
-// rotate90CW rotates image 90 degrees clockwise
-func rotate90CW(src image.Image) image.Image {
-	bounds := src.Bounds()
-	w, h := bounds.Dx(), bounds.Dy()
-	dst := image.NewRGBA(image.Rect(0, 0, h, w))
+// defaultQuality is the fixed JPEG/HEIC quality, high enough for good compatibility
+const defaultQuality = 85
+
+// encodeQuality returns the JPEG/HEIC quality for an image resized from originalWidth to newWidth.
+// With -adaptive-quality it scales linearly with the resize ratio between the floor and ceiling:
+//
+//	quality = floor + (ceiling - floor) * min(newWidth/originalWidth, 1)
+//
+// so a 25% downscale with the defaults (70-85) encodes at 70 + 15*0.25 = 73.75 -> 74,
+// while unscaled or upscaled images keep the ceiling.
+//
+// A quality set for the input's extension with -ext-settings replaces either.
+func encodeQuality(inputPath string, originalWidth, newWidth int) int {
+	if quality := extSettingFor(inputPath).Quality; quality > 0 {
+		return quality
+	}
+	if !config.AdaptiveQuality || originalWidth <= 0 {
+		return defaultQuality
+	}
+	ratio := math.Min(float64(newWidth)/float64(originalWidth), 1)
+	return int(math.Round(float64(config.QualityFloor) + float64(config.QualityCeiling-config.QualityFloor)*ratio))
+}
 
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			dst.Set(h-1-y, x, src.At(x, y))
-		}
+// describeEncoding summarizes non-default encoder settings for the report
+func describeEncoding(inputPath, outputPath string, quality int) string {
+	if outputImageFormat(outputPath) == "png" {
+		return describePNGEncoding(outputPath)
+	}
+	var parts []string
+	if extSettingFor(inputPath).Quality > 0 {
+		parts = append(parts, fmt.Sprintf("quality %d (-ext-settings)", quality))
+	} else if config.AdaptiveQuality {
+		parts = append(parts, fmt.Sprintf("quality %d (adaptive)", quality))
 	}
-	return dst
+	if outputImageFormat(outputPath) == "jpeg" && progressiveJPEG() {
+		parts = append(parts, "progressive")
+	}
+	if outputImageFormat(outputPath) == "jpeg" && jpegtranPath != "" {
+		parts = append(parts, "optimized")
+	}
+	return strings.Join(parts, ", ")
 }
 
-// rotate90CCW rotates image 90 degrees counter-clockwise
-func rotate90CCW(src image.Image) image.Image {
-	bounds := src.Bounds()
-	w, h := bounds.Dx(), bounds.Dy()
-	dst := image.NewRGBA(image.Rect(0, 0, h, w))
-
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			dst.Set(y, w-1-x, src.At(x, y))
+// shouldSkipImage checks if image should be skipped based on resolution thresholds and
+// returns the reason recorded in the reports, or an empty string to process it
+func shouldSkipImage(width, height int) string {
+	// Apply threshold logic based on scaling type
+	if config.ScalingRatio > 1.0 {
+		// Upscaling: skip images above threshold (too large to upscale)
+		if config.ThresholdWidth > 0 && width > config.ThresholdWidth {
+			return fmt.Sprintf("width %d above %dpx threshold", width, config.ThresholdWidth)
+		}
+		if config.ThresholdHeight > 0 && height > config.ThresholdHeight {
+			return fmt.Sprintf("height %d above %dpx threshold", height, config.ThresholdHeight)
+		}
+	} else if config.ScalingRatio < 1.0 {
+		// Downscaling: skip images below threshold (too small to downscale)
+		if config.ThresholdWidth > 0 && width < config.ThresholdWidth {
+			return fmt.Sprintf("width %d below %dpx threshold", width, config.ThresholdWidth)
+		}
+		if config.ThresholdHeight > 0 && height < config.ThresholdHeight {
+			return fmt.Sprintf("height %d below %dpx threshold", height, config.ThresholdHeight)
 		}
 	}
-	return dst
-}
 
-// rotate180 rotates image 180 degrees
-func rotate180(src image.Image) image.Image {
-	bounds := src.Bounds()
-	w, h := bounds.Dx(), bounds.Dy()
-	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	return ""
+}
 
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			dst.Set(w-1-x, h-1-y, src.At(x, y))
-		}
+// matchesOrientationFilter reports whether an image of the given size has the -orientation-filter
+// shape: landscape (wider than tall), portrait (taller than wide), square, or all
+func matchesOrientationFilter(width, height int) bool {
+	switch config.OrientationFilter {
+	case "landscape":
+		return width > height
+	case "portrait":
+		return height > width
+	case "square":
+		return width == height
+	default:
+		return true
 	}
-	return dst
 }
 
-// flipHorizontal flips image horizontally
-func flipHorizontal(src image.Image) image.Image {
-	bounds := src.Bounds()
-	w, h := bounds.Dx(), bounds.Dy()
-	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+// copyFile copies a file from source to destination while preserving file info,
+// retrying transient I/O failures
+func copyFile(src, dst string, info os.FileInfo) error {
+	return withRetry("Copying "+src, func() error {
+		return copyFileOnce(src, dst, info)
+	})
+}
 
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			dst.Set(w-1-x, y, src.At(x, y))
-		}
+// copyFileOnce makes a single copy attempt for copyFile; errors wrap the underlying I/O
+// error so withRetry can recognize transient ones
+func copyFileOnce(src, dst string, info os.FileInfo) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
 	}
-	return dst
-}
+	defer sourceFile.Close()
 
-// flipVertical flips image vertically
-func flipVertical(src image.Image) image.Image {
-	bounds := src.Bounds()
-	w, h := bounds.Dx(), bounds.Dy()
-	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	// Copy to a temp file so an interrupted copy never leaves a partial file at dst
+	tmpPath := tempOutputPath(dst)
+	defer os.Remove(tmpPath)
+	destFile, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer destFile.Close()
 
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			dst.Set(x, h-1-y, src.At(x, y))
+	_, err = io.Copy(destFile, sourceFile)
+	if err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+
+	// Make sure the data hit the disk before verifying it
+	if err := destFile.Close(); err != nil {
+		return fmt.Errorf("failed to close destination file: %w", err)
+	}
+
+	// Compare checksums so a truncated copy (e.g. disk full) is caught
+	if config.VerifyCopies {
+		if err := verifyCopy(src, tmpPath); err != nil {
+			return err
 		}
 	}
-	return dst
+
+	if err := commitTempOutput(tmpPath, dst); err != nil {
+		return err
+	}
+
+	// Preserve file access and modification times
+	return preserveFileTimes(dst, info)
 }
 
-// clearOrientationTag removes the orientation tag from EXIF data
-func clearOrientationTag(exifData []byte) []byte {
-	// For simplicity, we'll create a new EXIF segment with orientation set to 1 (normal)
-	// This is a basic implementation that works for most cases
-	if len(exifData) < 10 {
-		return exifData
-	}
-
-	// Make a copy of the EXIF data
-	cleanedData := make([]byte, len(exifData))
-	copy(cleanedData, exifData)
-
-	// Look for orientation tag (0x0112) in the EXIF data
-	// This is a simplified approach - in a full implementation, you'd parse the TIFF structure
-	for i := 0; i < len(cleanedData)-4; i++ {
-		// Look for orientation tag (0x0112 in big-endian or 0x1201 in little-endian)
-		if (cleanedData[i] == 0x01 && cleanedData[i+1] == 0x12) ||
-			(cleanedData[i] == 0x12 && cleanedData[i+1] == 0x01) {
-			// Found potential orientation tag, set value to 1 (normal orientation)
-			if i+8 < len(cleanedData) {
-				// Set the value to 1 (normal orientation)
-				cleanedData[i+6] = 0x00
-				cleanedData[i+7] = 0x01
-				break
-			}
-		}
+// preserveFileTimes copies the source atime and mtime (with subsecond precision) to dst
+func preserveFileTimes(dst string, info os.FileInfo) error {
+	return os.Chtimes(dst, fileAccessTime(info), info.ModTime())
+}
+
+// fileSHA256 computes the SHA-256 checksum of a file
+func fileSHA256(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	defer file.Close()
 
-	return cleanedData
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
 }
 
-func insertEXIFCorrectly(jpegData, exifData []byte) []byte {
-	if len(jpegData) < 4 || jpegData[0] != 0xFF || jpegData[1] != 0xD8 {
-		return jpegData // Not a valid JPEG file
+// verifyCopy checks that the destination is byte-identical to the source
+func verifyCopy(src, dst string) error {
+	srcSum, err := fileSHA256(src)
+	if err != nil {
+		return fmt.Errorf("failed to checksum source file: %v", err)
+	}
+	dstSum, err := fileSHA256(dst)
+	if err != nil {
+		return fmt.Errorf("failed to checksum destination file: %v", err)
 	}
+	if !bytes.Equal(srcSum, dstSum) {
+		return fmt.Errorf("checksum mismatch after copying %s to %s", src, dst)
+	}
+	return nil
+}
 
-	// exifData from extractEXIF already contains the complete APP1 segment
-	// (0xFFE1 marker + length + "Exif\x00\x00" + TIFF data)
-	// So we just need to insert it directly after the SOI marker
+// copyVerification returns the verification status recorded for copied files
+func copyVerification() string {
+	if config.VerifyCopies {
+		return "sha256 verified"
+	}
+	return ""
+}
 
-	// Insert complete APP1 segment after SOI marker (0xFFD8)
-	result := make([]byte, 0, len(jpegData)+len(exifData))
-	result = append(result, jpegData[0:2]...) // SOI marker (0xFFD8)
-	result = append(result, exifData...)      // Complete APP1 segment with EXIF
-	result = append(result, jpegData[2:]...)  // Rest of JPEG data
+// orientationRotatesPixels reports whether the EXIF orientation should be applied to the pixels.
+// tag-only still rotates PNG output, which has no EXIF to carry the tag.
+func orientationRotatesPixels(outputPath string) bool {
+	switch config.OrientationMode {
+	case "keep":
+		return false
+	case "tag-only":
+		return outputImageFormat(outputPath) == "png"
+	default:
+		return true
+	}
+}
 
-	return result
+// applyRotation rotates the image clockwise by the -rotate angle (0, 90, 180, 270)
+func applyRotation(img image.Image) image.Image {
+	switch config.Rotate {
+	case 90:
+		return media.Rotate90CW(img)
+	case 180:
+		return media.Rotate180(img)
+	case 270:
+		return media.Rotate90CCW(img)
+	default:
+		return img
+	}
 }
 
 // verifyEXIFPresence checks if a JPEG file contains EXIF data
@@ -521,24 +802,6 @@ func verifyEXIFPresence(filePath string) bool {
 	return false
 }
 
-// decodeHEIC decodes HEIC image using goheif library
-func decodeHEIC(data []byte) (image.Image, error) {
-	return goheif.Decode(bytes.NewReader(data))
-}
-
-// extractHEICExifData extracts EXIF information from HEIC file data
-func extractHEICExifData(data []byte) ([]byte, error) {
-	reader := bytes.NewReader(data)
-
-	// Use goheif.ExtractExif to extract EXIF from HEIC file
-	exifData, err := goheif.ExtractExif(reader)
-	if err != nil {
-		return nil, err
-	}
-
-	return exifData, nil
-}
-
 // isHEICSupported returns true if HEIC support is available
 func isHEICSupported() bool {
 	return true
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// inPlaceManifestName lists, inside -backup-dir, every output written in place. Files on the list
+// are never processed again, so reruns don't shrink the same photo twice.
+const inPlaceManifestName = ".batchmedia_inplace_outputs.txt"
+
+// inPlaceOutputs holds the manifest entries (slash-separated paths relative to the input directory)
+var inPlaceOutputs = make(map[string]bool)
+var inPlaceMutex sync.Mutex
+
+// validateInPlace checks the -output-same-as-input settings and loads the manifest of earlier runs
+func validateInPlace() error {
+	if !config.IKnowThisOverwrites {
+		return fmt.Errorf("--output-same-as-input replaces your original files; add --i-know-this-overwrites to confirm")
+	}
+	if config.BackupDir == "" {
+		return fmt.Errorf("--output-same-as-input requires --backup-dir to keep the originals")
+	}
+	if config.OutputDir != "" && filepath.Clean(config.OutputDir) != filepath.Clean(config.InputDir) {
+		return fmt.Errorf("--out must be omitted or equal to --inputdir with --output-same-as-input")
+	}
+	if config.OutputSuffix != "" || config.Sizes != "" || config.HEICAllImages || config.Dedupe {
+		return fmt.Errorf("--output-same-as-input cannot be combined with --output-suffix, --sizes, --heic-all-images or --dedupe")
+	}
+	if fi, err := os.Stat(config.InputDir); err == nil && !fi.IsDir() {
+		return fmt.Errorf("--output-same-as-input needs an input directory, not a single file")
+	}
+	config.OutputDir = config.InputDir
+
+	// Backups inside the input tree would be scanned and "processed" themselves
+	inputAbs, err := filepath.Abs(config.InputDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve input directory: %v", err)
+	}
+	backupAbs, err := filepath.Abs(config.BackupDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve backup directory: %v", err)
+	}
+	if rel, err := filepath.Rel(inputAbs, backupAbs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("--backup-dir must be outside the input directory")
+	}
+	if err := os.MkdirAll(config.BackupDir, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
+	return loadInPlaceManifest()
+}
+
+// loadInPlaceManifest reads the outputs recorded by earlier in-place runs
+func loadInPlaceManifest() error {
+	file, err := os.Open(filepath.Join(config.BackupDir, inPlaceManifestName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read in-place manifest: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			inPlaceOutputs[line] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// inPlaceProcessed reports whether an input file is an output of an earlier in-place run
+func inPlaceProcessed(relPath string) bool {
+	inPlaceMutex.Lock()
+	defer inPlaceMutex.Unlock()
+	return inPlaceOutputs[filepath.ToSlash(relPath)]
+}
+
+// backupOriginal keeps the original at the same relative path under -backup-dir before it is
+// overwritten. It stays in place until its replacement is complete, so a crash at any point
+// leaves either the original or the finished output, plus the backup.
+func backupOriginal(path, relPath string) (string, error) {
+	backupPath := filepath.Join(config.BackupDir, relPath)
+	if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %v", err)
+	}
+	os.Remove(backupPath)
+
+	// A hard link costs no space or time; across filesystems fall back to a copy
+	if err := os.Link(path, backupPath); err == nil {
+		return backupPath, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat original for backup: %v", err)
+	}
+	if err := copyFile(path, backupPath, info); err != nil {
+		return "", fmt.Errorf("failed to back up original: %v", err)
+	}
+	return backupPath, nil
+}
+
+// finishInPlace records the outputs that replaced an original and removes the original when
+// the output has a different name (e.g. HEIC transcoded to .jpg). On failure the original was
+// never touched, so only the backup is dropped, unless an output that failed -verify-output
+// already replaced it; then the original is restored from the backup.
+func finishInPlace(path, outputPath, backupPath string, processErr error) {
+	if processErr != nil {
+		var verifyErr *outputVerificationError
+		if errors.As(processErr, &verifyErr) && filepath.Clean(verifyErr.path) == filepath.Clean(path) {
+			if err := os.Rename(backupPath, path); err != nil {
+				logWarn("failed to restore %s from its backup %s: %v", path, backupPath, err)
+			}
+			return
+		}
+		os.Remove(backupPath)
+		return
+	}
+
+	// Processing writes either the output or, when the image was skipped, an untouched copy
+	// under the original name; the original itself still exists, so check the output first
+	written := outputPath
+	if _, err := os.Stat(outputPath); err != nil {
+		written = copiedOutputPath(path, outputPath)
+		if _, err := os.Stat(written); err != nil {
+			return
+		}
+	}
+
+	inPlaceMutex.Lock()
+	defer inPlaceMutex.Unlock()
+	file, err := os.OpenFile(filepath.Join(config.BackupDir, inPlaceManifestName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logWarn("failed to update in-place manifest: %v", err)
+	}
+	if rel, err := filepath.Rel(config.OutputDir, written); err == nil {
+		rel = filepath.ToSlash(rel)
+		if !inPlaceOutputs[rel] {
+			inPlaceOutputs[rel] = true
+			if file != nil {
+				fmt.Fprintln(file, rel)
+			}
+		}
+	}
+	if file != nil {
+		file.Close()
+	}
+
+	if written != path {
+		if err := os.Remove(path); err != nil {
+			logWarn("failed to remove replaced original %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+)
+
+// jpegtranPath is the jpegtran (from libjpeg-turbo or mozjpeg) that -jpeg-optimize pipes JPEG
+// outputs through; empty when -jpeg-optimize is off or jpegtran wasn't found
+var jpegtranPath string
+
+// jpegOptimizeTotal and jpegOptimizeInput count the JPEGs given to -jpeg-optimize and their
+// encoded bytes; jpegOptimizeCount and jpegOptimizeSaved the ones it made smaller and the bytes saved
+var jpegOptimizeTotal int64
+var jpegOptimizeInput int64
+var jpegOptimizeCount int64
+var jpegOptimizeSaved int64
+
+// setupJPEGOptimize looks for jpegtran once at startup; without it -jpeg-optimize warns and
+// JPEGs are written as encoded
+func setupJPEGOptimize() {
+	path, err := exec.LookPath("jpegtran")
+	if err != nil {
+		logWarn("--jpeg-optimize needs jpegtran (libjpeg-turbo or mozjpeg) on PATH; writing JPEGs unoptimized")
+		return
+	}
+	jpegtranPath = path
+	logDebug("Optimizing JPEGs with %s", path)
+}
+
+// optimizeJPEG losslessly rewrites an encoded JPEG with optimized Huffman tables, keeping its
+// EXIF and its progressive or baseline mode. The original is kept when jpegtran fails or
+// doesn't make it smaller.
+func optimizeJPEG(data []byte) []byte {
+	if jpegtranPath == "" {
+		return data
+	}
+	atomic.AddInt64(&jpegOptimizeTotal, 1)
+	atomic.AddInt64(&jpegOptimizeInput, int64(len(data)))
+	args := []string{"-copy", "all", "-optimize"}
+	if progressiveJPEG() {
+		args = append(args, "-progressive")
+	}
+	cmd := exec.Command(jpegtranPath, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		logWarn("jpegtran failed, keeping the unoptimized JPEG: %v: %s", err, strings.TrimSpace(stderr.String()))
+		return data
+	}
+	optimized := stdout.Bytes()
+	if len(optimized) == 0 || len(optimized) >= len(data) {
+		return data
+	}
+	atomic.AddInt64(&jpegOptimizeCount, 1)
+	atomic.AddInt64(&jpegOptimizeSaved, int64(len(data)-len(optimized)))
+	return optimized
+}
+
+// logJPEGOptimizeSummary reports what -jpeg-optimize saved on top of the encoder's output
+func logJPEGOptimizeSummary() {
+	total := atomic.LoadInt64(&jpegOptimizeTotal)
+	count := atomic.LoadInt64(&jpegOptimizeCount)
+	saved := atomic.LoadInt64(&jpegOptimizeSaved)
+	var percentage float64
+	if input := atomic.LoadInt64(&jpegOptimizeInput); input > 0 {
+		percentage = float64(saved) / float64(input) * 100
+	}
+	logInfo("JPEG optimization: %d of %d JPEG(s) smaller, %.2f MB (%.1f%%) saved", count, total, float64(saved)/(1024*1024), percentage)
+}
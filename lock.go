@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// runLockName is the lock file in the output directory held for the whole run, so two runs
+// (e.g. overlapping cron jobs) can't rewrite the same progress file at once
+const runLockName = ".batchmedia.lock"
+
+// errLocked is returned by lockRunFile when another process holds the lock
+var errLocked = errors.New("lock held by another process")
+
+// runLock keeps the lock file open; the lock lasts as long as the process, however it ends
+var runLock *os.File
+
+// acquireRunLock takes the output directory's lock or reports which run holds it. The lock is
+// an OS file lock rather than the file's existence, so the kernel drops it when the process
+// exits for any reason, including signals and crashes, and a stale file never blocks a run.
+func acquireRunLock(outputDir string) error {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+	path := filepath.Join(outputDir, runLockName)
+	file, err := lockRunFile(path)
+	if errors.Is(err, errLocked) {
+		holder := "another batchMedia run"
+		if data, err := os.ReadFile(path); err == nil {
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+				holder = fmt.Sprintf("another batchMedia run (pid %d)", pid)
+			}
+		}
+		return fmt.Errorf("%s is already using output directory %s; wait for it to finish (lock: %s)", holder, outputDir, path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock output directory: %v", err)
+	}
+
+	// Record the holder for the message above; the lock itself doesn't depend on it
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	runLock = file
+	return nil
+}
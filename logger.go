@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel controls which messages are written by the logger
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the tag printed in front of each message
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "UNKNOWN"
+}
+
+// parseLogLevel converts a -log-level value to a LogLevel
+func parseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("invalid -log-level %q: must be debug, info, warn or error", s)
+}
+
+// Logger state; messages go to stderr so stdout stays free for machine output
+var logLevel = LevelInfo
+var logOutput io.Writer = os.Stderr
+var logMutex sync.Mutex
+
+// logFile receives a copy of every log line when -log-file is set
+var logFile *os.File
+
+// logf writes a single timestamped line if the level is enabled
+func logf(level LogLevel, format string, args ...interface{}) {
+	if level < logLevel {
+		return
+	}
+	message := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	line := fmt.Sprintf("%s %-5s %s\n", time.Now().Format("2006-01-02 15:04:05"), level, message)
+
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	io.WriteString(logOutput, line)
+	if logFile != nil {
+		io.WriteString(logFile, line)
+	}
+}
+
+func logDebug(format string, args ...interface{}) { logf(LevelDebug, format, args...) }
+func logInfo(format string, args ...interface{})  { logf(LevelInfo, format, args...) }
+func logWarn(format string, args ...interface{})  { logf(LevelWarn, format, args...) }
+func logError(format string, args ...interface{}) { logf(LevelError, format, args...) }
+
+// openLogFile opens the -log-file target in append (or truncate) mode and writes a run banner
+func openLogFile(path string, truncate bool) error {
+	mode := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if truncate {
+		mode = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, mode, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+
+	// Delimit each run with a banner listing the full configuration
+	var banner strings.Builder
+	fmt.Fprintf(&banner, "===== batchMedia run started %s =====\n", time.Now().Format("2006-01-02 15:04:05"))
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(&banner, "  -%s=%s\n", f.Name, f.Value.String())
+	})
+	if _, err := io.WriteString(file, banner.String()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write log file: %v", err)
+	}
+
+	logMutex.Lock()
+	logFile = file
+	logMutex.Unlock()
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// longPath is a no-op outside Windows, where there is no MAX_PATH limit
+func longPath(path string) string {
+	return path
+}
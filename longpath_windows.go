@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPath converts a path to the \\?\ form so Windows APIs accept paths over 260 characters.
+// UNC shares (\\server\share) become \\?\UNC\server\share.
+func longPath(path string) string {
+	if path == "" || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -1,30 +1,119 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"html"
 	"io/ioutil"
-	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"batchMedia/pkg/media"
 )
 
 type Config struct {
+	ConfigFile       string `json:"-"` // JSON or YAML file with Config field values, overridden by flags
+	Preset           string `json:"-"` // Named output profile (web, archive, thumbnail, social) applied before the config file
+	ListFormats      bool   `json:"-"` // Print the supported input and output formats and exit
+	Compare          string `json:"-"` // Previous report.json to diff against the one in OutputDir, then exit
 	InputDir         string
 	OutputDir        string
+	OutputSuffix     string // Inserted before the extension of every output file (e.g. "_compressed")
+	OutputTemplate   string // Names image outputs from tokens such as {date}_{name}_{width}w (empty = input names)
+	OutputSameAsInput bool  // Replace the originals in place (requires BackupDir and IKnowThisOverwrites)
+	BackupDir        string // Where in-place mode keeps the originals, mirroring the input tree
+	IKnowThisOverwrites bool // Confirms that in-place mode may overwrite the input files
+	Dedupe           bool   // Process identical inputs once and link the others to the first output
+	DedupeLink       string // How -dedupe links duplicates: "hard" or "symlink"
+	KeepFormat       bool   // Re-encode images in their original format instead of JPEG
+	ExtSettings      string // Per-extension encoding overrides, e.g. "heic:quality=90,png:format=keep"
+	HEICAllImages    bool   // Export every image and the motion video of multi-image HEICs
+	RawMode          string // Camera RAW handling: copy, skip, or preview (embedded JPEG)
+	PNGCompression   string // PNG compression level: default, none, fast, best
+	PNGPalette       int    // Quantize PNG output to this many colors (0 = off, 2-256)
+	EXIFThumbnail    string // Embedded EXIF thumbnail handling: keep, strip, or regenerate
+	Since            string // Only process files modified at or after this RFC3339 time or duration ago
+	SinceMode        string // What to do with older files: ignore or copy
+	Order            string // Directory processing order: deep-first, shallow-first, alpha, size
+	AdaptiveQuality  bool   // Scale JPEG/HEIC quality with the resize ratio between QualityFloor and QualityCeiling
+	QualityFloor     int    // Adaptive quality for the most heavily downscaled images
+	QualityCeiling   int    // Adaptive quality for images that keep their size
+	JPEGProgressive  bool   // Write progressive JPEGs (needs a build with -tags libjpeg, else baseline)
+	JPEGOptimize     bool   // Losslessly optimize JPEG outputs with jpegtran when it is on PATH
+	MinSavings       float64 // Keep the original unless output/input size is at most this ratio (0 = off)
+	PreferSmaller    bool   // Keep the original when it is already the output size and smaller than the re-encoded image
+	OrientationMode  string // EXIF orientation handling: rotate-pixels, keep (ignore the tag), tag-only (leave it to viewers)
+	Rotate           int    // Forced clockwise rotation after EXIF correction: 0, 90, 180, 270
+	CropAspect       string // Crop to this aspect ratio (W:H) before resizing, empty = no crop
+	CropMode         string // Crop window placement: center or smart (highest entropy)
+	Sharpen          float64 // Unsharp mask amount applied after resizing (0 = off)
+	SharpenAll       bool   // Also sharpen images that were not downscaled
+	Brightness       float64 // Brightness offset (-1 to 1, 0 = unchanged)
+	Contrast         float64 // Contrast adjustment (-1 to 1, 0 = unchanged)
+	Saturation       float64 // Saturation adjustment (-1 to 1, 0 = unchanged, -1 = grayscale)
+	Border           string // Border width in pixels ("20") or percent of the shorter side ("2%")
+	BorderColor      string // Border color (#RRGGBB, white, black, gray)
+	Caption          string // Burn a caption into outputs: none, date (EXIF/creation time), filename or text
+	CaptionText      string // Caption for -caption text
+	CaptionPosition  string // Caption corner: bottom-right, bottom-left, top-right, top-left
+	CaptionSize      int    // Caption font size in pixels (0 = 3% of the output height)
+	CaptionColor     string // Caption color (#RRGGBB, white, black, gray)
+	VerifyCopies     bool   // Compare source and destination checksums after copying
+	VerifyOutput     bool   // Re-decode written images and re-probe written videos, failing the file if they don't read back
+	VerifyOutputDelete bool // Also delete outputs that fail -verify-output
+	QualityAnalysis  bool    // Compute SSIM and PSNR between each resized image and its decoded output
+	MinSSIM          float64 // Warn about outputs whose SSIM is below this (0 = no warning); needs -quality-analysis
+	MinResolution    string // Process only images at least this large (WxH), copying smaller ones; independent of the thresholds
+	OrientationFilter string // Process only landscape, portrait or square images (all = no filter); others are copied unchanged
+	ShiftTime        string // Shift EXIF DateTime/DateTimeOriginal/DateTimeDigitized and the mtime of processed images (e.g. 1h30m, -26h)
+	IgnoreErrors     bool   // Mark directories completed even if some files failed
+	KeepGoingOnDecodeError bool // Copy images that can't be decoded unchanged instead of failing them
+	CSVReport        bool   // Write per-file results to OutputDir/report.csv
+	JSONReport       bool   // Write the effective settings and per-file results to OutputDir/report.json
+	Manifest         bool   // Keep OutputDir/manifest.json, mapping each output to its input, up to date as files complete
+	Thumbnails       string // Also write image previews fitting WxH to OutputDir/.thumbnails for the reports
+	EmbedThumbnails  bool   // Inline small previews in the HTML reports as data URIs
+	Archive          string // Write all outputs and reports into this .zip or .tar instead of -out
+	S3Endpoint       string // Endpoint of S3-compatible storage for -out s3://... (empty = AWS)
+	S3Region         string // Region for -out s3://... (empty = AWS_REGION or the shared config, else us-east-1)
+	S3Progress       bool   // Keep progress.json in the bucket too, so runs on other machines resume
+	Serve            string // Listen address of the HTTP resize API (e.g. :8080); empty = batch mode
+	ServeConcurrency int    // Images -serve converts at once; further requests wait
+	ServeMaxUploadMB int    // Largest upload -serve accepts, in MB
+	Watch            bool   // After the batch, keep processing files as they appear in -inputdir
+	WatchSettle      string // How long a new file's size must stay unchanged before -watch processes it
+	FailFast         bool   // Abort the run on the first file failure
+	Retries          int    // Retries with exponential backoff for transient I/O and ffmpeg failures
+	PerFileTimeout   string // Give up on a file after this duration (e.g. 10m), killing its ffmpeg/libheif subprocesses; empty = no limit
+	TmpDir           string // Where outputs are written before being renamed into place (default: beside the output)
 	ScalingRatio     float64
 	Width            int
+	Height           int    // Target height in pixels; with Width the output is fitted to the WxH box
+	Fit              string // How a -width x -height box is filled: contain, cover (crop) or stretch
+	AllowUpscale     bool   // Allow targets larger than the source; otherwise images keep their original size
+	Sizes            string // Comma-separated widths for a responsive image set, one output per width
 	ThresholdWidth   int
 	ThresholdHeight  int
 	IgnoreSmartLimit bool
+	MaxPixels        int    // Images declaring more pixels than this are copied instead of decoded (0 = no limit)
 	// File filtering options
 	Extensions       string // Comma-separated list of extensions to process
 	FakeScan         bool   // Only scan and list files to be processed, don't actually process
+	ResetProgress    bool   `json:"-"` // Delete this run's progress file at startup to reprocess everything
+	ResetProgressDir string `json:"-"` // Mark one directory as not completed in the progress file
+	IncludeHidden    bool   // Also scan dot-directories (._ resource forks are always skipped)
+	MaxDepth         int    // Directory levels below InputDir to scan (0 = only InputDir, -1 = unlimited)
+	FollowSymlinks   bool   // Scan symlinked directories, skipping any directory already scanned
+	SidecarExtensions string // Comma-separated sidecar extensions (e.g. "xmp,aae") copied and renamed with their media
 	// Video processing options
 	VideoDisabled    bool
 	VideoCodec       string
@@ -32,8 +121,29 @@ type Config struct {
 	VideoResolution  string
 	VideoCRF         int
 	VideoPreset      string
+	VideoTwoPass     bool   // Two-pass encoding when a target bitrate is set (ignored in CRF mode)
+	VideoHWAccel     string // Hardware encoder family: videotoolbox, nvenc, qsv, or none
+	VideoTimeout     string // Kill ffmpeg and skip a video after this duration (e.g. 30m); empty = no limit
+	VideoScaleFlags  string // ffmpeg scale algorithm: bilinear, bicubic, lanczos, spline; empty = ffmpeg default
+	VideoThumbnails  bool   // Extract a poster frame next to each processed video
+	ThumbnailAt      string // Poster frame timestamp, defaults to 10% of the duration
+	// Audio processing options
+	AudioCodec       string // Audio codec for videos, "copy" keeps the original stream
+	AudioBitrate     string
+	AudioDisable     bool   // Strip audio from processed videos
+	AudioChannels    string // Channels of re-encoded audio: "keep", "stereo", or "copy" to never re-encode
+	StripSubtitles   bool   // Leave subtitle tracks out of processed videos
+	RotateVideo      int    // Forced clockwise rotation of videos after their rotation metadata: 0, 90, 180, 270
 	// Multithreading options
-	Multithread      int    // Number of concurrent threads for processing multiple directories
+	Multithread      int    // Number of concurrent threads for processing multiple directories (0 = one per CPU core)
+	VideoJobs        int    // Videos encoded at once across all threads (0 = one per 4 CPU cores)
+	VideoThreads     int    // Threads each ffmpeg encode may use (0 = CPU cores divided by VideoJobs)
+	MaxInflightBytes string // Cap on the total input size of directories processed at once (e.g. 4G); empty = count-based only
+	LogLevel         string // Minimum log level: debug, info, warn, error
+	Quiet            bool   // Only log errors (shorthand for -log-level error)
+	LogFile          string // Also append log output to this file
+	LogTruncate      bool   // Truncate the log file instead of appending
+	ProgressBar      bool   // Show an in-place progress bar instead of per-file lines (TTY only)
 }
 
 // DirectoryProgress represents the processing progress of a directory
@@ -76,51 +186,137 @@ func (pt *ProgressTracker) saveProgress(progressFile string) error {
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(progressFile, data, 0644)
+	// Write a temp file and rename it over the old one, so a crash or full disk mid-write
+	// leaves the previous progress intact instead of a truncated file
+	if err := writeFileAtomic(progressFile, data); err != nil {
+		return err
+	}
+	return mirrorProgress(progressFile, data)
 }
 
-// scanDirectories recursively scans for all directories to process
+// scanDirectories recursively scans for all directories to process, ordered by -order
 func scanDirectories(inputDir string) ([]string, error) {
 	var directories []string
+	sizes := make(map[string]int64) // Bytes of files directly inside each directory, for -order size
 	
-	err := filepath.Walk(inputDir, func(path string, info os.FileInfo, err error) error {
+	// With -follow-symlinks every scanned directory is remembered by its identity (device and
+	// inode on Unix) and skipped when reached again, so a link to an ancestor can't loop forever
+	// and two links to one directory don't process it twice
+	visitedDirs := make(map[fileID]bool)
+	scannedBefore := func(path string, info os.FileInfo) bool {
+		id, err := fileIdentity(path, info)
+		if err != nil {
+			return false // Directories that can't be identified are scanned
+		}
+		if visitedDirs[id] {
+			return true
+		}
+		visitedDirs[id] = true
+		return false
+	}
+	
+	var walkFn filepath.WalkFunc
+	walkFn = func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		
 		// Skip the root input directory itself
 		if path == inputDir {
+			if config.FollowSymlinks {
+				scannedBefore(path, info)
+			}
 			return nil
 		}
+		path = filepath.Clean(path)
+		
+		// Symlinked directories are skipped unless -follow-symlinks; filepath.Walk never
+		// descends into a link, but walking it with a trailing separator resolves it while the
+		// paths beneath keep the link's name. Links to files count as their target.
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			if err != nil {
+				return nil // Broken link, reported when its directory is listed
+			}
+			if target.IsDir() {
+				if !config.FollowSymlinks {
+					return nil
+				}
+				return filepath.Walk(path+string(filepath.Separator), walkFn)
+			}
+			info = target
+		}
 		
-		// Skip hidden directories
-		if info.IsDir() && strings.HasPrefix(info.Name(), ".") {
+		// Skip hidden directories unless -include-hidden; netatalk's .AppleDouble resource
+		// fork directories never hold real media and are always skipped
+		if info.IsDir() && (info.Name() == ".AppleDouble" ||
+			(!config.IncludeHidden && strings.HasPrefix(info.Name(), "."))) {
+			return filepath.SkipDir
+		}
+		
+		// Never descend into our own output directory
+		if info.IsDir() && excludedScanDir != "" && path == excludedScanDir {
+			return filepath.SkipDir
+		}
+		
+		// Skip directories nested deeper than -max-depth levels, with everything beneath them
+		if info.IsDir() && config.MaxDepth >= 0 {
+			if rel, err := filepath.Rel(inputDir, path); err == nil && directoryDepth(rel)+1 > config.MaxDepth {
+				return filepath.SkipDir
+			}
+		}
+		
+		if info.IsDir() && config.FollowSymlinks && scannedBefore(path, info) {
+			logDebug("Skipping already scanned directory: %s", path)
 			return filepath.SkipDir
 		}
 		
 		// Add all directories (including nested ones)
 		if info.IsDir() {
 			directories = append(directories, path)
+		} else {
+			sizes[filepath.Dir(path)] += info.Size()
 		}
 		
 		return nil
-	})
+	}
 	
-	if err != nil {
+	if err := filepath.Walk(inputDir, walkFn); err != nil {
 		return nil, err
 	}
 	
-	// Sort directories to process from deepest to shallowest
-	// This ensures we process leaf directories first
-	sort.Slice(directories, func(i, j int) bool {
-		depthI := strings.Count(directories[i], string(filepath.Separator))
-		depthJ := strings.Count(directories[j], string(filepath.Separator))
-		return depthI > depthJ // Deeper directories first
-	})
-	
+	sortDirectories(directories, sizes)
 	return directories, nil
 }
 
+// directoryDepth counts the separators in a directory path
+func directoryDepth(dir string) int {
+	return strings.Count(dir, string(filepath.Separator))
+}
+
+// sortDirectories orders the scanned directories according to -order
+func sortDirectories(directories []string, sizes map[string]int64) {
+	switch config.Order {
+	case "shallow-first":
+		sort.SliceStable(directories, func(i, j int) bool {
+			return directoryDepth(directories[i]) < directoryDepth(directories[j])
+		})
+	case "alpha":
+		sort.Strings(directories)
+	case "size":
+		// Largest directories first to surface the biggest savings early
+		sort.SliceStable(directories, func(i, j int) bool {
+			return sizes[directories[i]] > sizes[directories[j]]
+		})
+	default:
+		// Sort directories to process from deepest to shallowest
+		// This ensures we process leaf directories first
+		sort.Slice(directories, func(i, j int) bool {
+			return directoryDepth(directories[i]) > directoryDepth(directories[j]) // Deeper directories first
+		})
+	}
+}
+
 // markDirectoryCompleted marks a directory as completed in the progress tracker
 func (pt *ProgressTracker) markDirectoryCompleted(dirPath string) {
 	for i := range pt.Directories {
@@ -132,6 +328,21 @@ func (pt *ProgressTracker) markDirectoryCompleted(dirPath string) {
 	}
 }
 
+// resetDirectory marks a directory as not completed. The path may be given as stored (as
+// scanned, including -inputdir) or relative to -inputdir; it reports whether it was found.
+func (pt *ProgressTracker) resetDirectory(dirPath string) bool {
+	candidates := []string{filepath.Clean(dirPath), filepath.Join(config.InputDir, dirPath)}
+	for i := range pt.Directories {
+		stored := filepath.Clean(pt.Directories[i].Path)
+		if stored == candidates[0] || stored == candidates[1] {
+			pt.Directories[i].Completed = false
+			pt.Directories[i].Timestamp = ""
+			return true
+		}
+	}
+	return false
+}
+
 // getUncompletedDirectories returns directories that haven't been completed
 func (pt *ProgressTracker) getUncompletedDirectories() []string {
 	var uncompleted []string
@@ -148,6 +359,9 @@ type ProcessStats struct {
 	ProcessedImages  int
 	CopiedFiles      int
 	SkippedImages    int
+	ProcessedVideos  int
+	SkippedVideos    int
+	DuplicateFiles   int // Inputs linked to an earlier identical file by -dedupe
 	TotalInputSize   int64
 	TotalOutputSize  int64
 	ProcessingTime   string
@@ -160,6 +374,9 @@ type DirectoryStats struct {
 	ProcessedImages int
 	CopiedFiles     int
 	SkippedImages   int
+	ProcessedVideos int
+	SkippedVideos   int
+	DuplicateFiles  int
 	TotalInputSize  int64
 	TotalOutputSize int64
 	Files           []FileInfo
@@ -168,12 +385,27 @@ type DirectoryStats struct {
 
 type FileInfo struct {
 	Path         string
-	Type         string // "processed", "copied", "skipped"
+	Type         string // "processed", "copied", "skipped", "duplicate"
 	InputSize    int64
 	OutputSize   int64
 	OriginalDim  string
 	NewDim       string
+	CroppedDim       string // Size after -crop-aspect, before resizing (empty when not cropped)
 	CompressionRatio float64
+	AudioHandling    string // Audio handling for videos: "copy", "aac 128k stereo", "stripped", "none"
+	ThumbnailPath    string // Video poster frame or -thumbnails image preview, relative to the output directory
+	EmbeddedThumbnail string `json:"-"` // -embed-thumbnails preview as a data URI, for the HTML report only
+	DuplicateOf      string // -dedupe: input path of the identical file whose outputs were linked
+	Verification     string // Copy checksum status: "sha256 verified", or empty when not checked
+	Variants         []string // -sizes outputs relative to the output directory, smallest first
+	Extracted        []string // Extra outputs split from the source (HEIC burst frames, motion video)
+	RawHandling      string // -raw-mode applied to camera RAW files: "copy", "skip", "preview"
+	Adjustments      string // Color adjustments applied, e.g. "brightness +0.10, contrast +0.20"
+	Encoding         string // Non-default encoding, e.g. "palette 64 colors, best compression" or "quality 74 (adaptive)"
+	SkipReason       string // Why a skipped file was copied unchanged, e.g. "width 1280 below 1920px threshold"
+	Note             string // Why a file was copied instead of processed, e.g. it couldn't be decoded, or what processing left out
+	SSIM             float64 // -quality-analysis: structural similarity of the output to the resized image (1 = identical)
+	PSNR             float64 // -quality-analysis: peak signal-to-noise ratio in dB (100 = lossless)
 }
 
 var config Config
@@ -181,85 +413,484 @@ var stats ProcessStats
 var statsMutex sync.Mutex
 var progressMutex sync.Mutex
 
+// Global file progress across all directories, updated atomically by worker threads
+var filesTotal int64
+var filesDone int64
+
+// Process exit codes
+const (
+	exitSuccess        = 0 // All files processed
+	exitPartialFailure = 1 // Some files failed to process
+	exitConfigError    = 2 // Invalid arguments or startup failure
+)
+
+// fatalConfig reports a configuration or startup error and exits with exitConfigError
+func fatalConfig(format string, args ...interface{}) {
+	logError(format, args...)
+	os.Exit(exitConfigError)
+}
+
+// FileError records a single file that failed to process
+type FileError struct {
+	Path string
+	Dir  string
+	Err  error
+}
+
+var fileErrors []FileError
+var errorsMutex sync.Mutex
+
+// recordFileError prints and remembers a per-file failure for the final summary
+func recordFileError(dir, path string, err error) {
+	logError("Error processing %s: %v", path, err)
+	errorsMutex.Lock()
+	fileErrors = append(fileErrors, FileError{Path: path, Dir: dir, Err: err})
+	errorsMutex.Unlock()
+
+	// Abort the whole run on the first failure; progress.json keeps the directory incomplete
+	if config.FailFast {
+		stopProgressBar()
+		printErrorSummary()
+		logError("Aborting: -fail-fast is set")
+		os.Exit(exitPartialFailure)
+	}
+}
+
+// directoryHasErrors reports whether any file in the directory failed
+func directoryHasErrors(dir string) bool {
+	errorsMutex.Lock()
+	defer errorsMutex.Unlock()
+	for _, fe := range fileErrors {
+		if fe.Dir == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldMarkCompleted decides whether a directory can be marked completed in progress.json
+func shouldMarkCompleted(dir string) bool {
+	if !directoryHasErrors(dir) || config.IgnoreErrors {
+		return true
+	}
+	logWarn("Not marking directory %s as completed: some files failed (use -ignore-errors to override)", dir)
+	return false
+}
+
+// printErrorSummary lists all failed files and returns the number of failures
+func printErrorSummary() int {
+	errorsMutex.Lock()
+	defer errorsMutex.Unlock()
+	if len(fileErrors) == 0 {
+		return 0
+	}
+	logError("%d file(s) failed:", len(fileErrors))
+	for _, fe := range fileErrors {
+		logError("  %s: %v", fe.Path, fe.Err)
+	}
+	return len(fileErrors)
+}
+
 func init() {
 	stats.DirectoryStats = make(map[string]*DirectoryStats)
 	
 	// Core parameters (most commonly used)
-	flag.StringVar(&config.InputDir, "inputdir", "", "Input directory path (required)")
-	flag.StringVar(&config.OutputDir, "out", "", "Output directory path (required)")
+	flag.StringVar(&config.ConfigFile, "config", "", "Load settings from a JSON or YAML file keyed by Config field names; command-line flags override it")
+	flag.StringVar(&config.Preset, "preset", "", "Apply a named output profile (web, archive, thumbnail, social); the config file and explicit flags override its values")
+	flag.BoolVar(&config.ListFormats, "list-formats", false, "Print the supported input extensions and available output formats, then exit")
+	flag.StringVar(&config.InputDir, "inputdir", "", "Input directory path, or a single file to process (required)")
+	flag.StringVar(&config.OutputDir, "out", "", "Output directory path, s3://bucket/prefix, or the output file for a single input file (required)")
 	flag.Float64Var(&config.ScalingRatio, "size", 0, "Scaling ratio (e.g., 0.5 means scale to 50%)")
-	flag.IntVar(&config.Multithread, "multithread", 1, "Number of concurrent threads for processing multiple directories (default: 1)")
+	flag.IntVar(&config.Multithread, "multithread", 0, "Number of concurrent threads for processing multiple directories (default: 0 = one per CPU core)")
+	flag.IntVar(&config.VideoJobs, "video-jobs", 0, "Videos encoded at once across all threads, as ffmpeg already uses several cores per video (default: 0 = one per 4 CPU cores)")
+	flag.IntVar(&config.VideoThreads, "video-threads", 0, "Threads each ffmpeg encode may use (default: 0 = CPU cores divided by -video-jobs)")
+	flag.StringVar(&config.MaxInflightBytes, "max-inflight-bytes", "", "Limit the total input size of directories processed at once, e.g. 4G (K, M, G, T suffixes; default: no limit)")
+	flag.BoolVar(&config.ProgressBar, "progress-bar", false, "Show an overall progress bar with per-thread status, space saved and ETA (terminal only)")
+	flag.StringVar(&config.LogLevel, "log-level", "info", "Minimum log level written to stderr (debug, info, warn, error)")
+	flag.BoolVar(&config.Quiet, "quiet", false, "Only log errors (shorthand for -log-level error)")
+	flag.StringVar(&config.LogFile, "log-file", "", "Also append log output to this file, with a banner per run")
+	flag.BoolVar(&config.LogTruncate, "log-truncate", false, "Truncate the -log-file at startup instead of appending")
 	
 	// Image processing parameters
 	flag.IntVar(&config.Width, "width", 0, "Target width (pixels)")
+	flag.IntVar(&config.Height, "height", 0, "Target height (pixels); with -width, the WxH box the output is fitted to (see -fit)")
+	flag.StringVar(&config.Fit, "fit", "contain", "How -width and -height fill their box: contain (fit inside), cover (fill and crop) or stretch (exact size, distorted)")
 	flag.IntVar(&config.ThresholdWidth, "threshold-width", 0, "Width threshold (default: 1920 for downscaling, 3840 for upscaling)")
 	flag.IntVar(&config.ThresholdHeight, "threshold-height", 0, "Height threshold (default: 1080 for downscaling, 2160 for upscaling)")
 	flag.BoolVar(&config.IgnoreSmartLimit, "ignore-smart-limit", false, "Ignore smart default resolution limits")
+	flag.IntVar(&config.MaxPixels, "max-pixels", 250000000, "Copy images larger than this many pixels unchanged instead of decoding them (0 = no limit)")
+	flag.BoolVar(&config.KeepFormat, "keep-format", false, "Keep the original image format (HEIC stays HEIC, PNG stays PNG) instead of converting to JPEG")
+	flag.StringVar(&config.ExtSettings, "ext-settings", "", "Per-extension overrides of quality (1-100) and format (jpeg, keep), e.g. heic:quality=90,png:format=keep; they take precedence over the global flags")
+	flag.StringVar(&config.PNGCompression, "png-compression", "default", "PNG compression level (default, none, fast, best)")
+	flag.IntVar(&config.PNGPalette, "png-palette", 0, "Quantize PNG output to an indexed palette of this many colors with dithering (2-256, 0 = off)")
+	flag.StringVar(&config.EXIFThumbnail, "exif-thumbnail", "keep", "Embedded EXIF thumbnail handling (keep, strip, regenerate from the resized image)")
+	flag.StringVar(&config.Since, "since", "", "Only process files modified since an RFC3339 timestamp or a duration ago (e.g. 2024-01-02T15:04:05Z or 24h)")
+	flag.StringVar(&config.SinceMode, "since-mode", "ignore", "What to do with files older than -since (ignore, copy)")
+	flag.StringVar(&config.Order, "order", "deep-first", "Directory processing order (deep-first, shallow-first, alpha, size = largest first)")
+	flag.BoolVar(&config.AdaptiveQuality, "adaptive-quality", false, "Scale JPEG/HEIC quality with the resize ratio between -quality-floor and -quality-ceiling")
+	flag.IntVar(&config.QualityFloor, "quality-floor", 70, "Lowest quality used by -adaptive-quality (1-100)")
+	flag.IntVar(&config.QualityCeiling, "quality-ceiling", 85, "Highest quality used by -adaptive-quality (1-100)")
+	flag.BoolVar(&config.JPEGOptimize, "jpeg-optimize", false, "Losslessly shrink JPEG outputs by optimizing their Huffman tables with jpegtran (libjpeg-turbo or mozjpeg), if on PATH")
+	flag.BoolVar(&config.JPEGProgressive, "jpeg-progressive", false, "Write progressive JPEGs, which load gradually on the web (needs a build with -tags libjpeg; baseline otherwise)")
+	flag.Float64Var(&config.MinSavings, "min-savings", 0, "Keep the original image if output/input size exceeds this ratio (e.g. 0.95 requires 5% savings, 0 = off)")
+	flag.BoolVar(&config.PreferSmaller, "prefer-smaller", false, "Write whichever of the original and the re-encoded image is smaller; images that need resizing are always re-encoded")
+	flag.StringVar(&config.OrientationMode, "orientation-mode", "rotate-pixels", "EXIF orientation handling (rotate-pixels, keep = ignore the tag and reset it, tag-only = keep pixels and the tag)")
+	flag.IntVar(&config.Rotate, "rotate", 0, "Rotate processed images clockwise by 0, 90, 180 or 270 degrees after EXIF auto-orientation")
+	flag.BoolVar(&config.AllowUpscale, "allow-upscale", false, "Allow enlarging images beyond their original size (otherwise they keep their original size)")
+	flag.StringVar(&config.Sizes, "sizes", "", "Write one output per width with a _<width>w suffix (comma-separated, e.g. 320,640,1280); widths above the source are skipped")
+	flag.StringVar(&config.CropAspect, "crop-aspect", "", "Crop processed images to an aspect ratio before resizing (e.g. 1:1, 16:9)")
+	flag.StringVar(&config.CropMode, "crop-mode", "center", "Crop window placement for -crop-aspect (center, smart = most detailed region)")
+	flag.Float64Var(&config.Sharpen, "sharpen", 0, "Unsharp mask amount applied after downscaling (0 = off, typical 0.3-1.0)")
+	flag.BoolVar(&config.SharpenAll, "sharpen-all", false, "Also sharpen images that were not downscaled")
+	flag.Float64Var(&config.Brightness, "brightness", 0, "Brightness adjustment (-1 to 1, 0 = unchanged)")
+	flag.Float64Var(&config.Contrast, "contrast", 0, "Contrast adjustment (-1 to 1, 0 = unchanged)")
+	flag.Float64Var(&config.Saturation, "saturation", 0, "Saturation adjustment (-1 to 1, 0 = unchanged, -1 = grayscale)")
+	flag.StringVar(&config.Border, "border", "", "Add a solid border after resizing, in pixels (e.g. 20) or percent of the shorter side (e.g. 2%)")
+	flag.StringVar(&config.BorderColor, "border-color", "white", "Border color (#RRGGBB, white, black, gray)")
+	flag.StringVar(&config.Caption, "caption", "none", "Burn a caption into images and videos (none, date, filename, text)")
+	flag.StringVar(&config.CaptionText, "caption-text", "", "Caption for -caption text")
+	flag.StringVar(&config.CaptionPosition, "caption-position", "bottom-right", "Caption corner (bottom-right, bottom-left, top-right, top-left)")
+	flag.IntVar(&config.CaptionSize, "caption-size", 0, "Caption font size in pixels (0 = 3% of the output height)")
+	flag.StringVar(&config.CaptionColor, "caption-color", "white", "Caption color (#RRGGBB, white, black, gray)")
+	flag.StringVar(&config.RawMode, "raw-mode", "copy", "Camera RAW handling (copy, skip, preview): preview resizes the embedded JPEG")
+	flag.BoolVar(&config.HEICAllImages, "heic-all-images", false, "Also export every image of multi-image HEICs (bursts) with a numbered suffix, plus any embedded motion video")
 	
 	// File filtering parameters
 	flag.StringVar(&config.Extensions, "ext", "", "Process only files with specified extensions (comma-separated, e.g., heic,jpg,png)")
 	flag.BoolVar(&config.FakeScan, "fake-scan", false, "Only scan and list files to be processed, don't actually process them")
+	flag.BoolVar(&config.ResetProgress, "reset-progress", false, "Delete the progress file for this -out and -ext at startup and reprocess everything")
+	flag.StringVar(&config.ResetProgressDir, "reset-progress-dir", "", "Mark one directory (as scanned, or relative to -inputdir) as not completed so only it is reprocessed")
+	flag.StringVar(&config.SidecarExtensions, "sidecar-extensions", "", "Copy sidecar files with these extensions (comma-separated, e.g. xmp,aae) along with their media, renamed to match its output")
+	flag.BoolVar(&config.IncludeHidden, "include-hidden", false, "Also process dot-directories such as .photos (._ resource forks and .AppleDouble are always skipped)")
+	flag.BoolVar(&config.FollowSymlinks, "follow-symlinks", false, "Scan symlinked directories too, skipping directories already scanned so link cycles end")
+	flag.IntVar(&config.MaxDepth, "max-depth", -1, "Scan at most this many directory levels below -inputdir (0 = only its own files, -1 = unlimited)")
+	flag.BoolVar(&config.VerifyCopies, "verify-copies", false, "Verify copied files with a SHA-256 checksum and remove mismatched outputs")
+	flag.BoolVar(&config.VerifyOutput, "verify-output", false, "Re-decode written images and re-probe written videos, failing files that don't read back")
+	flag.BoolVar(&config.VerifyOutputDelete, "verify-output-delete", false, "Delete outputs that fail -verify-output")
+	flag.BoolVar(&config.QualityAnalysis, "quality-analysis", false, "Compute SSIM and PSNR between each resized image and its encoded output and add them to the reports")
+	flag.Float64Var(&config.MinSSIM, "min-ssim", 0, "Warn about images whose SSIM is below this (e.g. 0.95); requires -quality-analysis")
+	flag.StringVar(&config.MinResolution, "min-resolution", "", "Process only images at least WxH (e.g. 1920x1080), copying smaller ones unchanged; independent of the thresholds and scaling direction")
+	flag.StringVar(&config.OrientationFilter, "orientation-filter", "all", "Process only images of one shape (landscape, portrait, square, all); others are copied unchanged")
+	flag.StringVar(&config.ShiftTime, "shift-time", "", "Shift the EXIF dates and modification time of processed images by a duration (e.g. 1h30m, -26h) to fix a wrong camera clock")
+	flag.BoolVar(&config.CSVReport, "csv-report", false, "Write per-file results to report.csv in the output directory")
+	flag.BoolVar(&config.JSONReport, "json-report", false, "Write the effective settings and per-file results, including skip reasons, to report.json in the output directory")
+	flag.StringVar(&config.Compare, "compare", "", "Compare a previous run's report.json (or its output directory) with the report.json in -out, write compare_report.html there and exit without processing")
+	flag.StringVar(&config.Thumbnails, "thumbnails", "", "Also write a JPEG preview fitting WxH (e.g. 200x200) of each image to .thumbnails in the output directory, from the same decode, and show it in the HTML reports")
+	flag.BoolVar(&config.EmbedThumbnails, "embed-thumbnails", false, "Inline small JPEG previews in the HTML reports as base64 data URIs, so a report is one self-contained file")
+	flag.BoolVar(&config.Manifest, "manifest", false, "Keep manifest.json in the output directory, mapping each output to its source, format, dimensions and encoding, updated as files complete")
+	flag.StringVar(&config.S3Endpoint, "s3-endpoint", "", "Endpoint of S3-compatible storage for -out s3://bucket/prefix (e.g. http://localhost:9000 for MinIO)")
+	flag.StringVar(&config.S3Region, "s3-region", "", "Region for -out s3://bucket/prefix (default: AWS_REGION or the AWS shared config, else us-east-1)")
+	flag.BoolVar(&config.S3Progress, "s3-progress", false, "Keep progress.json in the bucket as well as on local disk, so a run on another machine resumes")
+	flag.StringVar(&config.Serve, "serve", "", "Run an HTTP resize API on this address (e.g. :8080) instead of processing -inputdir")
+	flag.IntVar(&config.ServeConcurrency, "serve-concurrency", 4, "Images -serve converts at once; further requests wait for a slot")
+	flag.IntVar(&config.ServeMaxUploadMB, "serve-max-upload-mb", 50, "Largest upload -serve accepts, in MB")
+	flag.BoolVar(&config.Watch, "watch", false, "After processing, keep watching -inputdir and process new and changed files as they appear (Ctrl-C to stop)")
+	flag.StringVar(&config.WatchSettle, "watch-settle", "2s", "How long a new or changed file's size must stay the same before -watch processes it")
+	flag.StringVar(&config.Archive, "archive", "", "Write outputs and reports into this .zip or .tar, keeping the directory structure, instead of loose files in -out")
+	flag.BoolVar(&config.KeepGoingOnDecodeError, "keep-going-on-decode-error", false, "Copy images that can't be decoded (corrupt or mislabeled) unchanged instead of failing them, so the output mirrors the input")
+	flag.BoolVar(&config.IgnoreErrors, "ignore-errors", false, "Mark directories as completed in progress.json even if some files failed")
+	flag.BoolVar(&config.FailFast, "fail-fast", false, "Abort the run on the first file that fails to process")
+	flag.StringVar(&config.TmpDir, "tmp-dir", "", "Directory for in-progress outputs, renamed into place when complete (default: next to each output)")
+	flag.IntVar(&config.Retries, "retries", 2, "Retry transient I/O and ffmpeg failures this many times with exponential backoff (0 = off)")
+	flag.StringVar(&config.PerFileTimeout, "per-file-timeout", "", "Give up on a file that takes longer than this duration, e.g. 10m, killing its ffmpeg or libheif subprocess (default: no limit)")
+	flag.StringVar(&config.OutputSuffix, "output-suffix", "", "Suffix inserted before the output file extension (e.g., _compressed); input files already bearing it are skipped")
+	flag.StringVar(&config.OutputTemplate, "output-template", "", "Name image outputs after a template relative to -out, without extension, e.g. {date}_{name}_{width}w; tokens: "+describeTemplateTokens())
+	flag.BoolVar(&config.OutputSameAsInput, "output-same-as-input", false, "Replace the originals with the processed files in place (-out defaults to -inputdir; requires -backup-dir and -i-know-this-overwrites)")
+	flag.StringVar(&config.BackupDir, "backup-dir", "", "Directory outside the input where -output-same-as-input keeps the originals, mirroring the input tree")
+	flag.BoolVar(&config.IKnowThisOverwrites, "i-know-this-overwrites", false, "Confirm that -output-same-as-input overwrites the input files")
+	flag.BoolVar(&config.Dedupe, "dedupe", false, "Detect identical inputs by SHA-256 and link their outputs to the first result instead of reprocessing")
+	flag.StringVar(&config.DedupeLink, "dedupe-link", "hard", "How -dedupe links duplicate outputs (hard, symlink); falls back to copying if linking fails")
 	
 	// Video processing parameters
 	flag.BoolVar(&config.VideoDisabled, "disable-video", false, "Disable video processing (video processing is enabled by default)")
-	flag.StringVar(&config.VideoCodec, "video-codec", "libx265", "Video codec (libx264, libx265, etc.)")
+	flag.StringVar(&config.VideoCodec, "video-codec", "libx265", "Video codec (libx264, libx265, libvpx-vp9, libaom-av1, etc.)")
 	flag.StringVar(&config.VideoBitrate, "video-bitrate", "", "Video bitrate (e.g., 2M, 1000k)")
 	flag.StringVar(&config.VideoResolution, "video-resolution", "", "Video resolution (e.g., 1920x1080, 1280x720)")
 	flag.IntVar(&config.VideoCRF, "video-crf", 23, "Video CRF quality (0-51, lower is better quality)")
 	flag.StringVar(&config.VideoPreset, "video-preset", "medium", "Video encoding preset (ultrafast, fast, medium, slow, veryslow)")
+	flag.BoolVar(&config.VideoTwoPass, "video-two-pass", false, "Use two-pass encoding to hit -video-bitrate more accurately (ignored in CRF mode)")
+	flag.StringVar(&config.VideoHWAccel, "video-hwaccel", "none", "Hardware-accelerated video encoding (videotoolbox, nvenc, qsv, none)")
+	flag.StringVar(&config.VideoScaleFlags, "video-scale-flags", "", "Scaling algorithm for resized videos (bilinear, bicubic, lanczos, spline; default: ffmpeg's bicubic)")
+	flag.StringVar(&config.VideoTimeout, "video-timeout", "", "Stop ffmpeg and mark a video as failed when it takes longer than this duration, e.g. 30m (default: no limit)")
+	flag.BoolVar(&config.VideoThumbnails, "video-thumbnails", false, "Extract a poster frame (JPEG) next to each processed video for the HTML report")
+	flag.StringVar(&config.ThumbnailAt, "thumbnail-at", "", "Poster frame timestamp in seconds or HH:MM:SS (default: 10% of duration)")
+	flag.IntVar(&config.RotateVideo, "rotate-video", 0, "Rotate processed videos clockwise by 0, 90, 180 or 270 degrees after their rotation metadata is applied")
+	flag.BoolVar(&config.StripSubtitles, "strip-subtitles", false, "Leave subtitle tracks out of processed videos (by default they are kept where the output container supports them)")
+
+	// Audio processing parameters
+	flag.StringVar(&config.AudioCodec, "audio-codec", "copy", "Audio codec for videos (copy, aac, libopus, etc.)")
+	flag.StringVar(&config.AudioBitrate, "audio-bitrate", "", "Audio bitrate when transcoding (e.g., 128k, 192k)")
+	flag.BoolVar(&config.AudioDisable, "audio-disable", false, "Strip audio from processed videos")
+	flag.StringVar(&config.AudioChannels, "audio-channels", "keep", "Channels of re-encoded audio: keep (surround stays surround), stereo (downmix), or copy (never re-encode audio)")
 	
 	// Custom usage function to display parameters in desired order
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nCore Parameters:\n")
-		fmt.Fprintf(os.Stderr, "  -inputdir string\n        Input directory path (required)\n")
-		fmt.Fprintf(os.Stderr, "  -out string\n        Output directory path (required)\n")
+		fmt.Fprintf(os.Stderr, "  -config string\n        Load settings from a JSON or YAML file keyed by Config field names; command-line flags override it\n")
+		fmt.Fprintf(os.Stderr, "  -preset string\n        Apply a named output profile (web, archive, thumbnail, social); the config file and explicit flags override its values\n")
+		fmt.Fprintf(os.Stderr, "  -list-formats\n        Print the supported input extensions and available output formats, then exit\n")
+		fmt.Fprintf(os.Stderr, "  -inputdir string\n        Input directory path, or a single file to process (required)\n")
+		fmt.Fprintf(os.Stderr, "  -out string\n        Output directory path, s3://bucket/prefix, or the output file for a single input file (required)\n")
 		fmt.Fprintf(os.Stderr, "  -size float\n        Scaling ratio (e.g., 0.5 means scale to 50%%)\n")
-		fmt.Fprintf(os.Stderr, "  -multithread int\n        Number of concurrent threads for processing multiple directories (default: 1) (default 1)\n")
+		fmt.Fprintf(os.Stderr, "  -multithread int\n        Number of concurrent threads for processing multiple directories (default: 0 = one per CPU core)\n")
+		fmt.Fprintf(os.Stderr, "  -video-jobs int\n        Videos encoded at once across all threads, as ffmpeg already uses several cores per video (default: 0 = one per 4 CPU cores)\n")
+		fmt.Fprintf(os.Stderr, "  -video-threads int\n        Threads each ffmpeg encode may use (default: 0 = CPU cores divided by -video-jobs)\n")
+		fmt.Fprintf(os.Stderr, "  -max-inflight-bytes string\n        Limit the total input size of directories processed at once, e.g. 4G (K, M, G, T suffixes; default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  -progress-bar\n        Show an overall progress bar with per-thread status, space saved and ETA (terminal only)\n")
+		fmt.Fprintf(os.Stderr, "  -log-level string\n        Minimum log level written to stderr (debug, info, warn, error) (default \"info\")\n")
+		fmt.Fprintf(os.Stderr, "  -quiet\n        Only log errors (shorthand for -log-level error)\n")
+		fmt.Fprintf(os.Stderr, "  -log-file string\n        Also append log output to this file, with a banner per run\n")
+		fmt.Fprintf(os.Stderr, "  -log-truncate\n        Truncate the -log-file at startup instead of appending\n")
 		fmt.Fprintf(os.Stderr, "\nImage Processing Parameters:\n")
 		fmt.Fprintf(os.Stderr, "  -width int\n        Target width (pixels)\n")
+		fmt.Fprintf(os.Stderr, "  -height int\n        Target height (pixels); with -width, the WxH box the output is fitted to (see -fit)\n")
+		fmt.Fprintf(os.Stderr, "  -fit string\n        How -width and -height fill their box: contain (fit inside), cover (fill and crop) or stretch (exact size, distorted) (default \"contain\")\n")
 		fmt.Fprintf(os.Stderr, "  -threshold-width int\n        Width threshold (default: 1920 for downscaling, 3840 for upscaling)\n")
 		fmt.Fprintf(os.Stderr, "  -threshold-height int\n        Height threshold (default: 1080 for downscaling, 2160 for upscaling)\n")
 		fmt.Fprintf(os.Stderr, "  -ignore-smart-limit\n        Ignore smart default resolution limits\n")
+		fmt.Fprintf(os.Stderr, "  -max-pixels int\n        Copy images larger than this many pixels unchanged instead of decoding them (0 = no limit) (default 250000000)\n")
+		fmt.Fprintf(os.Stderr, "  -keep-format\n        Keep the original image format (HEIC stays HEIC, PNG stays PNG) instead of converting to JPEG\n")
+		fmt.Fprintf(os.Stderr, "  -ext-settings string\n        Per-extension overrides of quality (1-100) and format (jpeg, keep), e.g. heic:quality=90,png:format=keep; they take precedence over the global flags\n")
+		fmt.Fprintf(os.Stderr, "  -png-compression string\n        PNG compression level (default, none, fast, best) (default \"default\")\n")
+		fmt.Fprintf(os.Stderr, "  -png-palette int\n        Quantize PNG output to an indexed palette of this many colors with dithering (2-256, 0 = off)\n")
+		fmt.Fprintf(os.Stderr, "  -exif-thumbnail string\n        Embedded EXIF thumbnail handling (keep, strip, regenerate from the resized image) (default \"keep\")\n")
+		fmt.Fprintf(os.Stderr, "  -since string\n        Only process files modified since an RFC3339 timestamp or a duration ago (e.g. 2024-01-02T15:04:05Z or 24h)\n")
+		fmt.Fprintf(os.Stderr, "  -since-mode string\n        What to do with files older than -since (ignore, copy) (default \"ignore\")\n")
+		fmt.Fprintf(os.Stderr, "  -order string\n        Directory processing order (deep-first, shallow-first, alpha, size = largest first) (default \"deep-first\")\n")
+		fmt.Fprintf(os.Stderr, "  -adaptive-quality\n        Scale JPEG/HEIC quality with the resize ratio between -quality-floor and -quality-ceiling\n")
+		fmt.Fprintf(os.Stderr, "  -quality-floor int\n        Lowest quality used by -adaptive-quality (1-100) (default 70)\n")
+		fmt.Fprintf(os.Stderr, "  -quality-ceiling int\n        Highest quality used by -adaptive-quality (1-100) (default 85)\n")
+		fmt.Fprintf(os.Stderr, "  -jpeg-optimize\n        Losslessly shrink JPEG outputs by optimizing their Huffman tables with jpegtran (libjpeg-turbo or mozjpeg), if on PATH\n")
+		fmt.Fprintf(os.Stderr, "  -jpeg-progressive\n        Write progressive JPEGs, which load gradually on the web (needs a build with -tags libjpeg; baseline otherwise)\n")
+		fmt.Fprintf(os.Stderr, "  -min-savings float\n        Keep the original image if output/input size exceeds this ratio (e.g. 0.95 requires 5%% savings, 0 = off)\n")
+		fmt.Fprintf(os.Stderr, "  -prefer-smaller\n        Write whichever of the original and the re-encoded image is smaller; images that need resizing are always re-encoded\n")
+		fmt.Fprintf(os.Stderr, "  -orientation-mode string\n        EXIF orientation handling (rotate-pixels, keep = ignore the tag and reset it, tag-only = keep pixels and the tag) (default \"rotate-pixels\")\n")
+		fmt.Fprintf(os.Stderr, "  -rotate int\n        Rotate processed images clockwise by 0, 90, 180 or 270 degrees after EXIF auto-orientation\n")
+		fmt.Fprintf(os.Stderr, "  -allow-upscale\n        Allow enlarging images beyond their original size (otherwise they keep their original size)\n")
+		fmt.Fprintf(os.Stderr, "  -sizes string\n        Write one output per width with a _<width>w suffix (comma-separated, e.g. 320,640,1280); widths above the source are skipped\n")
+		fmt.Fprintf(os.Stderr, "  -crop-aspect string\n        Crop processed images to an aspect ratio before resizing (e.g. 1:1, 16:9)\n")
+		fmt.Fprintf(os.Stderr, "  -crop-mode string\n        Crop window placement for -crop-aspect (center, smart = most detailed region) (default \"center\")\n")
+		fmt.Fprintf(os.Stderr, "  -sharpen float\n        Unsharp mask amount applied after downscaling (0 = off, typical 0.3-1.0)\n")
+		fmt.Fprintf(os.Stderr, "  -sharpen-all\n        Also sharpen images that were not downscaled\n")
+		fmt.Fprintf(os.Stderr, "  -brightness float\n        Brightness adjustment (-1 to 1, 0 = unchanged)\n")
+		fmt.Fprintf(os.Stderr, "  -contrast float\n        Contrast adjustment (-1 to 1, 0 = unchanged)\n")
+		fmt.Fprintf(os.Stderr, "  -saturation float\n        Saturation adjustment (-1 to 1, 0 = unchanged, -1 = grayscale)\n")
+		fmt.Fprintf(os.Stderr, "  -border string\n        Add a solid border after resizing, in pixels (e.g. 20) or percent of the shorter side (e.g. 2%%)\n")
+		fmt.Fprintf(os.Stderr, "  -border-color string\n        Border color (#RRGGBB, white, black, gray) (default \"white\")\n")
+		fmt.Fprintf(os.Stderr, "  -caption string\n        Burn a caption into images and videos (none, date, filename, text) (default \"none\")\n")
+		fmt.Fprintf(os.Stderr, "  -caption-text string\n        Caption for -caption text\n")
+		fmt.Fprintf(os.Stderr, "  -caption-position string\n        Caption corner (bottom-right, bottom-left, top-right, top-left) (default \"bottom-right\")\n")
+		fmt.Fprintf(os.Stderr, "  -caption-size int\n        Caption font size in pixels (0 = 3%% of the output height)\n")
+		fmt.Fprintf(os.Stderr, "  -caption-color string\n        Caption color (#RRGGBB, white, black, gray) (default \"white\")\n")
+		fmt.Fprintf(os.Stderr, "  -raw-mode string\n        Camera RAW handling (copy, skip, preview): preview resizes the embedded JPEG (default \"copy\")\n")
+		fmt.Fprintf(os.Stderr, "  -heic-all-images\n        Also export every image of multi-image HEICs (bursts) with a numbered suffix, plus any embedded motion video\n")
 		fmt.Fprintf(os.Stderr, "\nFile Filtering Parameters:\n")
 		fmt.Fprintf(os.Stderr, "  -ext string\n        Process only files with specified extensions (comma-separated, e.g., heic,jpg,png)\n")
 		fmt.Fprintf(os.Stderr, "  -fake-scan\n        Only scan and list files to be processed, don't actually process them\n")
+		fmt.Fprintf(os.Stderr, "  -reset-progress\n        Delete the progress file for this -out and -ext at startup and reprocess everything\n")
+		fmt.Fprintf(os.Stderr, "  -reset-progress-dir string\n        Mark one directory (as scanned, or relative to -inputdir) as not completed so only it is reprocessed\n")
+		fmt.Fprintf(os.Stderr, "  -sidecar-extensions string\n        Copy sidecar files with these extensions (comma-separated, e.g. xmp,aae) along with their media, renamed to match its output\n")
+		fmt.Fprintf(os.Stderr, "  -include-hidden\n        Also process dot-directories such as .photos (._ resource forks and .AppleDouble are always skipped)\n")
+		fmt.Fprintf(os.Stderr, "  -follow-symlinks\n        Scan symlinked directories too, skipping directories already scanned so link cycles end\n")
+		fmt.Fprintf(os.Stderr, "  -max-depth int\n        Scan at most this many directory levels below -inputdir (0 = only its own files, -1 = unlimited) (default -1)\n")
+		fmt.Fprintf(os.Stderr, "  -verify-copies\n        Verify copied files with a SHA-256 checksum and remove mismatched outputs\n")
+		fmt.Fprintf(os.Stderr, "  -verify-output\n        Re-decode written images and re-probe written videos, failing files that don't read back\n")
+		fmt.Fprintf(os.Stderr, "  -verify-output-delete\n        Delete outputs that fail -verify-output\n")
+		fmt.Fprintf(os.Stderr, "  -quality-analysis\n        Compute SSIM and PSNR between each resized image and its encoded output and add them to the reports\n")
+		fmt.Fprintf(os.Stderr, "  -min-ssim float\n        Warn about images whose SSIM is below this (e.g. 0.95); requires -quality-analysis\n")
+		fmt.Fprintf(os.Stderr, "  -min-resolution string\n        Process only images at least WxH (e.g. 1920x1080), copying smaller ones unchanged; independent of the thresholds and scaling direction\n")
+		fmt.Fprintf(os.Stderr, "  -orientation-filter string\n        Process only images of one shape (landscape, portrait, square, all); others are copied unchanged (default \"all\")\n")
+		fmt.Fprintf(os.Stderr, "  -shift-time string\n        Shift the EXIF dates and modification time of processed images by a duration (e.g. 1h30m, -26h) to fix a wrong camera clock\n")
+		fmt.Fprintf(os.Stderr, "  -csv-report\n        Write per-file results to report.csv in the output directory\n")
+		fmt.Fprintf(os.Stderr, "  -json-report\n        Write the effective settings and per-file results, including skip reasons, to report.json in the output directory\n")
+		fmt.Fprintf(os.Stderr, "  -compare string\n        Compare a previous run's report.json (or its output directory) with the report.json in -out, write compare_report.html there and exit without processing\n")
+		fmt.Fprintf(os.Stderr, "  -thumbnails string\n        Also write a JPEG preview fitting WxH (e.g. 200x200) of each image to .thumbnails in the output directory, from the same decode, and show it in the HTML reports\n")
+		fmt.Fprintf(os.Stderr, "  -embed-thumbnails\n        Inline small JPEG previews in the HTML reports as base64 data URIs, so a report is one self-contained file\n")
+		fmt.Fprintf(os.Stderr, "  -manifest\n        Keep manifest.json in the output directory, mapping each output to its source, format, dimensions and encoding, updated as files complete\n")
+		fmt.Fprintf(os.Stderr, "  -s3-endpoint string\n        Endpoint of S3-compatible storage for -out s3://bucket/prefix (e.g. http://localhost:9000 for MinIO)\n")
+		fmt.Fprintf(os.Stderr, "  -s3-region string\n        Region for -out s3://bucket/prefix (default: AWS_REGION or the AWS shared config, else us-east-1)\n")
+		fmt.Fprintf(os.Stderr, "  -s3-progress\n        Keep progress.json in the bucket as well as on local disk, so a run on another machine resumes\n")
+		fmt.Fprintf(os.Stderr, "  -serve string\n        Run an HTTP resize API on this address (e.g. :8080) instead of processing -inputdir\n")
+		fmt.Fprintf(os.Stderr, "  -serve-concurrency int\n        Images -serve converts at once; further requests wait for a slot (default 4)\n")
+		fmt.Fprintf(os.Stderr, "  -serve-max-upload-mb int\n        Largest upload -serve accepts, in MB (default 50)\n")
+		fmt.Fprintf(os.Stderr, "  -watch\n        After processing, keep watching -inputdir and process new and changed files as they appear (Ctrl-C to stop)\n")
+		fmt.Fprintf(os.Stderr, "  -watch-settle string\n        How long a new or changed file's size must stay the same before -watch processes it (default \"2s\")\n")
+		fmt.Fprintf(os.Stderr, "  -archive string\n        Write outputs and reports into this .zip or .tar, keeping the directory structure, instead of loose files in -out\n")
+		fmt.Fprintf(os.Stderr, "  -keep-going-on-decode-error\n        Copy images that can't be decoded (corrupt or mislabeled) unchanged instead of failing them, so the output mirrors the input\n")
+		fmt.Fprintf(os.Stderr, "  -ignore-errors\n        Mark directories as completed in progress.json even if some files failed\n")
+		fmt.Fprintf(os.Stderr, "  -fail-fast\n        Abort the run on the first file that fails to process\n")
+		fmt.Fprintf(os.Stderr, "  -tmp-dir string\n        Directory for in-progress outputs, renamed into place when complete (default: next to each output)\n")
+		fmt.Fprintf(os.Stderr, "  -retries int\n        Retry transient I/O and ffmpeg failures this many times with exponential backoff (0 = off) (default 2)\n")
+		fmt.Fprintf(os.Stderr, "  -per-file-timeout string\n        Give up on a file that takes longer than this duration, e.g. 10m, killing its ffmpeg or libheif subprocess (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  -output-suffix string\n        Suffix inserted before the output file extension (e.g., _compressed); input files already bearing it are skipped\n")
+		fmt.Fprintf(os.Stderr, "  -output-template string\n        Name image outputs after a template relative to -out, without extension, e.g. {date}_{name}_{width}w\n")
+		fmt.Fprintf(os.Stderr, "        Tokens: {dir} input subdirectory, {name} input name, {ext} input extension, {width} {height} resized size,\n")
+		fmt.Fprintf(os.Stderr, "        {date} capture date (YYYY-MM-DD, else modification date), {index} position in the directory (zero-padded)\n")
+		fmt.Fprintf(os.Stderr, "  -output-same-as-input\n        Replace the originals with the processed files in place (-out defaults to -inputdir; requires -backup-dir and -i-know-this-overwrites)\n")
+		fmt.Fprintf(os.Stderr, "  -backup-dir string\n        Directory outside the input where -output-same-as-input keeps the originals, mirroring the input tree\n")
+		fmt.Fprintf(os.Stderr, "  -i-know-this-overwrites\n        Confirm that -output-same-as-input overwrites the input files\n")
+		fmt.Fprintf(os.Stderr, "  -dedupe\n        Detect identical inputs by SHA-256 and link their outputs to the first result instead of reprocessing\n")
+		fmt.Fprintf(os.Stderr, "  -dedupe-link string\n        How -dedupe links duplicate outputs (hard, symlink); falls back to copying if linking fails (default \"hard\")\n")
 		fmt.Fprintf(os.Stderr, "\nVideo Processing Parameters:\n")
 		fmt.Fprintf(os.Stderr, "  -disable-video\n        Disable video processing (video processing is enabled by default)\n")
-		fmt.Fprintf(os.Stderr, "  -video-codec string\n        Video codec (libx264, libx265, etc.) (default \"libx265\")\n")
+		fmt.Fprintf(os.Stderr, "  -video-codec string\n        Video codec (libx264, libx265, libvpx-vp9, libaom-av1, etc.) (default \"libx265\")\n")
 		fmt.Fprintf(os.Stderr, "  -video-bitrate string\n        Video bitrate (e.g., 2M, 1000k)\n")
 		fmt.Fprintf(os.Stderr, "  -video-resolution string\n        Video resolution (e.g., 1920x1080, 1280x720)\n")
 		fmt.Fprintf(os.Stderr, "  -video-crf int\n        Video CRF quality (0-51, lower is better quality) (default 23)\n")
 		fmt.Fprintf(os.Stderr, "  -video-preset string\n        Video encoding preset (ultrafast, fast, medium, slow, veryslow) (default \"medium\")\n")
+		fmt.Fprintf(os.Stderr, "  -video-two-pass\n        Use two-pass encoding to hit -video-bitrate more accurately (ignored in CRF mode)\n")
+		fmt.Fprintf(os.Stderr, "  -video-hwaccel string\n        Hardware-accelerated video encoding (videotoolbox, nvenc, qsv, none) (default \"none\")\n")
+		fmt.Fprintf(os.Stderr, "  -video-scale-flags string\n        Scaling algorithm for resized videos (bilinear, bicubic, lanczos, spline; default: ffmpeg's bicubic)\n")
+		fmt.Fprintf(os.Stderr, "  -video-timeout string\n        Stop ffmpeg and mark a video as failed when it takes longer than this duration, e.g. 30m (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  -video-thumbnails\n        Extract a poster frame (JPEG) next to each processed video for the HTML report\n")
+		fmt.Fprintf(os.Stderr, "  -thumbnail-at string\n        Poster frame timestamp in seconds or HH:MM:SS (default: 10%% of duration)\n")
+		fmt.Fprintf(os.Stderr, "  -rotate-video int\n        Rotate processed videos clockwise by 0, 90, 180 or 270 degrees after their rotation metadata is applied\n")
+		fmt.Fprintf(os.Stderr, "  -strip-subtitles\n        Leave subtitle tracks out of processed videos (by default they are kept where the output container supports them)\n")
+		fmt.Fprintf(os.Stderr, "\nAudio Processing Parameters:\n")
+		fmt.Fprintf(os.Stderr, "  -audio-codec string\n        Audio codec for videos (copy, aac, libopus, etc.) (default \"copy\")\n")
+		fmt.Fprintf(os.Stderr, "  -audio-bitrate string\n        Audio bitrate when transcoding (e.g., 128k, 192k)\n")
+		fmt.Fprintf(os.Stderr, "  -audio-disable\n        Strip audio from processed videos\n")
+		fmt.Fprintf(os.Stderr, "  -audio-channels string\n        Channels of re-encoded audio: keep (surround stays surround), stereo (downmix), or copy (never re-encode audio) (default \"keep\")\n")
+		fmt.Fprintf(os.Stderr, "\nExit Codes:\n")
+		fmt.Fprintf(os.Stderr, "  0  all files processed successfully\n")
+		fmt.Fprintf(os.Stderr, "  1  some files failed to process\n")
+		fmt.Fprintf(os.Stderr, "  2  invalid arguments or startup error\n")
 	}
 }
 
 func validateConfig() error {
+	// Configure the logger first so later validation messages respect it
+	level, err := parseLogLevel(config.LogLevel)
+	if err != nil {
+		return err
+	}
+	if config.Quiet {
+		level = LevelError
+	}
+	logLevel = level
+	if config.LogFile != "" {
+		if err := openLogFile(config.LogFile, config.LogTruncate); err != nil {
+			return err
+		}
+	}
+
+	// The HTTP API takes its images from requests, not directories
+	if config.Serve != "" {
+		return validateServe()
+	}
+
 	if config.InputDir == "" {
 		return fmt.Errorf("input directory cannot be empty")
 	}
 
+	// In-place mode replaces the inputs, so -out defaults to -inputdir
+	if config.OutputSameAsInput {
+		if err := validateInPlace(); err != nil {
+			return err
+		}
+	}
+
+	// With -archive or an S3 -out, outputs are staged in a local directory and moved to the
+	// archive or bucket as directories finish
+	if config.Archive != "" {
+		if err := setupArchive(); err != nil {
+			return err
+		}
+	} else if isS3Output(config.OutputDir) {
+		if err := setupS3Output(); err != nil {
+			return err
+		}
+	} else if config.S3Endpoint != "" || config.S3Region != "" || config.S3Progress {
+		return fmt.Errorf("--s3-endpoint, --s3-region and --s3-progress need --out s3://bucket/prefix")
+	}
+
 	if config.OutputDir == "" {
 		return fmt.Errorf("output directory cannot be empty")
 	}
 
+	// A regular file as -inputdir is processed on its own; otherwise it must be a directory
+	nestedOutput := ""
+	if fi, err := os.Stat(config.InputDir); err == nil && fi.Mode().IsRegular() {
+		if err := setupSingleFile(); err != nil {
+			return err
+		}
+	} else if !config.OutputSameAsInput {
+		// Refuse or work around -out overlapping -inputdir, so reruns don't reprocess outputs
+		nestedOutput, err = outputInsideInput()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Use long-path form on Windows so deep archives and UNC shares can be walked;
+	// every path derived from these roots inherits the prefix
+	config.InputDir = longPath(config.InputDir)
+	config.OutputDir = longPath(config.OutputDir)
+	config.BackupDir = longPath(config.BackupDir)
+	if singleInput != "" {
+		singleInput = filepath.Join(config.InputDir, filepath.Base(singleInput))
+		if singleOutputPath != "" {
+			singleOutputPath = filepath.Join(config.OutputDir, filepath.Base(singleOutputPath))
+		}
+	}
+	if nestedOutput != "" {
+		excludedScanDir = filepath.Join(config.InputDir, nestedOutput)
+		logInfo("Output directory is inside the input directory, excluding it from the scan: %s", excludedScanDir)
+	}
+
+	if config.Watch {
+		if err := validateWatch(); err != nil {
+			return err
+		}
+	}
+
 	// Skip size/width validation in fake scan mode
 	if !config.FakeScan {
-		if config.ScalingRatio == 0 && config.Width == 0 {
-			return fmt.Errorf("must specify either --size or --width parameter")
+		if config.ScalingRatio == 0 && config.Width == 0 && config.Height == 0 && config.Sizes == "" {
+			return fmt.Errorf("must specify either --size, --width, --height or --sizes parameter")
+		}
+
+		if config.ScalingRatio != 0 && (config.Width != 0 || config.Height != 0) {
+			return fmt.Errorf("--size cannot be combined with --width or --height")
+		}
+
+		if config.Sizes != "" && (config.ScalingRatio != 0 || config.Width != 0 || config.Height != 0) {
+			return fmt.Errorf("--sizes cannot be combined with --size, --width or --height")
 		}
 
-		if config.ScalingRatio != 0 && config.Width != 0 {
-			return fmt.Errorf("--size and --width parameters cannot be used simultaneously")
+		if config.Sizes != "" {
+			widths, err := parseSizes(config.Sizes)
+			if err != nil {
+				return err
+			}
+			sizeWidths = widths
 		}
 
 		if config.ScalingRatio != 0 && (config.ScalingRatio <= 0 || config.ScalingRatio > 10) {
 			return fmt.Errorf("--size parameter must be between 0 and 10")
 		}
 
+		if config.ScalingRatio > 1 && !config.AllowUpscale {
+			logWarn("--size %.2f enlarges images, but without --allow-upscale they keep their original size", config.ScalingRatio)
+		}
+
 		if config.Width != 0 && config.Width <= 0 {
 			return fmt.Errorf("--width parameter must be greater than 0")
 		}
+
+		if config.Height < 0 {
+			return fmt.Errorf("--height parameter must be greater than 0")
+		}
+	}
+
+	switch config.Fit {
+	case "contain", "cover", "stretch":
+	default:
+		return fmt.Errorf("--fit must be contain, cover or stretch")
+	}
+	if config.Fit != "contain" && (config.Width == 0 || config.Height == 0) {
+		return fmt.Errorf("--fit %s needs both --width and --height", config.Fit)
 	}
 
 	// Validate threshold parameters
@@ -271,6 +902,311 @@ func validateConfig() error {
 		return fmt.Errorf("--threshold-height parameter must be non-negative")
 	}
 
+	if config.MaxPixels < 0 {
+		return fmt.Errorf("--max-pixels parameter must be non-negative")
+	}
+
+	// Validate hardware acceleration and probe ffmpeg for the encoder once up front
+	switch config.VideoHWAccel {
+	case "none":
+	case "videotoolbox", "nvenc", "qsv":
+		if !config.VideoDisabled {
+			if err := checkHWAccelAvailable(); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("--video-hwaccel must be one of videotoolbox, nvenc, qsv, none")
+	}
+
+	switch config.VideoScaleFlags {
+	case "", "bilinear", "bicubic", "lanczos", "spline":
+	default:
+		return fmt.Errorf("--video-scale-flags must be one of bilinear, bicubic, lanczos, spline")
+	}
+
+	switch config.AudioChannels {
+	case "keep", "stereo":
+	case "copy":
+		if config.AudioCodec != "copy" {
+			return fmt.Errorf("--audio-channels copy never re-encodes audio and needs --audio-codec copy")
+		}
+	default:
+		return fmt.Errorf("--audio-channels must be one of keep, stereo, copy")
+	}
+
+	if config.ExtSettings != "" {
+		settings, err := parseExtSettings(config.ExtSettings)
+		if err != nil {
+			return err
+		}
+		extSettings = settings
+	}
+
+	// HEIC re-encoding needs libheif's heif-enc, otherwise fall back to JPEG
+	if keepsFormat(".heic") {
+		checkHEICEncoder()
+	}
+
+	if config.QualityFloor < 1 || config.QualityCeiling > 100 || config.QualityFloor > config.QualityCeiling {
+		return fmt.Errorf("--quality-floor and --quality-ceiling must satisfy 1 <= floor <= ceiling <= 100")
+	}
+
+	if config.JPEGProgressive && !progressiveJPEGAvailable {
+		logWarn("--jpeg-progressive needs a build with -tags libjpeg; writing baseline JPEGs")
+	}
+	if config.JPEGOptimize {
+		setupJPEGOptimize()
+	}
+
+	if config.MinSavings < 0 || config.MinSavings > 1 {
+		return fmt.Errorf("--min-savings parameter must be between 0 and 1")
+	}
+
+	if config.OutputTemplate != "" {
+		if err := validateOutputTemplate(); err != nil {
+			return err
+		}
+	}
+
+	sidecarExtensions = parseSidecarExtensions(config.SidecarExtensions)
+
+	if config.Extensions != "" {
+		exts := parseExtensionFilter(config.Extensions)
+		if len(exts) == 0 {
+			return fmt.Errorf("--ext must list at least one extension")
+		}
+		extensionFilter = make(map[string]bool)
+		for _, ext := range exts {
+			extensionFilter[ext] = true
+		}
+	}
+
+	if config.TmpDir != "" {
+		if err := os.MkdirAll(config.TmpDir, 0755); err != nil {
+			return fmt.Errorf("failed to create --tmp-dir: %v", err)
+		}
+	}
+
+	if config.Retries < 0 {
+		return fmt.Errorf("--retries parameter must be non-negative")
+	}
+
+	if config.FakeScan && (config.ResetProgress || config.ResetProgressDir != "") {
+		return fmt.Errorf("--reset-progress and --reset-progress-dir cannot be combined with --fake-scan")
+	}
+
+	if config.MaxDepth < -1 {
+		return fmt.Errorf("--max-depth must be -1 (unlimited) or a non-negative depth")
+	}
+
+	if err := resolveThreads(); err != nil {
+		return err
+	}
+
+	if config.MaxInflightBytes != "" {
+		limit, err := parseByteSize(config.MaxInflightBytes)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("--max-inflight-bytes must be a positive size such as 512M or 4G")
+		}
+		maxInflightBytes = limit
+	}
+
+	if err := parsePerFileTimeout(); err != nil {
+		return err
+	}
+
+	if config.VideoTimeout != "" {
+		timeout, err := time.ParseDuration(config.VideoTimeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("--video-timeout must be a positive duration such as 30m or 2h")
+		}
+		videoTimeout = timeout
+	}
+
+	switch config.DedupeLink {
+	case "hard", "symlink":
+	default:
+		return fmt.Errorf("--dedupe-link must be one of hard, symlink")
+	}
+
+	if config.MinResolution != "" {
+		width, height, err := parseResolution(config.MinResolution)
+		if err != nil {
+			return fmt.Errorf("--min-resolution parameter is invalid: %v", err)
+		}
+		minResolutionWidth, minResolutionHeight = width, height
+	}
+
+	if config.Thumbnails != "" {
+		width, height, err := parseResolution(config.Thumbnails)
+		if err != nil || width == 0 || height == 0 {
+			return fmt.Errorf("--thumbnails must be a size like 200x200")
+		}
+		thumbnailWidth, thumbnailHeight = width, height
+	}
+
+	switch config.OrientationFilter {
+	case "landscape", "portrait", "square", "all":
+	default:
+		return fmt.Errorf("--orientation-filter must be one of landscape, portrait, square, all")
+	}
+
+	switch config.OrientationMode {
+	case "rotate-pixels", "keep", "tag-only":
+	default:
+		return fmt.Errorf("--orientation-mode must be one of rotate-pixels, keep, tag-only")
+	}
+
+	switch config.Rotate {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("--rotate must be one of 0, 90, 180, 270")
+	}
+
+	switch config.RotateVideo {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("--rotate-video must be one of 0, 90, 180, 270")
+	}
+
+	if config.CropAspect != "" {
+		w, h, err := parseAspect(config.CropAspect)
+		if err != nil {
+			return err
+		}
+		cropAspectWidth, cropAspectHeight = w, h
+	}
+	switch config.CropMode {
+	case "center", "smart":
+	default:
+		return fmt.Errorf("--crop-mode must be one of center, smart")
+	}
+
+	if config.Sharpen < 0 || config.Sharpen > 5 {
+		return fmt.Errorf("--sharpen parameter must be between 0 and 5")
+	}
+
+	if config.Brightness < -1 || config.Brightness > 1 {
+		return fmt.Errorf("--brightness parameter must be between -1 and 1")
+	}
+	if config.Contrast < -1 || config.Contrast > 1 {
+		return fmt.Errorf("--contrast parameter must be between -1 and 1")
+	}
+	if config.Saturation < -1 || config.Saturation > 1 {
+		return fmt.Errorf("--saturation parameter must be between -1 and 1")
+	}
+
+	if config.Border != "" {
+		px, pct, err := parseBorder(config.Border)
+		if err != nil {
+			return err
+		}
+		borderPixels, borderPercent = px, pct
+	}
+	borderColor, err = parseColor(config.BorderColor)
+	if err != nil {
+		return fmt.Errorf("--border-color parameter is invalid: %v", err)
+	}
+
+	if config.ShiftTime != "" {
+		shift, err := time.ParseDuration(config.ShiftTime)
+		if err != nil {
+			return fmt.Errorf("--shift-time must be a duration such as 1h30m or -26h")
+		}
+		timeShift = shift
+	}
+
+	if config.VerifyOutputDelete && !config.VerifyOutput {
+		return fmt.Errorf("--verify-output-delete requires --verify-output")
+	}
+
+	if config.MinSSIM < 0 || config.MinSSIM > 1 {
+		return fmt.Errorf("--min-ssim must be between 0 and 1")
+	}
+	if config.MinSSIM > 0 && !config.QualityAnalysis {
+		return fmt.Errorf("--min-ssim requires --quality-analysis")
+	}
+
+	switch config.Caption {
+	case "none", "date", "filename":
+	case "text":
+		if config.CaptionText == "" {
+			return fmt.Errorf("--caption text requires --caption-text")
+		}
+	default:
+		return fmt.Errorf("--caption must be one of none, date, filename, text")
+	}
+	switch config.CaptionPosition {
+	case "bottom-right", "bottom-left", "top-right", "top-left":
+	default:
+		return fmt.Errorf("--caption-position must be one of bottom-right, bottom-left, top-right, top-left")
+	}
+	if config.CaptionSize < 0 {
+		return fmt.Errorf("--caption-size must not be negative")
+	}
+	captionColor, err = parseColor(config.CaptionColor)
+	if err != nil {
+		return fmt.Errorf("--caption-color parameter is invalid: %v", err)
+	}
+
+	if _, ok := pngCompressionLevels[config.PNGCompression]; !ok {
+		return fmt.Errorf("--png-compression must be one of default, none, fast, best")
+	}
+
+	if config.PNGPalette != 0 && (config.PNGPalette < 2 || config.PNGPalette > 256) {
+		return fmt.Errorf("--png-palette parameter must be between 2 and 256")
+	}
+
+	if config.Since != "" {
+		cutoff, err := parseSince(config.Since, time.Now())
+		if err != nil {
+			return err
+		}
+		sinceCutoff = cutoff
+		logInfo("Only processing files modified since %s", sinceCutoff.Format(time.RFC3339))
+	}
+
+	switch config.SinceMode {
+	case "ignore", "copy":
+	default:
+		return fmt.Errorf("--since-mode must be one of ignore, copy")
+	}
+
+	switch config.Order {
+	case "deep-first", "shallow-first", "alpha", "size":
+	default:
+		return fmt.Errorf("--order must be one of deep-first, shallow-first, alpha, size")
+	}
+
+	switch config.EXIFThumbnail {
+	case "keep", "strip", "regenerate":
+	default:
+		return fmt.Errorf("--exif-thumbnail must be one of keep, strip, regenerate")
+	}
+
+	switch config.RawMode {
+	case "copy", "skip", "preview":
+	default:
+		return fmt.Errorf("--raw-mode must be one of copy, skip, preview")
+	}
+
+	// Splitting multi-image HEICs needs libheif's heif-convert
+	if config.HEICAllImages {
+		checkHEICConverter()
+	}
+
+	if config.ThumbnailAt != "" {
+		if _, err := parseTimestamp(config.ThumbnailAt); err != nil {
+			return fmt.Errorf("--thumbnail-at parameter is invalid: %v", err)
+		}
+	}
+
+	// Two-pass only makes sense with a target bitrate; CRF is already rate-controlled
+	if config.VideoTwoPass && config.VideoBitrate == "" {
+		logInfo("Note: -video-two-pass is ignored in CRF mode (set -video-bitrate to enable it)")
+	}
+
 	// Apply smart default resolution limits if not ignored
 	if !config.IgnoreSmartLimit {
 		applySmartDefaults()
@@ -284,29 +1220,101 @@ func validateConfig() error {
 	return nil
 }
 
-// applySmartDefaults applies intelligent default resolution limits based on scaling operation
-// shouldProcessExtension checks if the file extension should be processed based on the -ext filter
+// sinceCutoff is the -since modification time cutoff; zero when the filter is off
+var sinceCutoff time.Time
+
+// parseSince parses -since as an RFC3339 timestamp or a duration before now (e.g. 24h)
+func parseSince(spec string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, spec); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(spec)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("--since must be an RFC3339 timestamp (e.g. 2024-01-02T15:04:05Z) or a positive duration (e.g. 24h)")
+	}
+	return now.Add(-d), nil
+}
+
+// olderThanSince reports whether a file was last modified before the -since cutoff.
+// Future modification times are never older, so such files are always processed.
+func olderThanSince(info os.FileInfo) bool {
+	return !sinceCutoff.IsZero() && info.ModTime().Before(sinceCutoff)
+}
+
+// excludedScanDir is the output directory when it lies inside the input tree; scans skip it
+var excludedScanDir string
+
+// outputInsideInput checks how -out relates to -inputdir. It returns the output directory
+// relative to the input directory when nested inside it, and an error when both are the same
+// directory without an -output-suffix to tell outputs apart. An input inside the output needs
+// nothing: outputs are written under -out mirroring paths relative to -inputdir.
+func outputInsideInput() (string, error) {
+	inputAbs, err := filepath.Abs(config.InputDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve input directory: %v", err)
+	}
+	outputAbs, err := filepath.Abs(config.OutputDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve output directory: %v", err)
+	}
+
+	rel, err := filepath.Rel(inputAbs, outputAbs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", nil // Not nested
+	}
+	if rel == "." {
+		if config.OutputSuffix == "" {
+			return "", fmt.Errorf("output directory is the same as the input directory; use --output-suffix to write outputs alongside the originals")
+		}
+		return "", nil
+	}
+	return rel, nil
+}
+
+// extensionFilter is the parsed -ext allowlist (lowercase, without the dot), nil when unset
+var extensionFilter map[string]bool
+
+// parseExtensionFilter parses -ext, accepting "jpg", ".JPG" and spaces after commas alike
+func parseExtensionFilter(spec string) []string {
+	var exts []string
+	for _, ext := range strings.Split(spec, ",") {
+		ext = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+		if ext != "" {
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
+
+// shouldProcessExtension checks if the file extension should be processed based on the -ext filter.
+// The filter is the only allowlist: images, videos and files that would merely be copied are
+// all dropped unless listed. Sidecars are the exception, they follow their media.
 func shouldProcessExtension(filePath string) bool {
 	// If no extension filter is specified, process all supported files
-	if config.Extensions == "" {
+	if extensionFilter == nil {
 		return true
 	}
-	
-	// Parse the extensions list
-	allowedExts := strings.Split(strings.ToLower(config.Extensions), ",")
-	fileExt := strings.ToLower(strings.TrimPrefix(filepath.Ext(filePath), "."))
-	
-	// Check if the file extension is in the allowed list
-	for _, ext := range allowedExts {
-		ext = strings.TrimSpace(ext)
-		if fileExt == ext {
-			return true
-		}
+	return extensionFilter[strings.ToLower(strings.TrimPrefix(filepath.Ext(filePath), "."))]
+}
+
+// applyOutputSuffix inserts the -output-suffix before the file extension
+func applyOutputSuffix(path string) string {
+	if config.OutputSuffix == "" {
+		return path
 	}
-	
-	return false
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + config.OutputSuffix + ext
 }
 
+// hasOutputSuffix reports whether a file name already carries the -output-suffix
+func hasOutputSuffix(filename string) bool {
+	if config.OutputSuffix == "" {
+		return false
+	}
+	return strings.HasSuffix(strings.TrimSuffix(filename, filepath.Ext(filename)), config.OutputSuffix)
+}
+
+// applySmartDefaults applies intelligent default resolution limits based on scaling operation
 func applySmartDefaults() {
 	isDownscaling := false
 	isUpscaling := false
@@ -320,6 +1328,10 @@ func applySmartDefaults() {
 		// This is a heuristic - in practice, user should specify limits explicitly for width-based scaling
 		isDownscaling = config.Width <= 1920
 		isUpscaling = config.Width > 1920
+	} else if config.Height > 0 {
+		// The same heuristic against the height of 1080p
+		isDownscaling = config.Height <= 1080
+		isUpscaling = config.Height > 1080
 	}
 
 	// Apply defaults only if user hasn't specified custom values
@@ -327,104 +1339,240 @@ func applySmartDefaults() {
 		// For downscaling: set thresholds to avoid processing small images (skip images below threshold)
 		if config.ThresholdWidth == 0 {
 			config.ThresholdWidth = 1920
-			fmt.Printf("Smart default: Setting width threshold to %d (downscaling - skip below)\n", config.ThresholdWidth)
+			logInfo("Smart default: Setting width threshold to %d (downscaling - skip below)", config.ThresholdWidth)
+			smartDefaultsApplied = append(smartDefaultsApplied, fmt.Sprintf("width threshold %d (downscaling - skip below)", config.ThresholdWidth))
 		}
 		if config.ThresholdHeight == 0 {
 			config.ThresholdHeight = 1080
-			fmt.Printf("Smart default: Setting height threshold to %d (downscaling - skip below)\n", config.ThresholdHeight)
+			logInfo("Smart default: Setting height threshold to %d (downscaling - skip below)", config.ThresholdHeight)
+			smartDefaultsApplied = append(smartDefaultsApplied, fmt.Sprintf("height threshold %d (downscaling - skip below)", config.ThresholdHeight))
 		}
 	} else if isUpscaling {
 		// For upscaling: set thresholds to avoid processing very large images (skip images above threshold)
 		if config.ThresholdWidth == 0 {
 			config.ThresholdWidth = 3840
-			fmt.Printf("Smart default: Setting width threshold to %d (upscaling - skip above)\n", config.ThresholdWidth)
+			logInfo("Smart default: Setting width threshold to %d (upscaling - skip above)", config.ThresholdWidth)
+			smartDefaultsApplied = append(smartDefaultsApplied, fmt.Sprintf("width threshold %d (upscaling - skip above)", config.ThresholdWidth))
 		}
 		if config.ThresholdHeight == 0 {
 			config.ThresholdHeight = 2160
-			fmt.Printf("Smart default: Setting height threshold to %d (upscaling - skip above)\n", config.ThresholdHeight)
+			logInfo("Smart default: Setting height threshold to %d (upscaling - skip above)", config.ThresholdHeight)
+			smartDefaultsApplied = append(smartDefaultsApplied, fmt.Sprintf("height threshold %d (upscaling - skip above)", config.ThresholdHeight))
 		}
 	}
 }
 
-func processImages(targetDir string, threadID int) error {
-	// Create output directory
-	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
-	}
+// workItem is a candidate file found while listing a directory, stat'd exactly once
+type workItem struct {
+	path     string
+	info     os.FileInfo
+	sidecars []string // -sidecar-extensions files copied along with this media file
+}
 
-	// First pass: count total files to process in the target directory
-	totalFilesToProcess := 0
-	walkDir := config.InputDir
-	if targetDir != "" {
-		walkDir = targetDir
-	}
-	
-	// Read directory contents directly (non-recursive)
-	entries, err := os.ReadDir(walkDir)
+// directoryListings caches the count pass's listings so processImages doesn't read or stat again
+var directoryListings = make(map[string][]workItem)
+var listingsMutex sync.Mutex
+
+// listWorkItems reads a directory (non-recursive) and stats every file that passes the filters
+func listWorkItems(dir string) ([]workItem, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return fmt.Errorf("failed to read directory %s: %v", walkDir, err)
+		return nil, fmt.Errorf("failed to read directory %s: %v", dir, err)
 	}
-	
+
+	items := make([]workItem, 0, len(entries))
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue // Skip subdirectories
 		}
-		
+
 		filename := entry.Name()
-		path := filepath.Join(walkDir, filename)
-		
-		// Skip hidden files (macOS metadata files starting with ._)
+		path := filepath.Join(dir, filename)
+
+		// Skip macOS AppleDouble resource forks (._name), even with -include-hidden
 		if strings.HasPrefix(filename, "._") {
 			continue
 		}
-		
+
+		// Skip our own suffixed outputs when -out shares the input tree
+		if hasOutputSuffix(filename) {
+			continue
+		}
+
+		// Skip the run lock when the output directory is (inside) the input tree
+		if filename == runLockName {
+			continue
+		}
+
 		// Check if file extension should be processed based on filter
 		if !shouldProcessExtension(path) {
 			continue
 		}
+
+		info, err := entry.Info()
+		if err != nil {
+			logWarn("failed to get file info for %s: %v", path, err)
+			continue
+		}
 		
-		ext := strings.ToLower(filepath.Ext(path))
-		isImageSupported := ext == ".jpg" || ext == ".jpeg" || ext == ".heic" || ext == ".png"
-		isVideoSupported := isVideoFile(path)
-		if isImageSupported || isVideoSupported {
-			totalFilesToProcess++
+		// Linked directories are scanned as directories (with -follow-symlinks) and linked
+		// files are processed as their target
+		if entry.Type()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(path); err != nil {
+				logWarn("skipping broken symlink %s: %v", path, err)
+				continue
+			}
+			if info.IsDir() {
+				continue
+			}
 		}
+		items = append(items, workItem{path: path, info: info})
 	}
+	return attachSidecars(dir, entries, items), nil
+}
 
-	// Progress counter
-	processedCount := 0
+// takeWorkItems returns the listing cached for dir by the count pass, or lists it now
+func takeWorkItems(dir string) ([]workItem, error) {
+	listingsMutex.Lock()
+	items, ok := directoryListings[dir]
+	delete(directoryListings, dir)
+	listingsMutex.Unlock()
+	if ok {
+		return items, nil
+	}
+	return listWorkItems(dir)
+}
 
-	// Process files in target directory (non-recursive)
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue // Skip subdirectories
+// countFilesToProcess counts the supported media files in a directory listing
+func countFilesToProcess(items []workItem) int {
+	count := 0
+	for _, item := range items {
+		if olderThanSince(item.info) {
+			continue // Ignored or copied, either way not processed
+		}
+		ext := strings.ToLower(filepath.Ext(item.path))
+		isImageSupported := ext == ".jpg" || ext == ".jpeg" || ext == ".heic" || ext == ".png" ||
+			(media.IsRawFile(item.path) && config.RawMode == "preview")
+		isVideoSupported := isVideoFile(item.path)
+		if isImageSupported || isVideoSupported {
+			count++
 		}
+	}
+	return count
+}
 
-		filename := entry.Name()
-		path := filepath.Join(walkDir, filename)
+// countAllFilesToProcess lists all directories to process in parallel, caching the listings
+// for processImages, and sums their supported media files
+func countAllFilesToProcess(directories []string) int64 {
+	var total int64
+	workers := config.Multithread
+	if workers < 1 {
+		workers = 1
+	}
+	semaphore := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for _, dir := range directories {
+		wg.Add(1)
+		go func(dir string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			items, err := listWorkItems(dir)
+			if err != nil {
+				logWarn("failed to count files in %s: %v", dir, err)
+				return
+			}
+			listingsMutex.Lock()
+			directoryListings[dir] = items
+			listingsMutex.Unlock()
+			atomic.AddInt64(&total, int64(countFilesToProcess(items)))
+		}(dir)
+	}
+	wg.Wait()
+	return total
+}
 
-		// Skip hidden files (macOS metadata files starting with ._)
-		if strings.HasPrefix(filename, "._") {
-			continue
-		}
+// advanceProgress marks one more file as started and returns the global position
+func advanceProgress() (int64, int64, float64) {
+	done := atomic.AddInt64(&filesDone, 1)
+	total := atomic.LoadInt64(&filesTotal)
+	var percentage float64
+	if total > 0 {
+		percentage = float64(done) / float64(total) * 100
+	}
+	return done, total, percentage
+}
 
-		// Check if file extension should be processed based on filter
-		if !shouldProcessExtension(path) {
-			continue
+// countSkippedFile counts a file left alone because its output is already done, as a skipped
+// video or image
+func countSkippedFile(dirStats *DirectoryStats, isVideo bool) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	if isVideo {
+		stats.SkippedVideos++
+		dirStats.SkippedVideos++
+	} else {
+		stats.SkippedImages++
+		dirStats.SkippedImages++
+	}
+}
+
+func processImages(ctx context.Context, targetDir string, threadID int) error {
+	// Create output directory
+	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	walkDir := config.InputDir
+	if targetDir != "" {
+		walkDir = targetDir
+	}
+	
+	// Reuse the listing from the count pass so each file is stat'd only once
+	items, err := takeWorkItems(walkDir)
+	if err != nil {
+		return err
+	}
+	
+	// Drop this thread's line from the progress bar once the directory is done
+	if progressBar != nil {
+		defer progressBar.finishThread(threadID)
+	}
+	
+	// -output-template numbers the directory's images in listing order for {index}
+	templateIndex := make(map[string]int)
+	if config.OutputTemplate != "" {
+		for _, item := range items {
+			if isTemplatedImage(item.path) {
+				templateIndex[item.path] = len(templateIndex) + 1
+			}
 		}
+	}
 
-		// Get file info
-		info, err := entry.Info()
-		if err != nil {
-			fmt.Printf("Warning: failed to get file info for %s: %v\n", path, err)
-			continue
+	// Process files in target directory (non-recursive)
+	for _, item := range items {
+		path, info := item.path, item.info
+		if err := contextError(ctx); err != nil {
+			return err
 		}
 		
 		// Check file extension
 		ext := strings.ToLower(filepath.Ext(path))
-		isImageSupported := ext == ".jpg" || ext == ".jpeg" || ext == ".heic" || ext == ".png"
+		isRaw := media.IsRawFile(path)
+		isImageSupported := ext == ".jpg" || ext == ".jpeg" || ext == ".heic" || ext == ".png" ||
+			(isRaw && config.RawMode == "preview")
 		isVideoSupported := isVideoFile(path) && !config.VideoDisabled // Video processing enabled by default unless disabled
 		
+		// Files last modified before -since are ignored, or copied unchanged with -since-mode copy
+		if olderThanSince(info) {
+			if config.SinceMode == "ignore" {
+				logDebug("[thread-%d] Ignoring file older than -since: %s", threadID, path)
+				continue
+			}
+			isImageSupported, isVideoSupported = false, false
+		}
+		
 		// Calculate relative path
 		relPath, err := filepath.Rel(config.InputDir, path)
 		if err != nil {
@@ -445,21 +1593,103 @@ func processImages(targetDir string, threadID int) error {
 				Files:         make([]FileInfo, 0),
 			}
 		}
-		dirStats := stats.DirectoryStats[dirPath]
-		statsMutex.Unlock()
-		
-		// Build output path
-		outputPath := filepath.Join(config.OutputDir, relPath)
+		dirStats := stats.DirectoryStats[dirPath]
+		statsMutex.Unlock()
+		
+		// RAW files in skip mode are listed in the report but produce no output
+		if isRaw && config.RawMode == "skip" {
+			logDebug("[thread-%d] Skipping RAW file: %s", threadID, path)
+			fileInfo := FileInfo{
+				Path:        relPath,
+				Type:        "skipped",
+				InputSize:   info.Size(),
+				RawHandling: "skip",
+				SkipReason:  "RAW file (-raw-mode skip)",
+			}
+			statsMutex.Lock()
+			stats.Files = append(stats.Files, fileInfo)
+			dirStats.Files = append(dirStats.Files, fileInfo)
+			recordManifest(fileInfo)
+			statsMutex.Unlock()
+			continue
+		}
+		
+		// Build output path
+		outputPath := filepath.Join(config.OutputDir, relPath)
+		
+		// Convert HEIC files to JPEG extension since we encode them as JPEG (unless kept as HEIC),
+		// as well as PNGs with -ext-settings png:format=jpeg. Files that are only copied (e.g.
+		// older than -since) keep their name; processImage also copies threshold-skipped
+		// images to their original extension.
+		if isImageSupported && writesJPEGName(ext) {
+			outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".jpg"
+		}
+		
+		// RAW previews are written as JPEG
+		if isImageSupported && isRaw {
+			outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".jpg"
+		}
+		
+		// Use a container compatible with the video codec (e.g. .webm for VP9)
+		if isVideoSupported {
+			outputPath = videoOutputPath(outputPath)
+		}
+		
+		// Name images after -output-template, keeping the output format's extension
+		if config.OutputTemplate != "" && isImageSupported {
+			outputPath = templateOutputPath(path, relPath, outputPath, templateIndex[path], len(templateIndex))
+		}
+		
+		// Insert the output suffix before the (possibly rewritten) extension
+		outputPath = applyOutputSuffix(outputPath)
+		if config.OutputTemplate != "" && isImageSupported {
+			recordTemplatedOutput(relPath, outputPath)
+		}
+		
+		// In single-file mode an -out with an extension is the exact output path
+		if singleOutputPath != "" {
+			outputPath = singleOutputPath
+		}
+		
+		// In place, other files are left alone and outputs of earlier runs are not processed again
+		if config.OutputSameAsInput {
+			if !isImageSupported && !isVideoSupported {
+				logDebug("[thread-%d] Leaving non-media file in place: %s", threadID, path)
+				continue
+			}
+			if inPlaceProcessed(relPath) {
+				done, total, percentage := advanceProgress()
+				if progressBar != nil {
+					progressBar.startFile(threadID, path, done, total)
+				} else {
+					logDebug("[thread-%d] [%d/%d] (%.1f%%) Skipping file already processed in place: %s", threadID, done, total, percentage, path)
+				}
+				countSkippedFile(dirStats, isVideoSupported)
+				continue
+			}
+			// A renamed output (e.g. HEIC to .jpg) must not replace a different original
+			if outputPath != path {
+				if _, err := os.Stat(outputPath); err == nil {
+					recordFileError(targetDir, path, fmt.Errorf("in-place output %s already exists as a separate file", outputPath))
+					continue
+				}
+			}
+		}
 		
-		// Convert HEIC files to JPEG extension since we encode them as JPEG
-		if strings.ToLower(filepath.Ext(path)) == ".heic" {
-			outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".jpg"
+		// Check if output file already exists (skipped HEICs are kept under their own extension);
+		// inputs -watch saw change are processed again
+		changedWhileWatching := watchChanged(path)
+		existingPath := outputPath
+		if isImageSupported && len(sizeWidths) > 0 {
+			// Responsive sets have no single output; the smallest variant stands in for it
+			existingPath = sizeVariantPath(outputPath, sizeWidths[0])
 		}
-		
-		// Check if output file already exists
-		if _, err := os.Stat(outputPath); err == nil {
+		if !outputExists(existingPath) && isImageSupported {
+			existingPath = copiedOutputPath(path, outputPath)
+		}
+		if !config.OutputSameAsInput && outputExists(existingPath) {
 			// File already exists, check if it needs reprocessing
-			shouldReprocess := false
+			shouldReprocess := changedWhileWatching
 			
 			// For image files, verify EXIF preservation
 			if isImageSupported {
@@ -473,25 +1703,25 @@ func processImages(targetDir string, threadID int) error {
 				}
 				
 				// If original has EXIF, check if output file preserved it
-				if originalHasEXIF {
+				// EXIF verification only understands JPEG outputs on local disk
+				if originalHasEXIF && existingPath == outputPath && outputImageFormat(outputPath) == "jpeg" && stagingDir == "" {
 					outputHasEXIF := verifyEXIFPresence(outputPath)
 					if !outputHasEXIF {
 						shouldReprocess = true
-						fmt.Printf("[thread-%d] EXIF missing in output file, reprocessing: %s\n", threadID, outputPath)
+						logInfo("[thread-%d] EXIF missing in output file, reprocessing: %s", threadID, outputPath)
 					}
 				}
 			}
 			
 			if !shouldReprocess {
 				// File already exists and is valid, skip processing
-				processedCount++
-				var percentage float64
-				if totalFilesToProcess > 0 {
-					percentage = float64(processedCount) / float64(totalFilesToProcess) * 100
+				done, total, percentage := advanceProgress()
+				if progressBar != nil {
+					progressBar.startFile(threadID, path, done, total)
+				} else {
+					logDebug("[thread-%d] [%d/%d] (%.1f%%) Skipping existing file: %s -> %s", threadID, done, total, percentage, path, existingPath)
 				}
-				fmt.Printf("[thread-%d] [%d/%d] (%.1f%%) Skipping existing file: %s -> %s\n", threadID, processedCount, totalFilesToProcess, percentage, path, outputPath)
-				stats.SkippedImages++
-				dirStats.SkippedImages++
+				countSkippedFile(dirStats, isVideoSupported)
 				continue
 			}
 		}
@@ -507,14 +1737,16 @@ func processImages(targetDir string, threadID int) error {
 		
 		if config.FakeScan {
 			// Fake scan mode: only list files to be processed
-			processedCount++
-			percentage := float64(processedCount) / float64(totalFilesToProcess) * 100
+			done, total, percentage := advanceProgress()
+			if progressBar != nil {
+				progressBar.startFile(threadID, path, done, total)
+			}
 			if isVideoSupported {
-				fmt.Printf("[thread-%d] [%d/%d] (%.1f%%) Would process video: %s (size: %d bytes) -> %s\n", threadID, processedCount, totalFilesToProcess, percentage, path, info.Size(), outputPath)
+				fmt.Printf("[thread-%d] [%d/%d] (%.1f%%) Would process video: %s (size: %d bytes) -> %s\n", threadID, done, total, percentage, path, info.Size(), outputPath)
 			} else if isImageSupported {
-				fmt.Printf("[thread-%d] [%d/%d] (%.1f%%) Would process image: %s (size: %d bytes) -> %s\n", threadID, processedCount, totalFilesToProcess, percentage, path, info.Size(), outputPath)
+				fmt.Printf("[thread-%d] [%d/%d] (%.1f%%) Would process image: %s (size: %d bytes) -> %s\n", threadID, done, total, percentage, path, info.Size(), outputPath)
 			} else {
-				fmt.Printf("[thread-%d] [%d/%d] (%.1f%%) Would copy file: %s (size: %d bytes) -> %s\n", threadID, processedCount, totalFilesToProcess, percentage, path, info.Size(), outputPath)
+				fmt.Printf("[thread-%d] [%d/%d] (%.1f%%) Would copy file: %s (size: %d bytes) -> %s\n", threadID, done, total, percentage, path, info.Size(), outputPath)
 			}
 			statsMutex.Lock()
 			stats.TotalInputSize += info.Size()
@@ -523,35 +1755,108 @@ func processImages(targetDir string, threadID int) error {
 			continue
 		}
 		
+		// With -dedupe, identical inputs are processed once; later copies link to the first's outputs
+		var dedupe *dedupeEntry
+		if config.Dedupe {
+			entry, first, err := claimDedupe(path, relPath, outputPath)
+			if err != nil {
+				logWarn("[thread-%d] Failed to hash %s for -dedupe: %v", threadID, path, err)
+			} else if first {
+				// Every path below releases the waiting duplicates through finish, with no outputs
+				// when processing bails out early
+				dedupe = entry
+			} else {
+				linked, ok, err := linkDuplicate(entry, outputPath)
+				if err != nil {
+					recordFileError(targetDir, path, err)
+				} else if ok {
+					if isImageSupported || isVideoSupported {
+						advanceProgress()
+					}
+					logInfo("[thread-%d] Duplicate of %s: %s -> %s", threadID, entry.source, path, strings.Join(linked, ", "))
+					recordDuplicate(entry, linked, relPath, info, dirStats)
+					copySidecars(item.sidecars, path, outputPath, dirStats)
+					continue
+				}
+			}
+		}
+		
+		// Keep the original under -backup-dir before it is replaced
+		var backupPath string
+		if config.OutputSameAsInput {
+			backupPath, err = backupOriginal(path, relPath)
+			if err != nil {
+				recordFileError(targetDir, path, err)
+				if dedupe != nil {
+					dedupe.finish("")
+				}
+				continue
+			}
+		}
+		
 		if isVideoSupported {
 			// Process video file
-			processedCount++
-			percentage := float64(processedCount) / float64(totalFilesToProcess) * 100
-			fmt.Printf("[thread-%d] [%d/%d] (%.1f%%) Processing video: %s (size: %d bytes)\n", threadID, processedCount, totalFilesToProcess, percentage, path, info.Size())
+			done, total, percentage := advanceProgress()
+			if progressBar != nil {
+				progressBar.startFile(threadID, path, done, total)
+			} else {
+				logDebug("[thread-%d] [%d/%d] (%.1f%%) Processing video: %s (size: %d bytes)", threadID, done, total, percentage, path, info.Size())
+			}
 			statsMutex.Lock()
 			stats.TotalInputSize += info.Size()
 			dirStats.TotalInputSize += info.Size()
 			statsMutex.Unlock()
-			err = processVideo(path, outputPath, info, dirStats)
+			outputBefore := directoryOutputSize(dirStats)
+			fileCtx, cancel := fileContext(ctx)
+			err = withVideoSlot(fileCtx, func() error {
+				return processVideo(fileCtx, path, outputPath, info, dirStats)
+			})
+			cancel()
 			if err != nil {
-				fmt.Printf("Error processing video %s: %v\n", path, err)
+				recordFileError(targetDir, path, err)
+			} else if progressBar != nil {
+				progressBar.addSaved(info.Size() - (directoryOutputSize(dirStats) - outputBefore))
 			}
 		} else if isImageSupported {
 			// Process image file
-			processedCount++
-			percentage := float64(processedCount) / float64(totalFilesToProcess) * 100
-			fmt.Printf("[thread-%d] [%d/%d] (%.1f%%) Processing image: %s (size: %d bytes)\n", threadID, processedCount, totalFilesToProcess, percentage, path, info.Size())
+			done, total, percentage := advanceProgress()
+			if progressBar != nil {
+				progressBar.startFile(threadID, path, done, total)
+			} else {
+				logDebug("[thread-%d] [%d/%d] (%.1f%%) Processing image: %s (size: %d bytes)", threadID, done, total, percentage, path, info.Size())
+			}
 			statsMutex.Lock()
 			stats.TotalInputSize += info.Size()
 			dirStats.TotalInputSize += info.Size()
 			statsMutex.Unlock()
-			err = processImage(path, outputPath, relPath, info, dirStats)
+			outputBefore := directoryOutputSize(dirStats)
+			fileCtx, cancel := fileContext(ctx)
+			err = processImage(fileCtx, path, outputPath, relPath, info, dirStats)
+			cancel()
+			var undecodable *media.DecodeError
+			if config.KeepGoingOnDecodeError && errors.As(err, &undecodable) {
+				err = copyUndecodableImage(path, outputPath, relPath, info, dirStats, err)
+			}
 			if err != nil {
-				fmt.Printf("Error processing image %s: %v\n", path, err)
+				recordFileError(targetDir, path, err)
+			} else if progressBar != nil {
+				progressBar.addSaved(info.Size() - (directoryOutputSize(dirStats) - outputBefore))
 			}
 		} else {
-			// Copy unsupported files directly
-			fmt.Printf("[thread-%d] Copying unsupported file: %s (size: %d bytes)\n", threadID, path, info.Size())
+			// Copy unsupported files (and files older than -since) directly
+			if olderThanSince(info) {
+				logInfo("[thread-%d] Copying file older than -since: %s (size: %d bytes)", threadID, path, info.Size())
+			} else {
+				logInfo("[thread-%d] Copying unsupported file: %s (size: %d bytes)", threadID, path, info.Size())
+			}
+			err = copyFile(path, outputPath, info)
+			if err != nil {
+				if dedupe != nil {
+					dedupe.finish("")
+				}
+				return err
+			}
+			
 			statsMutex.Lock()
 			stats.CopiedFiles++
 			dirStats.CopiedFiles++
@@ -568,16 +1873,27 @@ func processImages(targetDir string, threadID int) error {
 				InputSize:    info.Size(),
 				OutputSize:   info.Size(),
 				CompressionRatio: 1.0,
+				Verification: copyVerification(),
+				RawHandling:  rawHandling(path),
 			}
 			statsMutex.Lock()
 			stats.Files = append(stats.Files, fileInfo)
 			dirStats.Files = append(dirStats.Files, fileInfo)
+			recordManifest(fileInfo)
 			statsMutex.Unlock()
-			
-			err = copyFile(path, outputPath, info)
-			if err != nil {
-				return err
-			}
+		}
+		
+		if config.OutputSameAsInput {
+			finishInPlace(path, outputPath, backupPath, err)
+		}
+		
+		// Sidecars follow their media once it has been written
+		if err == nil && len(item.sidecars) > 0 {
+			copySidecars(item.sidecars, path, outputPath, dirStats)
+		}
+		
+		if dedupe != nil {
+			dedupe.finish(path)
 		}
 	}
 	
@@ -587,34 +1903,117 @@ func processImages(targetDir string, threadID int) error {
 func main() {
 	flag.Parse()
 
+	if config.ListFormats {
+		listFormats()
+		return
+	}
+
+	// A -preset provides defaults underneath the config file and command line
+	if config.Preset != "" {
+		if err := applyPreset(config.Preset); err != nil {
+			fatalConfig("%v", err)
+		}
+	}
+
+	// Settings from -config fill in whatever wasn't given on the command line
+	if config.ConfigFile != "" {
+		if err := loadConfigFile(config.ConfigFile); err != nil {
+			fatalConfig("%v", err)
+		}
+	}
+
+	// -compare only reads two reports, so none of the processing options apply
+	if config.Compare != "" {
+		if err := runCompare(); err != nil {
+			fatalConfig("%v", err)
+		}
+		return
+	}
+
 	if err := validateConfig(); err != nil {
-		log.Fatal(err)
+		fatalConfig("%v", err)
+	}
+	if config.Preset != "" {
+		logPreset(config.Preset)
+	}
+	logEffectiveConfig()
+
+	// Files are processed under ctx; each one gets its own deadline from -per-file-timeout
+	ctx := context.Background()
+
+	if config.Serve != "" {
+		runServer()
+		return
+	}
+
+	// A single input file skips directory scanning, progress tracking and reports
+	if singleInput != "" {
+		runSingleFile(ctx)
+		return
 	}
 
 	// Handle fake scan mode - skip progress file operations
 	// Progress file path - use extension-specific name if filtering by extension
 	progressFileName := "progress.json"
 	if config.Extensions != "" {
-		// Replace commas and spaces with underscores for filename
-		extSuffix := strings.ReplaceAll(strings.ReplaceAll(config.Extensions, ",", "_"), " ", "")
+		// Join the normalized extensions with underscores for filename
+		extSuffix := strings.Join(parseExtensionFilter(config.Extensions), "_")
 		progressFileName = fmt.Sprintf("progress_%s.json", extSuffix)
 	}
 	progressFile := filepath.Join(config.OutputDir, progressFileName)
 
+	// Only one run at a time may update the output directory's progress
+	if !config.FakeScan {
+		if err := acquireRunLock(config.OutputDir); err != nil {
+			fatalConfig("%v", err)
+		}
+	}
+
+	if config.Archive != "" {
+		if err := openOutputArchive(); err != nil {
+			fatalConfig("%v", err)
+		}
+	}
+	if err := restoreProgress(progressFile); err != nil {
+		fatalConfig("%v", err)
+	}
+
+	// -reset-progress starts over without the user hunting for the -ext specific file name
+	if config.ResetProgress {
+		if err := os.Remove(progressFile); err != nil && !os.IsNotExist(err) {
+			fatalConfig("Failed to reset progress: %v", err)
+		}
+		logInfo("Reset progress: removed %s", progressFile)
+	}
+
 	// Load existing progress
 	tracker, err := loadProgress(progressFile)
 	if err != nil {
-		log.Fatalf("Failed to load progress: %v", err)
+		fatalConfig("Failed to load progress: %v", err)
+	}
+
+	if config.ResetProgressDir != "" {
+		if !tracker.resetDirectory(config.ResetProgressDir) {
+			fatalConfig("--reset-progress-dir %s is not a directory in %s", config.ResetProgressDir, progressFile)
+		}
+		if err := tracker.saveProgress(progressFile); err != nil {
+			fatalConfig("Failed to save progress: %v", err)
+		}
+		logInfo("Reset progress of directory: %s", config.ResetProgressDir)
+	}
+
+	if config.Manifest && !config.FakeScan {
+		startManifest()
 	}
 
 	if config.FakeScan {
 		// Fake scan mode: use progress file but don't save changes or do actual processing
 		// Scan directories if progress is empty
 		if len(tracker.Directories) == 0 {
-			fmt.Println("Scanning directories...")
+			logInfo("Scanning directories...")
 			directories, err := scanDirectories(config.InputDir)
 			if err != nil {
-				log.Fatalf("Failed to scan directories: %v", err)
+				fatalConfig("Failed to scan directories: %v", err)
 			}
 
 			// If no subdirectories found, process the root directory itself
@@ -629,71 +2028,84 @@ func main() {
 					Completed: false,
 				})
 			}
-			fmt.Printf("Found %d directories to process\n", len(directories))
+			logInfo("Found %d directories to process", len(directories))
 		}
 
 		// Get uncompleted directories
 		uncompletedDirs := tracker.getUncompletedDirectories()
 		if len(uncompletedDirs) == 0 {
-			fmt.Println("All directories have been processed!")
+			logInfo("All directories have been processed!")
 			return
 		}
 
-		fmt.Printf("Processing %d remaining directories...\n", len(uncompletedDirs))
+		logInfo("Processing %d remaining directories...", len(uncompletedDirs))
 
 		// Record start time
 		startTime := time.Now()
 
+		// Count files across all directories up front so progress is global
+		atomic.StoreInt64(&filesTotal, countAllFilesToProcess(uncompletedDirs))
+		atomic.StoreInt64(&filesDone, 0)
+		logInfo("Found %d files to process", filesTotal)
+		startProgressBar()
+
 		// Process directories with multithreading support in fake scan mode
 		if len(uncompletedDirs) <= 1 || config.Multithread <= 1 {
 			// Single-threaded processing for 1 directory or when multithread is disabled
 			for i, dirPath := range uncompletedDirs {
-				fmt.Printf("[%d/%d] Processing directory: %s\n", i+1, len(uncompletedDirs), dirPath)
+				logInfo("[%d/%d] Processing directory: %s", i+1, len(uncompletedDirs), dirPath)
 				
 				// Process this directory
-				if err := processImages(dirPath, 0); err != nil {
-					fmt.Printf("Error processing directory %s: %v\n", dirPath, err)
+				if err := processImages(ctx, dirPath, 0); err != nil {
+					recordFileError(dirPath, dirPath, err)
 					continue
 				}
 				
 				// Skip HTML report generation in fake scan mode
 				if config.Extensions != "" {
-					fmt.Printf("Skipping HTML report generation (extension filter active: %s)\n", config.Extensions)
+					logInfo("Skipping HTML report generation (extension filter active: %s)", config.Extensions)
 				}
 				
-				fmt.Printf("Completed directory: %s\n", dirPath)
+				logInfo("Completed directory: %s", dirPath)
 			}
 		} else {
 			// Multi-threaded processing
-			fmt.Printf("Using %d threads for parallel processing\n", config.Multithread)
+			logInfo("Using %d threads for parallel processing", config.Multithread)
+			if maxInflightBytes > 0 {
+				logInfo("Limiting directories in flight to %.1f MB of input", float64(maxInflightBytes)/(1024*1024))
+			}
 			
 			// Create semaphore to limit concurrent goroutines
 			semaphore := make(chan struct{}, config.Multithread)
 			var wg sync.WaitGroup
+			inflight := newByteLimiter(maxInflightBytes)
 			
 			for i, dirPath := range uncompletedDirs {
 				wg.Add(1)
 				go func(index int, path string) {
 					defer wg.Done()
 					
-					// Acquire semaphore
+					// Acquire semaphore, then room for the directory under -max-inflight-bytes
 					semaphore <- struct{}{}
 					defer func() { <-semaphore }()
+					cost := directoryCost(path)
+					inflight.acquire(cost)
+					defer inflight.release(cost)
 					
-					fmt.Printf("[%d/%d] Processing directory: %s\n", index+1, len(uncompletedDirs), path)
+					logInfo("[%d/%d] Processing directory: %s", index+1, len(uncompletedDirs), path)
 					
 					// Process this directory
-					if err := processImages(path, index+1); err != nil {
-						fmt.Printf("Error processing directory %s: %v\n", path, err)
+					if err := processImages(ctx, path, index+1); err != nil {
+						recordFileError(path, path, err)
 						return
 					}
 					
 					// Skip HTML report generation in fake scan mode
 					if config.Extensions != "" {
-						fmt.Printf("Skipping HTML report generation (extension filter active: %s)\n", config.Extensions)
+						logInfo("Skipping HTML report generation (extension filter active: %s)", config.Extensions)
 					}
 					
-					fmt.Printf("Completed directory: %s\n", path)
+					logInfo("Completed directory: %s", path)
 				}(i, dirPath)
 			}
 			
@@ -701,11 +2113,16 @@ func main() {
 			wg.Wait()
 		}
 
+		stopProgressBar()
+
 		// Record processing time
 		processingTime := time.Since(startTime).String()
 
-		fmt.Println("Batch processing completed!")
-		fmt.Printf("Total processing time: %s\n", processingTime)
+		logInfo("Batch processing completed!")
+		logInfo("Total processing time: %s", processingTime)
+		if printErrorSummary() > 0 {
+			os.Exit(exitPartialFailure)
+		}
 		return
 	}
 
@@ -713,10 +2130,10 @@ func main() {
 
 	// Scan directories if progress is empty
 	if len(tracker.Directories) == 0 {
-		fmt.Println("Scanning directories...")
+		logInfo("Scanning directories...")
 		directories, err := scanDirectories(config.InputDir)
 		if err != nil {
-			log.Fatalf("Failed to scan directories: %v", err)
+			fatalConfig("Failed to scan directories: %v", err)
 		}
 
 		// If no subdirectories found, process the root directory itself
@@ -734,41 +2151,60 @@ func main() {
 
 		// Save initial progress
 		if err := tracker.saveProgress(progressFile); err != nil {
-			log.Fatalf("Failed to save initial progress: %v", err)
+			fatalConfig("Failed to save initial progress: %v", err)
+		}
+		logInfo("Found %d directories to process", len(directories))
+	}
+
+	// -watch starts watching before the batch, so files arriving during it aren't missed
+	var inputWatch *inputWatcher
+	if config.Watch {
+		inputWatch, err = startWatching()
+		if err != nil {
+			fatalConfig("%v", err)
 		}
-		fmt.Printf("Found %d directories to process\n", len(directories))
 	}
 
 	// Get uncompleted directories
 	uncompletedDirs := tracker.getUncompletedDirectories()
 	if len(uncompletedDirs) == 0 {
-		fmt.Println("All directories have been processed!")
-		return
+		logInfo("All directories have been processed!")
+		if inputWatch == nil {
+			return
+		}
 	}
 
-	fmt.Printf("Processing %d remaining directories...\n", len(uncompletedDirs))
+	logInfo("Processing %d remaining directories...", len(uncompletedDirs))
 
 	// Record start time
 	startTime := time.Now()
 
+	// Count files across all directories up front so progress is global
+	atomic.StoreInt64(&filesTotal, countAllFilesToProcess(uncompletedDirs))
+	atomic.StoreInt64(&filesDone, 0)
+	logInfo("Found %d files to process", filesTotal)
+	startProgressBar()
+
 	// Process directories with multithreading support
 	if len(uncompletedDirs) <= 1 || config.Multithread <= 1 {
 		// Single-threaded processing for 1 directory or when multithread is disabled
 		for i, dirPath := range uncompletedDirs {
-			fmt.Printf("[%d/%d] Processing directory: %s\n", i+1, len(uncompletedDirs), dirPath)
+			logInfo("[%d/%d] Processing directory: %s", i+1, len(uncompletedDirs), dirPath)
 			
 			// Process this directory
-			if err := processImages(dirPath, 0); err != nil {
-				fmt.Printf("Error processing directory %s: %v\n", dirPath, err)
+			if err := processImages(ctx, dirPath, 0); err != nil {
+				recordFileError(dirPath, dirPath, err)
 				continue
 			}
 			
-			// Mark directory as completed
-			tracker.markDirectoryCompleted(dirPath)
-			
-			// Save progress after each directory
-			if err := tracker.saveProgress(progressFile); err != nil {
-				fmt.Printf("Warning: failed to save progress: %v\n", err)
+			// Mark directory as completed unless some of its files failed
+			if shouldMarkCompleted(dirPath) {
+				tracker.markDirectoryCompleted(dirPath)
+				
+				// Save progress after each directory
+				if err := tracker.saveProgress(progressFile); err != nil {
+					logWarn("failed to save progress: %v", err)
+				}
 			}
 			
 			// Generate HTML report for this directory only (skip if using extension filter)
@@ -776,51 +2212,64 @@ func main() {
 				for dirPath, dirStats := range stats.DirectoryStats {
 					if len(dirStats.Files) > 0 {
 						if err := generateDirectoryHTMLReport(dirPath, dirStats); err != nil {
-							fmt.Printf("Warning: failed to generate HTML report for directory '%s': %v\n", dirPath, err)
+							logWarn("failed to generate HTML report for directory '%s': %v", dirPath, err)
 						}
 					}
 				}
 			} else {
-				fmt.Printf("Skipping HTML report generation (extension filter active: %s)\n", config.Extensions)
+				logInfo("Skipping HTML report generation (extension filter active: %s)", config.Extensions)
 			}
 			
 			// Reset stats for next directory
+			collectReportFiles()
 			stats = ProcessStats{DirectoryStats: make(map[string]*DirectoryStats)}
 			
-			fmt.Printf("Completed directory: %s\n", dirPath)
+			// Move the finished directory to the archive or bucket
+			storeDirectory(dirPath)
+			
+			logInfo("Completed directory: %s", dirPath)
 		}
 	} else {
 		// Multi-threaded processing for multiple directories
-		fmt.Printf("Using %d threads for parallel processing\n", config.Multithread)
+		logInfo("Using %d threads for parallel processing", config.Multithread)
+		if maxInflightBytes > 0 {
+			logInfo("Limiting directories in flight to %.1f MB of input", float64(maxInflightBytes)/(1024*1024))
+		}
 		
 		// Create a semaphore to limit concurrent goroutines
 		semaphore := make(chan struct{}, config.Multithread)
 		var wg sync.WaitGroup
+		inflight := newByteLimiter(maxInflightBytes)
 		
 		for i, dirPath := range uncompletedDirs {
 			wg.Add(1)
 			go func(dir string, index int) {
 				defer wg.Done()
 				
-				// Acquire semaphore
+				// Acquire semaphore, then room for the directory under -max-inflight-bytes
 				semaphore <- struct{}{}
 				defer func() { <-semaphore }()
+				cost := directoryCost(dir)
+				inflight.acquire(cost)
+				defer inflight.release(cost)
 				
-				fmt.Printf("[%d/%d] Processing directory: %s\n", index+1, len(uncompletedDirs), dir)
+				logInfo("[%d/%d] Processing directory: %s", index+1, len(uncompletedDirs), dir)
 				
 				// Process this directory
-				if err := processImages(dir, index+1); err != nil {
-					fmt.Printf("Error processing directory %s: %v\n", dir, err)
+				if err := processImages(ctx, dir, index+1); err != nil {
+					recordFileError(dir, dir, err)
 					return
 				}
 				
 				// Thread-safe operations with mutex
-				progressMutex.Lock()
-				tracker.markDirectoryCompleted(dir)
-				if err := tracker.saveProgress(progressFile); err != nil {
-					fmt.Printf("Warning: failed to save progress: %v\n", err)
+				if shouldMarkCompleted(dir) {
+					progressMutex.Lock()
+					tracker.markDirectoryCompleted(dir)
+					if err := tracker.saveProgress(progressFile); err != nil {
+						logWarn("failed to save progress: %v", err)
+					}
+					progressMutex.Unlock()
 				}
-				progressMutex.Unlock()
 				
 				// Generate HTML report (thread-safe)
 				statsMutex.Lock()
@@ -828,31 +2277,116 @@ func main() {
 					for dirPath, dirStats := range stats.DirectoryStats {
 						if len(dirStats.Files) > 0 {
 							if err := generateDirectoryHTMLReport(dirPath, dirStats); err != nil {
-								fmt.Printf("Warning: failed to generate HTML report for directory '%s': %v\n", dirPath, err)
+								logWarn("failed to generate HTML report for directory '%s': %v", dirPath, err)
 							}
 						}
 					}
 				} else {
-					fmt.Printf("Skipping HTML report generation (extension filter active: %s)\n", config.Extensions)
+					logInfo("Skipping HTML report generation (extension filter active: %s)", config.Extensions)
 				}
 				// Reset stats for next directory
+				collectReportFiles()
 				stats = ProcessStats{DirectoryStats: make(map[string]*DirectoryStats)}
 				statsMutex.Unlock()
 				
-				fmt.Printf("Completed directory: %s\n", dir)
+				// Move the finished directory to the archive or bucket
+				storeDirectory(dir)
+				
+				logInfo("Completed directory: %s", dir)
 			}(dirPath, i)
 		}
 		
 		// Wait for all goroutines to complete
 		wg.Wait()
-		fmt.Println("All directories processed in parallel")
+		logInfo("All directories processed in parallel")
+	}
+
+	stopProgressBar()
+
+	// Keep processing new files until Ctrl-C
+	if inputWatch != nil {
+		inputWatch.run(ctx, tracker, progressFile)
 	}
 
 	// Record processing time
 	processingTime := time.Since(startTime).String()
 
-	fmt.Println("Batch processing completed!")
-	fmt.Printf("Total processing time: %s\n", processingTime)
+	// Per-file CSV and JSON are written regardless of the -ext filter
+	if config.CSVReport {
+		if err := writeCSVReport(reportFiles); err != nil {
+			logWarn("%v", err)
+		}
+	}
+	if config.JSONReport {
+		if err := writeJSONReport(reportFiles); err != nil {
+			logWarn("%v", err)
+		}
+	}
+	if err := closeManifest(); err != nil {
+		logWarn("%v", err)
+	}
+
+	// The reports go to the archive or bucket with the remaining outputs
+	if err := closeOutputStore(); err != nil {
+		logError("%v", err)
+		os.Exit(exitPartialFailure)
+	}
+
+	logInfo("Batch processing completed!")
+	logInfo("Total processing time: %s", processingTime)
+	if config.Dedupe {
+		logDedupeSummary()
+	}
+	if jpegtranPath != "" {
+		logJPEGOptimizeSummary()
+	}
+	if printErrorSummary() > 0 {
+		os.Exit(exitPartialFailure)
+	}
+}
+
+// reportOutputPath returns the output of a file relative to the output directory, for the
+// report links and the manifest; -sizes variants are listed in the file's Variants instead
+func reportOutputPath(file FileInfo) string {
+	filePath := file.Path
+	ext := strings.ToLower(filepath.Ext(filePath))
+
+	// Handle HEIC files that were converted to JPG
+	outputPath := filePath
+	if writesJPEGName(ext) && (file.Type == "processed" || file.Type == "duplicate") {
+		// Transcoded HEIC files (and PNGs set to JPEG) are converted to JPG, so update the link path
+		outputPath = strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".jpg"
+	}
+
+	// Encoded videos may have moved to a codec-specific container
+	if file.Type == "video_processed" {
+		outputPath = videoOutputPath(outputPath)
+	}
+	outputPath = applyOutputSuffix(outputPath)
+
+	// Images named by -output-template
+	if templated, ok := templatedReportPath(file); ok {
+		outputPath = templated
+	}
+	return outputPath
+}
+
+// reportURL turns a path relative to a report into an href or src value: each segment is
+// percent-encoded, so names with #, ? or % still resolve and a name like "x:y.jpg" can't be
+// read as a URL scheme, and the result is HTML-escaped. Embedded data URIs are only escaped.
+func reportURL(path string) string {
+	if strings.HasPrefix(path, "data:") {
+		return html.EscapeString(path)
+	}
+	segments := strings.Split(filepath.ToSlash(path), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	escaped := strings.Join(segments, "/")
+	if strings.Contains(segments[0], ":") {
+		escaped = "./" + escaped
+	}
+	return html.EscapeString(escaped)
 }
 
 // generateDirectoryHTMLReport generates an HTML report for a specific directory
@@ -879,7 +2413,7 @@ func generateDirectoryHTMLReport(currentDir string, dirStats *DirectoryStats) er
 	}
 	
 	// Generate directory title
-	dirTitle := fmt.Sprintf("Directory: %s", currentDir)
+	dirTitle := html.EscapeString(fmt.Sprintf("Directory: %s", currentDir))
 	
 	htmlContent := fmt.Sprintf(`<!DOCTYPE html>
 <html lang="en">
@@ -909,6 +2443,7 @@ func generateDirectoryHTMLReport(currentDir string, dirStats *DirectoryStats) er
         .video_processed { background: #d1ecf1; color: #0c5460; }
         .copied { background: #fff3cd; color: #856404; }
         .skipped { background: #f8d7da; color: #721c24; }
+        .duplicate { background: #e2e3e5; color: #383d41; }
         
         .thumbnail { width: 100%%; height: 200px; object-fit: cover; border-radius: 5px; margin: 10px 0; background: #f8f9fa; display: flex; align-items: center; justify-content: center; color: #666; }
         .video-placeholder { background: #e9ecef; border: 2px dashed #adb5bd; }
@@ -921,6 +2456,7 @@ func generateDirectoryHTMLReport(currentDir string, dirStats *DirectoryStats) er
         .compression-ratio { font-weight: bold; color: #28a745; }
         
         h2 { color: #333; margin-top: 30px; }
+        .settings { font-size: 14px; color: #666; max-width: 600px; }
     </style>
 </head>
 <body>
@@ -944,6 +2480,18 @@ func generateDirectoryHTMLReport(currentDir string, dirStats *DirectoryStats) er
                 <div class="stat-number">%d</div>
                 <div class="stat-label">Skipped Images</div>
             </div>
+            <div class="stat-card">
+                <div class="stat-number">%d</div>
+                <div class="stat-label">Processed Videos</div>
+            </div>
+            <div class="stat-card">
+                <div class="stat-number">%d</div>
+                <div class="stat-label">Skipped Videos</div>
+            </div>
+            <div class="stat-card">
+                <div class="stat-number">%d</div>
+                <div class="stat-label">Duplicates</div>
+            </div>
             <div class="stat-card">
                 <div class="stat-number">%.1f MB</div>
                 <div class="stat-label">Input Size</div>
@@ -957,7 +2505,7 @@ func generateDirectoryHTMLReport(currentDir string, dirStats *DirectoryStats) er
                 <div class="stat-label">Space Saved</div>
             </div>
         </div>
-        
+        %s
         <h2>Processed Files</h2>
         <div class="files-grid">`,
 		dirTitle, dirTitle,
@@ -965,9 +2513,13 @@ func generateDirectoryHTMLReport(currentDir string, dirStats *DirectoryStats) er
 		dirStats.ProcessedImages,
 		dirStats.CopiedFiles,
 		dirStats.SkippedImages,
+		dirStats.ProcessedVideos,
+		dirStats.SkippedVideos,
+		dirStats.DuplicateFiles,
 		float64(dirStats.TotalInputSize)/1024/1024,
 		float64(dirStats.TotalOutputSize)/1024/1024,
-		spaceSavedPercent)
+		spaceSavedPercent,
+		reportSettingsHTML())
 	
 	// Add file cards for this directory
 	for _, file := range dirStats.Files {
@@ -977,11 +2529,11 @@ func generateDirectoryHTMLReport(currentDir string, dirStats *DirectoryStats) er
 		isImage := ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".heic"
 		isVideo := strings.Contains(file.Type, "video") || ext == ".mov" || ext == ".mp4" || ext == ".avi" || ext == ".mkv"
 		
-		// Handle HEIC files that were converted to JPG
-		actualFilePath := filePath
-		if ext == ".heic" {
-			// HEIC files are converted to JPG, so update the link path
-			actualFilePath = strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".jpg"
+		actualFilePath := reportOutputPath(file)
+		
+		// Responsive sets have no single output, preview the largest variant
+		if len(file.Variants) > 0 {
+			actualFilePath = file.Variants[len(file.Variants)-1]
 		}
 		
 		// Adjust the file path to be relative to the report location
@@ -1000,7 +2552,13 @@ func generateDirectoryHTMLReport(currentDir string, dirStats *DirectoryStats) er
 		// Create thumbnail or placeholder
 		var thumbnailHTML string
 		if isImage {
-			thumbnailHTML = fmt.Sprintf(`<img src="%s" alt="%s" class="thumbnail" onerror="this.style.display='none'; this.nextElementSibling.style.display='flex';"><div class="thumbnail" style="display:none;">📷 Image Preview</div>`, actualFilePath, actualFilePath)
+			// The -thumbnails preview when there is one, rather than the full-size output
+			previewPath := reportImageSource(file, currentDir, actualFilePath)
+			thumbnailHTML = fmt.Sprintf(`<img src="%s" alt="%s" class="thumbnail" loading="lazy" onerror="this.style.display='none'; this.nextElementSibling.style.display='flex';"><div class="thumbnail" style="display:none;">📷 Image Preview</div>`, reportURL(previewPath), html.EscapeString(actualFilePath))
+		} else if isVideo && file.ThumbnailPath != "" {
+			// Use the extracted poster frame, relative to the report location
+			posterPath := reportImageSource(file, currentDir, "")
+			thumbnailHTML = fmt.Sprintf(`<img src="%s" alt="%s" class="thumbnail" onerror="this.style.display='none'; this.nextElementSibling.style.display='flex';"><div class="thumbnail video-placeholder" style="display:none;">🎬 Video File</div>`, reportURL(posterPath), html.EscapeString(posterPath))
 		} else if isVideo {
 			thumbnailHTML = `<div class="thumbnail video-placeholder">🎬 Video File</div>`
 		} else {
@@ -1023,10 +2581,10 @@ func generateDirectoryHTMLReport(currentDir string, dirStats *DirectoryStats) er
                         <span class="detail-label">Output Size:</span>
                         <span>%.1f KB</span>
                     </div>`,
-			actualFilePath,
-			filePath,
-			file.Type,
-			file.Type,
+			reportURL(actualFilePath),
+			html.EscapeString(filePath),
+			html.EscapeString(file.Type),
+			html.EscapeString(file.Type),
 			thumbnailHTML,
 			float64(file.InputSize)/1024,
 			float64(file.OutputSize)/1024)
@@ -1037,7 +2595,115 @@ func generateDirectoryHTMLReport(currentDir string, dirStats *DirectoryStats) er
                     <div class="detail-row">
                         <span class="detail-label">Dimensions:</span>
                         <span>%s → %s</span>
-                    </div>`, file.OriginalDim, file.NewDim)
+                    </div>`, html.EscapeString(file.OriginalDim), html.EscapeString(file.NewDim))
+		}
+		
+		// Show the crop applied before resizing
+		if file.CroppedDim != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Cropped:</span>
+                        <span>%s → %s</span>
+                    </div>`, html.EscapeString(file.OriginalDim), html.EscapeString(file.CroppedDim))
+		}
+		
+		// List the responsive image set written for this source
+		if len(file.Variants) > 0 {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Sizes:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(strings.Join(file.Variants, ", ")))
+		}
+		
+		// Add audio handling info for videos
+		if file.AudioHandling != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Audio:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.AudioHandling))
+		}
+		
+		// Point duplicates at the identical file they were linked to
+		if file.DuplicateOf != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Duplicate of:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.DuplicateOf))
+		}
+		
+		// Explain why a skipped file was copied unchanged
+		if file.SkipReason != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Skipped:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.SkipReason))
+		}
+		
+		// Explain why a file was copied instead of processed
+		if file.Note != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Note:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.Note))
+		}
+		
+		// Add checksum verification status for copied files
+		if file.Verification != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Checksum:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.Verification))
+		}
+		
+		// Show color adjustments applied to processed images
+		if file.Adjustments != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Adjustments:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.Adjustments))
+		}
+		
+		// Show non-default PNG encoding so sizes can be compared
+		if file.Encoding != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Encoding:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.Encoding))
+		}
+		
+		// Show the -quality-analysis result
+		if file.SSIM > 0 {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Quality:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(describeQuality(file)))
+		}
+		
+		// Show how camera RAW files were handled
+		if file.RawHandling != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">RAW:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.RawHandling))
+		}
+		
+		// List extra outputs split from multi-image HEICs
+		if len(file.Extracted) > 0 {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Extracted:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(strings.Join(file.Extracted, ", ")))
 		}
 		
 		htmlContent += fmt.Sprintf(`
@@ -1090,6 +2756,7 @@ func generateHTMLReport() error {
         .video_processed { background: #d1ecf1; color: #0c5460; }
         .copied { background: #fff3cd; color: #856404; }
         .skipped { background: #f8d7da; color: #721c24; }
+        .duplicate { background: #e2e3e5; color: #383d41; }
         
         .thumbnail { width: 100%%; height: 200px; object-fit: cover; border-radius: 5px; margin: 10px 0; background: #f8f9fa; display: flex; align-items: center; justify-content: center; color: #666; }
         .video-placeholder { background: #e9ecef; border: 2px dashed #adb5bd; }
@@ -1102,6 +2769,7 @@ func generateHTMLReport() error {
         .compression-ratio { font-weight: bold; color: #28a745; }
         
         h2 { color: #333; margin-top: 30px; }
+        .settings { font-size: 14px; color: #666; max-width: 600px; }
     </style>
 </head>
 <body>
@@ -1125,6 +2793,18 @@ func generateHTMLReport() error {
                 <div class="stat-number">%d</div>
                 <div class="stat-label">Skipped Images</div>
             </div>
+            <div class="stat-card">
+                <div class="stat-number">%d</div>
+                <div class="stat-label">Processed Videos</div>
+            </div>
+            <div class="stat-card">
+                <div class="stat-number">%d</div>
+                <div class="stat-label">Skipped Videos</div>
+            </div>
+            <div class="stat-card">
+                <div class="stat-number">%d</div>
+                <div class="stat-label">Duplicates</div>
+            </div>
             <div class="stat-card">
                 <div class="stat-number">%.1f MB</div>
                 <div class="stat-label">Input Size</div>
@@ -1142,17 +2822,21 @@ func generateHTMLReport() error {
                 <div class="stat-label">Processing Time</div>
             </div>
         </div>
-        
+        %s
         <h2>Processed Files</h2>
         <div class="files-grid">`,
 		stats.TotalFiles,
 		stats.ProcessedImages,
 		stats.CopiedFiles,
 		stats.SkippedImages,
+		stats.ProcessedVideos,
+		stats.SkippedVideos,
+		stats.DuplicateFiles,
 		float64(stats.TotalInputSize)/1024/1024,
 		float64(stats.TotalOutputSize)/1024/1024,
 		(1.0-float64(stats.TotalOutputSize)/float64(stats.TotalInputSize))*100,
-		stats.ProcessingTime)
+		stats.ProcessingTime,
+		reportSettingsHTML())
 	
 	// Add file cards
 	for _, file := range stats.Files {
@@ -1162,17 +2846,21 @@ func generateHTMLReport() error {
 		isImage := ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".heic"
 		isVideo := strings.Contains(file.Type, "video") || ext == ".mov" || ext == ".mp4" || ext == ".avi" || ext == ".mkv"
 		
-		// Handle HEIC files that were converted to JPG
-		actualFilePath := filePath
-		if ext == ".heic" {
-			// HEIC files are converted to JPG, so update the link path
-			actualFilePath = strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".jpg"
+		actualFilePath := reportOutputPath(file)
+		
+		// Responsive sets have no single output, preview the largest variant
+		if len(file.Variants) > 0 {
+			actualFilePath = file.Variants[len(file.Variants)-1]
 		}
 		
 		// Create thumbnail or placeholder
 		var thumbnailHTML string
 		if isImage {
-			thumbnailHTML = fmt.Sprintf(`<img src="%s" alt="%s" class="thumbnail" onerror="this.style.display='none'; this.nextElementSibling.style.display='flex';"><div class="thumbnail" style="display:none;">📷 Image Preview</div>`, actualFilePath, actualFilePath)
+			// The -thumbnails preview when there is one, rather than the full-size output
+			previewPath := reportImageSource(file, ".", actualFilePath)
+			thumbnailHTML = fmt.Sprintf(`<img src="%s" alt="%s" class="thumbnail" loading="lazy" onerror="this.style.display='none'; this.nextElementSibling.style.display='flex';"><div class="thumbnail" style="display:none;">📷 Image Preview</div>`, reportURL(previewPath), html.EscapeString(actualFilePath))
+		} else if isVideo && file.ThumbnailPath != "" {
+			thumbnailHTML = fmt.Sprintf(`<img src="%s" alt="%s" class="thumbnail" onerror="this.style.display='none'; this.nextElementSibling.style.display='flex';"><div class="thumbnail video-placeholder" style="display:none;">🎬 Video File</div>`, reportURL(file.ThumbnailPath), html.EscapeString(file.ThumbnailPath))
 		} else if isVideo {
 			thumbnailHTML = `<div class="thumbnail video-placeholder">🎬 Video File</div>`
 		} else {
@@ -1195,10 +2883,10 @@ func generateHTMLReport() error {
                         <span class="detail-label">Output Size:</span>
                         <span>%.1f KB</span>
                     </div>`,
-			actualFilePath,
-			filePath,
-			file.Type,
-			file.Type,
+			reportURL(actualFilePath),
+			html.EscapeString(filePath),
+			html.EscapeString(file.Type),
+			html.EscapeString(file.Type),
 			thumbnailHTML,
 			float64(file.InputSize)/1024,
 			float64(file.OutputSize)/1024)
@@ -1209,7 +2897,115 @@ func generateHTMLReport() error {
                     <div class="detail-row">
                         <span class="detail-label">Dimensions:</span>
                         <span>%s → %s</span>
-                    </div>`, file.OriginalDim, file.NewDim)
+                    </div>`, html.EscapeString(file.OriginalDim), html.EscapeString(file.NewDim))
+		}
+		
+		// Show the crop applied before resizing
+		if file.CroppedDim != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Cropped:</span>
+                        <span>%s → %s</span>
+                    </div>`, html.EscapeString(file.OriginalDim), html.EscapeString(file.CroppedDim))
+		}
+		
+		// List the responsive image set written for this source
+		if len(file.Variants) > 0 {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Sizes:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(strings.Join(file.Variants, ", ")))
+		}
+		
+		// Add audio handling info for videos
+		if file.AudioHandling != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Audio:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.AudioHandling))
+		}
+		
+		// Point duplicates at the identical file they were linked to
+		if file.DuplicateOf != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Duplicate of:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.DuplicateOf))
+		}
+		
+		// Explain why a skipped file was copied unchanged
+		if file.SkipReason != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Skipped:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.SkipReason))
+		}
+		
+		// Explain why a file was copied instead of processed
+		if file.Note != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Note:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.Note))
+		}
+		
+		// Add checksum verification status for copied files
+		if file.Verification != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Checksum:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.Verification))
+		}
+		
+		// Show color adjustments applied to processed images
+		if file.Adjustments != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Adjustments:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.Adjustments))
+		}
+		
+		// Show non-default PNG encoding so sizes can be compared
+		if file.Encoding != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Encoding:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.Encoding))
+		}
+		
+		// Show the -quality-analysis result
+		if file.SSIM > 0 {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Quality:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(describeQuality(file)))
+		}
+		
+		// Show how camera RAW files were handled
+		if file.RawHandling != "" {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">RAW:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(file.RawHandling))
+		}
+		
+		// List extra outputs split from multi-image HEICs
+		if len(file.Extracted) > 0 {
+			htmlContent += fmt.Sprintf(`
+                    <div class="detail-row">
+                        <span class="detail-label">Extracted:</span>
+                        <span>%s</span>
+                    </div>`, html.EscapeString(strings.Join(file.Extracted, ", ")))
 		}
 		
 		htmlContent += fmt.Sprintf(`
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestName is the file -manifest keeps in the output directory
+const manifestName = "manifest.json"
+
+// manifestFlushInterval bounds how often the manifest is rewritten while files complete
+const manifestFlushInterval = time.Second
+
+// manifestEntry describes one output file and the input that produced it
+type manifestEntry struct {
+	Source        string    // Input path relative to the input directory
+	SourceSize    int64     // With SourceModTime, tells whether the source changed since
+	SourceModTime time.Time `json:",omitempty"`
+	Type          string    // Result as in the reports: processed, copied, skipped, video_processed, ...
+	Format        string    // Output format: jpeg, png, heic for images, else the file extension
+	Width         int       `json:",omitempty"`
+	Height        int       `json:",omitempty"`
+	Size          int64     // Output size in bytes
+	Encoding      string    `json:",omitempty"`
+	Adjustments   string    `json:",omitempty"`
+	AudioHandling string    `json:",omitempty"`
+	Completed     time.Time
+}
+
+// manifestFile is the layout of OutputDir/manifest.json. Outputs are keyed by their path
+// relative to the output directory, with forward slashes.
+type manifestFile struct {
+	Settings ReportSettings
+	Outputs  map[string]manifestEntry
+}
+
+// manifestOutputs holds the entries of the whole run, including the ones read back from an
+// earlier run's manifest; nil when -manifest is off
+var manifestOutputs map[string]manifestEntry
+var manifestDirty bool
+var manifestMutex sync.Mutex
+
+// manifestDone stops the flusher started by startManifest, manifestFlusher waits for it
+var manifestDone chan struct{}
+var manifestFlusher sync.WaitGroup
+
+// startManifest reads back the manifest of earlier runs and starts rewriting it as files
+// complete, at most once per manifestFlushInterval
+func startManifest() {
+	manifestOutputs = make(map[string]manifestEntry)
+	path := filepath.Join(config.OutputDir, manifestName)
+	if data, err := os.ReadFile(path); err == nil {
+		var previous manifestFile
+		if err := json.Unmarshal(data, &previous); err != nil {
+			logWarn("ignoring the unreadable %s, starting a new manifest: %v", path, err)
+		} else if previous.Outputs != nil {
+			manifestOutputs = previous.Outputs
+			logDebug("Read %d manifest entries from %s", len(manifestOutputs), path)
+		}
+	}
+
+	manifestDone = make(chan struct{})
+	manifestFlusher.Add(1)
+	go func() {
+		defer manifestFlusher.Done()
+		ticker := time.NewTicker(manifestFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := flushManifest(); err != nil {
+					logWarn("%v", err)
+				}
+			case <-manifestDone:
+				return
+			}
+		}
+	}()
+}
+
+// closeManifest stops the flusher and writes the final manifest
+func closeManifest() error {
+	if manifestDone == nil {
+		return nil
+	}
+	close(manifestDone)
+	manifestFlusher.Wait()
+	manifestDone = nil
+	return flushManifest()
+}
+
+// recordManifest adds the outputs of a finished file to the manifest: its output, or each
+// -sizes variant, and any files extracted from it. RAW files skipped by -raw-mode have none.
+func recordManifest(file FileInfo) {
+	if manifestOutputs == nil || file.RawHandling == "skip" {
+		return
+	}
+	entry := manifestEntry{
+		Source:        filepath.ToSlash(file.Path),
+		Type:          file.Type,
+		Encoding:      file.Encoding,
+		Adjustments:   file.Adjustments,
+		AudioHandling: file.AudioHandling,
+		Completed:     time.Now(),
+	}
+	if info, err := os.Stat(filepath.Join(config.InputDir, file.Path)); err == nil && info.Mode().IsRegular() {
+		entry.SourceSize, entry.SourceModTime = info.Size(), info.ModTime()
+	}
+
+	outputs := file.Variants
+	if len(outputs) == 0 {
+		outputs = []string{reportOutputPath(file)}
+		fmt.Sscanf(file.NewDim, "%dx%d", &entry.Width, &entry.Height)
+	}
+	outputs = append(outputs, file.Extracted...)
+
+	manifestMutex.Lock()
+	defer manifestMutex.Unlock()
+	for _, output := range outputs {
+		outputEntry := entry
+		outputPath := filepath.Join(config.OutputDir, output)
+		if info, err := os.Stat(outputPath); err == nil {
+			outputEntry.Size = info.Size()
+		}
+		outputEntry.Format = manifestFormat(file, outputPath)
+		manifestOutputs[filepath.ToSlash(output)] = outputEntry
+	}
+	manifestDirty = true
+}
+
+// manifestFormat names the format of an output: images re-encoded by this run by their
+// encoding, which a .png name without -keep-format doesn't tell, other files by extension
+func manifestFormat(file FileInfo, outputPath string) string {
+	ext := strings.ToLower(filepath.Ext(outputPath))
+	switch {
+	case file.Type != "processed" && file.Type != "duplicate":
+	case ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".heic":
+		return outputImageFormat(outputPath)
+	}
+	return strings.TrimPrefix(ext, ".")
+}
+
+// flushManifest rewrites the manifest atomically if files completed since the last write, so
+// a reader never sees a partial file and an interrupted run leaves a valid one
+func flushManifest() error {
+	manifestMutex.Lock()
+	if !manifestDirty {
+		manifestMutex.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(manifestFile{Settings: currentReportSettings(), Outputs: manifestOutputs}, "", "  ")
+	manifestDirty = false
+	manifestMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+
+	path := filepath.Join(config.OutputDir, manifestName)
+	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return nil
+}
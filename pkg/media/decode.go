@@ -0,0 +1,138 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/jdeng/goheif"
+)
+
+// DecodeHEIC decodes HEIC image using goheif library; given an io.ReaderAt such as a file
+// section it reads only the boxes it needs instead of the whole file
+func DecodeHEIC(r io.Reader) (image.Image, error) {
+	return goheif.Decode(r)
+}
+
+// heifBrands are the ftyp brands of HEIC/HEIF still images and sequences
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true,
+	"hevc": true, "hevx": true, "mif1": true, "msf1": true,
+}
+
+// SniffFormat identifies an image by its first bytes (a Source's Header) and returns the
+// matching extension, ".jpg", ".png" or ".heic", or "" when it is none of them, so files
+// with the wrong extension still reach the right decoder
+func SniffFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return ".jpg"
+	case bytes.HasPrefix(header, pngSignature):
+		return ".png"
+	case len(header) >= 12 && string(header[4:8]) == "ftyp" && heifBrands[string(header[8:12])]:
+		return ".heic"
+	}
+	return ""
+}
+
+// DecodeConfig reads an image's dimensions from its header without decoding the pixels
+func DecodeConfig(ext string, r io.Reader) (image.Config, error) {
+	if ext == ".heic" {
+		return goheif.DecodeConfig(r)
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	return cfg, err
+}
+
+// DecodeError reports an input that can't be decoded as the image its extension or content
+// says (corrupt or mislabeled), as opposed to failures reading the file or writing the output
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string { return e.Err.Error() }
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// Input is an opened image with its decoder chosen and its metadata read, ready to decode
+type Input struct {
+	*Source
+	Ext        string // Lowercase extension of the path
+	Format     string // Decoder: Ext, or the format found by content when the extension is wrong
+	EXIF       []byte // Complete APP1 segment, nil when the image has none
+	EXIFErr    error  // Why the EXIF of a JPEG or HEIC couldn't be read; not fatal
+	RawPreview []byte // Embedded JPEG of a RAW file, which is decoded in its place
+}
+
+// ReadImage chooses the decoder of an opened image and reads its EXIF, leaving the pixels to
+// Decode so the header can be checked first. The content decides the decoder, so mislabeled
+// files (a PNG saved as .jpg, a phone export with the wrong extension) still decode; the
+// extension is the fallback. RAW files (raw) go through their embedded JPEG preview, whose
+// metadata is used since many cameras don't embed EXIF there.
+func ReadImage(src *Source, path string, raw bool) (*Input, error) {
+	in := &Input{Source: src, Ext: strings.ToLower(filepath.Ext(path))}
+	in.Format = in.Ext
+	if sniffed := SniffFormat(src.Header); sniffed != "" && !raw && sniffed != in.Ext &&
+		!(sniffed == ".jpg" && in.Ext == ".jpeg") {
+		in.Format = sniffed
+	}
+
+	if raw {
+		preview, err := ExtractRawPreview(src.Data)
+		if err != nil {
+			return nil, &DecodeError{fmt.Errorf("failed to extract RAW preview: %v", err)}
+		}
+		in.RawPreview = preview
+		in.EXIF, _ = ExtractEXIF(preview)
+		return in, nil
+	}
+
+	// PNG files typically don't contain EXIF data, so no extraction needed
+	switch in.Format {
+	case ".jpg", ".jpeg":
+		in.EXIF, in.EXIFErr = ExtractEXIF(src.Header)
+	case ".heic":
+		in.EXIF, in.EXIFErr = ExtractHEICEXIF(src.Section())
+	}
+	return in, nil
+}
+
+// Config reads the declared dimensions without decoding the pixels
+func (in *Input) Config() (image.Config, error) {
+	switch {
+	case in.RawPreview != nil:
+		return DecodeConfig(".jpg", bytes.NewReader(in.RawPreview))
+	case in.Format == ".heic":
+		return DecodeConfig(in.Format, in.Section())
+	}
+	return DecodeConfig(in.Format, bytes.NewReader(in.Header))
+}
+
+// Decode decodes the pixels as stored, before the EXIF orientation is applied
+func (in *Input) Decode() (image.Image, error) {
+	var img image.Image
+	var err error
+	switch {
+	case in.Format == ".heic":
+		if img, err = DecodeHEIC(in.Section()); err != nil {
+			return nil, &DecodeError{fmt.Errorf("failed to decode HEIC image: %v", err)}
+		}
+	case in.Format == ".png":
+		if img, err = png.Decode(in.Stream()); err != nil {
+			return nil, &DecodeError{fmt.Errorf("failed to decode PNG image: %v", err)}
+		}
+	case in.RawPreview != nil:
+		if img, err = jpeg.Decode(bytes.NewReader(in.RawPreview)); err != nil {
+			return nil, &DecodeError{fmt.Errorf("failed to decode RAW preview: %v", err)}
+		}
+	default:
+		if img, err = jpeg.Decode(in.Stream()); err != nil {
+			return nil, &DecodeError{fmt.Errorf("failed to decode JPEG image: %v", err)}
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,252 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/jdeng/goheif"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// ExtractEXIF extracts EXIF information from image file data
+func ExtractEXIF(data []byte) ([]byte, error) {
+	reader := bytes.NewReader(data)
+
+	// Check if it's a JPEG file by looking at the header
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		// Not a JPEG file (likely HEIC), skip EXIF extraction
+		return nil, fmt.Errorf("EXIF extraction only supported for JPEG files")
+	}
+
+	// Find APP1 segment (EXIF data) directly without calling exif.Decode
+	// This avoids TIFF byte order errors from corrupted EXIF data
+	buf := make([]byte, 2)
+
+	// Check JPEG file header
+	if _, err := reader.Read(buf); err != nil {
+		return nil, err
+	}
+	if buf[0] != 0xFF || buf[1] != 0xD8 {
+		return nil, fmt.Errorf("not a valid JPEG file")
+	}
+
+	// Find APP1 segment
+	for {
+		if _, err := reader.Read(buf); err != nil {
+			return nil, err
+		}
+
+		if buf[0] != 0xFF {
+			continue
+		}
+
+		// Found APP1 segment
+		if buf[1] == 0xE1 {
+			// Read segment length
+			if _, err := reader.Read(buf); err != nil {
+				return nil, err
+			}
+			length := int(buf[0])<<8 | int(buf[1])
+
+			// Read entire APP1 segment
+			exifSegment := make([]byte, length+2) // +2 for marker
+			exifSegment[0] = 0xFF
+			exifSegment[1] = 0xE1
+			exifSegment[2] = buf[0]
+			exifSegment[3] = buf[1]
+
+			if _, err := reader.Read(exifSegment[4:]); err != nil {
+				return nil, err
+			}
+
+			return exifSegment, nil
+		}
+
+		// If it's another segment, skip it
+		if buf[1] >= 0xE0 && buf[1] <= 0xEF {
+			if _, err := reader.Read(buf); err != nil {
+				return nil, err
+			}
+			length := int(buf[0])<<8 | int(buf[1])
+			reader.Seek(int64(length-2), io.SeekCurrent)
+		} else {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("EXIF data not found")
+}
+
+// EXIFOrientation returns the EXIF orientation (1-8) of the source, or 1 when it has none
+func EXIFOrientation(src *Source) int {
+	// Try to extract EXIF orientation from whichever container the source uses
+	exifSource := orientationEXIFSource(src)
+	if exifSource == nil {
+		return 1
+	}
+	reader := bytes.NewReader(exifSource)
+	x, err := exif.Decode(reader)
+	if err != nil {
+		// No EXIF data or unable to decode (e.g., TIFF byte order errors), treat as upright
+		// This is not an error condition, just means we can't apply orientation correction
+		return 1
+	}
+
+	// Get orientation tag
+	orientationTag, err := x.Get(exif.Orientation)
+	if err != nil {
+		// No orientation tag
+		return 1
+	}
+
+	// Get orientation value
+	orientation, err := orientationTag.Int(0)
+	if err != nil {
+		return 1
+	}
+	return orientation
+}
+
+// ApplyEXIFOrientation applies EXIF orientation correction to the image
+func ApplyEXIFOrientation(img image.Image, src *Source) image.Image {
+	orientation := EXIFOrientation(src)
+
+	// Apply transformation based on orientation value
+	switch orientation {
+	case 1:
+		// Normal orientation, no transformation needed
+		return img
+	case 2:
+		// Flip horizontal
+		return FlipHorizontal(img)
+	case 3:
+		// Rotate 180 degrees
+		return Rotate180(img)
+	case 4:
+		// Flip vertical
+		return FlipVertical(img)
+	case 5:
+		// Rotate 90 degrees clockwise and flip horizontal
+		return FlipHorizontal(Rotate90CW(img))
+	case 6:
+		// Rotate 90 degrees clockwise
+		return Rotate90CW(img)
+	case 7:
+		// Rotate 90 degrees counter-clockwise and flip horizontal
+		return FlipHorizontal(Rotate90CCW(img))
+	case 8:
+		// Rotate 90 degrees counter-clockwise
+		return Rotate90CCW(img)
+	default:
+		// Unknown orientation, return original
+		return img
+	}
+}
+
+// ClearOrientationTag removes the orientation tag from EXIF data
+func ClearOrientationTag(exifData []byte) []byte {
+	// For simplicity, we'll create a new EXIF segment with orientation set to 1 (normal)
+	// This is a basic implementation that works for most cases
+	if len(exifData) < 10 {
+		return exifData
+	}
+
+	// Make a copy of the EXIF data
+	cleanedData := make([]byte, len(exifData))
+	copy(cleanedData, exifData)
+
+	// Look for orientation tag (0x0112) in the EXIF data
+	// This is a simplified approach - in a full implementation, you'd parse the TIFF structure
+	for i := 0; i < len(cleanedData)-4; i++ {
+		// Look for orientation tag (0x0112 in big-endian or 0x1201 in little-endian)
+		if (cleanedData[i] == 0x01 && cleanedData[i+1] == 0x12) ||
+			(cleanedData[i] == 0x12 && cleanedData[i+1] == 0x01) {
+			// Found potential orientation tag, set value to 1 (normal orientation)
+			if i+8 < len(cleanedData) {
+				// Set the value to 1 (normal orientation)
+				cleanedData[i+6] = 0x00
+				cleanedData[i+7] = 0x01
+				break
+			}
+		}
+	}
+
+	return cleanedData
+}
+
+// InsertEXIF inserts a complete APP1 EXIF segment (as returned by ExtractEXIF) after the
+// SOI marker of JPEG data
+func InsertEXIF(jpegData, exifData []byte) []byte {
+	if len(jpegData) < 4 || jpegData[0] != 0xFF || jpegData[1] != 0xD8 {
+		return jpegData // Not a valid JPEG file
+	}
+
+	// exifData from ExtractEXIF already contains the complete APP1 segment
+	// (0xFFE1 marker + length + "Exif\x00\x00" + TIFF data)
+	// So we just need to insert it directly after the SOI marker
+
+	// Insert complete APP1 segment after SOI marker (0xFFD8)
+	result := make([]byte, 0, len(jpegData)+len(exifData))
+	result = append(result, jpegData[0:2]...) // SOI marker (0xFFD8)
+	result = append(result, exifData...)      // Complete APP1 segment with EXIF
+	result = append(result, jpegData[2:]...)  // Rest of JPEG data
+
+	return result
+}
+
+// orientationEXIFSource returns bytes exif.Decode can parse for the given source file: the
+// eXIf chunk for PNG, the Exif item for HEIC, and the header for JPEG and TIFF-based RAW
+func orientationEXIFSource(src *Source) []byte {
+	header := src.Header
+	switch {
+	case bytes.HasPrefix(header, pngSignature):
+		return pngEXIFChunk(header)
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		exifData, err := ExtractHEICEXIF(src.Section())
+		if err != nil {
+			return nil
+		}
+		// Strip the APP1-style header so exif.Decode sees the TIFF structure
+		return bytes.TrimPrefix(exifData, []byte("Exif\x00\x00"))
+	default:
+		return header
+	}
+}
+
+// pngSignature is the 8-byte header every PNG file starts with
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngEXIFChunk returns the TIFF-formatted EXIF data of a PNG eXIf chunk, or nil if absent
+func pngEXIFChunk(data []byte) []byte {
+	for offset := len(pngSignature); offset+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		chunkType := string(data[offset+4 : offset+8])
+		start := offset + 8
+		if length < 0 || start+length+4 > len(data) {
+			return nil
+		}
+		switch chunkType {
+		case "eXIf":
+			// Some writers keep the JPEG-style "Exif\0\0" prefix
+			return bytes.TrimPrefix(data[start:start+length], []byte("Exif\x00\x00"))
+		case "IEND":
+			return nil
+		}
+		offset = start + length + 4 // skip data and CRC
+	}
+	return nil
+}
+
+// ExtractHEICEXIF extracts EXIF information from a HEIC file
+func ExtractHEICEXIF(reader io.ReaderAt) ([]byte, error) {
+	// Use goheif.ExtractExif to extract EXIF from HEIC file
+	exifData, err := goheif.ExtractExif(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return exifData, nil
+}
@@ -0,0 +1,218 @@
+// Package media is the image and video core of batchMedia: decoding JPEG, PNG, HEIC and the
+// JPEG previews of camera RAW files, EXIF handling and orientation, resizing, and ffmpeg video
+// re-encoding. The batchMedia command walks directories, tracks progress and writes reports on
+// top of it; ProcessImage and ProcessVideo convert a single file for callers that need none of
+// that.
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+)
+
+// ImageOptions controls ProcessImage. The size fields work like the batchMedia command's
+// -width, -height, -fit, -size and -allow-upscale flags.
+type ImageOptions struct {
+	// Width and Height are the output size in pixels: with both, the image is fitted into the
+	// Width x Height box as Fit says; with one, the other follows the aspect ratio. When
+	// neither is set, ScalingRatio is used.
+	Width  int
+	Height int
+	// Fit fills a Width x Height box: contain (the default) fits the image inside it, cover
+	// fills it and trims the overhang, stretch takes the box size exactly
+	Fit string
+	// ScalingRatio scales both dimensions (e.g. 0.5 halves them); 0 keeps the size
+	ScalingRatio float64
+	// AllowUpscale lets the size options enlarge an image; otherwise it keeps its size
+	AllowUpscale bool
+	// Quality is the JPEG quality (1-100); 0 means 85
+	Quality int
+	// KeepOrientation leaves the pixels as stored instead of applying the EXIF orientation
+	KeepOrientation bool
+	// Writer puts the output in place
+	Writer Writer
+
+	// The hooks below let a caller such as the batchMedia command extend the pipeline; nil
+	// hooks are skipped. An error returned by a hook stops processing and is returned as is.
+
+	// Open opens the input; nil uses Open, reading RAW files whole
+	Open func(path string) (*Source, error)
+	// Inspect sees the input's decoder and metadata before the pixels are decoded
+	Inspect func(in *Input) error
+	// Prepare gets the upright image and returns the image to size
+	Prepare func(img image.Image) (image.Image, error)
+	// Finish gets the sized image and returns the image to encode
+	Finish func(img image.Image) (image.Image, error)
+	// Encode replaces the JPEG encoding, which carries the EXIF over with the orientation
+	// tag cleared
+	Encode func(img image.Image, in *Input) ([]byte, error)
+}
+
+// ImageResult describes a converted image
+type ImageResult struct {
+	OriginalWidth  int // Upright size of the decoded input
+	OriginalHeight int
+	Width          int // Size of the encoded output
+	Height         int
+	OutputSize     int64
+}
+
+// ProcessImage converts one JPEG, PNG, HEIC or RAW image at inputPath to a JPEG at outputPath:
+// it applies the EXIF orientation, resizes according to opts and carries the EXIF over with the
+// orientation tag cleared. The output is written through opts.Writer. Decoding and encoding
+// can't be interrupted, so ctx is checked between the steps and nothing is written once it is
+// done.
+func ProcessImage(ctx context.Context, opts ImageOptions, inputPath, outputPath string) (*ImageResult, error) {
+	if opts.Quality == 0 {
+		opts.Quality = 85
+	}
+	if opts.Quality < 1 || opts.Quality > 100 {
+		return nil, fmt.Errorf("quality must be between 1 and 100")
+	}
+	if opts.Width < 0 || opts.Height < 0 || opts.ScalingRatio < 0 {
+		return nil, fmt.Errorf("width, height and scaling ratio must not be negative")
+	}
+
+	// Decoders stream from the file, only the metadata header is read up front
+	raw := IsRawFile(inputPath)
+	open := opts.Open
+	if open == nil {
+		open = func(path string) (*Source, error) { return Open(path, raw) }
+	}
+	src, err := open(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input file: %v", err)
+	}
+	defer src.Close()
+
+	// Choose the decoder and read the EXIF; the pixels are decoded once the header is checked
+	in, err := ReadImage(src, inputPath, raw)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Inspect != nil {
+		if err := opts.Inspect(in); err != nil {
+			return nil, err
+		}
+	}
+	img, err := in.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if !opts.KeepOrientation {
+		img = ApplyEXIFOrientation(img, src)
+	}
+
+	// The input isn't read past this point; release it before the output (which may replace
+	// it in place) is written
+	src.Close()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	result := &ImageResult{OriginalWidth: bounds.Dx(), OriginalHeight: bounds.Dy()}
+	if opts.Prepare != nil {
+		if img, err = opts.Prepare(img); err != nil {
+			return nil, err
+		}
+	}
+	width, height := opts.TargetSize(img.Bounds().Dx(), img.Bounds().Dy())
+	img = opts.FitCrop(Resize(img, width, height))
+	if opts.Finish != nil {
+		if img, err = opts.Finish(img); err != nil {
+			return nil, err
+		}
+	}
+	result.Width, result.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	var data []byte
+	if opts.Encode != nil {
+		data, err = opts.Encode(img, in)
+	} else {
+		// The orientation has been applied to the pixels
+		data, err = EncodeJPEG(img, opts.Quality, ClearOrientationTag(in.EXIF))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := opts.Writer.Write(outputPath, data); err != nil {
+		return nil, fmt.Errorf("failed to write output file: %v", err)
+	}
+	result.OutputSize = int64(len(data))
+	return result, nil
+}
+
+// EncodeJPEG encodes img as a baseline JPEG and inserts exifData, a complete APP1 segment as
+// ExtractEXIF returns it, when there is one
+func EncodeJPEG(img image.Image, quality int, exifData []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %v", err)
+	}
+	if exifData == nil {
+		return buf.Bytes(), nil
+	}
+	return InsertEXIF(buf.Bytes(), exifData), nil
+}
+
+// TargetSize returns the size an image of width x height is resized to. Targets larger than
+// the image are clamped to its size unless AllowUpscale is set.
+func (opts ImageOptions) TargetSize(width, height int) (int, int) {
+	newWidth, newHeight := width, height
+	if opts.Width > 0 && opts.Height > 0 {
+		// Fit into the Width x Height box
+		newWidth, newHeight = FitSize(width, height, opts.Width, opts.Height, opts.fit())
+	} else if opts.Width > 0 {
+		// Scale by width, maintain aspect ratio
+		ratio := float64(opts.Width) / float64(width)
+		newWidth, newHeight = opts.Width, int(float64(height)*ratio)
+	} else if opts.Height > 0 {
+		// Scale by height, maintain aspect ratio
+		ratio := float64(opts.Height) / float64(height)
+		newWidth, newHeight = int(float64(width)*ratio), opts.Height
+	} else if opts.ScalingRatio > 0 {
+		// Scale by ratio
+		newWidth = int(float64(width) * opts.ScalingRatio)
+		newHeight = int(float64(height) * opts.ScalingRatio)
+	}
+
+	// Never enlarge pixels unless asked to
+	if !opts.AllowUpscale && (newWidth > width || newHeight > height) {
+		return width, height
+	}
+	return max(newWidth, 1), max(newHeight, 1)
+}
+
+// FitCrop trims an image scaled to cover the Width x Height box (Fit cover) to the box,
+// keeping the center. An image kept smaller than the box (no AllowUpscale) is only trimmed on
+// the side that overhangs.
+func (opts ImageOptions) FitCrop(img image.Image) image.Image {
+	if opts.fit() != "cover" || opts.Width == 0 || opts.Height == 0 {
+		return img
+	}
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	cropW, cropH := min(w, opts.Width), min(h, opts.Height)
+	if cropW == w && cropH == h {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, cropW, cropH))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min.Add(image.Pt((w-cropW)/2, (h-cropH)/2)), draw.Src)
+	return dst
+}
+
+// fit returns Fit, contain when empty
+func (opts ImageOptions) fit() string {
+	if opts.Fit == "" {
+		return "contain"
+	}
+	return opts.Fit
+}
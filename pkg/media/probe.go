@@ -0,0 +1,165 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// HDR transfer characteristics as named by ffmpeg's color_trc option
+const (
+	HDRTransferPQ  = "smpte2084"
+	HDRTransferHLG = "arib-std-b67"
+)
+
+// Probe is one ffprobe run's output, parsed once and shared by every check on a video.
+// A nil *Probe stands for a failed probe: SDR, no audio.
+type Probe struct {
+	Streams []struct {
+		CodecType      string            `json:"codec_type"`
+		CodecName      string            `json:"codec_name"`
+		Width          int               `json:"width"`
+		Height         int               `json:"height"`
+		ColorTransfer  string            `json:"color_transfer"`
+		ColorPrimaries string            `json:"color_primaries"`
+		Channels       int               `json:"channels"`
+		Tags           map[string]string `json:"tags"`
+		SideData       []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		Duration string            `json:"duration"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+// ProbeVideo runs ffprobe on a video and parses its output. ffprobe is killed when ctx is
+// done, so a hung probe goes with the file.
+func ProbeVideo(ctx context.Context, path string) (*Probe, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffprobe", "-show_format", "-show_streams", "-of", "json", path)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to probe video file: [%s] %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return ParseProbe(stdout.Bytes())
+}
+
+// ParseProbe parses the JSON written by ffprobe -show_format -show_streams -of json
+func ParseProbe(data []byte) (*Probe, error) {
+	probe := &Probe{}
+	if err := json.Unmarshal(data, probe); err != nil {
+		return nil, fmt.Errorf("failed to parse probe output: %v", err)
+	}
+	return probe, nil
+}
+
+// Resolution returns the displayed size of the first video stream. Phone videos are often
+// stored sideways with a rotation; ffmpeg applies it when encoding, so the stored width and
+// height are swapped for 90 and 270 degree rotations.
+func (p *Probe) Resolution() (int, int, error) {
+	if p == nil {
+		return 0, 0, fmt.Errorf("video was not probed")
+	}
+	for _, stream := range p.Streams {
+		if stream.CodecType != "video" || stream.Width <= 0 || stream.Height <= 0 {
+			continue
+		}
+		// Older files carry the rotation as a tag, newer ffprobe reports display matrix side data
+		rotation, _ := strconv.ParseFloat(stream.Tags["rotate"], 64)
+		for _, sideData := range stream.SideData {
+			if sideData.Rotation != 0 {
+				rotation = sideData.Rotation
+			}
+		}
+		if quarterTurns := int(math.Round(rotation/90)) % 2; quarterTurns != 0 {
+			return stream.Height, stream.Width, nil
+		}
+		return stream.Width, stream.Height, nil
+	}
+	return 0, 0, fmt.Errorf("no video stream found")
+}
+
+// HDRTransfer returns the HDR transfer characteristic of the first video stream, empty for
+// SDR or a failed probe. Only the stream's own color fields count, never the file name or
+// tags. HDR takes BT.2020 primaries with the PQ or HLG transfer; HLG and PQ need different
+// transfer tags, otherwise HLG gets re-tagged as PQ and displays wrong.
+func (p *Probe) HDRTransfer() string {
+	if p == nil {
+		return ""
+	}
+	for _, stream := range p.Streams {
+		if stream.CodecType != "video" {
+			continue
+		}
+		if stream.ColorPrimaries != "bt2020" {
+			return ""
+		}
+		switch stream.ColorTransfer {
+		case HDRTransferPQ:
+			return HDRTransferPQ
+		case HDRTransferHLG:
+			return HDRTransferHLG
+		}
+		return ""
+	}
+	return ""
+}
+
+// HasAudio reports whether the video has an audio stream
+func (p *Probe) HasAudio() bool {
+	if p == nil {
+		return false
+	}
+	for _, stream := range p.Streams {
+		if stream.CodecType == "audio" {
+			return true
+		}
+	}
+	return false
+}
+
+// SubtitleCodecs returns the codec of each subtitle stream, in stream order
+func (p *Probe) SubtitleCodecs() []string {
+	if p == nil {
+		return nil
+	}
+	var codecs []string
+	for _, stream := range p.Streams {
+		if stream.CodecType == "subtitle" {
+			codecs = append(codecs, stream.CodecName)
+		}
+	}
+	return codecs
+}
+
+// AudioChannels returns the channel count of the first audio stream, 0 when unknown
+func (p *Probe) AudioChannels() int {
+	if p == nil {
+		return 0
+	}
+	for _, stream := range p.Streams {
+		if stream.CodecType == "audio" {
+			return stream.Channels
+		}
+	}
+	return 0
+}
+
+// Duration returns the container duration in seconds
+func (p *Probe) Duration() (float64, error) {
+	if p == nil {
+		return 0, fmt.Errorf("video was not probed")
+	}
+	duration, err := strconv.ParseFloat(p.Format.Duration, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %v", p.Format.Duration, err)
+	}
+	return duration, nil
+}
@@ -0,0 +1,179 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/jpeg"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RawExtensions lists the TIFF-based camera RAW formats, which are decoded through their
+// embedded JPEG preview
+var RawExtensions = []string{".dng", ".cr2", ".nef", ".nrw", ".arw", ".srf", ".sr2", ".orf", ".rw2", ".pef", ".srw"}
+
+// IsRawFile reports whether the file is a recognized camera RAW file
+func IsRawFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, rawExt := range RawExtensions {
+		if ext == rawExt {
+			return true
+		}
+	}
+	return false
+}
+
+// TIFF tags used to locate embedded JPEG previews
+const (
+	tagCompression     = 0x0103
+	tagStripOffsets    = 0x0111
+	tagStripByteCounts = 0x0117
+	tagSubIFDs         = 0x014A
+	tagJPEGOffset      = 0x0201
+	tagJPEGLength      = 0x0202
+	tagExifIFD         = 0x8769
+	compressionOldJPEG = 6
+	compressionJPEG    = 7
+	maxRawIFDsToVisit  = 64
+)
+
+// rawPreview is a candidate JPEG stream inside a RAW file
+type rawPreview struct {
+	offset uint32
+	length uint32
+}
+
+// ExtractRawPreview returns the largest embedded JPEG preview that decodes, found by
+// walking the TIFF IFD chain, SubIFDs (DNG previews) and the EXIF IFD
+func ExtractRawPreview(data []byte) ([]byte, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("file too small to be a RAW image")
+	}
+
+	var order binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a TIFF-based RAW file")
+	}
+	// Magic is 42 for TIFF/DNG/NEF/CR2/ARW, ORF and RW2 use their own values
+	switch order.Uint16(data[2:4]) {
+	case 42, 0x4F52, 0x5352, 0x55:
+	default:
+		return nil, fmt.Errorf("unrecognized TIFF magic in RAW file")
+	}
+
+	var previews []rawPreview
+	visited := make(map[uint32]bool)
+	queue := []uint32{order.Uint32(data[4:8])}
+	for len(queue) > 0 && len(visited) < maxRawIFDsToVisit {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset == 0 || visited[offset] || int(offset)+2 > len(data) {
+			continue
+		}
+		visited[offset] = true
+
+		found, children, next := parseRawIFD(data, order, offset)
+		previews = append(previews, found...)
+		queue = append(queue, children...)
+		queue = append(queue, next)
+	}
+
+	// Prefer the largest preview; skip lossless JPEG raw data that image/jpeg can't decode
+	sort.Slice(previews, func(i, j int) bool { return previews[i].length > previews[j].length })
+	for _, p := range previews {
+		end := uint64(p.offset) + uint64(p.length)
+		if p.length == 0 || end > uint64(len(data)) {
+			continue
+		}
+		candidate := data[p.offset:end]
+		if len(candidate) < 2 || candidate[0] != 0xFF || candidate[1] != 0xD8 {
+			continue
+		}
+		if _, err := jpeg.DecodeConfig(bytes.NewReader(candidate)); err == nil {
+			return candidate, nil
+		}
+	}
+	return nil, fmt.Errorf("no embedded JPEG preview found")
+}
+
+// parseRawIFD reads one IFD and returns its JPEG candidates, child IFD offsets and the next IFD offset
+func parseRawIFD(data []byte, order binary.ByteOrder, offset uint32) ([]rawPreview, []uint32, uint32) {
+	count := int(order.Uint16(data[offset:]))
+	entriesStart := int(offset) + 2
+	if entriesStart+count*12+4 > len(data) {
+		return nil, nil, 0
+	}
+
+	var compression, stripOffset, stripLength, jpegOffset, jpegLength uint32
+	var children []uint32
+	for i := 0; i < count; i++ {
+		entry := data[entriesStart+i*12:]
+		tag := order.Uint16(entry[0:2])
+		valueCount := order.Uint32(entry[4:8])
+		value := rawIFDValue(entry, order)
+		switch tag {
+		case tagCompression:
+			compression = value
+		case tagStripOffsets:
+			// Previews are stored as a single strip
+			if valueCount == 1 {
+				stripOffset = value
+			}
+		case tagStripByteCounts:
+			if valueCount == 1 {
+				stripLength = value
+			}
+		case tagJPEGOffset:
+			jpegOffset = value
+		case tagJPEGLength:
+			jpegLength = value
+		case tagExifIFD:
+			children = append(children, value)
+		case tagSubIFDs:
+			children = append(children, rawSubIFDOffsets(data, order, entry, valueCount)...)
+		}
+	}
+
+	var previews []rawPreview
+	if jpegOffset != 0 && jpegLength != 0 {
+		previews = append(previews, rawPreview{jpegOffset, jpegLength})
+	}
+	if (compression == compressionOldJPEG || compression == compressionJPEG) && stripOffset != 0 && stripLength != 0 {
+		previews = append(previews, rawPreview{stripOffset, stripLength})
+	}
+
+	next := order.Uint32(data[entriesStart+count*12:])
+	return previews, children, next
+}
+
+// rawIFDValue reads a SHORT or LONG value stored inline in an IFD entry
+func rawIFDValue(entry []byte, order binary.ByteOrder) uint32 {
+	if order.Uint16(entry[2:4]) == 3 { // SHORT
+		return uint32(order.Uint16(entry[8:10]))
+	}
+	return order.Uint32(entry[8:12])
+}
+
+// rawSubIFDOffsets reads the SubIFDs array, which is stored out of line when it has more than one entry
+func rawSubIFDOffsets(data []byte, order binary.ByteOrder, entry []byte, count uint32) []uint32 {
+	if count == 1 {
+		return []uint32{order.Uint32(entry[8:12])}
+	}
+	start := order.Uint32(entry[8:12])
+	var offsets []uint32
+	for i := uint32(0); i < count && i < maxRawIFDsToVisit; i++ {
+		pos := uint64(start) + uint64(i)*4
+		if pos+4 > uint64(len(data)) {
+			break
+		}
+		offsets = append(offsets, order.Uint32(data[pos:]))
+	}
+	return offsets
+}
@@ -0,0 +1,179 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// maxHeaderBytes bounds the metadata kept in memory per image; a header running past it is cut
+// short, which only loses metadata, never pixels
+const maxHeaderBytes = 16 << 20
+
+// Source is an input image opened for decoding. JPEG, PNG and HEIC decoders read straight
+// from the file, so only the metadata header sits in memory next to the decoded image. Inputs
+// that need random access to all their bytes (RAW previews, HEIC motion videos) are buffered whole.
+type Source struct {
+	file *os.File
+	Data []byte // the whole file when buffered, otherwise nil
+	size int64
+	// Header holds the bytes metadata is read from: JPEG segments up to the scan data, PNG
+	// chunks other than IDAT, the ftyp box of a HEIC (its EXIF is read through the file), or
+	// the whole file when buffered
+	Header []byte
+}
+
+// Open opens an image for processing, reading its metadata header, or the whole file when
+// buffered is set
+func Open(path string, buffered bool) (*Source, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	src := &Source{file: file, size: fi.Size()}
+
+	if buffered {
+		src.Data, err = io.ReadAll(file)
+		src.Header = src.Data
+	} else {
+		src.Header, err = readImageHeader(io.NewSectionReader(file, 0, src.size))
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return src, nil
+}
+
+// Close releases the input file; closing again is harmless
+func (s *Source) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Section returns a fresh reader over the whole input; it is also an io.ReaderAt, which
+// goheif uses to avoid reading the file into memory
+func (s *Source) Section() *io.SectionReader {
+	if s.Data != nil {
+		return io.NewSectionReader(bytes.NewReader(s.Data), 0, s.size)
+	}
+	return io.NewSectionReader(s.file, 0, s.size)
+}
+
+// Stream returns a buffered reader over the whole input for sequential decoders
+func (s *Source) Stream() io.Reader {
+	return bufio.NewReader(s.Section())
+}
+
+// readImageHeader reads the metadata header of a JPEG or PNG, chosen by content. Anything
+// else, HEIC included, gets just its first bytes, enough to tell the container apart.
+func readImageHeader(r *io.SectionReader) ([]byte, error) {
+	magic := make([]byte, 12)
+	n, err := io.ReadFull(r, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0xFF, 0xD8}):
+		return readJPEGHeader(io.NewSectionReader(r, 0, r.Size()))
+	case bytes.HasPrefix(magic, pngSignature):
+		return readPNGHeader(r)
+	default:
+		// A HEIC's EXIF is read through the file; its leading ftyp box identifies it
+		return magic, nil
+	}
+}
+
+// readJPEGHeader returns the JPEG markers and segments before the first scan (SOS), which hold
+// the EXIF, ICC and frame header, as a JPEG prefix ExtractEXIF and DecodeConfig can parse
+func readJPEGHeader(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	var header bytes.Buffer
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(br, soi); err != nil {
+		return nil, err
+	}
+	header.Write(soi)
+
+	marker := make([]byte, 2)
+	for header.Len() < maxHeaderBytes {
+		if _, err := io.ReadFull(br, marker); err != nil {
+			break // Truncated file; the decoder reports it
+		}
+		if marker[0] != 0xFF {
+			break
+		}
+		header.Write(marker)
+		switch {
+		case marker[1] == 0xDA:
+			// Start of scan: no more metadata. Keep its length, which DecodeConfig reads
+			// before it stops at the scan (non-JFIF files only stop there)
+			length := make([]byte, 2)
+			if _, err := io.ReadFull(br, length); err == nil {
+				header.Write(length)
+			}
+			return header.Bytes(), nil
+		case marker[1] == 0xD9:
+			// End of image: no more metadata
+			return header.Bytes(), nil
+		case marker[1] == 0x01 || (marker[1] >= 0xD0 && marker[1] <= 0xD7):
+			continue // Standalone markers carry no length
+		}
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(br, length); err != nil {
+			break
+		}
+		header.Write(length)
+		segmentLength := int64(binary.BigEndian.Uint16(length)) - 2
+		if segmentLength < 0 {
+			break
+		}
+		if _, err := io.CopyN(&header, br, segmentLength); err != nil {
+			break
+		}
+	}
+	return header.Bytes(), nil
+}
+
+// readPNGHeader returns the PNG signature followed by every chunk except IDAT, so metadata
+// written after the image data (some encoders put eXIf there) is still found. IDAT chunks are
+// skipped by offset without being read.
+func readPNGHeader(r *io.SectionReader) ([]byte, error) {
+	var header bytes.Buffer
+	header.Write(pngSignature)
+	chunkHeader := make([]byte, 8)
+	for offset := int64(len(pngSignature)); offset+8 <= r.Size() && header.Len() < maxHeaderBytes; {
+		if _, err := r.ReadAt(chunkHeader, offset); err != nil {
+			return nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(chunkHeader))
+		chunkType := string(chunkHeader[4:8])
+		chunkEnd := offset + 8 + length + 4 // data and CRC
+		if chunkEnd > r.Size() {
+			break
+		}
+		if chunkType != "IDAT" {
+			if _, err := io.Copy(&header, io.NewSectionReader(r, offset, chunkEnd-offset)); err != nil {
+				return nil, err
+			}
+		}
+		if chunkType == "IEND" {
+			break
+		}
+		offset = chunkEnd
+	}
+	return header.Bytes(), nil
+}
@@ -0,0 +1,101 @@
+package media
+
+import (
+	"image"
+	"math"
+
+	"github.com/nfnt/resize"
+)
+
+// Resize resizes image using high-quality algorithm
+func Resize(src image.Image, newWidth, newHeight int) image.Image {
+	// Use Lanczos3 algorithm for high-quality scaling
+	// Lanczos3 provides the best image quality, especially suitable for photo scaling
+	return resize.Resize(uint(newWidth), uint(newHeight), src, resize.Lanczos3)
+}
+
+// FitSize scales width x height to a boxWidth x boxHeight box. contain fits the image inside
+// the box and cover fills it, both keeping the aspect ratio (cover overhangs on one side and is
+// trimmed afterwards); stretch takes the box size exactly.
+func FitSize(width, height, boxWidth, boxHeight int, fit string) (int, int) {
+	if fit == "stretch" {
+		return boxWidth, boxHeight
+	}
+	scaleX := float64(boxWidth) / float64(width)
+	scaleY := float64(boxHeight) / float64(height)
+	// The side that decides the scale matches the box exactly, without rounding
+	if (scaleX < scaleY) == (fit == "contain") {
+		return boxWidth, max(int(math.Round(float64(height)*scaleX)), 1)
+	}
+	return max(int(math.Round(float64(width)*scaleY)), 1), boxHeight
+}
+
+// Rotate90CW rotates image 90 degrees clockwise
+func Rotate90CW(src image.Image) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, h, w))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(h-1-y, x, src.At(x, y))
+		}
+	}
+	return dst
+}
+
+// Rotate90CCW rotates image 90 degrees counter-clockwise
+func Rotate90CCW(src image.Image) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, h, w))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(y, w-1-x, src.At(x, y))
+		}
+	}
+	return dst
+}
+
+// Rotate180 rotates image 180 degrees
+func Rotate180(src image.Image) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(w-1-x, h-1-y, src.At(x, y))
+		}
+	}
+	return dst
+}
+
+// FlipHorizontal flips image horizontally
+func FlipHorizontal(src image.Image) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(w-1-x, y, src.At(x, y))
+		}
+	}
+	return dst
+}
+
+// FlipVertical flips image vertically
+func FlipVertical(src image.Image) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(x, h-1-y, src.At(x, y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,299 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// VideoOptions controls ProcessVideo. The encoder fields are used as given; start from
+// DefaultVideoOptions for the batchMedia command's defaults. The size fields work like the
+// command's -width, -height, -fit, -size, -video-resolution and -video-scale-flags flags.
+type VideoOptions struct {
+	// Codec is the ffmpeg video encoder; empty means libx265
+	Codec string
+	// CRF is the constant quality (lower is better, 0 is lossless)
+	CRF int
+	// Bitrate is the target video bitrate (e.g. "5M"), replacing CRF when set
+	Bitrate string
+	// Preset is the encoder speed preset; empty means medium
+	Preset string
+	// AudioCodec is the ffmpeg audio encoder; empty means copy
+	AudioCodec string
+
+	// Resolution is an ffmpeg scale expression (e.g. "1280:-2") that replaces the size
+	// fields below
+	Resolution string
+	// ScalingRatio scales both dimensions (e.g. 0.5 halves them); 0 uses Width and Height
+	ScalingRatio float64
+	// Width and Height are the output size in pixels: with both, the video is fitted into
+	// the Width x Height box as Fit says; with one, the other follows the aspect ratio. When
+	// none of the size fields is set the video keeps its size.
+	Width  int
+	Height int
+	// Fit fills a Width x Height box: contain (the default) fits the video inside it, cover
+	// fills it and crops the overhang, stretch takes the box size exactly
+	Fit string
+	// ScaleFlags is the ffmpeg scaling algorithm (e.g. lanczos); empty is ffmpeg's default
+	ScaleFlags string
+	// Rotate turns the picture clockwise by 90, 180 or 270 degrees before it is scaled
+	Rotate int
+	// Writer puts the output in place
+	Writer Writer
+
+	// The hooks below let a caller such as the batchMedia command extend the pipeline; nil
+	// hooks are skipped. An error returned by a hook stops processing and is returned as is.
+
+	// Inspect sees the probe before anything is encoded; a nil probe means ffprobe failed
+	Inspect func(probe *Probe) error
+	// Filter gets the rotated and scaled video stream and the size it was scaled to, and
+	// returns the stream to encode
+	Filter func(video *ffmpeg.Stream, width, height int) (*ffmpeg.Stream, error)
+	// Encode replaces the ffmpeg run, which maps the video and any audio to tmpPath with
+	// kwargs (EncoderArgs of the options and the input's HDR transfer) and AudioCodec
+	Encode func(input, video *ffmpeg.Stream, kwargs ffmpeg.KwArgs, tmpPath string) error
+}
+
+// VideoResult describes a re-encoded video
+type VideoResult struct {
+	// OriginalWidth and OriginalHeight are the displayed input size, 0 when it couldn't be
+	// probed (the size fields then work from 1920x1080)
+	OriginalWidth  int
+	OriginalHeight int
+	// Width and Height are the size requested from the scale filter, 0 when the video keeps
+	// its size or Resolution sets it
+	Width  int
+	Height int
+}
+
+// DefaultVideoOptions returns the batchMedia command's default encoder settings: HEVC with
+// CRF 23, the medium preset and the audio copied
+func DefaultVideoOptions() VideoOptions {
+	return VideoOptions{Codec: "libx265", CRF: 23, Preset: "medium", AudioCodec: "copy"}
+}
+
+// withDefaults fills in the empty names of opts
+func (opts VideoOptions) withDefaults() VideoOptions {
+	if opts.Codec == "" {
+		opts.Codec = "libx265"
+	}
+	if opts.Preset == "" {
+		opts.Preset = "medium"
+	}
+	if opts.AudioCodec == "" {
+		opts.AudioCodec = "copy"
+	}
+	return opts
+}
+
+// ProcessVideo re-encodes the video at inputPath to outputPath with ffmpeg, which must be on the
+// PATH. HDR inputs keep their transfer (PQ or HLG) and BT.2020 color. The output is encoded to
+// a temp file and committed through opts.Writer once ffmpeg succeeds, so an interrupted run
+// never leaves a truncated video. ffmpeg is killed when ctx is done.
+func ProcessVideo(ctx context.Context, opts VideoOptions, inputPath, outputPath string) (*VideoResult, error) {
+	opts = opts.withDefaults()
+
+	// Probe once; a failed probe leaves a nil Probe, encoded as SDR without audio
+	probe, err := ProbeVideo(ctx, inputPath)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	result := &VideoResult{}
+	width, height := 1920, 1080
+	if w, h, err := probe.Resolution(); err == nil {
+		width, height = w, h
+		result.OriginalWidth, result.OriginalHeight = w, h
+	}
+	if opts.Inspect != nil {
+		if err := opts.Inspect(probe); err != nil {
+			return nil, err
+		}
+	}
+
+	// Rotation turns the upright picture, so scaling works on the rotated size
+	if opts.Rotate == 90 || opts.Rotate == 270 {
+		width, height = height, width
+	}
+	input := ffmpeg.Input(inputPath)
+	video := rotateVideo(input.Video(), opts.Rotate)
+	scale, newWidth, newHeight := opts.scaleFilter(width, height)
+	if scale != "" {
+		var scaleKwargs []ffmpeg.KwArgs
+		if opts.ScaleFlags != "" {
+			scaleKwargs = append(scaleKwargs, ffmpeg.KwArgs{"flags": opts.ScaleFlags})
+		}
+		video = video.Filter("scale", ffmpeg.Args{scale}, scaleKwargs...)
+		if opts.Resolution == "" {
+			result.Width, result.Height = newWidth, newHeight
+			if opts.fit() == "cover" && opts.Width > 0 && opts.Height > 0 {
+				// Cover overhangs the box on one side; crop it back to the box
+				video = video.Filter("crop", ffmpeg.Args{fmt.Sprintf("%d:%d", opts.Width, opts.Height)})
+			}
+		}
+	}
+	if opts.Filter != nil {
+		if video, err = opts.Filter(video, newWidth, newHeight); err != nil {
+			return nil, err
+		}
+	}
+
+	kwargs := EncoderArgs(opts, probe.HDRTransfer())
+	tmpPath := opts.Writer.TempPath(outputPath)
+	defer os.Remove(tmpPath)
+	encode := opts.Encode
+	if encode == nil {
+		encode = func(input, video *ffmpeg.Stream, kwargs ffmpeg.KwArgs, tmpPath string) error {
+			streams := []*ffmpeg.Stream{video}
+			if probe.HasAudio() {
+				// Mapping a missing audio stream fails the encode
+				kwargs["c:a"] = opts.AudioCodec
+				streams = append(streams, input.Audio())
+			}
+			var stderr bytes.Buffer
+			err := ffmpeg.OutputContext(ctx, streams, tmpPath, kwargs).OverWriteOutput().WithErrorOutput(&stderr).Run()
+			if err != nil && ctx.Err() == nil {
+				return fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+			}
+			return ctx.Err()
+		}
+	}
+	if err := encode(input, video, kwargs, tmpPath); err != nil {
+		return nil, err
+	}
+	if err := opts.Writer.Commit(tmpPath, outputPath); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// scaleFilter returns the ffmpeg scale expression for a video of width x height, and the size
+// it scales to; an empty expression keeps the size
+func (opts VideoOptions) scaleFilter(width, height int) (string, int, int) {
+	switch {
+	case opts.Resolution != "":
+		return opts.Resolution, width, height
+	case opts.ScalingRatio > 0:
+		newWidth := int(float64(width) * opts.ScalingRatio)
+		newHeight := int(float64(height) * opts.ScalingRatio)
+		return fmt.Sprintf("%d:%d", newWidth, newHeight), newWidth, newHeight
+	case opts.Width > 0 && opts.Height > 0:
+		// Cover is cropped to the box after scaling, so the box is the size it ends up at
+		newWidth, newHeight := FitSize(width, height, opts.Width, opts.Height, opts.fit())
+		scale := fmt.Sprintf("%d:%d", newWidth, newHeight)
+		if opts.fit() == "cover" {
+			return scale, opts.Width, opts.Height
+		}
+		return scale, newWidth, newHeight
+	case opts.Width > 0:
+		return fmt.Sprintf("%d:-1", opts.Width), opts.Width, int(float64(height) * float64(opts.Width) / float64(width))
+	case opts.Height > 0:
+		return fmt.Sprintf("-1:%d", opts.Height), int(float64(width) * float64(opts.Height) / float64(height)), opts.Height
+	}
+	return "", width, height
+}
+
+// fit returns Fit, contain when empty
+func (opts VideoOptions) fit() string {
+	if opts.Fit == "" {
+		return "contain"
+	}
+	return opts.Fit
+}
+
+// rotateVideo turns a video stream clockwise by degrees. ffmpeg has already turned the frames
+// upright from the rotation metadata, so this turns the upright picture.
+func rotateVideo(stream *ffmpeg.Stream, degrees int) *ffmpeg.Stream {
+	switch degrees {
+	case 90:
+		return stream.Filter("transpose", ffmpeg.Args{"clock"})
+	case 180:
+		return stream.Filter("hflip", nil).Filter("vflip", nil)
+	case 270:
+		return stream.Filter("transpose", ffmpeg.Args{"cclock"})
+	}
+	return stream
+}
+
+// EncoderArgs returns the ffmpeg output options encoding video with opts, for an SDR input
+// (empty hdrTransfer) or an HDR input with the given transfer characteristic, which is kept.
+func EncoderArgs(opts VideoOptions, hdrTransfer string) ffmpeg.KwArgs {
+	var kwargs ffmpeg.KwArgs
+
+	if hdrTransfer != "" {
+		// HDR10 luma/chroma QP optimization only applies to PQ content
+		x265Params := "repeat-headers=1:colorprim=bt2020:transfer=" + hdrTransfer + ":colormatrix=bt2020nc"
+		if hdrTransfer == HDRTransferPQ {
+			x265Params = "hdr-opt=1:" + x265Params
+		}
+
+		// HDR video encoding parameters, keeping the source transfer (PQ or HLG)
+		kwargs = ffmpeg.KwArgs{
+			"c:v":             opts.Codec,
+			"preset":          opts.Preset,
+			"crf":             fmt.Sprintf("%d", opts.CRF),
+			"profile:v":       "main10",
+			"pix_fmt":         "yuv420p10le",
+			"color_primaries": "bt2020",
+			"color_trc":       hdrTransfer,
+			"colorspace":      "bt2020nc",
+			"x265-params":     x265Params,
+			"level":           "5.1",
+			"map_metadata":    "0",
+		}
+	} else {
+		// SDR video encoding parameters (standard rec709 colorspace)
+		kwargs = ffmpeg.KwArgs{
+			"c:v":          opts.Codec,
+			"preset":       opts.Preset,
+			"crf":          fmt.Sprintf("%d", opts.CRF),
+			"profile:v":    "main",
+			"pix_fmt":      "yuv420p",
+			"level":        "4.0",
+			"map_metadata": "0",
+		}
+	}
+
+	// VP9 and AV1 encoders have no H.26x profile/level/preset options
+	if isVP9OrAV1Codec(opts.Codec) {
+		delete(kwargs, "profile:v")
+		delete(kwargs, "level")
+		delete(kwargs, "preset")
+		delete(kwargs, "x265-params")
+		// Constant quality mode in libvpx/libaom requires an unconstrained bitrate
+		kwargs["b:v"] = "0"
+	}
+
+	// Apply user-specified bitrate if provided
+	if opts.Bitrate != "" {
+		kwargs["b:v"] = opts.Bitrate
+		delete(kwargs, "crf") // Remove CRF when using bitrate
+	}
+
+	applyCodecTag(kwargs)
+	return kwargs
+}
+
+// isVP9OrAV1Codec reports whether the codec is one of the royalty-free VP9/AV1 encoders
+func isVP9OrAV1Codec(codec string) bool {
+	return codec == "libvpx-vp9" || codec == "libaom-av1"
+}
+
+// isHEVCCodec reports whether the ffmpeg encoder name produces HEVC output
+func isHEVCCodec(codec string) bool {
+	return codec == "libx265" || codec == "hevc" || strings.HasPrefix(codec, "hevc_")
+}
+
+// applyCodecTag sets the hvc1 tag for HEVC encoders so QuickTime and iOS accept the file,
+// and clears it for everything else since a wrong tag makes players refuse the stream
+func applyCodecTag(kwargs ffmpeg.KwArgs) {
+	codec, _ := kwargs["c:v"].(string)
+	if isHEVCCodec(codec) {
+		kwargs["tag:v"] = "hvc1"
+	} else {
+		delete(kwargs, "tag:v")
+	}
+}
@@ -0,0 +1,103 @@
+package media
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Writer puts outputs in place atomically: each is written to a temp file that is renamed to
+// its final path once complete, so an output is always complete or absent. The zero value
+// writes temp files next to their outputs.
+type Writer struct {
+	// TmpDir holds the temp files; empty writes them next to their outputs
+	TmpDir string
+	// WriteFile writes a temp file whole; nil uses os.WriteFile. The batchMedia command
+	// retries transient failures here.
+	WriteFile func(path string, data []byte) error
+}
+
+// tempSeq keeps TmpDir names unique when same-named outputs are written at once
+var tempSeq int64
+
+// TempPath returns where an output is written before being renamed into place: inside TmpDir
+// when set, otherwise next to the output. ".tmp" goes before the extension so ffmpeg still
+// picks the container from the name.
+func (w Writer) TempPath(outputPath string) string {
+	ext := filepath.Ext(outputPath)
+	base := strings.TrimSuffix(filepath.Base(outputPath), ext)
+	if w.TmpDir != "" {
+		seq := atomic.AddInt64(&tempSeq, 1)
+		return filepath.Join(w.TmpDir, fmt.Sprintf("%s.%d.tmp%s", base, seq, ext))
+	}
+	return filepath.Join(filepath.Dir(outputPath), base+".tmp"+ext)
+}
+
+// Commit moves a finished temp file to its final path. The rename is atomic on one
+// filesystem; when it fails from a TmpDir (e.g. on another filesystem) the file is first
+// copied next to the output and renamed from there.
+func (w Writer) Commit(tmpPath, outputPath string) error {
+	err := os.Rename(tmpPath, outputPath)
+	if err == nil {
+		return nil
+	}
+	if w.TmpDir == "" {
+		// Beside the output there's no filesystem boundary, so this is a real failure
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to move output into place: %v", err)
+	}
+
+	staging := filepath.Join(filepath.Dir(outputPath), "."+filepath.Base(tmpPath))
+	if err := copyTempFile(tmpPath, staging); err != nil {
+		os.Remove(staging)
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to move output from temp directory: %v", err)
+	}
+	os.Remove(tmpPath)
+	if err := os.Rename(staging, outputPath); err != nil {
+		os.Remove(staging)
+		return fmt.Errorf("failed to move output into place: %v", err)
+	}
+	return nil
+}
+
+// Write writes data to a temp file and commits it to path once complete
+func (w Writer) Write(path string, data []byte) error {
+	tmpPath := w.TempPath(path)
+	writeFile := w.WriteFile
+	if writeFile == nil {
+		writeFile = func(path string, data []byte) error {
+			return os.WriteFile(path, data, 0644)
+		}
+	}
+	if err := writeFile(tmpPath, data); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return w.Commit(tmpPath, path)
+}
+
+// copyTempFile copies a temp file across filesystems, syncing it before it is renamed
+func copyTempFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
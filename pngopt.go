@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"sort"
+)
+
+// maxPaletteSamples caps how many pixels feed the median cut on large images
+const maxPaletteSamples = 1 << 18
+
+// pngCompressionLevels maps -png-compression values to the encoder's levels
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// encodePNG encodes with -png-compression, quantizing to -png-palette colors when set
+func encodePNG(img image.Image) ([]byte, error) {
+	if config.PNGPalette > 0 {
+		img = quantizeImage(img, config.PNGPalette)
+	}
+	encoder := png.Encoder{CompressionLevel: pngCompressionLevels[config.PNGCompression]}
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// describePNGEncoding summarizes non-default PNG settings for the report
+func describePNGEncoding(outputPath string) string {
+	if outputImageFormat(outputPath) != "png" {
+		return ""
+	}
+	desc := ""
+	if config.PNGPalette > 0 {
+		desc = fmt.Sprintf("palette %d colors", config.PNGPalette)
+	}
+	if config.PNGCompression != "default" {
+		if desc != "" {
+			desc += ", "
+		}
+		desc += config.PNGCompression + " compression"
+	}
+	return desc
+}
+
+// colorBox is a set of sampled colors for median cut
+type colorBox []color.RGBA
+
+// channelRange returns the widest channel (0-3 for R, G, B, A) and its spread
+func (b colorBox) channelRange() (int, int) {
+	lo := [4]uint8{255, 255, 255, 255}
+	var hi [4]uint8
+	for _, c := range b {
+		for ch, v := range [4]uint8{c.R, c.G, c.B, c.A} {
+			if v < lo[ch] {
+				lo[ch] = v
+			}
+			if v > hi[ch] {
+				hi[ch] = v
+			}
+		}
+	}
+	best, spread := 0, -1
+	for ch := 0; ch < 4; ch++ {
+		if d := int(hi[ch]) - int(lo[ch]); d > spread {
+			best, spread = ch, d
+		}
+	}
+	return best, spread
+}
+
+// average returns the mean color of the box
+func (b colorBox) average() color.RGBA {
+	var r, g, bl, a int
+	for _, c := range b {
+		r += int(c.R)
+		g += int(c.G)
+		bl += int(c.B)
+		a += int(c.A)
+	}
+	n := len(b)
+	return color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), uint8(a / n)}
+}
+
+// medianCutPalette builds a palette of up to n colors by repeatedly splitting
+// the box with the widest channel range at its median
+func medianCutPalette(img *image.RGBA, n int) color.Palette {
+	bounds := img.Bounds()
+	pixels := bounds.Dx() * bounds.Dy()
+	step := 1
+	if pixels > maxPaletteSamples {
+		step = pixels / maxPaletteSamples
+	}
+	samples := make(colorBox, 0, pixels/step+1)
+	for i := 0; i < pixels; i += step {
+		o := i * 4
+		samples = append(samples, color.RGBA{img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3]})
+	}
+	if len(samples) == 0 {
+		return color.Palette{color.RGBA{0, 0, 0, 255}}
+	}
+
+	boxes := []colorBox{samples}
+	for len(boxes) < n {
+		// Split the box with the largest spread; stop when every box is a single color
+		target, targetSpread, targetChannel := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if ch, spread := box.channelRange(); spread > targetSpread {
+				target, targetSpread, targetChannel = i, spread, ch
+			}
+		}
+		if target < 0 {
+			break
+		}
+
+		box := boxes[target]
+		sort.Slice(box, func(i, j int) bool {
+			return channelValue(box[i], targetChannel) < channelValue(box[j], targetChannel)
+		})
+		mid := len(box) / 2
+		boxes[target] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	palette := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		palette = append(palette, box.average())
+	}
+	return palette
+}
+
+// channelValue returns one channel of a color by index (0-3 for R, G, B, A)
+func channelValue(c color.RGBA, ch int) uint8 {
+	switch ch {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	case 2:
+		return c.B
+	default:
+		return c.A
+	}
+}
+
+// quantizeImage reduces the image to an n-color median-cut palette with Floyd-Steinberg dithering
+func quantizeImage(img image.Image, n int) *image.Paletted {
+	src := toRGBA(img)
+	palette := medianCutPalette(src, n)
+	dst := image.NewPaletted(src.Bounds(), palette)
+	draw.FloydSteinberg.Draw(dst, dst.Bounds(), src, image.Point{})
+	return dst
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// presetSetting is one flag value a preset fills in
+type presetSetting struct {
+	flag  string
+	value string
+}
+
+// presets maps -preset names to the flag values they default. They are applied before the
+// config file and command line, so anything given explicitly wins.
+var presets = map[string][]presetSetting{
+	// Browser-friendly: Full HD JPEGs, lighter quality, H.264/AAC video that plays everywhere
+	"web": {
+		{"width", "1920"},
+		{"adaptive-quality", "true"},
+		{"quality-floor", "72"},
+		{"quality-ceiling", "82"},
+		{"exif-thumbnail", "strip"},
+		{"video-codec", "libx264"},
+		{"video-crf", "26"},
+		{"video-resolution", "1920x1080"},
+		{"audio-codec", "aac"},
+		{"audio-bitrate", "128k"},
+	},
+	// Long-term storage: full size, original formats, high quality HEVC, verified copies
+	"archive": {
+		{"size", "1"},
+		{"keep-format", "true"},
+		{"adaptive-quality", "true"},
+		{"quality-floor", "92"},
+		{"quality-ceiling", "95"},
+		{"video-codec", "libx265"},
+		{"video-crf", "20"},
+		{"video-preset", "slow"},
+		{"verify-copies", "true"},
+	},
+	// Small previews: 320px wide images without embedded thumbnails, videos left out
+	"thumbnail": {
+		{"width", "320"},
+		{"ignore-smart-limit", "true"},
+		{"adaptive-quality", "true"},
+		{"quality-floor", "65"},
+		{"quality-ceiling", "75"},
+		{"exif-thumbnail", "strip"},
+		{"disable-video", "true"},
+	},
+	// Social media uploads: 1080px wide, sharpened after downscaling, H.264/AAC video
+	"social": {
+		{"width", "1080"},
+		{"sharpen", "0.5"},
+		{"exif-thumbnail", "strip"},
+		{"video-codec", "libx264"},
+		{"video-crf", "23"},
+		{"video-resolution", "1920x1080"},
+		{"audio-codec", "aac"},
+		{"audio-bitrate", "128k"},
+	},
+}
+
+// presetApplied records the flags set by the preset, which don't count as explicit later
+var presetApplied = make(map[string]bool)
+
+// presetSizingFlags are mutually exclusive; an explicit one replaces the preset's sizing
+var presetSizingFlags = []string{"size", "width", "height", "sizes"}
+
+// presetNames returns the preset names in alphabetical order for messages
+func presetNames() string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// applyPreset fills in the preset's flag values that weren't given on the command line
+func applyPreset(name string) error {
+	settings, ok := presets[name]
+	if !ok {
+		return fmt.Errorf("unknown --preset %q (available: %s)", name, presetNames())
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	explicitSizing := false
+	for _, sizing := range presetSizingFlags {
+		explicitSizing = explicitSizing || explicit[sizing]
+	}
+
+	for _, setting := range settings {
+		if explicit[setting.flag] || (explicitSizing && isPresetSizingFlag(setting.flag)) {
+			continue
+		}
+		if err := flag.Set(setting.flag, setting.value); err != nil {
+			return fmt.Errorf("failed to apply preset %s: -%s: %v", name, setting.flag, err)
+		}
+		presetApplied[setting.flag] = true
+	}
+	return nil
+}
+
+// isPresetSizingFlag reports whether a flag is one of the mutually exclusive sizing flags
+func isPresetSizingFlag(name string) bool {
+	for _, sizing := range presetSizingFlags {
+		if name == sizing {
+			return true
+		}
+	}
+	return false
+}
+
+// clearPresetSizing resets the sizing flags the preset filled in, for a config file that sets its own
+func clearPresetSizing() {
+	for _, sizing := range presetSizingFlags {
+		if presetApplied[sizing] {
+			flag.Set(sizing, flag.Lookup(sizing).DefValue)
+			delete(presetApplied, sizing)
+		}
+	}
+}
+
+// logPreset logs the preset in use with the effective value of each setting it covers,
+// marking the ones that were overridden by the config file or command line
+func logPreset(name string) {
+	var parts []string
+	for _, setting := range presets[name] {
+		value := flag.Lookup(setting.flag).Value.String()
+		if value == setting.value {
+			parts = append(parts, fmt.Sprintf("%s=%s", setting.flag, value))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s=%s (overridden)", setting.flag, value))
+		}
+	}
+	logInfo("Using preset %s: %s", name, strings.Join(parts, ", "))
+}
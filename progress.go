@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProgressBar renders an in-place overall progress bar plus one line per active thread.
+// All drawing happens under a single mutex so concurrent threads don't clobber each other.
+type ProgressBar struct {
+	mu      sync.Mutex
+	total   int64
+	done    int64
+	saved   int64          // input minus output bytes of the files finished so far
+	start   time.Time      // when the bar started, for the ETA
+	current map[int]string // threadID -> file currently being processed
+	drawn   int            // number of lines drawn by the last render
+	out     *os.File       // the terminal the bar and log lines are drawn on
+	prevLog io.Writer      // logger output to restore when the bar stops
+}
+
+// progressBar is the active progress display, nil when running with line-by-line logging
+var progressBar *ProgressBar
+
+// isTerminal reports whether the file is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// startProgressBar enables the progress display if requested and stderr is a terminal
+func startProgressBar() {
+	if !config.ProgressBar {
+		return
+	}
+	if !isTerminal(os.Stderr) {
+		logWarn("Progress bar disabled: stderr is not a terminal")
+		return
+	}
+
+	pb := &ProgressBar{
+		total:   atomic.LoadInt64(&filesTotal),
+		start:   time.Now(),
+		current: make(map[int]string),
+		out:     os.Stderr,
+	}
+
+	// Route log lines through the bar so they scroll above it
+	logMutex.Lock()
+	pb.prevLog = logOutput
+	logOutput = pb
+	logMutex.Unlock()
+
+	progressBar = pb
+	pb.mu.Lock()
+	pb.render()
+	pb.mu.Unlock()
+}
+
+// stopProgressBar restores the logger output and leaves the final bar on screen
+func stopProgressBar() {
+	pb := progressBar
+	if pb == nil {
+		return
+	}
+	progressBar = nil
+
+	logMutex.Lock()
+	logOutput = pb.prevLog
+	logMutex.Unlock()
+
+	pb.mu.Lock()
+	pb.current = make(map[int]string)
+	pb.clear()
+	pb.render()
+	pb.drawn = 0
+	pb.mu.Unlock()
+}
+
+// Write prints a log line above the bar and redraws it
+func (pb *ProgressBar) Write(p []byte) (int, error) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	pb.clear()
+	n, err := pb.out.Write(p)
+	pb.render()
+	return n, err
+}
+
+// startFile records that a thread has moved on to the next file
+func (pb *ProgressBar) startFile(threadID int, path string, done, total int64) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	// Counters are advanced atomically outside the lock, so keep the highest seen
+	if done > pb.done {
+		pb.done = done
+	}
+	pb.total = total
+	pb.current[threadID] = path
+	pb.clear()
+	pb.render()
+}
+
+// addSaved adds the space saved by a finished file to the running total
+func (pb *ProgressBar) addSaved(bytes int64) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	pb.saved += bytes
+	pb.clear()
+	pb.render()
+}
+
+// directoryOutputSize returns the bytes written so far for a directory's files. Each
+// directory is handled by one thread, so the change across a file is that file's output.
+func directoryOutputSize(dirStats *DirectoryStats) int64 {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	return dirStats.TotalOutputSize
+}
+
+// finishThread removes a thread's line once its directory is done
+func (pb *ProgressBar) finishThread(threadID int) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	delete(pb.current, threadID)
+	pb.clear()
+	pb.render()
+}
+
+// clear erases the previously drawn lines, caller must hold the mutex
+func (pb *ProgressBar) clear() {
+	for i := 0; i < pb.drawn; i++ {
+		// Move up one line and erase it
+		fmt.Fprint(pb.out, "\033[1A\033[2K")
+	}
+	pb.drawn = 0
+}
+
+// eta estimates the time remaining from the average time per file so far, caller must hold the mutex
+func (pb *ProgressBar) eta() string {
+	if pb.done == 0 || pb.done >= pb.total {
+		return ""
+	}
+	perFile := time.Since(pb.start) / time.Duration(pb.done)
+	remaining := perFile * time.Duration(pb.total-pb.done)
+	return fmt.Sprintf(", ETA %s", remaining.Round(time.Second))
+}
+
+// render draws the overall bar and per-thread lines, caller must hold the mutex
+func (pb *ProgressBar) render() {
+	const barWidth = 40
+	var percentage float64
+	if pb.total > 0 {
+		percentage = float64(pb.done) / float64(pb.total) * 100
+	}
+	filled := int(percentage / 100 * barWidth)
+	if filled > barWidth {
+		filled = barWidth
+	}
+	fmt.Fprintf(pb.out, "[%s%s] %d/%d (%.1f%%) saved %.1f MB%s\n", strings.Repeat("#", filled), strings.Repeat("-", barWidth-filled),
+		pb.done, pb.total, percentage, float64(pb.saved)/1024/1024, pb.eta())
+	pb.drawn = 1
+
+	threadIDs := make([]int, 0, len(pb.current))
+	for id := range pb.current {
+		threadIDs = append(threadIDs, id)
+	}
+	sort.Ints(threadIDs)
+	for _, id := range threadIDs {
+		fmt.Fprintf(pb.out, "  [thread-%d] %s\n", id, filepath.Base(pb.current[id]))
+		pb.drawn++
+	}
+}
@@ -0,0 +1,108 @@
+//go:build libjpeg && cgo
+
+package main
+
+/*
+#cgo LDFLAGS: -ljpeg
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <setjmp.h>
+#include <jpeglib.h>
+
+struct error_mgr {
+	struct jpeg_error_mgr pub;
+	jmp_buf jump;
+	char message[JMSG_LENGTH_MAX];
+};
+
+// on_error replaces libjpeg's default of exiting the process
+static void on_error(j_common_ptr cinfo) {
+	struct error_mgr *err = (struct error_mgr *)cinfo->err;
+	(*cinfo->err->format_message)(cinfo, err->message);
+	longjmp(err->jump, 1);
+}
+
+// encode_progressive compresses packed RGB rows into a progressive JPEG in memory. On success
+// *out must be released with free(); on failure the library's message is copied to message.
+static int encode_progressive(unsigned char *rgb, int width, int height, int quality,
+		unsigned char **out, unsigned long *out_size, char *message) {
+	struct jpeg_compress_struct cinfo;
+	struct error_mgr err;
+
+	*out = NULL;
+	*out_size = 0;
+	cinfo.err = jpeg_std_error(&err.pub);
+	err.pub.error_exit = on_error;
+	if (setjmp(err.jump)) {
+		memcpy(message, err.message, JMSG_LENGTH_MAX);
+		jpeg_destroy_compress(&cinfo);
+		free(*out);
+		*out = NULL;
+		return 1;
+	}
+
+	jpeg_create_compress(&cinfo);
+	jpeg_mem_dest(&cinfo, out, out_size);
+	cinfo.image_width = width;
+	cinfo.image_height = height;
+	cinfo.input_components = 3;
+	cinfo.in_color_space = JCS_RGB;
+	jpeg_set_defaults(&cinfo);
+	jpeg_set_quality(&cinfo, quality, TRUE);
+	jpeg_simple_progression(&cinfo);
+
+	jpeg_start_compress(&cinfo, TRUE);
+	while (cinfo.next_scanline < cinfo.image_height) {
+		JSAMPROW row = rgb + (size_t)cinfo.next_scanline * width * 3;
+		jpeg_write_scanlines(&cinfo, &row, 1);
+	}
+	jpeg_finish_compress(&cinfo);
+	jpeg_destroy_compress(&cinfo);
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+// progressiveJPEGAvailable reports whether this build can write progressive JPEGs
+const progressiveJPEGAvailable = true
+
+// encodeProgressiveJPEG encodes img as a progressive JPEG with libjpeg. Like image/jpeg,
+// transparent pixels come out over black.
+func encodeProgressiveJPEG(img image.Image, quality int) ([]byte, error) {
+	rgba := toRGBA(img)
+	width, height := rgba.Rect.Dx(), rgba.Rect.Dy()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("failed to encode image: empty image")
+	}
+
+	// libjpeg takes packed RGB rows from C memory
+	buffer := C.malloc(C.size_t(width * height * 3))
+	if buffer == nil {
+		return nil, fmt.Errorf("failed to encode image: out of memory")
+	}
+	defer C.free(buffer)
+	rgb := unsafe.Slice((*byte)(buffer), width*height*3)
+	for y := 0; y < height; y++ {
+		src := rgba.Pix[y*rgba.Stride : y*rgba.Stride+width*4]
+		dst := rgb[y*width*3 : (y+1)*width*3]
+		for x := 0; x < width; x++ {
+			dst[x*3], dst[x*3+1], dst[x*3+2] = src[x*4], src[x*4+1], src[x*4+2]
+		}
+	}
+
+	var out *C.uchar
+	var size C.ulong
+	var message [C.JMSG_LENGTH_MAX]C.char
+	if C.encode_progressive((*C.uchar)(buffer), C.int(width), C.int(height), C.int(quality), &out, &size, &message[0]) != 0 {
+		return nil, fmt.Errorf("failed to encode image: libjpeg: %s", C.GoString(&message[0]))
+	}
+	defer C.free(unsafe.Pointer(out))
+	return C.GoBytes(unsafe.Pointer(out), C.int(size)), nil
+}
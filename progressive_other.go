@@ -0,0 +1,16 @@
+//go:build !libjpeg || !cgo
+
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+// progressiveJPEGAvailable reports whether this build can write progressive JPEGs; the
+// standard library only writes baseline JPEGs, so that needs a build with -tags libjpeg
+const progressiveJPEGAvailable = false
+
+func encodeProgressiveJPEG(img image.Image, quality int) ([]byte, error) {
+	return nil, fmt.Errorf("progressive JPEG encoding needs a build with -tags libjpeg")
+}
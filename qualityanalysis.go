@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+)
+
+// ssimWindow is the side of the square windows SSIM is averaged over
+const ssimWindow = 8
+
+// maxPSNR is the PSNR reported for outputs identical to the encoded image, e.g. lossless PNGs
+const maxPSNR = 100.0
+
+// analyzeOutputQuality compares the image that was encoded with the decoded output bytes
+// (-quality-analysis) and returns their SSIM and PSNR. Outputs below -min-ssim are logged as
+// warnings; the file itself is kept.
+func analyzeOutputQuality(outputPath string, reference image.Image, encoded []byte) (ssim, psnr float64, err error) {
+	decoded, err := decodeOutputImage(bytes.NewReader(encoded), outputPath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode output for quality analysis: %v", err)
+	}
+	ref, out := toRGBA(reference), toRGBA(decoded)
+	if ref.Rect.Dx() != out.Rect.Dx() || ref.Rect.Dy() != out.Rect.Dy() {
+		return 0, 0, fmt.Errorf("output decoded as %dx%d, expected %dx%d", out.Rect.Dx(), out.Rect.Dy(), ref.Rect.Dx(), ref.Rect.Dy())
+	}
+
+	ssim, psnr = imageSSIM(ref, out), imagePSNR(ref, out)
+	if config.MinSSIM > 0 && ssim < config.MinSSIM {
+		logWarn("%s: SSIM %.4f is below -min-ssim %.4f (PSNR %.2f dB)", outputPath, ssim, config.MinSSIM, psnr)
+	}
+	return ssim, psnr, nil
+}
+
+// describeQuality formats a file's -quality-analysis result for the HTML report
+func describeQuality(file FileInfo) string {
+	text := fmt.Sprintf("SSIM %.4f, PSNR %.2f dB", file.SSIM, file.PSNR)
+	if file.PSNR >= maxPSNR {
+		text = fmt.Sprintf("SSIM %.4f, lossless", file.SSIM)
+	}
+	if config.MinSSIM > 0 && file.SSIM < config.MinSSIM {
+		text += fmt.Sprintf(" (below -min-ssim %.4f)", config.MinSSIM)
+	}
+	return text
+}
+
+// imagePSNR returns the peak signal-to-noise ratio over the RGB channels in dB, capped at
+// maxPSNR so identical images get a finite value the JSON report can hold
+func imagePSNR(a, b *image.RGBA) float64 {
+	width, height := a.Rect.Dx(), a.Rect.Dy()
+	var sum float64
+	for y := 0; y < height; y++ {
+		rowA, rowB := a.Pix[y*a.Stride:], b.Pix[y*b.Stride:]
+		for i := 0; i < width*4; i += 4 {
+			for c := 0; c < 3; c++ {
+				d := float64(rowA[i+c]) - float64(rowB[i+c])
+				sum += d * d
+			}
+		}
+	}
+	if sum == 0 {
+		return maxPSNR
+	}
+	mse := sum / float64(width*height*3)
+	return min(10*math.Log10(255*255/mse), maxPSNR)
+}
+
+// imageSSIM returns the structural similarity of the luma of two equally sized images, the
+// mean over non-overlapping 8x8 windows (smaller at the right and bottom edges). 1 means
+// identical.
+func imageSSIM(a, b *image.RGBA) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	width, height := a.Rect.Dx(), a.Rect.Dy()
+	lumaA, lumaB := imageLuma(a), imageLuma(b)
+
+	var total float64
+	var windows int
+	for y0 := 0; y0 < height; y0 += ssimWindow {
+		for x0 := 0; x0 < width; x0 += ssimWindow {
+			y1, x1 := min(y0+ssimWindow, height), min(x0+ssimWindow, width)
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					va, vb := lumaA[y*width+x], lumaB[y*width+x]
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+				}
+			}
+			n := float64((y1 - y0) * (x1 - x0))
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			covar := sumAB/n - meanA*meanB
+			total += ((2*meanA*meanB + c1) * (2*covar + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 1
+	}
+	return total / float64(windows)
+}
+
+// imageLuma returns the BT.601 luma of every pixel, row by row
+func imageLuma(img *image.RGBA) []float64 {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	luma := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			p := row[x*4:]
+			luma[y*width+x] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+		}
+	}
+	return luma
+}
@@ -0,0 +1,11 @@
+package main
+
+import "batchMedia/pkg/media"
+
+// rawHandling returns the -raw-mode applied to a file, or empty for non-RAW files
+func rawHandling(filename string) string {
+	if !media.IsRawFile(filename) {
+		return ""
+	}
+	return config.RawMode
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// smartDefaultsApplied lists the thresholds applySmartDefaults filled in, for the reports
+var smartDefaultsApplied []string
+
+// ReportSettings is the effective configuration that decides which files are skipped. It heads
+// every HTML report and the JSON report, so an old report still explains its skips.
+type ReportSettings struct {
+	Scaling           string   // e.g. "ratio 0.50", "width 1920px", "sizes 640, 1280"
+	ThresholdWidth    int      // Width threshold in effect (0 = none)
+	ThresholdHeight   int      // Height threshold in effect (0 = none)
+	ImageThresholds   string   // How images are judged against the thresholds, e.g. "skip below"
+	VideoThresholds   string   // How videos are judged against the thresholds
+	SmartDefaults     []string // Thresholds chosen by the smart defaults rather than given on the command line
+	IgnoreSmartLimit  bool
+	AllowUpscale      bool
+	MinResolution     string `json:",omitempty"`
+	OrientationFilter string `json:",omitempty"`
+	JPEGMode          string // "baseline" or "progressive", and whether -jpeg-optimize applies
+}
+
+// currentReportSettings describes the configuration of this run
+func currentReportSettings() ReportSettings {
+	settings := ReportSettings{
+		ThresholdWidth:   config.ThresholdWidth,
+		ThresholdHeight:  config.ThresholdHeight,
+		SmartDefaults:    smartDefaultsApplied,
+		IgnoreSmartLimit: config.IgnoreSmartLimit,
+		AllowUpscale:     config.AllowUpscale,
+		MinResolution:    config.MinResolution,
+		JPEGMode:         "baseline",
+	}
+	if progressiveJPEG() {
+		settings.JPEGMode = "progressive"
+	} else if config.JPEGProgressive {
+		settings.JPEGMode = "baseline (-jpeg-progressive needs a -tags libjpeg build)"
+	}
+	if jpegtranPath != "" {
+		settings.JPEGMode += ", optimized by jpegtran"
+	} else if config.JPEGOptimize {
+		settings.JPEGMode += ", not optimized (-jpeg-optimize found no jpegtran)"
+	}
+	switch {
+	case len(sizeWidths) > 0:
+		widths := make([]string, len(sizeWidths))
+		for i, width := range sizeWidths {
+			widths[i] = fmt.Sprintf("%d", width)
+		}
+		settings.Scaling = "sizes " + strings.Join(widths, ", ")
+	case config.ScalingRatio > 0:
+		settings.Scaling = fmt.Sprintf("ratio %.2f", config.ScalingRatio)
+	case config.Width > 0 && config.Height > 0:
+		settings.Scaling = fmt.Sprintf("box %dx%d (%s)", config.Width, config.Height, config.Fit)
+	case config.Width > 0:
+		settings.Scaling = fmt.Sprintf("width %dpx", config.Width)
+	case config.Height > 0:
+		settings.Scaling = fmt.Sprintf("height %dpx", config.Height)
+	}
+	if config.OrientationFilter != "all" {
+		settings.OrientationFilter = config.OrientationFilter
+	}
+
+	// Mirrors shouldSkipImage and shouldSkipVideo
+	if config.ThresholdWidth > 0 || config.ThresholdHeight > 0 {
+		switch {
+		case config.ScalingRatio > 1.0:
+			settings.ImageThresholds = "skip above (upscaling)"
+		case config.ScalingRatio < 1.0:
+			settings.ImageThresholds = "skip below (downscaling)"
+		default:
+			settings.ImageThresholds = "not applied (ratio 1.0)"
+		}
+		settings.VideoThresholds = "skip above"
+		if config.IgnoreSmartLimit {
+			settings.VideoThresholds = "not applied (-ignore-smart-limit)"
+		}
+	}
+	return settings
+}
+
+// reportSettingsHTML renders the settings section shown above the file cards
+func reportSettingsHTML() string {
+	settings := currentReportSettings()
+	rows := [][2]string{
+		{"Scaling", settings.Scaling},
+		{"Thresholds", fmt.Sprintf("%dx%d", settings.ThresholdWidth, settings.ThresholdHeight)},
+		{"Images", settings.ImageThresholds},
+		{"Videos", settings.VideoThresholds},
+		{"Smart defaults", strings.Join(settings.SmartDefaults, "; ")},
+		{"Smart limit", fmt.Sprintf("ignored: %t", settings.IgnoreSmartLimit)},
+		{"Allow upscale", fmt.Sprintf("%t", settings.AllowUpscale)},
+		{"Min resolution", settings.MinResolution},
+		{"Orientation filter", settings.OrientationFilter},
+		{"JPEG", settings.JPEGMode},
+	}
+	if settings.ThresholdWidth == 0 && settings.ThresholdHeight == 0 {
+		rows[1][1] = "none"
+	}
+
+	var b strings.Builder
+	b.WriteString(`
+        <h2>Settings</h2>
+        <div class="settings">`)
+	for _, row := range rows {
+		if row[1] == "" {
+			continue
+		}
+		fmt.Fprintf(&b, `
+            <div class="detail-row">
+                <span class="detail-label">%s:</span>
+                <span>%s</span>
+            </div>`, row[0], html.EscapeString(row[1]))
+	}
+	b.WriteString(`
+        </div>
+        `)
+	return b.String()
+}
+
+// jsonReport is the layout of OutputDir/report.json
+type jsonReport struct {
+	Settings ReportSettings
+	Files    []FileInfo
+}
+
+// writeJSONReport writes the settings and every file's result to OutputDir/report.json
+func writeJSONReport(files []FileInfo) error {
+	reportPath := filepath.Join(config.OutputDir, "report.json")
+	data, err := json.MarshalIndent(jsonReport{Settings: currentReportSettings(), Files: files}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON report: %v", err)
+	}
+	if err := os.WriteFile(reportPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write JSON report: %v", err)
+	}
+	logInfo("JSON report written: %s", reportPath)
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// retryBaseDelay is the wait before the first retry; it doubles on each further attempt
+const retryBaseDelay = 500 * time.Millisecond
+
+// transientError marks a failure that is worth retrying, e.g. ffmpeg reporting an I/O error
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// transientErrnos are the system errors busy or flaky (network) storage produces
+var transientErrnos = []error{
+	syscall.EAGAIN,
+	syscall.EINTR,
+	syscall.EBUSY,
+	syscall.EIO,
+	syscall.ETIMEDOUT,
+	syscall.ESTALE,
+}
+
+// transientFFmpegMessages are ffmpeg stderr fragments that point at storage rather than the media
+var transientFFmpegMessages = []string{
+	"Resource temporarily unavailable",
+	"Input/output error",
+	"Connection timed out",
+	"Stale file handle",
+	"Device or resource busy",
+}
+
+// isTransientError reports whether an error looks like a temporary I/O failure.
+// Decode and format errors are permanent and never retried.
+func isTransientError(err error) bool {
+	var transient *transientError
+	if errors.As(err, &transient) || os.IsTimeout(err) {
+		return true
+	}
+	for _, errno := range transientErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransientFFmpegOutput reports whether ffmpeg's stderr shows a temporary I/O failure
+func isTransientFFmpegOutput(stderr string) bool {
+	for _, message := range transientFFmpegMessages {
+		if strings.Contains(stderr, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRetry runs op, retrying up to -retries times with exponential backoff while it fails
+// with a transient error
+func withRetry(what string, op func() error) error {
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt > config.Retries || !isTransientError(err) {
+			return err
+		}
+		logWarn("%s failed (attempt %d/%d), retrying in %s: %v", what, attempt, config.Retries+1, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// writeFileWithRetry writes a whole file, retrying transient failures
+func writeFileWithRetry(path string, data []byte) error {
+	return withRetry("Writing "+path, func() error {
+		return os.WriteFile(path, data, 0644)
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// s3Backend is the output backend of -out s3://bucket/prefix: it uploads finished outputs to
+// S3 or S3-compatible storage (MinIO, R2, ...) with the usual AWS credentials
+type s3Backend struct {
+	bucket   string
+	prefix   string // Key prefix ending in "/", or empty for the bucket root
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+// isS3Output reports whether -out names an S3 location rather than a local directory
+func isS3Output(out string) bool {
+	return strings.HasPrefix(out, "s3://")
+}
+
+// setupS3Output connects to the bucket of -out and stages outputs in a local directory. The
+// staging directory is the same for every run to one location, so its progress file lets the
+// next run resume and its lock keeps two runs from uploading to the same prefix at once.
+func setupS3Output() error {
+	location := config.OutputDir
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+	if bucket == "" {
+		return fmt.Errorf("--out %s: expected s3://bucket/prefix", location)
+	}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+	if err := checkStagedOutput("--out s3://"); err != nil {
+		return err
+	}
+
+	awsConfig := aws.Config{}
+	if config.S3Endpoint != "" {
+		// S3-compatible servers rarely have per-bucket host names
+		awsConfig.Endpoint = aws.String(config.S3Endpoint)
+		awsConfig.S3ForcePathStyle = aws.Bool(true)
+	}
+	if config.S3Region != "" {
+		awsConfig.Region = aws.String(config.S3Region)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up S3 client: %v", err)
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		sess.Config.Region = aws.String("us-east-1")
+	}
+
+	sum := sha256.Sum256([]byte(config.S3Endpoint + "\x00" + bucket + "/" + prefix))
+	tmpDir := config.TmpDir
+	if tmpDir == "" {
+		tmpDir = os.TempDir()
+	}
+	staging := filepath.Join(tmpDir, fmt.Sprintf("batchMedia-s3-%x", sum[:6]))
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return fmt.Errorf("failed to create S3 staging directory: %v", err)
+	}
+
+	outputStore = &s3Backend{
+		bucket:   bucket,
+		prefix:   prefix,
+		client:   s3.New(sess),
+		uploader: s3manager.NewUploader(sess),
+	}
+	config.OutputDir = staging
+	stagingDir = staging
+	keepStagingDir = true
+	logInfo("Uploading outputs to %s (staging in %s)", location, staging)
+	return nil
+}
+
+// Write uploads one output; its modification time is kept in the mtime metadata, since S3
+// sets Last-Modified to the upload time
+func (b *s3Backend) Write(name string, r io.Reader, info os.FileInfo) error {
+	input := &s3manager.UploadInput{
+		Bucket:   aws.String(b.bucket),
+		Key:      aws.String(b.prefix + name),
+		Body:     r,
+		Metadata: map[string]*string{"mtime": aws.String(info.ModTime().UTC().Format(time.RFC3339))},
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	_, err := b.uploader.Upload(input)
+	return err
+}
+
+func (b *s3Backend) Exists(name string) (bool, error) {
+	_, err := b.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.prefix + name),
+	})
+	if err == nil {
+		return true, nil
+	}
+	if failure, ok := err.(awserr.RequestFailure); ok && failure.StatusCode() == 404 {
+		return false, nil
+	}
+	return false, err
+}
+
+// restoreProgress replaces the local progress file with the bucket's copy (-s3-progress), so a
+// run on another machine resumes where the last one stopped
+func restoreProgress(progressFile string) error {
+	backend, ok := outputStore.(*s3Backend)
+	if !ok || !config.S3Progress {
+		return nil
+	}
+	key := backend.prefix + filepath.Base(progressFile)
+	result, err := backend.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(backend.bucket),
+		Key:    aws.String(key),
+	})
+	if failure, ok := err.(awserr.RequestFailure); ok && failure.StatusCode() == 404 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download progress from s3://%s/%s: %v", backend.bucket, key, err)
+	}
+	defer result.Body.Close()
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		return fmt.Errorf("failed to download progress from s3://%s/%s: %v", backend.bucket, key, err)
+	}
+	logInfo("Resuming from progress in s3://%s/%s", backend.bucket, key)
+	return writeFileAtomic(progressFile, data)
+}
+
+// mirrorProgress uploads the progress file next to the outputs (-s3-progress)
+func mirrorProgress(progressFile string, data []byte) error {
+	backend, ok := outputStore.(*s3Backend)
+	if !ok || !config.S3Progress {
+		return nil
+	}
+	_, err := backend.uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(backend.bucket),
+		Key:         aws.String(backend.prefix + filepath.Base(progressFile)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload progress: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// coresPerVideoJob is how many CPU cores the -video-jobs default counts per ffmpeg encode
+const coresPerVideoJob = 4
+
+// videoSlots bounds the videos encoded at once across all threads (-video-jobs); nil when
+// no limit applies (e.g. -serve)
+var videoSlots chan struct{}
+
+// resolveThreads fills in the automatic -multithread (one thread per CPU core), -video-jobs
+// (one encode per coresPerVideoJob cores, as ffmpeg spreads each video over several cores) and
+// -video-threads (the cores shared out between the encodes running at once). Image decoding is
+// single-threaded, so images use every thread while videos share fewer slots.
+func resolveThreads() error {
+	if config.Multithread < 0 {
+		return fmt.Errorf("--multithread must be 0 (one per CPU core) or a positive number of threads")
+	}
+	if config.VideoJobs < 0 {
+		return fmt.Errorf("--video-jobs must be 0 (automatic) or a positive number of videos")
+	}
+	if config.VideoThreads < 0 {
+		return fmt.Errorf("--video-threads must be 0 (automatic) or a positive number of threads")
+	}
+	if config.Multithread == 0 {
+		config.Multithread = runtime.NumCPU()
+	}
+	if config.VideoJobs == 0 {
+		config.VideoJobs = max(runtime.NumCPU()/coresPerVideoJob, 1)
+	}
+	config.VideoJobs = min(config.VideoJobs, config.Multithread)
+	if config.VideoThreads == 0 {
+		config.VideoThreads = max(runtime.NumCPU()/config.VideoJobs, 1)
+	}
+	videoSlots = make(chan struct{}, config.VideoJobs)
+	logDebug("Using up to %d threads, encoding up to %d videos at once with %d ffmpeg threads each",
+		config.Multithread, config.VideoJobs, config.VideoThreads)
+	return nil
+}
+
+// withVideoSlot runs encode once a -video-jobs slot is free, giving up when ctx ends first
+func withVideoSlot(ctx context.Context, encode func() error) error {
+	if videoSlots == nil {
+		return encode()
+	}
+	select {
+	case videoSlots <- struct{}{}:
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+	defer func() { <-videoSlots }()
+	return encode()
+}
+
+// maxInflightBytes is the parsed -max-inflight-bytes, 0 when directories are limited by count only
+var maxInflightBytes int64
+
+// parseByteSize parses a size such as "4G", "512M" or "1048576", with optional K, M, G or T
+// suffixes (binary multiples, optionally followed by "B")
+func parseByteSize(spec string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(spec)), "B")
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", spec)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// byteLimiter caps the total input bytes of the directories being processed at once
+// (-max-inflight-bytes), so a few huge directories don't run side by side and exhaust memory
+// while many small ones can still share the threads
+type byteLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	max      int64
+	inflight int64
+}
+
+// newByteLimiter returns a limiter for max bytes, or nil when the limit is off
+func newByteLimiter(max int64) *byteLimiter {
+	if max <= 0 {
+		return nil
+	}
+	l := &byteLimiter{max: max}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits until cost fits under the limit. A directory larger than the whole limit
+// waits until nothing else is in flight and then runs alone rather than never.
+func (l *byteLimiter) acquire(cost int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	for l.inflight > 0 && l.inflight+cost > l.max {
+		l.cond.Wait()
+	}
+	l.inflight += cost
+	l.mu.Unlock()
+}
+
+// release returns cost to the limiter and wakes the waiting directories
+func (l *byteLimiter) release(cost int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.inflight -= cost
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// directoryCost estimates a directory's cost as the total size of its cached listing
+func directoryCost(dir string) int64 {
+	listingsMutex.Lock()
+	items := directoryListings[dir]
+	listingsMutex.Unlock()
+
+	var cost int64
+	for _, item := range items {
+		cost += item.info.Size()
+	}
+	return cost
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"batchMedia/pkg/media"
+)
+
+// serveShutdownTimeout is how long requests in flight get to finish after SIGINT/SIGTERM
+const serveShutdownTimeout = 30 * time.Second
+
+// imageServer handles the -serve API; slots bounds the images converted at once
+type imageServer struct {
+	slots chan struct{}
+}
+
+// validateServe checks the settings of -serve mode, which needs no input or output directory.
+// -width and -size become the defaults for requests that give neither.
+func validateServe() error {
+	if config.ServeConcurrency < 1 {
+		return fmt.Errorf("--serve-concurrency must be at least 1")
+	}
+	if config.ServeMaxUploadMB < 1 {
+		return fmt.Errorf("--serve-max-upload-mb must be at least 1")
+	}
+	if config.Width < 0 || config.ScalingRatio < 0 {
+		return fmt.Errorf("--width and --size must not be negative")
+	}
+	if config.Height != 0 {
+		return fmt.Errorf("--serve resizes by width or size; --height is not supported")
+	}
+	if config.MaxPixels < 0 {
+		return fmt.Errorf("--max-pixels must be non-negative")
+	}
+	return parsePerFileTimeout()
+}
+
+// runServer serves the resize API on -serve until SIGINT or SIGTERM:
+//
+//	POST /process  multipart form with the image in "file" (or the raw image as the body with
+//	               ?name=photo.heic); optional width, size, quality and allow_upscale query
+//	               values. Responds with the JPEG.
+//	GET  /healthz  "ok" while the server is up
+func runServer() {
+	server := &imageServer{slots: make(chan struct{}, config.ServeConcurrency)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/process", server.handleProcess)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+	httpServer := &http.Server{
+		Addr:              config.Serve,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		logInfo("Shutting down, waiting for requests in flight")
+		ctx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+	}()
+
+	logInfo("Serving on %s (%d images at once, uploads up to %d MB)", config.Serve, config.ServeConcurrency, config.ServeMaxUploadMB)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatalConfig("%v", err)
+	}
+}
+
+// handleProcess converts one uploaded image. The upload is spooled to a temp file, as the
+// library works on files and HEIC and RAW decoding need random access.
+func (s *imageServer) handleProcess(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	maxBytes := int64(config.ServeMaxUploadMB) << 20
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	opts, err := serveImageOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Wait for a slot; a client that gives up stops waiting
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-r.Context().Done():
+		return
+	}
+
+	inputPath, status, err := spoolUpload(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer os.Remove(inputPath)
+	if status, err := checkUploadPixels(inputPath); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	outputPath := strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + ".out.jpg"
+	defer os.Remove(outputPath)
+	ctx, cancel := fileContext(r.Context())
+	defer cancel()
+	result, err := media.ProcessImage(ctx, opts, inputPath, outputPath)
+	if err != nil {
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			http.Error(w, ctxErr.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		http.Error(w, "failed to read output", http.StatusInternalServerError)
+		return
+	}
+
+	logInfo("Served %s: %dx%d -> %dx%d, %d bytes", r.RemoteAddr, result.OriginalWidth, result.OriginalHeight, result.Width, result.Height, len(data))
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("X-Original-Size", fmt.Sprintf("%dx%d", result.OriginalWidth, result.OriginalHeight))
+	w.Header().Set("X-Output-Size", fmt.Sprintf("%dx%d", result.Width, result.Height))
+	w.Write(data)
+}
+
+// serveImageOptions reads the resize parameters of a request, defaulting to -width or -size
+func serveImageOptions(r *http.Request) (media.ImageOptions, error) {
+	opts := media.ImageOptions{
+		Width:        config.Width,
+		ScalingRatio: config.ScalingRatio,
+		AllowUpscale: config.AllowUpscale,
+	}
+	// Query values only: form values would read a multipart body before the slot is taken
+	query := r.URL.Query()
+	if value := query.Get("width"); value != "" {
+		width, err := strconv.Atoi(value)
+		if err != nil || width < 1 {
+			return opts, fmt.Errorf("width must be a positive number of pixels")
+		}
+		opts.Width, opts.ScalingRatio = width, 0
+	}
+	if value := query.Get("size"); value != "" {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio <= 0 {
+			return opts, fmt.Errorf("size must be a positive scaling ratio such as 0.5")
+		}
+		opts.ScalingRatio, opts.Width = ratio, 0
+	}
+	if value := query.Get("quality"); value != "" {
+		quality, err := strconv.Atoi(value)
+		if err != nil || quality < 1 || quality > 100 {
+			return opts, fmt.Errorf("quality must be between 1 and 100")
+		}
+		opts.Quality = quality
+	}
+	if value := query.Get("allow_upscale"); value != "" {
+		allow, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("allow_upscale must be true or false")
+		}
+		opts.AllowUpscale = allow
+	}
+	return opts, nil
+}
+
+// spoolUpload writes the uploaded image to a temp file named with its extension, which tells
+// RAW files apart. It returns the HTTP status to answer with when the upload is unusable.
+func spoolUpload(r *http.Request) (string, int, error) {
+	body, name := r.Body, r.URL.Query().Get("name")
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return "", http.StatusBadRequest, err
+		}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				return "", uploadErrorStatus(err), fmt.Errorf("no \"file\" field in the form: %v", err)
+			}
+			if part.FormName() == "file" {
+				body, name = part, part.FileName()
+				break
+			}
+		}
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".heic" && !media.IsRawFile(name) {
+		return "", http.StatusUnsupportedMediaType, fmt.Errorf("unsupported image %q: send a .jpg, .png, .heic or camera RAW file name", name)
+	}
+	file, err := os.CreateTemp(config.TmpDir, "batchMedia-serve-*"+ext)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("failed to store upload")
+	}
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", uploadErrorStatus(err), fmt.Errorf("failed to read upload: %v", err)
+	}
+	return file.Name(), 0, nil
+}
+
+// uploadErrorStatus answers uploads over -serve-max-upload-mb with 413
+func uploadErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// checkUploadPixels refuses images whose declared size exceeds -max-pixels before they are
+// decoded, so a small file can't claim gigabytes of pixels
+func checkUploadPixels(path string) (int, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if config.MaxPixels == 0 || media.IsRawFile(path) {
+		return 0, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to read upload")
+	}
+	defer file.Close()
+	cfg, err := media.DecodeConfig(ext, file)
+	if err != nil {
+		return http.StatusUnprocessableEntity, fmt.Errorf("failed to read image header: %v", err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > int64(config.MaxPixels) {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("%dx%d exceeds the %d pixel limit", cfg.Width, cfg.Height, config.MaxPixels)
+	}
+	return 0, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"batchMedia/pkg/media"
+)
+
+// sidecarExtensions holds the -sidecar-extensions list, lowercased with a leading dot
+var sidecarExtensions []string
+
+// parseSidecarExtensions parses a comma-separated extension list such as "xmp,aae"
+func parseSidecarExtensions(spec string) []string {
+	var exts []string
+	for _, ext := range strings.Split(spec, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	return exts
+}
+
+// isSidecarFile reports whether a file has one of the -sidecar-extensions
+func isSidecarFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, sidecarExt := range sidecarExtensions {
+		if ext == sidecarExt {
+			return true
+		}
+	}
+	return false
+}
+
+// isSidecarOwner reports whether a file is media that sidecars can belong to
+func isSidecarOwner(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".jpg" || ext == ".jpeg" || ext == ".heic" || ext == ".png" || media.IsRawFile(name) || isVideoFile(name)
+}
+
+// attachSidecars pairs each media item with the sidecars next to it and drops those sidecars
+// from the listing, so they are copied with their media instead of on their own. A sidecar
+// belongs to IMG_0001.HEIC when it is named IMG_0001.<ext> (Lightroom, iPhone) or
+// IMG_0001.HEIC.<ext> (darktable), compared case-insensitively. Sidecars are found among all
+// directory entries, so -ext need not list them.
+func attachSidecars(dir string, entries []os.DirEntry, items []workItem) []workItem {
+	if len(sidecarExtensions) == 0 {
+		return items
+	}
+
+	sidecarsByName := make(map[string]string) // lowercased name -> actual name
+	for _, entry := range entries {
+		if !entry.IsDir() && isSidecarFile(entry.Name()) {
+			sidecarsByName[strings.ToLower(entry.Name())] = entry.Name()
+		}
+	}
+	if len(sidecarsByName) == 0 {
+		return items
+	}
+
+	claimed := make(map[string]bool)
+	for i, item := range items {
+		name := filepath.Base(item.path)
+		if !isSidecarOwner(name) {
+			continue
+		}
+		lowerName := strings.ToLower(name)
+		lowerStem := strings.TrimSuffix(lowerName, filepath.Ext(lowerName))
+		for _, ext := range sidecarExtensions {
+			for _, candidate := range []string{lowerStem + ext, lowerName + ext} {
+				if actual, ok := sidecarsByName[candidate]; ok && !claimed[actual] {
+					claimed[actual] = true
+					items[i].sidecars = append(items[i].sidecars, filepath.Join(dir, actual))
+				}
+			}
+		}
+	}
+
+	kept := items[:0]
+	for _, item := range items {
+		if !claimed[filepath.Base(item.path)] {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// sidecarOutputPath names a sidecar after its media's output, keeping the media file's naming
+// style: IMG_0001.xmp next to IMG_0001.jpg, or IMG_0001.jpg.xmp for darktable-style sidecars
+func sidecarOutputPath(sidecarPath, mediaPath, mediaOutputPath string) string {
+	sidecarExt := filepath.Ext(sidecarPath)
+	sidecarStem := strings.TrimSuffix(filepath.Base(sidecarPath), sidecarExt)
+	if strings.EqualFold(sidecarStem, filepath.Base(mediaPath)) {
+		return mediaOutputPath + sidecarExt
+	}
+	return strings.TrimSuffix(mediaOutputPath, filepath.Ext(mediaOutputPath)) + sidecarExt
+}
+
+// copySidecars copies a processed media file's sidecars next to its output and records them
+// in the report like other copied files
+func copySidecars(sidecars []string, mediaPath, mediaOutputPath string, dirStats *DirectoryStats) {
+	// Media copied untouched keeps its own extension (e.g. a skipped HEIC)
+	if _, err := os.Stat(mediaOutputPath); err != nil {
+		if copied := copiedOutputPath(mediaPath, mediaOutputPath); copied != mediaOutputPath {
+			if _, err := os.Stat(copied); err == nil {
+				mediaOutputPath = copied
+			}
+		}
+	}
+
+	for _, sidecar := range sidecars {
+		info, err := os.Stat(sidecar)
+		if err != nil {
+			logWarn("failed to stat sidecar %s: %v", sidecar, err)
+			continue
+		}
+		outputPath := sidecarOutputPath(sidecar, mediaPath, mediaOutputPath)
+		if err := copyFile(sidecar, outputPath, info); err != nil {
+			logWarn("failed to copy sidecar %s: %v", sidecar, err)
+			continue
+		}
+		logDebug("Copied sidecar %s -> %s", sidecar, outputPath)
+
+		relPath, err := filepath.Rel(config.InputDir, sidecar)
+		if err != nil {
+			relPath = filepath.Base(sidecar)
+		}
+		fileInfo := FileInfo{
+			Path:             relPath,
+			Type:             "copied",
+			InputSize:        info.Size(),
+			OutputSize:       info.Size(),
+			CompressionRatio: 1.0,
+			Verification:     copyVerification(),
+		}
+		statsMutex.Lock()
+		stats.CopiedFiles++
+		dirStats.CopiedFiles++
+		stats.TotalInputSize += info.Size()
+		stats.TotalOutputSize += info.Size()
+		dirStats.TotalInputSize += info.Size()
+		dirStats.TotalOutputSize += info.Size()
+		stats.Files = append(stats.Files, fileInfo)
+		dirStats.Files = append(dirStats.Files, fileInfo)
+		recordManifest(fileInfo)
+		statsMutex.Unlock()
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// singleInput is the file given as -inputdir in single-file mode, empty when processing a directory
+var singleInput string
+
+// singleOutputPath is the exact output file when -out names one, empty when -out is a directory
+var singleOutputPath string
+
+// setupSingleFile switches to single-file mode: -inputdir becomes the file's directory, and an
+// -out with an extension that isn't an existing directory is taken as the output file itself
+func setupSingleFile() error {
+	singleInput = config.InputDir
+	config.InputDir = filepath.Dir(singleInput)
+
+	if filepath.Ext(config.OutputDir) != "" {
+		if fi, err := os.Stat(config.OutputDir); err != nil || !fi.IsDir() {
+			singleOutputPath = config.OutputDir
+			config.OutputDir = filepath.Dir(singleOutputPath)
+		}
+	}
+
+	// Writing into the input's own directory is only safe under a different name
+	inputAbs, err := filepath.Abs(singleInput)
+	if err != nil {
+		return fmt.Errorf("failed to resolve input file: %v", err)
+	}
+	outputAbs, err := filepath.Abs(filepath.Join(config.OutputDir, filepath.Base(singleInput)))
+	if singleOutputPath != "" {
+		outputAbs, err = filepath.Abs(singleOutputPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve output path: %v", err)
+	}
+	if outputAbs == inputAbs && config.OutputSuffix == "" {
+		return fmt.Errorf("output would overwrite the input file; give a different output path or use --output-suffix")
+	}
+	return nil
+}
+
+// runSingleFile processes the one input file without progress tracking or HTML reports
+func runSingleFile(ctx context.Context) {
+	info, err := os.Stat(singleInput)
+	if err != nil {
+		fatalConfig("Failed to read input file: %v", err)
+	}
+	if !shouldProcessExtension(singleInput) {
+		logInfo("Skipping %s: extension not in -ext %s", singleInput, config.Extensions)
+		return
+	}
+
+	startTime := time.Now()
+	stats = ProcessStats{DirectoryStats: make(map[string]*DirectoryStats)}
+	listingsMutex.Lock()
+	directoryListings[config.InputDir] = []workItem{{path: singleInput, info: info}}
+	listingsMutex.Unlock()
+	atomic.StoreInt64(&filesTotal, int64(countFilesToProcess([]workItem{{path: singleInput, info: info}})))
+	atomic.StoreInt64(&filesDone, 0)
+
+	if err := processImages(ctx, config.InputDir, 0); err != nil {
+		recordFileError(config.InputDir, singleInput, err)
+	}
+
+	logInfo("Processing completed in %s", time.Since(startTime))
+	if printErrorSummary() > 0 {
+		os.Exit(exitPartialFailure)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"batchMedia/pkg/media"
+)
+
+// sizeWidths holds the -sizes widths in ascending order (empty = single output)
+var sizeWidths []int
+
+// parseSizes parses -sizes as a comma-separated list of positive widths
+func parseSizes(spec string) ([]int, error) {
+	var widths []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		width, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || width <= 0 {
+			return nil, fmt.Errorf("--sizes must be a comma-separated list of positive widths (e.g. 320,640,1280)")
+		}
+		if !seen[width] {
+			seen[width] = true
+			widths = append(widths, width)
+		}
+	}
+	sort.Ints(widths)
+	return widths, nil
+}
+
+// sizeVariantPath inserts the width suffix before the extension, e.g. photo.jpg -> photo_640w.jpg
+func sizeVariantPath(outputPath string, width int) string {
+	ext := filepath.Ext(outputPath)
+	return fmt.Sprintf("%s_%dw%s", strings.TrimSuffix(outputPath, ext), width, ext)
+}
+
+// writeSizeVariants resizes one decoded image to every -sizes width and writes each variant,
+// then records a single report entry for the source listing all variants
+func writeSizeVariants(ctx context.Context, img image.Image, inputPath, outputPath, relPath string, exifData []byte, info os.FileInfo, dirStats *DirectoryStats, originalDim, croppedDim string) error {
+	sourceWidth, sourceHeight := img.Bounds().Dx(), img.Bounds().Dy()
+
+	var variants []string
+	var totalSize int64
+	largestDim := ""
+	var smallest image.Image
+	for _, width := range sizeWidths {
+		if width > sourceWidth && !config.AllowUpscale {
+			logInfo("Skipping %dw variant of %s: wider than the %dpx source (use -allow-upscale)", width, inputPath, sourceWidth)
+			continue
+		}
+		height := sourceHeight * width / sourceWidth
+		if height < 1 {
+			height = 1
+		}
+
+		resized := applySharpen(media.Resize(img, width, height), sourceWidth, width)
+		quality := encodeQuality(inputPath, sourceWidth, width)
+		if captionEnabled() {
+			resized = applyCaption(resized, captionText(info, exifData))
+		}
+		resized = applyBorder(resized)
+		data, err := encodeOutputImage(ctx, resized, outputPath, exifData, quality)
+		if err != nil {
+			return err
+		}
+
+		if err := contextError(ctx); err != nil {
+			return err
+		}
+		variantPath := sizeVariantPath(outputPath, width)
+		if err := writeFileAtomic(variantPath, data); err != nil {
+			return fmt.Errorf("failed to write output file: %v", err)
+		}
+		if err := verifyImageOutput(variantPath, resized.Bounds().Dx(), resized.Bounds().Dy()); err != nil {
+			return err
+		}
+		if err := preserveImageTimes(variantPath, info); err != nil {
+			return fmt.Errorf("failed to set file time: %v", err)
+		}
+
+		if rel, err := filepath.Rel(config.OutputDir, variantPath); err == nil {
+			variantPath = rel
+		}
+		variants = append(variants, variantPath)
+		if smallest == nil {
+			smallest = resized
+		}
+		totalSize += int64(len(data))
+		largestDim = fmt.Sprintf("%dx%d", resized.Bounds().Dx(), resized.Bounds().Dy())
+	}
+
+	if len(variants) == 0 {
+		return fmt.Errorf("no -sizes width fits the %dpx wide source", sourceWidth)
+	}
+	thumbnail, embedded := writePreviews(smallest, outputPath)
+
+	statsMutex.Lock()
+	stats.ProcessedImages++
+	stats.TotalOutputSize += totalSize
+	dirStats.ProcessedImages++
+	dirStats.TotalOutputSize += totalSize
+	fileInfo := FileInfo{
+		Path:              relPath,
+		Type:              "processed",
+		InputSize:         info.Size(),
+		OutputSize:        totalSize,
+		CompressionRatio:  float64(totalSize) / float64(info.Size()),
+		OriginalDim:       originalDim,
+		NewDim:            largestDim,
+		CroppedDim:        croppedDim,
+		Variants:          variants,
+		ThumbnailPath:     thumbnail,
+		EmbeddedThumbnail: embedded,
+		RawHandling:       rawHandling(inputPath),
+		Adjustments:       describeColorAdjustments(),
+	}
+	stats.Files = append(stats.Files, fileInfo)
+	dirStats.Files = append(dirStats.Files, fileInfo)
+	recordManifest(fileInfo)
+	statsMutex.Unlock()
+
+	logInfo("Processing completed: %s (%s -> %d sizes, %d bytes -> %d bytes total)",
+		inputPath, originalDim, len(variants), info.Size(), totalSize)
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"batchMedia/pkg/media"
+)
+
+// openImageSource opens an image with media.Open, retrying transient failures
+func openImageSource(path string, buffered bool) (*media.Source, error) {
+	var src *media.Source
+	err := withRetry("Reading "+path, func() error {
+		var err error
+		src, err = media.Open(path, buffered)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// bufferImageSource reports whether an input must be read whole: RAW previews are located by
+// offsets anywhere in the file, and -heic-all-images scans the HEIC for a motion video
+func bufferImageSource(inputPath string) bool {
+	return media.IsRawFile(inputPath) ||
+		(strings.EqualFold(filepath.Ext(inputPath), ".heic") && config.HEICAllImages)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"batchMedia/pkg/media"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// textSubtitleCodecs are the text subtitle formats ffmpeg converts between containers; bitmap
+// subtitles (PGS, DVD, DVB) can only be copied into a container that holds them
+var textSubtitleCodecs = map[string]bool{
+	"subrip": true, "srt": true, "ass": true, "ssa": true, "mov_text": true, "webvtt": true, "text": true,
+}
+
+// subtitleEncoder returns the encoder that puts a subtitle stream into the output container,
+// empty when the container can't hold it: Matroska copies everything, MP4 and QuickTime take
+// text as mov_text, WebM takes text as WebVTT
+func subtitleEncoder(outputPath, codecName string) string {
+	text := textSubtitleCodecs[codecName]
+	switch strings.ToLower(filepath.Ext(outputPath)) {
+	case ".mkv":
+		return "copy"
+	case ".mp4", ".mov", ".m4v":
+		if text {
+			return "mov_text"
+		}
+	case ".webm":
+		if text {
+			return "webvtt"
+		}
+	}
+	return ""
+}
+
+// mapSubtitles keeps the chapters and returns the input's subtitle streams the output can
+// hold, setting their encoders in kwargs, with a note on the ones left out. -strip-subtitles
+// leaves them all out without a note.
+func mapSubtitles(input *ffmpeg.Stream, probe *media.Probe, kwargs ffmpeg.KwArgs, inputPath, outputPath string) ([]*ffmpeg.Stream, string) {
+	kwargs["map_chapters"] = 0
+	codecs := probe.SubtitleCodecs()
+	if len(codecs) == 0 {
+		return nil, ""
+	}
+	if config.StripSubtitles {
+		logDebug("Stripping %d subtitle track(s) from %s", len(codecs), inputPath)
+		return nil, ""
+	}
+
+	var streams []*ffmpeg.Stream
+	var dropped []string
+	for i, codec := range codecs {
+		encoder := subtitleEncoder(outputPath, codec)
+		if encoder == "" {
+			dropped = append(dropped, codec)
+			continue
+		}
+		kwargs[fmt.Sprintf("c:s:%d", len(streams))] = encoder
+		streams = append(streams, input.Get(fmt.Sprintf("s:%d", i)))
+	}
+	logDebug("Keeping %d of %d subtitle track(s) in %s", len(streams), len(codecs), inputPath)
+	if len(dropped) == 0 {
+		return streams, ""
+	}
+	note := fmt.Sprintf("%d subtitle track(s) dropped (%s), %s can't hold them", len(dropped),
+		strings.Join(dropped, ", "), strings.ToLower(filepath.Ext(outputPath)))
+	logWarn("%s: %s", inputPath, note)
+	return streams, note
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"batchMedia/pkg/media"
+)
+
+// outputTemplateTokens are the placeholders -output-template understands
+var outputTemplateTokens = []string{"dir", "name", "ext", "width", "height", "date", "index"}
+
+// templateDateLayout formats {date}
+const templateDateLayout = "2006-01-02"
+
+var templateTokenPattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// templateNeedsSize and templateNeedsDate report whether the template reads image headers
+var templateNeedsSize bool
+var templateNeedsDate bool
+
+// templateClaims maps each templated output (lowercased, for case-insensitive filesystems) to
+// the input that claimed it; templatedOutputs maps inputs relative to -inputdir to their
+// outputs relative to the output directory, for the report links
+var templateClaims = make(map[string]string)
+var templatedOutputs = make(map[string]string)
+var templateMutex sync.Mutex
+
+// validateOutputTemplate checks -output-template for unknown tokens and paths leaving the
+// output directory
+func validateOutputTemplate() error {
+	template := config.OutputTemplate
+	switch {
+	case config.OutputSameAsInput:
+		return fmt.Errorf("--output-template cannot be combined with --in-place")
+	case singleOutputPath != "":
+		return fmt.Errorf("--output-template cannot be combined with an output file path as --out")
+	case filepath.IsAbs(template) || strings.HasPrefix(template, "/"):
+		return fmt.Errorf("--output-template must be relative to the output directory")
+	}
+	for _, match := range templateTokenPattern.FindAllStringSubmatch(template, -1) {
+		known := false
+		for _, token := range outputTemplateTokens {
+			known = known || match[1] == token
+		}
+		if !known {
+			return fmt.Errorf("unknown token {%s} in --output-template; available: %s", match[1], describeTemplateTokens())
+		}
+		templateNeedsSize = templateNeedsSize || match[1] == "width" || match[1] == "height"
+		templateNeedsDate = templateNeedsDate || match[1] == "date"
+	}
+	if strings.ContainsAny(templateTokenPattern.ReplaceAllString(template, ""), "{}") {
+		return fmt.Errorf("--output-template has an unmatched brace: %s", template)
+	}
+	for _, segment := range strings.Split(filepath.ToSlash(template), "/") {
+		if segment == ".." {
+			return fmt.Errorf("--output-template must stay inside the output directory")
+		}
+	}
+	if strings.HasSuffix(filepath.ToSlash(template), "/") {
+		return fmt.Errorf("--output-template must end with a file name, not a directory")
+	}
+	if templateNeedsSize && len(sizeWidths) > 0 {
+		return fmt.Errorf("--output-template {width} and {height} cannot be combined with --sizes, whose variants are named by width")
+	}
+	return nil
+}
+
+// describeTemplateTokens lists the tokens for help and error messages
+func describeTemplateTokens() string {
+	tokens := make([]string, len(outputTemplateTokens))
+	for i, token := range outputTemplateTokens {
+		tokens[i] = "{" + token + "}"
+	}
+	return strings.Join(tokens, " ")
+}
+
+// templateOutputPath names an image's output after -output-template, keeping the extension of
+// the output format. index is the image's 1-based position among the count images of its
+// directory. An output another input already claimed gets _2, _3, ... appended, so inputs are
+// numbered in listing order and a rerun names them the same way.
+func templateOutputPath(inputPath, relPath, outputPath string, index, count int) string {
+	dir := filepath.Dir(relPath)
+	if dir == "." {
+		dir = ""
+	}
+	inputExt := filepath.Ext(inputPath)
+	values := map[string]string{
+		"dir":   dir,
+		"name":  strings.TrimSuffix(filepath.Base(inputPath), inputExt),
+		"ext":   strings.ToLower(strings.TrimPrefix(inputExt, ".")),
+		"index": fmt.Sprintf("%0*d", len(strconv.Itoa(count)), index),
+	}
+	if templateNeedsSize || templateNeedsDate {
+		width, height, date := readTemplateFacts(inputPath, outputPath)
+		values["width"], values["height"], values["date"] = width, height, date
+	}
+	name := templateTokenPattern.ReplaceAllStringFunc(config.OutputTemplate, func(token string) string {
+		return values[token[1:len(token)-1]]
+	})
+	return claimTemplatedOutput(filepath.Join(config.OutputDir, filepath.FromSlash(name))+filepath.Ext(outputPath), inputPath)
+}
+
+// claimTemplatedOutput returns outputPath, or the first numbered variant of it no other input
+// has claimed in this run
+func claimTemplatedOutput(outputPath, inputPath string) string {
+	templateMutex.Lock()
+	defer templateMutex.Unlock()
+	ext := filepath.Ext(outputPath)
+	base := strings.TrimSuffix(outputPath, ext)
+	candidate := outputPath
+	for n := 2; ; n++ {
+		key := strings.ToLower(candidate)
+		if owner, taken := templateClaims[key]; !taken || owner == inputPath {
+			templateClaims[key] = inputPath
+			if candidate != outputPath {
+				logDebug("%s is taken, naming the output of %s %s", outputPath, inputPath, candidate)
+			}
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s_%d%s", base, n, ext)
+	}
+}
+
+// isTemplatedImage reports whether -output-template names the output of path
+func isTemplatedImage(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".heic":
+		return true
+	}
+	return media.IsRawFile(path) && config.RawMode == "preview"
+}
+
+// recordTemplatedOutput remembers where -output-template put an input's output
+func recordTemplatedOutput(relPath, outputPath string) {
+	rel, err := filepath.Rel(config.OutputDir, outputPath)
+	if err != nil {
+		return
+	}
+	templateMutex.Lock()
+	templatedOutputs[relPath] = rel
+	templateMutex.Unlock()
+}
+
+// templatedReportPath returns the output path the reports link for a templated input; images
+// copied unchanged keep their own extension
+func templatedReportPath(file FileInfo) (string, bool) {
+	templateMutex.Lock()
+	output, ok := templatedOutputs[file.Path]
+	templateMutex.Unlock()
+	if !ok {
+		return "", false
+	}
+	if file.Type != "processed" && file.Type != "duplicate" {
+		output = copiedOutputPath(file.Path, output)
+	}
+	return output, true
+}
+
+// readTemplateFacts returns the {width}, {height} and {date} of an image: the size it is
+// resized to, before any border or caption, and its capture date (after -shift-time), or the
+// modification date without EXIF. Unreadable sizes expand to 0 and are left for the decoder
+// to report.
+func readTemplateFacts(inputPath, outputPath string) (string, string, string) {
+	width, height, date := "0", "0", ""
+	if info, err := os.Stat(inputPath); err == nil {
+		date = info.ModTime().Add(timeShift).Format(templateDateLayout)
+	}
+	src, err := openImageSource(inputPath, bufferImageSource(inputPath))
+	if err != nil {
+		logWarn("failed to read %s for --output-template: %v", inputPath, err)
+		return width, height, date
+	}
+	defer src.Close()
+
+	ext := strings.ToLower(filepath.Ext(inputPath))
+	if sniffed := media.SniffFormat(src.Header); sniffed != "" && !media.IsRawFile(inputPath) {
+		ext = sniffed
+	}
+	// The config decoders stop after the frame header, so streaming the file reads little more
+	var header io.Reader = src.Stream()
+	var exifData []byte
+	switch {
+	case media.IsRawFile(inputPath):
+		preview, err := media.ExtractRawPreview(src.Data)
+		if err != nil {
+			logWarn("failed to read %s for --output-template: %v", inputPath, err)
+			return width, height, date
+		}
+		header, ext = bytes.NewReader(preview), ".jpg"
+		exifData, _ = media.ExtractEXIF(preview)
+	case ext == ".heic":
+		exifData, _ = media.ExtractHEICEXIF(src.Section())
+	case ext == ".jpg" || ext == ".jpeg":
+		exifData, _ = media.ExtractEXIF(src.Header)
+	}
+	if taken, ok := exifDateTime(shiftEXIFDates(exifData)); ok {
+		date = taken.Format(templateDateLayout)
+	}
+	if !templateNeedsSize {
+		return width, height, date
+	}
+
+	cfg, err := media.DecodeConfig(ext, header)
+	if err != nil {
+		logWarn("failed to read the size of %s for --output-template: %v", inputPath, err)
+		return width, height, date
+	}
+	w, h := cfg.Width, cfg.Height
+	if orientation := media.EXIFOrientation(src); orientation >= 5 && orientationRotatesPixels(outputPath) {
+		w, h = h, w
+	}
+	if config.Rotate == 90 || config.Rotate == 270 {
+		w, h = h, w
+	}
+	w, h = imageOptions().TargetSize(cropSize(w, h))
+	return strconv.Itoa(w), strconv.Itoa(h), date
+}
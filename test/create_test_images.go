@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/jpeg"
 	"image/png"
+	"math"
 	"os"
 	"path/filepath"
 )
@@ -51,12 +56,132 @@ func savePNG(img image.Image, filename string) error {
 	return png.Encode(file, img)
 }
 
+// savePNGWithOrientation saves image as PNG with an eXIf chunk carrying the EXIF orientation tag
+func savePNGWithOrientation(img image.Image, filename string, orientation uint16) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+
+	// Minimal little-endian TIFF: header + IFD0 with a single Orientation (0x0112) SHORT entry
+	exif := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 1, 0}
+	exif = binary.LittleEndian.AppendUint16(exif, 0x0112)
+	exif = binary.LittleEndian.AppendUint16(exif, 3)
+	exif = binary.LittleEndian.AppendUint32(exif, 1)
+	exif = binary.LittleEndian.AppendUint16(exif, orientation)
+	exif = append(exif, 0, 0, 0, 0, 0, 0) // padding and next IFD offset
+
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(exif)))
+	chunk = append(chunk, "eXIf"...)
+	chunk = append(chunk, exif...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	// Insert right after the IHDR chunk (8-byte signature + 25-byte IHDR)
+	out := append([]byte{}, data[:33]...)
+	out = append(out, chunk...)
+	out = append(out, data[33:]...)
+	return os.WriteFile(filename, out, 0644)
+}
+
+// saveJPEGWithDates saves image as JPEG with an EXIF APP1 segment carrying DateTime (IFD0)
+// and DateTimeOriginal (EXIF IFD), both set to date ("YYYY:MM:DD HH:MM:SS")
+func saveJPEGWithDates(img image.Image, filename string, date string) error {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+
+	// Little-endian TIFF: IFD0 at 8 (DateTime, ExifIFD pointer), EXIF IFD at 38
+	// (DateTimeOriginal), then the two 20-byte date strings at 56 and 76
+	le := binary.LittleEndian
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = append(le.AppendUint16(le.AppendUint16(tiff, 0x0132), 2), 20, 0, 0, 0)
+	tiff = le.AppendUint32(tiff, 56)
+	tiff = append(le.AppendUint16(le.AppendUint16(tiff, 0x8769), 4), 1, 0, 0, 0)
+	tiff = le.AppendUint32(tiff, 38)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = append(le.AppendUint16(le.AppendUint16(tiff, 0x9003), 2), 20, 0, 0, 0)
+	tiff = le.AppendUint32(tiff, 76)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = append(append(tiff, date...), 0)
+	tiff = append(append(tiff, date...), 0)
+
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(tiff)+8))
+	app1 = append(app1, "Exif\x00\x00"...)
+	app1 = append(app1, tiff...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	out = append(out, data[2:]...)
+	return os.WriteFile(filename, out, 0644)
+}
+
+// createStripeImage creates an image of vertical dark and light gray stripes, stripe pixels wide,
+// whose hard edges show how much a resize blurs and a sharpen restores; the grays leave room
+// for the unsharp mask to overshoot
+func createStripeImage(width, height, stripe int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if (x/stripe)%2 == 0 {
+				img.Set(x, y, color.RGBA{192, 192, 192, 255})
+			} else {
+				img.Set(x, y, color.RGBA{64, 64, 64, 255})
+			}
+		}
+	}
+	return img
+}
+
+// printEdgeContrast prints the mean absolute luminance difference between horizontally
+// adjacent pixels of an image, which goes up as its edges get sharper
+func printEdgeContrast(filename string) {
+	file, err := os.Open(filename)
+	if err != nil {
+		println(err.Error())
+		os.Exit(1)
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		println(err.Error())
+		os.Exit(1)
+	}
+
+	bounds := img.Bounds()
+	var total float64
+	var count int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X + 1; x < bounds.Max.X; x++ {
+			left := color.GrayModel.Convert(img.At(x-1, y)).(color.Gray).Y
+			right := color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+			total += math.Abs(float64(left) - float64(right))
+			count++
+		}
+	}
+	fmt.Printf("%.3f\n", total/float64(count))
+}
+
 func main() {
+	// go run create_test_images.go edge-contrast <file> measures an output instead
+	if len(os.Args) == 3 && os.Args[1] == "edge-contrast" {
+		printEdgeContrast(os.Args[2])
+		return
+	}
+
 	// Create test directories
 	dirs := []string{
 		"input/images",
 		"input/videos", 
 		"input/mixed",
+		"input/oriented",
+		"input/dated",
+		"input/edges",
 	}
 	
 	for _, dir := range dirs {
@@ -110,6 +235,15 @@ func main() {
 		}
 	}
 	
+	// PNG tagged with EXIF orientation 6 (rotate 90° CW), stored as 640x480
+	savePNGWithOrientation(createTestImage(640, 480, color.RGBA{0, 128, 255, 255}), "input/oriented/rotated_exif.png", 6)
+	
+	// JPEG with EXIF dates just before a daylight saving change, for -shift-time
+	saveJPEGWithDates(createTestImage(640, 480, color.RGBA{255, 128, 0, 255}), "input/dated/dated_exif.jpg", "2021:03:14 01:30:00")
+	
+	// Hard-edged stripes for the -sharpen before/after comparison
+	savePNG(createStripeImage(640, 480, 15), "input/edges/stripes.png")
+	
 	println("Test images created successfully!")
 	println("Large images (>= 1920x1080):")
 	println("  - large_4k.jpg (3840x2160)")
@@ -125,4 +259,8 @@ func main() {
 	println("  - small_hd.jpg (1280x720)")
 	println("  - small_vga.png (640x480)")
 	println("  - small_thumb.jpg (320x240)")
+	println("")
+	println("Orientation-tagged images:")
+	println("  - oriented/rotated_exif.png (640x480, EXIF orientation 6)")
+	println("  - dated/dated_exif.jpg (640x480, EXIF dates 2021:03:14 01:30:00)")
 }
\ No newline at end of file
@@ -51,7 +51,7 @@ generate_test_data() {
 cleanup_test_data() {
     echo "清理测试数据..."
     rm -rf output/*
-    rm -rf input/small_images
+    rm -rf input/small_images input/nested_videos input/mtime input/selfnest input/small_heic input/inplace input/extfilter input/symlinks input/legacyvideo input/caption input/minres input/archive input/watch input/undecodable input/misnamed input/template input/extsettings input/portrait input/htmlescape input/hdrprobe input/longpath input/surround input/subtitles input/progresskill output_inplace_backup
     echo "✓ 测试数据清理完成"
 }
 
@@ -127,6 +127,49 @@ verify_video_resolution() {
     fi
 }
 
+# 视频编码标签验证函数
+verify_video_codec_tag() {
+    local file="$1"
+    local expected_tag="$2"
+    local test_name="$3"
+
+    if [ ! -f "$file" ]; then
+        echo "✗ $test_name: 视频文件不存在 - $file"
+        return 1
+    fi
+
+    local actual_tag=$(ffprobe -v quiet -select_streams v:0 -show_entries stream=codec_tag_string -of default=noprint_wrappers=1:nokey=1 "$file" 2>/dev/null)
+
+    if [ "$actual_tag" = "$expected_tag" ]; then
+        echo "✓ $test_name: 编码标签正确 ${actual_tag}"
+        return 0
+    else
+        echo "✗ $test_name: 编码标签错误 - 期望: ${expected_tag}, 实际: ${actual_tag}"
+        return 1
+    fi
+}
+
+# 修改时间验证函数（纳秒精度，需要 GNU stat）
+verify_mtime() {
+    local source=$1
+    local output=$2
+    local test_name=$3
+
+    if [ ! -f "$output" ]; then
+        echo "❌ $test_name: 文件不存在 $output"
+        return 1
+    fi
+
+    local expected=$(stat -c %y "$source")
+    local actual=$(stat -c %y "$output")
+    if [ "$expected" = "$actual" ]; then
+        echo "✓ $test_name: 修改时间一致 ($actual)"
+    else
+        echo "❌ $test_name: 修改时间不一致 (期望: $expected, 实际: $actual)"
+        return 1
+    fi
+}
+
 # 测试用例定义
 echo "=== 开始参数组合测试 ==="
 echo
@@ -212,7 +255,7 @@ mkdir -p input/small_images output/test10
 cp input/images/small_hd.jpg input/small_images/
 cp input/images/small_vga.png input/small_images/
 cp input/images/small_thumb.jpg input/small_images/
-../bin/batchMedia -inputdir input/small_images -out output/test10 -size 1.5 -ignore-smart-limit
+../bin/batchMedia -inputdir input/small_images -out output/test10 -size 1.5 -ignore-smart-limit -allow-upscale
 # 验证小图片放大效果
 verify_image_resolution "output/test10/small_hd.jpg" "1920" "1080" "测试10-小图片放大"
 verify_image_resolution "output/test10/small_vga.png" "960" "720" "测试10-VGA图片放大"
@@ -232,6 +275,22 @@ if command -v ffmpeg >/dev/null 2>&1; then
         mkdir -p output/test11
         ../bin/batchMedia -inputdir input/videos -out output/test11 -size 0.5
         verify_video_resolution "output/test11/test_video.mp4" "960" "540" "测试11-视频缩放"
+        verify_video_codec_tag "output/test11/test_video.mp4" "hvc1" "测试11-HEVC编码标签"
+
+        # H.264 输出不应带 hvc1 标签
+        mkdir -p output/test11_h264
+        ../bin/batchMedia -inputdir input/videos -out output/test11_h264 -size 0.5 -video-codec libx264
+        verify_video_codec_tag "output/test11_h264/test_video.mp4" "avc1" "测试11-H.264编码标签"
+
+        # 子目录中被跳过的视频，报告链接应相对于输入目录
+        mkdir -p input/nested_videos/sub output/test11_skip
+        cp input/videos/test_video.mp4 input/nested_videos/sub/
+        ../bin/batchMedia -inputdir input/nested_videos -out output/test11_skip -size 0.5 -threshold-width 1000
+        if grep -q 'href="test_video.mp4"' output/test11_skip/sub/processing_report.html 2>/dev/null; then
+            echo "✓ 测试11-跳过视频报告路径正确"
+        else
+            echo "❌ 测试11-跳过视频报告路径错误"
+        fi
         echo "✓ 测试11执行完成"
     else
         echo "⚠ 测试11跳过：无法创建测试视频"
@@ -241,6 +300,920 @@ else
 fi
 echo
 
+# 测试12: 文件时间保留（纳秒精度）
+echo "测试12: 文件时间保留"
+if stat -c %y . >/dev/null 2>&1; then
+    mkdir -p input/mtime output/test12
+    cp input/images/small_hd.jpg input/mtime/
+    echo "notes" > input/mtime/notes.txt
+    touch -d "2020-01-02 03:04:05.123456789" input/mtime/small_hd.jpg input/mtime/notes.txt
+    ../bin/batchMedia -inputdir input/mtime -out output/test12 -size 0.5 -ignore-smart-limit
+    verify_mtime "input/mtime/small_hd.jpg" "output/test12/small_hd.jpg" "测试12-处理后图片时间"
+    verify_mtime "input/mtime/notes.txt" "output/test12/notes.txt" "测试12-复制文件时间"
+    echo "✓ 测试12执行完成"
+else
+    echo "⚠ 测试12跳过：需要 GNU stat"
+fi
+echo
+
+# 测试13: PNG EXIF 方向校正
+echo "测试13: PNG EXIF 方向校正 (eXIf orientation=6)"
+mkdir -p output/test13
+../bin/batchMedia -inputdir input/oriented -out output/test13 -size 0.5 -ignore-smart-limit
+# 640x480 旋转 90° 后为 480x640，缩放 50% 得到 240x320
+verify_image_resolution "output/test13/rotated_exif.png" "240" "320" "测试13-PNG方向校正"
+echo "✓ 测试13执行完成"
+echo
+
+# 测试14: 输出目录位于输入目录内
+echo "测试14: 输出目录嵌套在输入目录内 (重复运行不应处理自身输出)"
+mkdir -p input/selfnest/photos input/selfnest/out
+cp input/images/small_hd.jpg input/selfnest/photos/
+for run in 1 2; do
+    rm -f input/selfnest/out/progress.json
+    ../bin/batchMedia -inputdir input/selfnest -out input/selfnest/out -size 0.5 -ignore-smart-limit
+done
+verify_image_resolution "input/selfnest/out/photos/small_hd.jpg" "640" "360" "测试14-嵌套输出"
+if [ -d "input/selfnest/out/out" ]; then
+    echo -e "${RED}✗ 测试14-输出目录被重复扫描: input/selfnest/out/out${NC}"
+else
+    echo -e "${GREEN}✓ 测试14-输出目录已排除在扫描之外${NC}"
+fi
+# 输入与输出为同一目录且未指定 -output-suffix 时应报错退出
+if ../bin/batchMedia -inputdir input/selfnest/photos -out input/selfnest/photos -size 0.5 >/dev/null 2>&1; then
+    echo -e "${RED}✗ 测试14-同目录未报错${NC}"
+else
+    echo -e "${GREEN}✓ 测试14-同目录被拒绝${NC}"
+fi
+echo "✓ 测试14执行完成"
+echo
+
+# 测试15: 低于阈值的 HEIC 原样复制并保留 .heic 扩展名
+echo "测试15: 低于阈值的 HEIC 保持 .heic 输出"
+if command -v heif-enc >/dev/null 2>&1; then
+    mkdir -p input/small_heic output/test15
+    heif-enc -q 80 input/images/small_thumb.jpg -o input/small_heic/small_thumb.heic >/dev/null
+    # 使用智能默认阈值 (1920x1080)，320x240 的 HEIC 会被跳过并原样复制
+    ../bin/batchMedia -inputdir input/small_heic -out output/test15 -size 0.5
+    if [ -f "output/test15/small_thumb.jpg" ]; then
+        echo -e "${RED}✗ 测试15-跳过的 HEIC 被写成 .jpg${NC}"
+    elif [ -f "output/test15/small_thumb.heic" ] && [ "$(dd if=output/test15/small_thumb.heic bs=1 skip=4 count=4 2>/dev/null)" = "ftyp" ] && \
+        cmp -s input/small_heic/small_thumb.heic output/test15/small_thumb.heic; then
+        echo -e "${GREEN}✓ 测试15-输出为有效 HEIC 且与原文件一致${NC}"
+    else
+        echo -e "${RED}✗ 测试15-未找到有效的 HEIC 输出${NC}"
+    fi
+    echo "✓ 测试15执行完成"
+else
+    echo "⚠ 测试15跳过：需要 heif-enc (libheif)"
+fi
+echo
+
+# 测试16: 强制旋转
+echo "测试16: 强制旋转 (rotate=90)"
+mkdir -p output/test16
+../bin/batchMedia -inputdir input/mixed -out output/test16 -size 0.5 -ignore-smart-limit -rotate 90 -ext jpg
+# 1280x720 旋转 90° 后为 720x1280，缩放 50% 得到 360x640
+verify_image_resolution "output/test16/small_hd.jpg" "360" "640" "测试16-旋转90度"
+echo "✓ 测试16执行完成"
+echo
+
+# 测试17: 单文件输入
+echo "测试17: 单文件输入 (目录输出和指定输出文件)"
+mkdir -p output/test17
+../bin/batchMedia -inputdir input/mixed/small_hd.jpg -out output/test17 -size 0.5 -ignore-smart-limit
+verify_image_resolution "output/test17/small_hd.jpg" "640" "360" "测试17-输出到目录"
+../bin/batchMedia -inputdir input/mixed/small_hd.jpg -out output/test17/named/thumb.jpg -width 320 -ignore-smart-limit
+verify_image_resolution "output/test17/named/thumb.jpg" "320" "180" "测试17-指定输出文件"
+if [ -f "output/test17/progress.json" ]; then
+    echo -e "${RED}✗ 测试17-单文件模式不应写入 progress.json${NC}"
+fi
+echo "✓ 测试17执行完成"
+echo
+
+# 测试18: 中断后不留残缺输出
+echo "测试18: 中断处理 (写入中途强制终止)"
+partial_found=0
+for delay in 0.05 0.1 0.2 0.4; do
+    rm -rf output/test18 && mkdir -p output/test18
+    ../bin/batchMedia -inputdir input/images -out output/test18 -size 0.9 -ignore-smart-limit -ext jpg &
+    pid=$!
+    sleep $delay
+    kill -9 $pid 2>/dev/null
+    wait $pid 2>/dev/null
+    # 最终路径上的 JPEG 必须完整（以 EOI 标记 FFD9 结尾），未完成的只能留在 .tmp 文件中
+    for f in output/test18/*.jpg; do
+        [ -f "$f" ] || continue
+        case "$f" in *.tmp.jpg) continue ;; esac
+        if [ "$(tail -c 2 "$f" | od -An -tx1 | tr -d ' \n')" != "ffd9" ]; then
+            echo -e "${RED}✗ 测试18-发现残缺输出: $f (终止延迟 ${delay}s)${NC}"
+            partial_found=1
+        fi
+    done
+done
+if [ $partial_found -eq 0 ]; then
+    echo -e "${GREEN}✓ 测试18-中断后最终路径上没有残缺文件${NC}"
+fi
+echo "✓ 测试18执行完成"
+echo
+
+# 测试19: 原地覆盖模式
+echo "测试19: 原地覆盖 (带备份)"
+mkdir -p input/inplace
+cp input/images/small_hd.jpg input/inplace/
+if ../bin/batchMedia -inputdir input/inplace -size 0.5 -ignore-smart-limit -output-same-as-input -backup-dir output_inplace_backup 2>/dev/null; then
+    echo -e "${RED}✗ 测试19-缺少 -i-know-this-overwrites 时应拒绝运行${NC}"
+fi
+../bin/batchMedia -inputdir input/inplace -size 0.5 -ignore-smart-limit -output-same-as-input -backup-dir output_inplace_backup -i-know-this-overwrites
+verify_image_resolution "input/inplace/small_hd.jpg" "640" "360" "测试19-原文件被替换"
+verify_image_resolution "output_inplace_backup/small_hd.jpg" "1280" "720" "测试19-原文件已备份"
+# 再次运行（删除进度文件以强制重新扫描）不应再次缩小
+rm -f input/inplace/progress.json
+../bin/batchMedia -inputdir input/inplace -size 0.5 -ignore-smart-limit -output-same-as-input -backup-dir output_inplace_backup -i-know-this-overwrites
+verify_image_resolution "input/inplace/small_hd.jpg" "640" "360" "测试19-重复运行不再处理"
+echo "✓ 测试19执行完成"
+echo
+
+# 测试20: -ext 过滤同时作用于图片、视频和其他文件
+echo "测试20: 混合扩展名过滤 (ext=jpg / ext=.TXT,jpg)"
+mkdir -p input/extfilter output/test20_jpg output/test20_mixed
+cp input/images/small_hd.jpg input/extfilter/
+echo "not a real video" > input/extfilter/clip.mp4
+echo "notes" > input/extfilter/notes.txt
+../bin/batchMedia -inputdir input/extfilter -out output/test20_jpg -size 0.5 -ignore-smart-limit -ext jpg
+verify_image_resolution "output/test20_jpg/small_hd.jpg" "640" "360" "测试20-仅处理jpg"
+if [ -e "output/test20_jpg/clip.mp4" ] || [ -e "output/test20_jpg/notes.txt" ]; then
+    echo -e "${RED}✗ 测试20-ext=jpg 时不应处理或复制视频和其他文件${NC}"
+fi
+# 大小写和前导点不影响匹配，未列出的视频仍被排除
+../bin/batchMedia -inputdir input/extfilter -out output/test20_mixed -size 0.5 -ignore-smart-limit -ext ".TXT, jpg"
+verify_image_resolution "output/test20_mixed/small_hd.jpg" "640" "360" "测试20-混合过滤处理jpg"
+if [ ! -f "output/test20_mixed/notes.txt" ] || [ -e "output/test20_mixed/clip.mp4" ]; then
+    echo -e "${RED}✗ 测试20-ext=.TXT,jpg 应只复制 txt 而不处理视频${NC}"
+fi
+echo "✓ 测试20执行完成"
+echo
+
+# 测试21: 跟随符号链接目录，指向上级目录的循环链接只扫描一次
+echo "测试21: 符号链接目录 (follow-symlinks)"
+mkdir -p input/symlinks/real output/test21
+cp input/images/small_hd.jpg input/symlinks/real/
+ln -sfn ../images input/symlinks/real/linked
+ln -sfn .. input/symlinks/real/loop
+if command -v timeout >/dev/null 2>&1; then
+    timeout 60 ../bin/batchMedia -inputdir input/symlinks -out output/test21 -size 0.5 -ignore-smart-limit -follow-symlinks -ext jpg
+else
+    ../bin/batchMedia -inputdir input/symlinks -out output/test21 -size 0.5 -ignore-smart-limit -follow-symlinks -ext jpg
+fi
+verify_image_resolution "output/test21/real/linked/small_hd.jpg" "640" "360" "测试21-链接目录被处理"
+if [ -e "output/test21/real/loop" ]; then
+    echo -e "${RED}✗ 测试21-循环链接不应被再次扫描${NC}"
+fi
+echo "✓ 测试21执行完成"
+echo
+
+# 测试22: 进度文件写入中途崩溃后，之前的进度仍可加载
+echo "测试22: progress.json 原子写入"
+mkdir -p output/test22
+../bin/batchMedia -inputdir input/mixed -out output/test22 -size 0.5 -ignore-smart-limit -ext jpg
+# 模拟写入中途崩溃：写入先落在同目录的临时文件 progress_jpg.tmp.json（扩展名前加 .tmp），
+# 只留下被截断的临时文件，原 progress_jpg.json 不受影响
+head -c 40 output/test22/progress_jpg.json > output/test22/progress_jpg.tmp.json
+if ../bin/batchMedia -inputdir input/mixed -out output/test22 -size 0.5 -ignore-smart-limit -ext jpg 2>&1 | grep -q "All directories have been processed"; then
+    echo -e "${GREEN}✓ 测试22-中断的写入不影响已保存的进度${NC}"
+else
+    echo -e "${RED}✗ 测试22-之前的进度无法加载${NC}"
+fi
+# 处理过程中多次强制结束进程（每个目录完成后都会写一次进度），进度文件每次都应能加载
+mkdir -p input/progresskill output/test22_kill
+for dir in $(seq 1 40); do
+    mkdir -p "input/progresskill/dir_$dir"
+    cp input/images/small_hd.jpg "input/progresskill/dir_$dir/"
+done
+corrupt=0
+for delay in 0.1 0.2 0.3 0.5 0.8; do
+    ../bin/batchMedia -inputdir input/progresskill -out output/test22_kill -size 0.5 -ignore-smart-limit >/dev/null 2>&1 &
+    pid=$!
+    sleep "$delay"
+    kill -9 "$pid" 2>/dev/null
+    wait "$pid" 2>/dev/null
+    if [ -f "output/test22_kill/progress.json" ] &&
+        ../bin/batchMedia -inputdir input/progresskill -out output/test22_kill -size 0.5 -ignore-smart-limit -fake-scan 2>&1 | grep -q "Failed to load progress"; then
+        corrupt=$((corrupt + 1))
+    fi
+done
+if [ "$corrupt" -eq 0 ]; then
+    echo -e "${GREEN}✓ 测试22-强制结束后进度文件仍可加载${NC}"
+else
+    echo -e "${RED}✗ 测试22-强制结束后有 $corrupt 次进度文件损坏${NC}"
+fi
+echo "✓ 测试22执行完成"
+echo
+
+# 测试23: 超过 -per-file-timeout 的文件记为失败且不留下输出
+echo "测试23: 单文件超时 (per-file-timeout)"
+mkdir -p output/test23
+if ../bin/batchMedia -inputdir input/mixed -out output/test23 -size 0.5 -ignore-smart-limit -ext jpg -per-file-timeout 1ns 2>&1 | grep -q "timed out after"; then
+    echo -e "${GREEN}✓ 测试23-超时文件被记为失败${NC}"
+else
+    echo -e "${RED}✗ 测试23-未报告超时${NC}"
+fi
+if [ -f "output/test23/small_hd.jpg" ]; then
+    echo -e "${RED}✗ 测试23-超时文件不应写出输出${NC}"
+fi
+echo "✓ 测试23执行完成"
+echo
+
+# 测试24: 摄像机/DVD 视频格式被识别为视频，并输出为 .mp4
+echo "测试24: 旧式视频容器识别 (3gp/mts/m2ts/ts/mpg/mpeg/vob)"
+mkdir -p input/legacyvideo output/test24
+for ext in 3gp mts m2ts ts mpg mpeg VOB; do
+    touch "input/legacyvideo/clip_$ext.$ext"
+done
+legacy_count=$(../bin/batchMedia -inputdir input/legacyvideo -out output/test24 -size 0.5 -fake-scan 2>&1 | grep "Would process video" | grep -c "\.mp4$")
+if [ "$legacy_count" -eq 7 ]; then
+    echo -e "${GREEN}✓ 测试24-7 种旧式容器均作为视频处理并输出 .mp4${NC}"
+else
+    echo -e "${RED}✗ 测试24-仅识别 $legacy_count/7 个旧式容器视频${NC}"
+fi
+echo "✓ 测试24执行完成"
+echo
+
+# 测试25: 烧录文字不改变输出尺寸，且输出与不加文字时不同
+echo "测试25: 烧录文字 (caption)"
+mkdir -p input/caption output/test25_plain output/test25_caption
+cp input/images/small_hd.jpg input/caption/
+../bin/batchMedia -inputdir input/caption -out output/test25_plain -size 0.5 -ignore-smart-limit
+../bin/batchMedia -inputdir input/caption -out output/test25_caption -size 0.5 -ignore-smart-limit -caption text -caption-text "batchMedia: test" -caption-position top-left
+verify_image_resolution "output/test25_caption/small_hd.jpg" "640" "360" "测试25-加文字后尺寸不变"
+if cmp -s "output/test25_plain/small_hd.jpg" "output/test25_caption/small_hd.jpg"; then
+    echo -e "${RED}✗ 测试25-输出中没有烧录文字${NC}"
+else
+    echo -e "${GREEN}✓ 测试25-文字已烧录到输出图片${NC}"
+fi
+echo "✓ 测试25执行完成"
+echo
+
+# 测试26: 输出校验，正常输出通过校验；--verify-output-delete 需要 --verify-output
+echo "测试26: 输出校验 (verify-output)"
+mkdir -p output/test26
+if ../bin/batchMedia -inputdir input/caption -out output/test26 -size 0.5 -ignore-smart-limit -verify-output 2>&1 | grep -q "output verification failed"; then
+    echo -e "${RED}✗ 测试26-正常输出未通过校验${NC}"
+else
+    verify_image_resolution "output/test26/small_hd.jpg" "640" "360" "测试26-校验后保留输出"
+fi
+if ../bin/batchMedia -inputdir input/caption -out output/test26 -size 0.5 -verify-output-delete >/dev/null 2>&1; then
+    echo -e "${RED}✗ 测试26-单独使用 --verify-output-delete 应报错${NC}"
+else
+    echo -e "${GREEN}✓ 测试26-单独使用 --verify-output-delete 被拒绝${NC}"
+fi
+echo "✓ 测试26执行完成"
+echo
+
+# 测试27: EXIF 日期平移（正向/负向），EXIF 日期不带时区，按字面时间平移（跨夏令时也不受影响）
+echo "测试27: EXIF 日期平移 (shift-time)"
+for shift in 1h -26h; do
+    mkdir -p "output/test27_$shift"
+    ../bin/batchMedia -inputdir input/dated -out "output/test27_$shift" -size 0.5 -ignore-smart-limit -shift-time="$shift"
+done
+# 原始日期 2021:03:14 01:30:00，+1h 落在美国夏令时跳过的 02:30，仍应按字面时间写入
+for case in "1h|2021:03:14 02:30:00|3600" "-26h|2021:03:12 23:30:00|-93600"; do
+    IFS='|' read -r shift expected_date expected_delta <<< "$case"
+    output="output/test27_$shift/dated_exif.jpg"
+    date_count=$(grep -a -o "$expected_date" "$output" 2>/dev/null | wc -l | tr -d ' ')
+    if [ "$date_count" -eq 2 ]; then
+        echo -e "${GREEN}✓ 测试27-平移 $shift 后 DateTime/DateTimeOriginal 为 $expected_date${NC}"
+    else
+        echo -e "${RED}✗ 测试27-平移 $shift 后应有 2 个 $expected_date 日期，实际 $date_count 个${NC}"
+    fi
+    if stat -c %Y . >/dev/null 2>&1; then
+        delta=$(( $(stat -c %Y "$output") - $(stat -c %Y input/dated/dated_exif.jpg) ))
+        if [ "$delta" -eq "$expected_delta" ]; then
+            echo -e "${GREEN}✓ 测试27-平移 $shift 后修改时间相差 ${delta}s${NC}"
+        else
+            echo -e "${RED}✗ 测试27-平移 $shift 后修改时间相差 ${delta}s，期望 ${expected_delta}s${NC}"
+        fi
+    fi
+done
+# 重新编码更大而保留原图 (-prefer-smaller) 时，保留的原图同样平移 EXIF 日期和修改时间
+mkdir -p output/test27_kept
+../bin/batchMedia -inputdir input/dated -out output/test27_kept -size 1 -ignore-smart-limit -adaptive-quality -quality-floor 100 -quality-ceiling 100 -prefer-smaller -shift-time=1h -json-report
+output="output/test27_kept/dated_exif.jpg"
+if grep -q '"Note": "original kept' output/test27_kept/report.json; then
+    echo -e "${GREEN}✓ 测试27-保留了原图${NC}"
+else
+    echo -e "${RED}✗ 测试27-重新编码更大时应保留原图${NC}"
+fi
+date_count=$(grep -a -o "2021:03:14 02:30:00" "$output" 2>/dev/null | wc -l | tr -d ' ')
+if [ "$date_count" -eq 2 ] && [ "$(stat -c %s "$output")" -eq "$(stat -c %s input/dated/dated_exif.jpg)" ]; then
+    echo -e "${GREEN}✓ 测试27-保留的原图 DateTime/DateTimeOriginal 平移为 2021:03:14 02:30:00${NC}"
+else
+    echo -e "${RED}✗ 测试27-保留的原图应有 2 个平移后的日期，实际 $date_count 个${NC}"
+fi
+if stat -c %Y . >/dev/null 2>&1; then
+    delta=$(( $(stat -c %Y "$output") - $(stat -c %Y input/dated/dated_exif.jpg) ))
+    if [ "$delta" -eq 3600 ]; then
+        echo -e "${GREEN}✓ 测试27-保留的原图修改时间相差 ${delta}s${NC}"
+    else
+        echo -e "${RED}✗ 测试27-保留的原图修改时间相差 ${delta}s，期望 3600s${NC}"
+    fi
+fi
+echo "✓ 测试27执行完成"
+echo
+
+# 测试28: 形状过滤按方向校正后的尺寸判断（640x480 + orientation 6 = 纵向）
+echo "测试28: 形状过滤 (orientation-filter)"
+mkdir -p output/test28_portrait output/test28_landscape
+../bin/batchMedia -inputdir input/oriented -out output/test28_portrait -size 0.5 -ignore-smart-limit -orientation-filter portrait
+../bin/batchMedia -inputdir input/oriented -out output/test28_landscape -size 0.5 -ignore-smart-limit -orientation-filter landscape
+verify_image_resolution "output/test28_portrait/rotated_exif.png" "240" "320" "测试28-纵向图片被处理"
+if cmp -s "input/oriented/rotated_exif.png" "output/test28_landscape/rotated_exif.png"; then
+    echo -e "${GREEN}✓ 测试28-只处理横向时纵向图片原样复制${NC}"
+else
+    echo -e "${RED}✗ 测试28-只处理横向时纵向图片应原样复制${NC}"
+fi
+echo "✓ 测试28执行完成"
+echo
+
+# 测试29: 最小分辨率过滤，与缩放方向和智能阈值无关
+echo "测试29: 最小分辨率过滤 (min-resolution=1280x720)"
+mkdir -p input/minres output/test29
+cp input/images/small_hd.jpg input/images/small_vga.png input/minres/
+../bin/batchMedia -inputdir input/minres -out output/test29 -size 0.5 -ignore-smart-limit -min-resolution 1280x720 -json-report
+verify_image_resolution "output/test29/small_hd.jpg" "640" "360" "测试29-达到最小分辨率的图片被处理"
+if cmp -s "input/minres/small_vga.png" "output/test29/small_vga.png"; then
+    echo -e "${GREEN}✓ 测试29-小于最小分辨率的图片原样复制${NC}"
+else
+    echo -e "${RED}✗ 测试29-小于最小分辨率的图片应原样复制${NC}"
+fi
+if grep -q '"SkipReason": "640x480 smaller than -min-resolution 1280x720"' output/test29/report.json; then
+    echo -e "${GREEN}✓ 测试29-报告注明跳过原因${NC}"
+else
+    echo -e "${RED}✗ 测试29-报告缺少跳过原因${NC}"
+fi
+echo "✓ 测试29执行完成"
+echo
+
+# 测试30: 报告记录生效的阈值和跳过原因
+echo "测试30: JSON/HTML 报告中的设置与跳过原因"
+../bin/batchMedia -inputdir input/minres -out output/test30 -size 0.5 -threshold-width 1280 -threshold-height 720 -json-report
+if grep -q '"SkipReason": "width 640 below 1280px threshold"' output/test30/report.json && grep -q '"ThresholdWidth": 1280' output/test30/report.json; then
+    echo -e "${GREEN}✓ 测试30-JSON 报告包含阈值和跳过原因${NC}"
+else
+    echo -e "${RED}✗ 测试30-JSON 报告缺少阈值或跳过原因${NC}"
+fi
+if grep -q "<h2>Settings</h2>" output/test30/processing_report.html && grep -q "below 1280px threshold" output/test30/processing_report.html; then
+    echo -e "${GREEN}✓ 测试30-HTML 报告包含设置和跳过原因${NC}"
+else
+    echo -e "${RED}✗ 测试30-HTML 报告缺少设置或跳过原因${NC}"
+fi
+echo "✓ 测试30执行完成"
+echo
+
+# 测试31: 质量分析，报告记录 SSIM/PSNR，--min-ssim 需要 --quality-analysis
+echo "测试31: 质量分析 (SSIM/PSNR)"
+../bin/batchMedia -inputdir input/minres -out output/test31 -size 0.5 -ignore-smart-limit -quality-analysis -min-ssim 0.9 -json-report
+if grep -q '"SSIM": 0\.9' output/test31/report.json && grep -q "PSNR" output/test31/processing_report.html; then
+    echo -e "${GREEN}✓ 测试31-报告包含 SSIM/PSNR${NC}"
+else
+    echo -e "${RED}✗ 测试31-报告缺少 SSIM/PSNR${NC}"
+fi
+if ../bin/batchMedia -inputdir input/minres -out output/test31b -size 0.5 -min-ssim 0.9 2>/dev/null; then
+    echo -e "${RED}✗ 测试31-单独使用 --min-ssim 应报错${NC}"
+else
+    echo -e "${GREEN}✓ 测试31-单独使用 --min-ssim 被拒绝${NC}"
+fi
+echo "✓ 测试31执行完成"
+echo
+
+# 测试32: 输出写入 tar 归档，保留目录结构并包含报告
+echo "测试32: 归档输出 (-archive)"
+mkdir -p input/archive/sub
+cp input/images/small_hd.jpg input/archive/sub/
+../bin/batchMedia -inputdir input/archive -archive output/test32.tar -size 0.5 -ignore-smart-limit -json-report
+if tar tf output/test32.tar | grep -q "^sub/small_hd.jpg$" && tar tf output/test32.tar | grep -q "^report.json$" && tar tf output/test32.tar | grep -q "^sub/processing_report.html$"; then
+    echo -e "${GREEN}✓ 测试32-归档包含输出文件和报告${NC}"
+else
+    echo -e "${RED}✗ 测试32-归档缺少输出文件或报告${NC}"
+fi
+if tar tf output/test32.tar | grep -q "progress.json\|batchmedia.lock"; then
+    echo -e "${RED}✗ 测试32-归档不应包含进度文件或锁文件${NC}"
+else
+    echo -e "${GREEN}✓ 测试32-归档不含进度文件和锁文件${NC}"
+fi
+echo "✓ 测试32执行完成"
+echo
+
+# 测试33: HTTP 服务模式，上传图片返回缩放后的 JPEG
+echo "测试33: HTTP 服务模式 (-serve)"
+../bin/batchMedia -serve 127.0.0.1:18433 -width 320 &
+serve_pid=$!
+for _ in 1 2 3 4 5 6 7 8 9 10; do
+    curl -s http://127.0.0.1:18433/healthz >/dev/null && break
+    sleep 0.5
+done
+if curl -s -F file=@input/images/small_hd.jpg "http://127.0.0.1:18433/process" -o output/test33.jpg; then
+    verify_image_resolution "output/test33.jpg" "320" "180" "测试33-上传图片按默认宽度缩放"
+else
+    echo -e "${RED}✗ 测试33-请求失败${NC}"
+fi
+status=$(curl -s -o /dev/null -w "%{http_code}" --data-binary @input/images/small_hd.jpg "http://127.0.0.1:18433/process?name=a.gif")
+if [ "$status" = "415" ]; then
+    echo -e "${GREEN}✓ 测试33-不支持的格式返回 415${NC}"
+else
+    echo -e "${RED}✗ 测试33-不支持的格式应返回 415，实际 $status${NC}"
+fi
+kill $serve_pid 2>/dev/null
+wait $serve_pid 2>/dev/null
+echo "✓ 测试33执行完成"
+echo
+
+# 测试34: 监视模式，处理运行期间新增的文件
+echo "测试34: 监视模式 (-watch)"
+mkdir -p input/watch/album output/test34
+cp input/images/small_hd.jpg input/watch/album/first.jpg
+../bin/batchMedia -inputdir input/watch -out output/test34 -width 320 -ignore-smart-limit -watch -watch-settle 1s &
+watch_pid=$!
+sleep 2
+cp input/images/small_hd.jpg input/watch/album/second.jpg
+mkdir -p input/watch/later
+cp input/images/small_hd.jpg input/watch/later/third.jpg
+for _ in 1 2 3 4 5 6 7 8 9 10; do
+    [ -f output/test34/later/third.jpg ] && [ -f output/test34/album/second.jpg ] && break
+    sleep 0.5
+done
+kill -INT $watch_pid 2>/dev/null
+wait $watch_pid 2>/dev/null
+verify_image_resolution "output/test34/album/first.jpg" "320" "180" "测试34-已有文件"
+verify_image_resolution "output/test34/album/second.jpg" "320" "180" "测试34-监视期间新增的文件"
+verify_image_resolution "output/test34/later/third.jpg" "320" "180" "测试34-新建子目录中的文件"
+echo "✓ 测试34执行完成"
+echo
+
+# 测试35: 按宽高框缩放，验证 contain/cover/stretch 三种方式
+echo "测试35: 宽高框缩放 (-width -height -fit)"
+for fit in contain cover stretch; do
+    ../bin/batchMedia -inputdir input/minres -out output/test35_$fit -width 400 -height 400 -fit $fit -ignore-smart-limit
+done
+verify_image_resolution "output/test35_contain/small_hd.jpg" "400" "225" "测试35-contain 完整放入框内"
+verify_image_resolution "output/test35_cover/small_hd.jpg" "400" "400" "测试35-cover 填满并裁剪"
+verify_image_resolution "output/test35_stretch/small_hd.jpg" "400" "400" "测试35-stretch 拉伸"
+../bin/batchMedia -inputdir input/minres -out output/test35_height -height 360 -ignore-smart-limit
+verify_image_resolution "output/test35_height/small_hd.jpg" "640" "360" "测试35-仅指定高度"
+echo "✓ 测试35执行完成"
+echo
+
+# 测试36: 渐进式 JPEG，未启用 libjpeg 构建标签时回退为基线 JPEG
+echo "测试36: 渐进式 JPEG (-jpeg-progressive)"
+../bin/batchMedia -inputdir input/minres -out output/test36 -size 0.5 -ignore-smart-limit -jpeg-progressive -json-report
+verify_image_resolution "output/test36/small_hd.jpg" "640" "360" "测试36-渐进式 JPEG 输出"
+if grep -q '"JPEGMode": "progressive"' output/test36/report.json; then
+    echo -e "${GREEN}✓ 测试36-报告记录为渐进式 JPEG${NC}"
+elif grep -q '"JPEGMode": "baseline' output/test36/report.json; then
+    echo -e "${YELLOW}⚠ 测试36-未使用 -tags libjpeg 构建，已回退为基线 JPEG${NC}"
+else
+    echo -e "${RED}✗ 测试36-报告中缺少 JPEG 模式${NC}"
+fi
+echo "✓ 测试36执行完成"
+echo
+
+# 测试37: JPEG 无损优化，jpegtran 不存在时照常输出
+echo "测试37: JPEG 无损优化 (-jpeg-optimize)"
+../bin/batchMedia -inputdir input/minres -out output/test37 -size 0.5 -ignore-smart-limit -jpeg-optimize -json-report
+verify_image_resolution "output/test37/small_hd.jpg" "640" "360" "测试37-优化后的 JPEG"
+if command -v jpegtran >/dev/null 2>&1; then
+    if grep -q 'optimized by jpegtran' output/test37/report.json; then
+        echo -e "${GREEN}✓ 测试37-报告记录 jpegtran 优化${NC}"
+    else
+        echo -e "${RED}✗ 测试37-报告中缺少优化记录${NC}"
+    fi
+else
+    echo -e "${YELLOW}⚠ 测试37-未找到 jpegtran，已按未优化输出${NC}"
+fi
+echo "✓ 测试37执行完成"
+echo
+
+# 测试38: 无法解码的图片原样复制
+echo "测试38: 解码失败时复制原文件 (-keep-going-on-decode-error)"
+mkdir -p input/undecodable output/test38
+cp input/images/small_hd.jpg input/undecodable/
+head -c 500 input/images/small_hd.jpg > input/undecodable/truncated.jpg
+echo "not an image" > input/undecodable/text.png
+if ../bin/batchMedia -inputdir input/undecodable -out output/test38 -size 0.5 -ignore-smart-limit -keep-going-on-decode-error -json-report; then
+    echo -e "${GREEN}✓ 测试38-无法解码的图片不计为失败${NC}"
+else
+    echo -e "${RED}✗ 测试38-运行应成功完成${NC}"
+fi
+if cmp -s input/undecodable/truncated.jpg output/test38/truncated.jpg && cmp -s input/undecodable/text.png output/test38/text.png; then
+    echo -e "${GREEN}✓ 测试38-无法解码的图片已原样复制${NC}"
+else
+    echo -e "${RED}✗ 测试38-无法解码的图片应原样复制${NC}"
+fi
+if grep -q '"Note": "not decodable' output/test38/report.json; then
+    echo -e "${GREEN}✓ 测试38-报告记录解码错误${NC}"
+else
+    echo -e "${RED}✗ 测试38-报告中缺少解码错误说明${NC}"
+fi
+verify_image_resolution "output/test38/small_hd.jpg" "640" "360" "测试38-正常图片照常处理"
+echo "✓ 测试38执行完成"
+echo
+
+# 测试39: 按内容识别图片格式
+echo "测试39: 扩展名与内容不符的图片按内容解码"
+mkdir -p input/misnamed output/test39
+cp input/images/small_vga.png input/misnamed/png_content.jpg
+cp input/images/small_hd.jpg input/misnamed/jpeg_content.png
+if ../bin/batchMedia -inputdir input/misnamed -out output/test39 -size 0.5 -ignore-smart-limit; then
+    echo -e "${GREEN}✓ 测试39-扩展名错误的图片处理成功${NC}"
+else
+    echo -e "${RED}✗ 测试39-扩展名错误的图片应能处理${NC}"
+fi
+verify_image_resolution "output/test39/png_content.jpg" "320" "240" "测试39-PNG内容按PNG解码"
+verify_image_resolution "output/test39/jpeg_content.png" "640" "360" "测试39-JPEG内容按JPEG解码"
+echo "✓ 测试39执行完成"
+echo
+
+# 测试40: 原图与重新编码结果取较小者
+echo "测试40: 保留较小的文件 (-prefer-smaller)"
+mkdir -p output/test40_keep output/test40_resize
+if ../bin/batchMedia -inputdir input/images -out output/test40_keep -size 1 -ignore-smart-limit -adaptive-quality -quality-floor 100 -quality-ceiling 100 -prefer-smaller -ext jpg; then
+    if cmp -s input/images/small_hd.jpg output/test40_keep/small_hd.jpg; then
+        echo -e "${GREEN}✓ 测试40-重新编码更大时保留原图${NC}"
+    else
+        echo -e "${RED}✗ 测试40-重新编码更大时应保留原图${NC}"
+    fi
+else
+    echo -e "${RED}✗ 测试40-处理失败${NC}"
+fi
+if ../bin/batchMedia -inputdir input/images -out output/test40_resize -size 0.5 -ignore-smart-limit -adaptive-quality -quality-floor 100 -quality-ceiling 100 -prefer-smaller -ext jpg; then
+    verify_image_resolution "output/test40_resize/small_hd.jpg" "640" "360" "测试40-需要缩放时总是写入缩放结果"
+else
+    echo -e "${RED}✗ 测试40-缩放处理失败${NC}"
+fi
+echo "✓ 测试40执行完成"
+echo
+
+# 测试41: 按模板命名输出
+echo "测试41: 输出文件名模板 (-output-template)"
+mkdir -p input/template/a input/template/b output/test41
+cp input/images/small_hd.jpg input/template/a/photo.jpg
+cp input/images/small_hd.jpg input/template/b/photo.jpg
+if ../bin/batchMedia -inputdir input/template -out output/test41 -size 0.5 -ignore-smart-limit -multithread 1 -output-template "{index}_{name}_{width}x{height}"; then
+    echo -e "${GREEN}✓ 测试41-模板命名处理成功${NC}"
+else
+    echo -e "${RED}✗ 测试41-模板命名处理失败${NC}"
+fi
+verify_image_resolution "output/test41/1_photo_640x360.jpg" "640" "360" "测试41-按模板命名"
+verify_image_resolution "output/test41/1_photo_640x360_2.jpg" "640" "360" "测试41-同名输出追加序号"
+if ../bin/batchMedia -inputdir input/template -out output/test41_bad -size 0.5 -output-template "{unknown}" 2>/dev/null; then
+    echo -e "${RED}✗ 测试41-未知占位符应报错${NC}"
+else
+    echo -e "${GREEN}✓ 测试41-未知占位符被拒绝${NC}"
+fi
+echo "✓ 测试41执行完成"
+echo
+
+# 测试42: 按扩展名覆盖编码设置
+echo "测试42: 按扩展名的设置 (-ext-settings)"
+mkdir -p input/extsettings output/test42
+cp input/images/small_hd.jpg input/images/small_vga.png input/extsettings/
+if ../bin/batchMedia -inputdir input/extsettings -out output/test42 -size 0.5 -ignore-smart-limit -ext-settings "png:format=keep,jpg:quality=60"; then
+    echo -e "${GREEN}✓ 测试42-按扩展名设置处理成功${NC}"
+else
+    echo -e "${RED}✗ 测试42-按扩展名设置处理失败${NC}"
+fi
+if [ "$(head -c 4 output/test42/small_vga.png | tail -c 3)" = "PNG" ]; then
+    echo -e "${GREEN}✓ 测试42-PNG保持PNG格式${NC}"
+else
+    echo -e "${RED}✗ 测试42-PNG应保持PNG格式${NC}"
+fi
+verify_image_resolution "output/test42/small_hd.jpg" "640" "360" "测试42-JPEG按扩展名质量编码"
+if ../bin/batchMedia -inputdir input/extsettings -out output/test42_bad -size 0.5 -ext-settings "gif:quality=50" 2>/dev/null; then
+    echo -e "${RED}✗ 测试42-不支持的扩展名应报错${NC}"
+else
+    echo -e "${GREEN}✓ 测试42-不支持的扩展名被拒绝${NC}"
+fi
+echo "✓ 测试42执行完成"
+echo
+
+# 测试43: 自动线程数
+echo "测试43: 按CPU核心数自动选择线程数 (-multithread 0)"
+mkdir -p output/test43
+if ../bin/batchMedia -inputdir input/images -out output/test43 -size 0.5 -ignore-smart-limit -multithread 0 -video-jobs 1; then
+    echo -e "${GREEN}✓ 测试43-自动线程数处理成功${NC}"
+else
+    echo -e "${RED}✗ 测试43-自动线程数处理失败${NC}"
+fi
+verify_image_resolution "output/test43/small_hd.jpg" "640" "360" "测试43-自动线程数"
+if ../bin/batchMedia -inputdir input/images -out output/test43_bad -size 0.5 -video-jobs -1 2>/dev/null; then
+    echo -e "${RED}✗ 测试43-负数的视频并发数应报错${NC}"
+else
+    echo -e "${GREEN}✓ 测试43-负数的视频并发数被拒绝${NC}"
+fi
+echo "✓ 测试43执行完成"
+echo
+
+# 测试44: ffmpeg线程数
+echo "测试44: 限制每个视频编码的线程数 (-video-threads)"
+if command -v ffmpeg >/dev/null 2>&1 && [ -f "input/videos/test_video.mp4" ]; then
+    mkdir -p output/test44 output/test44_h264
+    # libx265 的线程数经 x265-params pools 传入，其他编码器用 -threads
+    if ../bin/batchMedia -inputdir input/videos -out output/test44 -size 0.5 -ignore-smart-limit -video-jobs 2 -video-threads 2; then
+        verify_video_resolution "output/test44/test_video.mp4" "960" "540" "测试44-限制线程数的 HEVC 编码"
+    else
+        echo -e "${RED}✗ 测试44-指定视频线程数编码失败${NC}"
+    fi
+    if ../bin/batchMedia -inputdir input/videos -out output/test44_h264 -size 0.5 -ignore-smart-limit -video-codec libx264 -video-threads 1; then
+        verify_video_resolution "output/test44_h264/test_video.mp4" "960" "540" "测试44-限制线程数的 H.264 编码"
+    else
+        echo -e "${RED}✗ 测试44-指定视频线程数的 H.264 编码失败${NC}"
+    fi
+else
+    echo "⚠ FFmpeg未安装或测试视频不存在，跳过测试44的编码"
+fi
+if ../bin/batchMedia -inputdir input/images -out output/test44_bad -size 0.5 -video-threads -1 2>/dev/null; then
+    echo -e "${RED}✗ 测试44-负数的视频线程数应报错${NC}"
+else
+    echo -e "${GREEN}✓ 测试44-负数的视频线程数被拒绝${NC}"
+fi
+echo "✓ 测试44执行完成"
+echo
+
+# 测试45: 音频声道
+echo "测试45: 重新编码音频时的声道 (-audio-channels)"
+if command -v ffmpeg >/dev/null 2>&1; then
+    mkdir -p input/surround output/test45_stereo output/test45_keep
+    # 5.1 声道的测试视频：正弦波经 pan 复制到 6 个声道
+    ffmpeg -f lavfi -i testsrc=duration=2:size=640x360:rate=1 \
+        -f lavfi -i "sine=frequency=440:duration=2,pan=5.1|c0=c0|c1=c0|c2=c0|c3=c0|c4=c0|c5=c0" \
+        -c:v libx264 -c:a aac -shortest input/surround/surround.mp4 -y >/dev/null 2>&1
+    if [ -f "input/surround/surround.mp4" ]; then
+        ../bin/batchMedia -inputdir input/surround -out output/test45_stereo -size 0.5 -ignore-smart-limit -audio-codec aac -audio-channels stereo
+        channels=$(ffprobe -v error -select_streams a:0 -show_entries stream=channels -of csv=p=0 output/test45_stereo/surround.mp4 2>/dev/null)
+        if [ "$channels" = "2" ]; then
+            echo -e "${GREEN}✓ 测试45-缩混为立体声: 2 声道${NC}"
+        else
+            echo -e "${RED}✗ 测试45-缩混后应为 2 声道，实际: ${channels}${NC}"
+        fi
+
+        ../bin/batchMedia -inputdir input/surround -out output/test45_keep -size 0.5 -ignore-smart-limit -audio-codec aac -audio-channels keep -json-report
+        channels=$(ffprobe -v error -select_streams a:0 -show_entries stream=channels -of csv=p=0 output/test45_keep/surround.mp4 2>/dev/null)
+        if [ "$channels" = "6" ]; then
+            echo -e "${GREEN}✓ 测试45-保留 5.1 声道: 6 声道${NC}"
+        else
+            echo -e "${RED}✗ 测试45-保留声道后应为 6 声道，实际: ${channels}${NC}"
+        fi
+        # 未指定 -audio-bitrate 时多声道按每声道 64k 计算，5.1 为 384k
+        if grep -q '"AudioHandling": "aac 384k 5.1"' output/test45_keep/report.json; then
+            echo -e "${GREEN}✓ 测试45-5.1 声道默认码率 384k${NC}"
+        else
+            echo -e "${RED}✗ 测试45-5.1 声道默认码率应为 384k${NC}"
+        fi
+    else
+        echo "✗ 测试45: 无法创建 5.1 声道测试视频"
+    fi
+else
+    echo "⚠ FFmpeg未安装，跳过测试45的编码"
+fi
+if ../bin/batchMedia -inputdir input/images -out output/test45_bad -size 0.5 -audio-codec aac -audio-channels copy 2>/dev/null; then
+    echo -e "${RED}✗ 测试45-copy 声道配合 aac 编码应报错${NC}"
+else
+    echo -e "${GREEN}✓ 测试45-copy 声道配合 aac 编码被拒绝${NC}"
+fi
+echo "✓ 测试45执行完成"
+echo
+
+# 测试46: 字幕轨和章节
+echo "测试46: 保留字幕轨和章节，或去除字幕轨 (-strip-subtitles)"
+if command -v ffmpeg >/dev/null 2>&1; then
+    mkdir -p input/subtitles output/test46 output/test46_strip
+    printf '1\n00:00:00,000 --> 00:00:01,500\nHello\n\n2\n00:00:01,500 --> 00:00:02,000\nWorld\n' > output/test46_subs.srt
+    printf ';FFMETADATA1\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=1000\ntitle=Intro\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=1000\nEND=2000\ntitle=Outro\n' > output/test46_chapters.txt
+    # 带 SRT 字幕和两个章节的 MKV，以及字幕为 mov_text 的 MP4
+    ffmpeg -f lavfi -i testsrc=duration=2:size=640x360:rate=1 -i output/test46_subs.srt -i output/test46_chapters.txt \
+        -map 0:v -map 1:s -map_chapters 2 -c:v libx264 -c:s srt input/subtitles/subtitled.mkv -y >/dev/null 2>&1
+    ffmpeg -f lavfi -i testsrc=duration=2:size=640x360:rate=1 -i output/test46_subs.srt -i output/test46_chapters.txt \
+        -map 0:v -map 1:s -map_chapters 2 -c:v libx264 -c:s mov_text input/subtitles/subtitled.mp4 -y >/dev/null 2>&1
+    if [ -f "input/subtitles/subtitled.mkv" ] && [ -f "input/subtitles/subtitled.mp4" ]; then
+        ../bin/batchMedia -inputdir input/subtitles -out output/test46 -size 0.5 -ignore-smart-limit
+        for check in "subtitled.mp4:mov_text" "subtitled.mkv:subrip"; do
+            file="output/test46/${check%%:*}"
+            expected="${check#*:}"
+            codec=$(ffprobe -v error -select_streams s -show_entries stream=codec_name -of csv=p=0 "$file" 2>/dev/null | head -1)
+            chapters=$(ffprobe -v error -show_chapters -of csv=p=0 "$file" 2>/dev/null | grep -c .)
+            if [ "$codec" = "$expected" ] && [ "$chapters" -eq 2 ]; then
+                echo -e "${GREEN}✓ 测试46-${file##*/} 保留了 $expected 字幕和 2 个章节${NC}"
+            else
+                echo -e "${RED}✗ 测试46-${file##*/} 应有 $expected 字幕和 2 个章节，实际字幕: ${codec:-无}，章节: $chapters${NC}"
+            fi
+        done
+
+        ../bin/batchMedia -inputdir input/subtitles -out output/test46_strip -size 0.5 -ignore-smart-limit -strip-subtitles
+        for file in output/test46_strip/subtitled.mp4 output/test46_strip/subtitled.mkv; do
+            subtitles=$(ffprobe -v error -select_streams s -show_entries stream=codec_name -of csv=p=0 "$file" 2>/dev/null | grep -c .)
+            chapters=$(ffprobe -v error -show_chapters -of csv=p=0 "$file" 2>/dev/null | grep -c .)
+            if [ -f "$file" ] && [ "$subtitles" -eq 0 ] && [ "$chapters" -eq 2 ]; then
+                echo -e "${GREEN}✓ 测试46-${file##*/} 去除了字幕，章节仍保留${NC}"
+            else
+                echo -e "${RED}✗ 测试46-${file##*/} 应没有字幕轨并保留章节，实际字幕轨: $subtitles，章节: $chapters${NC}"
+            fi
+        done
+    else
+        echo "✗ 测试46: 无法创建带字幕的测试视频"
+    fi
+else
+    echo "⚠ FFmpeg未安装，跳过测试46"
+fi
+echo "✓ 测试46执行完成"
+echo
+
+# 测试47: 竖拍视频的旋转元数据
+echo "测试47: 竖拍视频按旋转元数据转正 (-rotate-video)"
+if command -v ffmpeg >/dev/null 2>&1; then
+    mkdir -p input/portrait output/test47 output/test47_rotated
+    # 模拟 iPhone 竖拍：横向像素加 90 度旋转元数据（新版 ffmpeg 用 -display_rotation，旧版用 rotate 标签）
+    ffmpeg -display_rotation 90 -f lavfi -i testsrc=duration=2:size=1280x720:rate=1 -c:v libx264 input/portrait/portrait.mp4 -y >/dev/null 2>&1 ||
+        ffmpeg -f lavfi -i testsrc=duration=2:size=1280x720:rate=1 -c:v libx264 -metadata:s:v rotate=90 input/portrait/portrait.mp4 -y >/dev/null 2>&1
+    if [ -f "input/portrait/portrait.mp4" ]; then
+        ../bin/batchMedia -inputdir input/portrait -out output/test47 -size 0.5 -ignore-smart-limit
+        verify_video_resolution "output/test47/portrait.mp4" "360" "640" "测试47-竖拍视频按显示方向缩放"
+        ../bin/batchMedia -inputdir input/portrait -out output/test47_rotated -size 0.5 -ignore-smart-limit -rotate-video 90
+        verify_video_resolution "output/test47_rotated/portrait.mp4" "640" "360" "测试47-手动旋转90度"
+    else
+        echo "✗ 测试47: 无法创建竖拍测试视频"
+    fi
+    if ../bin/batchMedia -inputdir input/portrait -out output/test47_bad -size 0.5 -rotate-video 45 2>/dev/null; then
+        echo -e "${RED}✗ 测试47-非法旋转角度应报错${NC}"
+    else
+        echo -e "${GREEN}✓ 测试47-非法旋转角度被拒绝${NC}"
+    fi
+else
+    echo "⚠ FFmpeg未安装，跳过测试47"
+fi
+echo "✓ 测试47执行完成"
+echo
+
+# 测试48: 输出清单
+echo "测试48: 输出到源文件的清单 (-manifest)"
+mkdir -p output/test48
+if ../bin/batchMedia -inputdir input/images -out output/test48 -size 0.5 -ignore-smart-limit -manifest; then
+    if grep -q '"Source"' output/test48/manifest.json 2>/dev/null; then
+        echo -e "${GREEN}✓ 测试48-已生成 manifest.json${NC}"
+    else
+        echo -e "${RED}✗ 测试48-manifest.json 缺失或没有条目${NC}"
+    fi
+else
+    echo -e "${RED}✗ 测试48-生成清单运行失败${NC}"
+fi
+echo "✓ 测试48执行完成"
+echo
+
+# 测试49: 对比两次运行
+echo "测试49: 对比两次运行的报告 (-compare)"
+mkdir -p output/test49_q60 output/test49_q90
+../bin/batchMedia -inputdir input/images -out output/test49_q60 -size 0.5 -ignore-smart-limit -adaptive-quality -quality-floor 60 -quality-ceiling 60 -json-report >/dev/null 2>&1
+../bin/batchMedia -inputdir input/images -out output/test49_q90 -size 0.5 -ignore-smart-limit -adaptive-quality -quality-floor 90 -quality-ceiling 90 -json-report >/dev/null 2>&1
+if ../bin/batchMedia -compare output/test49_q60/report.json -out output/test49_q90 && [ -f output/test49_q90/compare_report.html ]; then
+    echo -e "${GREEN}✓ 测试49-已生成对比报告${NC}"
+else
+    echo -e "${RED}✗ 测试49-生成对比报告失败${NC}"
+fi
+echo "✓ 测试49执行完成"
+echo
+
+# 测试50: 报告缩略图
+echo "测试50: 生成缩略图并在报告中使用 (-thumbnails)"
+mkdir -p output/test50
+if ../bin/batchMedia -inputdir input/images -out output/test50 -size 0.5 -ignore-smart-limit -thumbnails 200x200; then
+    verify_image_resolution "output/test50/.thumbnails/large_4k.jpg.jpg" "200" "113" "测试50-缩略图放入200x200"
+    if grep -rq '.thumbnails/' output/test50 --include=processing_report.html; then
+        echo -e "${GREEN}✓ 测试50-报告使用缩略图${NC}"
+    else
+        echo -e "${RED}✗ 测试50-报告未使用缩略图${NC}"
+    fi
+else
+    echo -e "${RED}✗ 测试50-生成缩略图运行失败${NC}"
+fi
+echo "✓ 测试50执行完成"
+echo
+
+# 测试51: 内嵌缩略图
+echo "测试51: 报告内嵌 base64 缩略图 (-embed-thumbnails)"
+mkdir -p output/test51
+if ../bin/batchMedia -inputdir input/images -out output/test51 -size 0.5 -ignore-smart-limit -embed-thumbnails; then
+    if grep -rq 'src="data:image/jpeg;base64,' output/test51 --include=processing_report.html; then
+        echo -e "${GREEN}✓ 测试51-报告内嵌缩略图${NC}"
+    else
+        echo -e "${RED}✗ 测试51-报告未内嵌缩略图${NC}"
+    fi
+else
+    echo -e "${RED}✗ 测试51-内嵌缩略图运行失败${NC}"
+fi
+echo "✓ 测试51执行完成"
+echo
+
+# 测试52: 报告中的文件名转义
+echo "测试52: HTML 报告转义文件名中的 HTML"
+mkdir -p input/htmlescape output/test52
+cp input/images/large_4k.jpg 'input/htmlescape/a"><img src=x onerror=alert(1)>.jpg'
+if ../bin/batchMedia -inputdir input/htmlescape -out output/test52 -size 0.5 -ignore-smart-limit; then
+    if grep -q '<img src=x onerror' output/test52/processing_report.html; then
+        echo -e "${RED}✗ 测试52-报告中文件名未转义${NC}"
+    elif grep -q '&lt;img src=x onerror=alert(1)&gt;' output/test52/processing_report.html; then
+        echo -e "${GREEN}✓ 测试52-报告中文件名已转义${NC}"
+    else
+        echo -e "${RED}✗ 测试52-报告中缺少该文件${NC}"
+    fi
+else
+    echo -e "${RED}✗ 测试52-文件名转义运行失败${NC}"
+fi
+echo "✓ 测试52执行完成"
+echo
+
+# 测试53: HDR 传输特性识别
+echo "测试53: 只根据视频流的 color_transfer/color_primaries 区分 PQ、HLG 和 SDR"
+# ffprobe 换成按文件名返回 fixtures/ffprobe 中探测结果的脚本；ffmpeg 直接失败，只检查识别结果
+mkdir -p input/hdrprobe/videos input/hdrprobe/bin output/test53
+for name in hdr_pq hdr_hlg hlg_trip; do
+    : > "input/hdrprobe/videos/$name.mov"
+done
+cat > input/hdrprobe/bin/ffprobe <<EOF
+#!/bin/sh
+for last; do :; done
+name=\$(basename "\$last")
+cat "$(pwd)/fixtures/ffprobe/\${name%.*}.json"
+EOF
+printf '#!/bin/sh\nexit 1\n' > input/hdrprobe/bin/ffmpeg
+chmod +x input/hdrprobe/bin/ffprobe input/hdrprobe/bin/ffmpeg
+hdr_log=$(PATH="$(pwd)/input/hdrprobe/bin:$PATH" ../bin/batchMedia -inputdir input/hdrprobe/videos -out output/test53 -size 0.5 -ignore-smart-limit -log-level debug 2>&1 || true)
+if echo "$hdr_log" | grep -q "Processing HDR video (smpte2084): .*hdr_pq.mov"; then
+    echo -e "${GREEN}✓ 测试53-PQ 视频识别为 smpte2084${NC}"
+else
+    echo -e "${RED}✗ 测试53-PQ 视频识别错误${NC}"
+fi
+if echo "$hdr_log" | grep -q "Processing HDR video (arib-std-b67): .*hdr_hlg.mov"; then
+    echo -e "${GREEN}✓ 测试53-HLG 视频识别为 arib-std-b67${NC}"
+else
+    echo -e "${RED}✗ 测试53-HLG 视频识别错误${NC}"
+fi
+if echo "$hdr_log" | grep -q "Processing SDR video: .*hlg_trip.mov"; then
+    echo -e "${GREEN}✓ 测试53-文件名和标签含 HLG/bt2020 的 SDR 视频仍为 SDR${NC}"
+else
+    echo -e "${RED}✗ 测试53-SDR 视频被误判为 HDR${NC}"
+fi
+echo "✓ 测试53执行完成"
+echo
+
+# 测试54: 锐化前后的边缘对比度
+echo "测试54: -sharpen 开启时缩小后的边缘对比度提高，关闭时输出不变"
+../bin/batchMedia -inputdir input/edges -out output/test54_default -size 0.5 -ignore-smart-limit
+../bin/batchMedia -inputdir input/edges -out output/test54_off -size 0.5 -ignore-smart-limit -sharpen 0
+../bin/batchMedia -inputdir input/edges -out output/test54_on -size 0.5 -ignore-smart-limit -sharpen 1.0
+if cmp -s output/test54_default/stripes.png output/test54_off/stripes.png; then
+    echo -e "${GREEN}✓ 测试54-关闭锐化时输出与默认一致${NC}"
+else
+    echo -e "${RED}✗ 测试54-关闭锐化时输出与默认不同${NC}"
+fi
+contrast_off=$(go run create_test_images.go edge-contrast output/test54_off/stripes.png)
+contrast_on=$(go run create_test_images.go edge-contrast output/test54_on/stripes.png)
+if awk "BEGIN { exit !($contrast_on > $contrast_off) }"; then
+    echo -e "${GREEN}✓ 测试54-锐化后边缘对比度提高 ($contrast_off -> $contrast_on)${NC}"
+else
+    echo -e "${RED}✗ 测试54-锐化后边缘对比度未提高 ($contrast_off -> $contrast_on)${NC}"
+fi
+echo "✓ 测试54执行完成"
+echo
+
+# 测试55: 超过 260 字符的长路径（Windows 上经 \\?\ 长路径访问，另测 UNC 共享路径）
+echo "测试55: 长路径 (路径超过 260 字符)"
+long_rel="input/longpath"
+for part in 1 2 3 4 5; do
+    long_rel="$long_rel/$(printf "nested_album_%s_%048d" "$part" 0)"
+done
+mkdir -p "$long_rel" output/test55
+cp input/images/small_hd.jpg "$long_rel/"
+long_file="$(pwd)/$long_rel/small_hd.jpg"
+echo "输入文件路径长度: ${#long_file} 字符"
+if [ ${#long_file} -le 260 ]; then
+    echo -e "${RED}✗ 测试55-测试路径未超过 260 字符${NC}"
+fi
+../bin/batchMedia -inputdir input/longpath -out output/test55 -size 0.5 -ignore-smart-limit
+verify_image_resolution "output/test55/${long_rel#input/longpath/}/small_hd.jpg" "640" "360" "测试55-长路径下的图片被处理"
+case "$(uname -s)" in
+    MINGW*|MSYS*|CYGWIN*)
+        # C:\path 对应的管理共享 \\localhost\C$\path，转换为 \\?\UNC\localhost\C$\path 访问
+        win_dir=$(cygpath -w "$(pwd)/input/longpath")
+        unc_dir="\\\\localhost\\${win_dir%%:*}\$${win_dir#*:}"
+        ../bin/batchMedia -inputdir "$unc_dir" -out output/test55_unc -size 0.5 -ignore-smart-limit
+        verify_image_resolution "output/test55_unc/${long_rel#input/longpath/}/small_hd.jpg" "640" "360" "测试55-UNC 共享路径下的图片被处理"
+        ;;
+    *)
+        echo -e "${YELLOW}⚠ 测试55-UNC 共享路径仅在 Windows 上测试${NC}"
+        ;;
+esac
+echo "✓ 测试55执行完成"
+echo
+
 # 显示测试结果统计和文件大小验证
 echo -e "${BLUE}=== 测试结果统计与文件大小验证 ===${NC}"
 echo
@@ -315,22 +1288,16 @@ echo "总输出文件数: $total_files"
 echo "总输出大小: $total_size"
 echo
 
-# 测试结果汇总
+# 测试结果汇总：列出本脚本中每个测试的标题（各测试的 ✓/✗ 和跳过原因见上方输出）
 echo "=== 测试结果汇总 ==="
-echo "✓ 测试1: 按比例缩放 (30%) - 验证分辨率缩放正确性"
-echo "✓ 测试2: 按宽度缩放 (800px) - 验证宽度固定缩放"
-echo "✓ 测试3: 宽度阈值过滤 (2000px) - 验证宽度阈值过滤"
-echo "✓ 测试4: 高度阈值过滤 (1200px) - 验证高度阈值过滤"
-echo "✓ 测试5: 双阈值过滤缩放 - 验证双重阈值过滤功能"
-echo "✓ 测试6: 文件类型过滤 - 验证扩展名过滤"
-echo "✓ 测试7: 假扫描模式 - 验证预览功能"
-echo "✓ 测试8: 多线程处理 - 验证并发处理能力"
-echo "✓ 测试9: 混合文件处理 - 验证多类型文件处理"
-echo "✓ 测试10: 图片放大测试 - 验证图片放大功能"
-if command -v ffmpeg >/dev/null 2>&1; then
-    echo "✓ 测试11: 视频处理 - 验证视频缩放功能"
-else
-    echo "⚠ 测试11: 视频处理 - 跳过(FFmpeg未安装)"
+test_count=$(grep -c '^# 测试[0-9][0-9]*:' "${BASH_SOURCE[0]}")
+grep '^# 测试[0-9][0-9]*:' "${BASH_SOURCE[0]}" | sed 's/^# /• /'
+echo "共 $test_count 个测试"
+if ! command -v ffmpeg >/dev/null 2>&1; then
+    echo -e "${YELLOW}⚠ FFmpeg未安装，视频相关测试已跳过${NC}"
+fi
+if ! command -v heif-enc >/dev/null 2>&1; then
+    echo -e "${YELLOW}⚠ heif-enc未安装，HEIC 相关测试已跳过${NC}"
 fi
 echo
 
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"batchMedia/pkg/media"
+)
+
+// thumbnailDir is the subdirectory of the output directory -thumbnails mirrors the outputs into
+const thumbnailDir = ".thumbnails"
+
+// thumbnailQuality is the JPEG quality of -thumbnails previews, which are only looked at small
+const thumbnailQuality = 80
+
+// Thumbnail box parsed from -thumbnails (0x0 = no thumbnails)
+var thumbnailWidth, thumbnailHeight int
+
+// -embed-thumbnails previews fit embedThumbnailSize square and take at most
+// embedThumbnailMaxBytes before base64, so a report of hundreds of photos stays a few MB
+const embedThumbnailSize = 240
+const embedThumbnailMaxBytes = 16 << 10
+
+// embedThumbnailQualities are tried in turn until a preview fits embedThumbnailMaxBytes
+var embedThumbnailQualities = []int{75, 60, 45, 30}
+
+// writePreviews writes the -thumbnails file and encodes the -embed-thumbnails data URI of an
+// output from its image in memory, returning FileInfo's ThumbnailPath and EmbeddedThumbnail
+func writePreviews(img image.Image, outputPath string) (string, string) {
+	if img == nil {
+		return "", ""
+	}
+	var embedded string
+	if config.EmbedThumbnails {
+		embedded = embedThumbnail(img)
+	}
+	return writeThumbnail(img, outputPath), embedded
+}
+
+// shrinkToFit scales img down to fit a box, leaving smaller images as they are
+func shrinkToFit(img image.Image, boxWidth, boxHeight int) image.Image {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if width <= boxWidth && height <= boxHeight {
+		return img
+	}
+	width, height = media.FitSize(width, height, boxWidth, boxHeight, "contain")
+	return media.Resize(img, width, height)
+}
+
+// embedThumbnail encodes a small JPEG of img as a data URI for the reports, lowering the
+// quality until it fits embedThumbnailMaxBytes; empty when even the lowest quality doesn't
+// fit, and the report then links the image file as usual
+func embedThumbnail(img image.Image) string {
+	img = shrinkToFit(img, embedThumbnailSize, embedThumbnailSize)
+	for _, quality := range embedThumbnailQualities {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			logWarn("failed to encode embedded thumbnail: %v", err)
+			return ""
+		}
+		if buf.Len() <= embedThumbnailMaxBytes {
+			return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+		}
+	}
+	return ""
+}
+
+// embedThumbnailFile embeds a JPEG already on disk, such as a video's poster frame
+func embedThumbnailFile(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	img, err := jpeg.Decode(file)
+	if err != nil {
+		logWarn("failed to read %s for an embedded thumbnail: %v", path, err)
+		return ""
+	}
+	return embedThumbnail(img)
+}
+
+// writeThumbnail writes a JPEG fitting the -thumbnails box from an image already in memory,
+// so no output is decoded again. It goes to .thumbnails in the output directory under the
+// output's relative path with .jpg appended (photo.png -> .thumbnails/photo.png.jpg, so
+// photo.jpg can't collide with it). The returned path is relative to the output directory,
+// empty when -thumbnails is off or writing failed, which only costs the report its preview.
+func writeThumbnail(img image.Image, outputPath string) string {
+	if thumbnailWidth == 0 {
+		return ""
+	}
+	rel, err := filepath.Rel(config.OutputDir, outputPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	rel = filepath.Join(thumbnailDir, rel+".jpg")
+
+	// Images already inside the box are written as they are, never enlarged
+	img = shrinkToFit(img, thumbnailWidth, thumbnailHeight)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		logWarn("failed to encode thumbnail of %s: %v", outputPath, err)
+		return ""
+	}
+
+	thumbPath := filepath.Join(config.OutputDir, rel)
+	if err := os.MkdirAll(filepath.Dir(thumbPath), 0755); err != nil {
+		logWarn("failed to create thumbnail directory for %s: %v", outputPath, err)
+		return ""
+	}
+	if err := writeFileAtomic(thumbPath, buf.Bytes()); err != nil {
+		logWarn("failed to write thumbnail of %s: %v", outputPath, err)
+		return ""
+	}
+	return rel
+}
+
+// reportImageSource returns the src of a file card's preview relative to the report in
+// reportDir: the -embed-thumbnails data URI, the -thumbnails preview or video poster, else the
+// output itself (outputSrc)
+func reportImageSource(file FileInfo, reportDir, outputSrc string) string {
+	if file.EmbeddedThumbnail != "" {
+		return file.EmbeddedThumbnail
+	}
+	if file.ThumbnailPath == "" {
+		return outputSrc
+	}
+	if rel, err := filepath.Rel(reportDir, file.ThumbnailPath); err == nil {
+		return rel
+	}
+	return file.ThumbnailPath
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// perFileTimeout is the parsed -per-file-timeout, 0 when files may take as long as they need
+var perFileTimeout time.Duration
+
+// videoTimeout is the parsed -video-timeout, 0 when videos are only bound by -per-file-timeout
+var videoTimeout time.Duration
+
+// parsePerFileTimeout sets perFileTimeout from -per-file-timeout
+func parsePerFileTimeout() error {
+	if config.PerFileTimeout == "" {
+		return nil
+	}
+	timeout, err := time.ParseDuration(config.PerFileTimeout)
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("--per-file-timeout must be a positive duration such as 90s or 10m")
+	}
+	perFileTimeout = timeout
+	return nil
+}
+
+// fileContext derives the context one file is processed under, bounded by -per-file-timeout
+func fileContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if perFileTimeout > 0 {
+		return context.WithTimeoutCause(ctx, perFileTimeout, fmt.Errorf("timed out after %s (--per-file-timeout)", perFileTimeout))
+	}
+	return context.WithCancel(ctx)
+}
+
+// videoContext bounds one video's probing and encoding by -video-timeout, on top of ctx
+func videoContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if videoTimeout > 0 {
+		return context.WithTimeoutCause(ctx, videoTimeout, fmt.Errorf("video timed out after %s (--video-timeout), ffmpeg was stopped and the video skipped", videoTimeout))
+	}
+	return context.WithCancel(ctx)
+}
+
+// contextError returns nil while ctx is live, otherwise an error saying why processing stopped:
+// which timeout ran out, or that the run was canceled. Subprocesses killed by the context fail
+// with "signal: killed"; callers report this instead.
+func contextError(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	cause := context.Cause(ctx)
+	switch {
+	case errors.Is(cause, context.Canceled):
+		return fmt.Errorf("processing canceled")
+	case errors.Is(cause, context.DeadlineExceeded):
+		return fmt.Errorf("timed out")
+	default:
+		return cause
+	}
+}
+
+// mediaError returns err from a pkg/media pipeline, with the bare context error of a stopped
+// pipeline replaced by contextError's reason
+func mediaError(ctx context.Context, err error) error {
+	if ctxErr := contextError(ctx); ctxErr != nil && errors.Is(err, ctx.Err()) {
+		return ctxErr
+	}
+	return err
+}
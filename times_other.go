@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// fileAccessTime falls back to the modification time where atime isn't exposed
+func fileAccessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
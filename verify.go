@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+
+	"batchMedia/pkg/media"
+)
+
+// verifyImageOutput re-reads a written image (-verify-output) and checks it decodes to the
+// expected size. The pixels are decoded, not just the header, since a file truncated after
+// its header still passes image.DecodeConfig.
+func verifyImageOutput(path string, width, height int) error {
+	if !config.VerifyOutput {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return outputVerificationFailed(path, err)
+	}
+	defer file.Close()
+
+	img, err := decodeOutputImage(file, path)
+	if err != nil {
+		return outputVerificationFailed(path, err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != width || bounds.Dy() != height {
+		return outputVerificationFailed(path, fmt.Errorf("decoded as %dx%d, expected %dx%d", bounds.Dx(), bounds.Dy(), width, height))
+	}
+	return nil
+}
+
+// decodeOutputImage decodes an output image in the format its path was encoded with
+func decodeOutputImage(r io.Reader, path string) (image.Image, error) {
+	switch outputImageFormat(path) {
+	case "png":
+		return png.Decode(r)
+	case "heic":
+		return media.DecodeHEIC(r)
+	default:
+		return jpeg.Decode(r)
+	}
+}
+
+// verifyVideoOutput checks the probe of a written video (-verify-output): ffprobe must find a
+// video stream and a duration, which a truncated MP4 without its index lacks
+func verifyVideoOutput(ctx context.Context, path string, probe *media.Probe, probeErr error) error {
+	if !config.VerifyOutput {
+		return nil
+	}
+	err := probeErr
+	if err == nil {
+		_, _, err = probe.Resolution()
+	}
+	if err == nil {
+		_, err = probe.Duration()
+	}
+	if err != nil {
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			return ctxErr
+		}
+		return outputVerificationFailed(path, err)
+	}
+	return nil
+}
+
+// outputVerificationError reports an output that was written but failed -verify-output
+type outputVerificationError struct {
+	path    string
+	deleted bool
+	err     error
+}
+
+func (e *outputVerificationError) Error() string {
+	if e.deleted {
+		return fmt.Sprintf("output verification failed, deleted %s: %v", e.path, e.err)
+	}
+	return fmt.Sprintf("output verification failed for %s: %v", e.path, e.err)
+}
+
+// outputVerificationFailed removes the invalid output when -verify-output-delete is set and
+// returns the error the file is reported with
+func outputVerificationFailed(path string, err error) error {
+	verifyErr := &outputVerificationError{path: path, err: err}
+	if config.VerifyOutputDelete {
+		if removeErr := os.Remove(path); removeErr != nil && !os.IsNotExist(removeErr) {
+			logWarn("failed to delete invalid output %s: %v", path, removeErr)
+		} else {
+			verifyErr.deleted = true
+		}
+	}
+	return verifyErr
+}
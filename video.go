@@ -1,20 +1,36 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"batchMedia/pkg/media"
 	ffmpeg "github.com/u2takey/ffmpeg-go"
 )
 
+// videoExtensions lists the video formats transcoded with ffmpeg
+var videoExtensions = []string{".mp4", ".avi", ".mkv", ".mov", ".wmv", ".flv", ".webm", ".m4v",
+	".3gp", ".mts", ".m2ts", ".ts", ".mpg", ".mpeg", ".vob"}
+
+// legacyVideoContainers are camcorder, phone and DVD containers (MPEG program and transport
+// streams, 3GP) that can't hold, or that players don't expect, H.264/HEVC with the hvc1 tag.
+// Their outputs are written as .mp4.
+var legacyVideoContainers = map[string]bool{
+	".3gp": true, ".mts": true, ".m2ts": true, ".ts": true, ".mpg": true, ".mpeg": true, ".vob": true,
+}
+
 // isVideoFile checks if the file is a supported video format
 func isVideoFile(filename string) bool {
 	ext := strings.ToLower(filepath.Ext(filename))
-	supportedFormats := []string{".mp4", ".avi", ".mkv", ".mov", ".wmv", ".flv", ".webm", ".m4v"}
-	
-	for _, format := range supportedFormats {
+	for _, format := range videoExtensions {
 		if ext == format {
 			return true
 		}
@@ -22,197 +38,128 @@ func isVideoFile(filename string) bool {
 	return false
 }
 
-// shouldSkipVideo checks if video should be skipped based on resolution thresholds
-func shouldSkipVideo(width, height int) bool {
+// shouldSkipVideo checks if video should be skipped based on resolution thresholds and
+// returns the reason recorded in the reports, or an empty string to process it
+func shouldSkipVideo(width, height int) string {
 	if config.IgnoreSmartLimit {
-		return false
+		return ""
 	}
 
 	// Check if video exceeds threshold (should be skipped)
 	if config.ThresholdWidth > 0 && width > config.ThresholdWidth {
-		return true
+		return fmt.Sprintf("width %d above %dpx threshold", width, config.ThresholdWidth)
 	}
 	if config.ThresholdHeight > 0 && height > config.ThresholdHeight {
-		return true
+		return fmt.Sprintf("height %d above %dpx threshold", height, config.ThresholdHeight)
 	}
-	return false
+	return ""
 }
 
-// getVideoResolution gets the resolution of a video file using ffprobe
-func getVideoResolution(inputPath string) (int, int, error) {
-	// Use ffprobe to get video information
-	probe, err := ffmpeg.Probe(inputPath)
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to probe video file: %v", err)
+// errVideoSkipped stops media.ProcessVideo when the Inspect hook leaves a video to be copied
+var errVideoSkipped = errors.New("video skipped")
+
+// videoOptions returns the media.VideoOptions of the -video-* and size flags, with the output
+// written through outputWriter
+func videoOptions() media.VideoOptions {
+	return media.VideoOptions{
+		Codec:        config.VideoCodec,
+		CRF:          config.VideoCRF,
+		Bitrate:      config.VideoBitrate,
+		Preset:       config.VideoPreset,
+		AudioCodec:   config.AudioCodec,
+		Resolution:   config.VideoResolution,
+		ScalingRatio: config.ScalingRatio,
+		Width:        config.Width,
+		Height:       config.Height,
+		Fit:          config.Fit,
+		ScaleFlags:   config.VideoScaleFlags,
+		Rotate:       config.RotateVideo,
+		Writer:       outputWriter(),
 	}
-	
-	// Parse probe result to extract width and height
-	// This is a simplified implementation - in practice you'd parse the JSON output
-	// For now, return default values to avoid compilation errors
-	_ = probe // Use probe variable to avoid unused variable error
-	return 1920, 1080, nil
 }
 
-// processVideo processes a single video file using FFmpeg
-func processVideo(inputPath, outputPath string, info os.FileInfo, dirStats *DirectoryStats) error {
-	// Get video resolution for threshold checking
-	originalWidth, originalHeight, err := getVideoResolution(inputPath)
-	if err != nil {
-		fmt.Printf("Warning: Could not get video resolution for %s, proceeding with processing\n", inputPath)
-		originalWidth = 1920 // Default values
-		originalHeight = 1080
-	}
-
-	// Check if video should be skipped based on resolution thresholds
-	if shouldSkipVideo(originalWidth, originalHeight) {
-		fmt.Printf("Skipping video (resolution %dx%d exceeds threshold): %s (size: %d bytes)\n", 
-			originalWidth, originalHeight, inputPath, info.Size())
-		statsMutex.Lock()
-		stats.SkippedImages++ // Using same counter for videos
-		stats.TotalOutputSize += info.Size()
-		statsMutex.Unlock()
-		
-		// Record file info
-		statsMutex.Lock()
-		stats.Files = append(stats.Files, FileInfo{
-			Path:             filepath.Base(inputPath),
-			Type:             "skipped",
-			InputSize:        info.Size(),
-			OutputSize:       info.Size(),
-			OriginalDim:      fmt.Sprintf("%dx%d", originalWidth, originalHeight),
-			NewDim:           fmt.Sprintf("%dx%d", originalWidth, originalHeight),
-			CompressionRatio: 1.0,
-		})
-		statsMutex.Unlock()
-		
-		// Copy original file
-		return copyFile(inputPath, outputPath, info)
-	}
-
-	// Ensure output directory exists
-	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
-	}
-
-	// Calculate new dimensions based on same logic as images
-	newWidth := originalWidth
-	newHeight := originalHeight
-	var scaleFilter string
-	
-	// Add resolution scaling if specified
-	if config.VideoResolution != "" {
-		scaleFilter = config.VideoResolution
-	} else if config.ScalingRatio > 0 {
-		// Use scaling ratio
-		newWidth = int(float64(originalWidth) * config.ScalingRatio)
-		newHeight = int(float64(originalHeight) * config.ScalingRatio)
-		scaleFilter = fmt.Sprintf("%d:%d", newWidth, newHeight)
-	} else if config.Width > 0 {
-		// Scale by width, maintain aspect ratio
-		newWidth = config.Width
-		newHeight = int(float64(originalHeight) * float64(config.Width) / float64(originalWidth))
-		scaleFilter = fmt.Sprintf("%d:-1", config.Width)
-	}
-
-	// Build FFmpeg arguments using filter_complex and proper mapping
-	input := ffmpeg.Input(inputPath)
-	var output *ffmpeg.Stream
-	
-	// Use filter_complex for video scaling
-	if scaleFilter != "" {
-		// Apply scale filter using filter_complex
-		output = input.Video().Filter("scale", ffmpeg.Args{scaleFilter})
-	} else {
-		// No scaling, use original video stream
-		output = input.Video()
-	}
+// processVideo processes a single video file with media.ProcessVideo, whose hooks add the
+// skip check, caption, hardware and two-pass encoding and the audio and subtitle handling
+func processVideo(ctx context.Context, inputPath, outputPath string, info os.FileInfo, dirStats *DirectoryStats) error {
+	// Report paths are relative to the input directory in every branch
+	relPath, _ := filepath.Rel(config.InputDir, inputPath)
 
-	// Check if input video is HDR
-	isHDR := isHDRVideo(inputPath)
-	
-	// Apply video encoding options based on HDR detection
-	var kwargs ffmpeg.KwArgs
-	
-	if isHDR {
-		// HDR video encoding parameters
-		kwargs = ffmpeg.KwArgs{
-			"c:v": config.VideoCodec,
-			"preset": config.VideoPreset,
-			"crf": fmt.Sprintf("%d", config.VideoCRF),
-			"profile:v": "main10",
-			"pix_fmt": "yuv420p10le",
-			"tag:v": "hvc1",
-			"color_primaries": "bt2020",
-			"color_trc": "smpte2084",
-			"colorspace": "bt2020nc",
-			"x265-params": "hdr-opt=1:repeat-headers=1:colorprim=bt2020:transfer=smpte2084:colormatrix=bt2020nc",
-			"level": "5.1",
-			"progress": "pipe:1",
-			"stats": "",
-			"map_metadata": "0",
+	// -video-timeout kills ffmpeg on pathological inputs; the temp output is removed with it
+	ctx, cancel := videoContext(ctx)
+	defer cancel()
+
+	var (
+		probe                         *media.Probe
+		originalWidth, originalHeight int
+		originalDim, skipReason       string
+		audioHandling, subtitleNote   string
+	)
+	opts := videoOptions()
+
+	// The resolution, HDR and audio checks below all read the one ffprobe output
+	opts.Inspect = func(p *media.Probe) error {
+		probe = p
+		var err error
+		originalWidth, originalHeight, err = probe.Resolution()
+		originalDim = fmt.Sprintf("%dx%d", originalWidth, originalHeight)
+		if err != nil {
+			logWarn("could not get video resolution for %s, proceeding with processing", inputPath)
+			originalWidth = 1920 // Default values
+			originalHeight = 1080
+			originalDim = "" // Unknown, so the report doesn't show the assumed size
 		}
-		fmt.Printf("Processing HDR video: %s\n", inputPath)
-	} else {
-		// SDR video encoding parameters (standard rec709 colorspace)
-		kwargs = ffmpeg.KwArgs{
-			"c:v": config.VideoCodec,
-			"preset": config.VideoPreset,
-			"crf": fmt.Sprintf("%d", config.VideoCRF),
-			"profile:v": "main",
-			"pix_fmt": "yuv420p",
-			"tag:v": "hvc1",
-			"level": "4.0",
-			"progress": "pipe:1",
-			"stats": "",
-			"map_metadata": "0",
+
+		// Check if video should be skipped based on resolution thresholds
+		if reason := shouldSkipVideo(originalWidth, originalHeight); reason != "" {
+			logInfo("Skipping video (resolution %dx%d exceeds threshold, %s): %s (size: %d bytes)",
+				originalWidth, originalHeight, reason, inputPath, info.Size())
+			skipReason = reason
+			return errVideoSkipped
 		}
-		fmt.Printf("Processing SDR video: %s\n", inputPath)
+
+		// Ensure output directory exists
+		if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %v", err)
+		}
+		return nil
 	}
-	
-	// Apply user-specified bitrate if provided
-	if config.VideoBitrate != "" {
-		kwargs["b:v"] = config.VideoBitrate
-		delete(kwargs, "crf") // Remove CRF when using bitrate
+
+	// Burn in the -caption after scaling so its size follows the output
+	if captionEnabled() {
+		var fontPath string
+		defer func() {
+			if fontPath != "" {
+				os.Remove(fontPath)
+			}
+		}()
+		opts.Filter = func(video *ffmpeg.Stream, width, height int) (*ffmpeg.Stream, error) {
+			var err error
+			if fontPath, err = writeCaptionFont(); err != nil {
+				return nil, err
+			}
+			return video.Filter("drawtext", nil, drawtextKwargs(videoCaptionText(info, probe), fontPath, height)), nil
+		}
 	}
-	
-	// Handle audio stream
-	if hasAudioStream(inputPath) {
-		// Copy audio stream without re-encoding
-		kwargs["c:a"] = "copy"
-		fmt.Printf("Audio stream detected in %s, will preserve audio\n", inputPath)
-		
-		// Map both video and audio streams
-		err = ffmpeg.Output([]*ffmpeg.Stream{output, input.Audio()}, outputPath, kwargs).OverWriteOutput().Run()
-	} else {
-		// No audio stream, process video only
-		fmt.Printf("No audio stream detected in %s, processing video only\n", inputPath)
-		
-		// Map only video stream
-		err = output.Output(outputPath, kwargs).OverWriteOutput().Run()
+
+	opts.Encode = func(input, output *ffmpeg.Stream, kwargs ffmpeg.KwArgs, encodePath string) error {
+		var err error
+		audioHandling, subtitleNote, err = encodeVideo(ctx, input, output, kwargs, probe, inputPath, outputPath, encodePath)
+		return err
 	}
 
-	// Run FFmpeg command
+	result, err := media.ProcessVideo(ctx, opts, inputPath, outputPath)
+	if errors.Is(err, errVideoSkipped) {
+		return copySkippedVideo(inputPath, outputPath, relPath, info, dirStats, originalDim, skipReason)
+	}
 	if err != nil {
-		// If processing fails and video has audio, try with audio re-encoding
-		if hasAudioStream(inputPath) {
-			fmt.Printf("Warning: Audio copy failed for %s, trying with audio re-encoding...\n", inputPath)
-			
-			// Remove the failed output file
-			os.Remove(outputPath)
-			
-			// Retry with audio re-encoding
-			kwargs["c:a"] = "aac"
-			kwargs["b:a"] = "128k"
-			delete(kwargs, "map") // Remove mapping that might cause issues
-			
-			err = output.Output(outputPath, kwargs).OverWriteOutput().Run()
-			if err != nil {
-				return fmt.Errorf("failed to process video even with audio re-encoding: %v", err)
-			}
-			fmt.Printf("Successfully processed %s with audio re-encoding\n", inputPath)
-		} else {
-			return fmt.Errorf("failed to process video: %v", err)
-		}
+		return mediaError(ctx, err)
+	}
+	newWidth, newHeight := result.Width, result.Height
+
+	// Probe the output once; verification, the reported resolution and the poster all read it
+	outputProbe, outputProbeErr := media.ProbeVideo(ctx, outputPath)
+	if err := verifyVideoOutput(ctx, outputPath, outputProbe, outputProbeErr); err != nil {
+		return err
 	}
 
 	// Get output file info for statistics
@@ -224,17 +171,48 @@ func processVideo(inputPath, outputPath string, info os.FileInfo, dirStats *Dire
 	// Record statistics
 	outputSize := outputInfo.Size()
 	statsMutex.Lock()
-	stats.ProcessedImages++ // Using same counter for videos
+	stats.ProcessedVideos++
 	stats.TotalOutputSize += outputSize
-	dirStats.ProcessedImages++
+	dirStats.ProcessedVideos++
 	dirStats.TotalOutputSize += outputSize
 	statsMutex.Unlock()
 	
 	// Calculate compression ratio
 	compressionRatio := float64(outputSize) / float64(info.Size())
+
+	// Report the resolution ffmpeg actually produced, which confirms the scale filter took effect
+	var newDim string
+	if outputProbeErr != nil {
+		logWarn("could not get output video resolution for %s: %v", outputPath, outputProbeErr)
+	} else if finalWidth, finalHeight, err := outputProbe.Resolution(); err != nil {
+		logWarn("could not get output video resolution for %s: %v", outputPath, err)
+	} else {
+		newDim = fmt.Sprintf("%dx%d", finalWidth, finalHeight)
+		// Encoders round odd sizes to even ones, so allow a couple of pixels either way
+		if newWidth > 0 &&
+			(math.Abs(float64(finalWidth-newWidth)) > 2 || math.Abs(float64(finalHeight-newHeight)) > 2) {
+			logWarn("Video %s was encoded at %s, requested %dx%d", inputPath, newDim, newWidth, newHeight)
+		}
+	}
 	
-	// Get relative path for file info
-	relPath, _ := filepath.Rel(config.InputDir, inputPath)
+	// Grab a poster frame from the encoded output for the HTML report
+	var thumbnailPath, embeddedThumbnail string
+	if config.VideoThumbnails {
+		posterPath := videoPosterPath(outputPath)
+		err := outputProbeErr
+		if err == nil {
+			err = extractVideoPoster(ctx, outputPath, posterPath, outputProbe)
+		}
+		if err != nil {
+			logWarn("failed to extract poster frame for %s: %v", inputPath, err)
+		} else {
+			thumbnailPath, _ = filepath.Rel(config.OutputDir, posterPath)
+			if config.EmbedThumbnails {
+				embeddedThumbnail = embedThumbnailFile(posterPath)
+			}
+			logDebug("Poster frame saved: %s", posterPath)
+		}
+	}
 	
 	// Record file info
 	fileInfo := FileInfo{
@@ -243,67 +221,439 @@ func processVideo(inputPath, outputPath string, info os.FileInfo, dirStats *Dire
 		InputSize:        info.Size(),
 		OutputSize:       outputSize,
 		CompressionRatio: compressionRatio,
+		OriginalDim:      originalDim,
+		NewDim:           newDim,
+		AudioHandling:    audioHandling,
+		ThumbnailPath:    thumbnailPath,
+		EmbeddedThumbnail: embeddedThumbnail,
+		Note:             subtitleNote,
 	}
 	statsMutex.Lock()
 	stats.Files = append(stats.Files, fileInfo)
 	dirStats.Files = append(dirStats.Files, fileInfo)
+	recordManifest(fileInfo)
 	statsMutex.Unlock()
 
-	// Preserve original file modification time
-	if err := os.Chtimes(outputPath, info.ModTime(), info.ModTime()); err != nil {
+	// Preserve original file access and modification times
+	if err := preserveFileTimes(outputPath, info); err != nil {
 		return fmt.Errorf("failed to set file time: %v", err)
 	}
 
-	fmt.Printf("Video processing completed: %s (%d bytes -> %d bytes, ratio: %.2f)\n", 
+	logInfo("Video processing completed: %s (%d bytes -> %d bytes, ratio: %.2f)", 
 		inputPath, info.Size(), outputSize, compressionRatio)
 	return nil
 }
 
-// isHDRVideo checks if the video file is HDR format
-func isHDRVideo(inputPath string) bool {
-	probe, err := ffmpeg.Probe(inputPath)
-	if err != nil {
-		return false // Assume SDR if probe fails
+// copySkippedVideo copies a video left unprocessed by the thresholds to the output, keeping its
+// own container extension, and records it as skipped for the given reason
+func copySkippedVideo(inputPath, outputPath, relPath string, info os.FileInfo, dirStats *DirectoryStats, originalDim, reason string) error {
+	copyPath := strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + filepath.Ext(inputPath)
+	if err := copyFile(inputPath, copyPath, info); err != nil {
+		return err
 	}
+
+	statsMutex.Lock()
+	stats.SkippedVideos++
+	stats.TotalOutputSize += info.Size()
+	dirStats.SkippedVideos++
+	dirStats.TotalOutputSize += info.Size()
+	statsMutex.Unlock()
+
+	// Record file info
+	fileInfo := FileInfo{
+		Path:             relPath,
+		Type:             "skipped",
+		InputSize:        info.Size(),
+		OutputSize:       info.Size(),
+		OriginalDim:      originalDim,
+		NewDim:           originalDim,
+		CompressionRatio: 1.0,
+		Verification:     copyVerification(),
+		SkipReason:       reason,
+	}
+	statsMutex.Lock()
+	stats.Files = append(stats.Files, fileInfo)
+	dirStats.Files = append(dirStats.Files, fileInfo)
+	recordManifest(fileInfo)
+	statsMutex.Unlock()
+	return nil
+}
+
+// encodeVideo runs the ffmpeg encode of processVideo to encodePath: kwargs are the software
+// encoder options for the input's HDR transfer, to which the hardware encoder, two-pass, audio
+// and subtitle options are added. It returns the audio handling and subtitle note of the report.
+func encodeVideo(ctx context.Context, input, output *ffmpeg.Stream, kwargs ffmpeg.KwArgs, probe *media.Probe, inputPath, outputPath, encodePath string) (string, string, error) {
+	// Check if input video is HDR
+	hdrTransfer := probe.HDRTransfer()
+	isHDR := hdrTransfer != ""
 	
-	// Check for HDR indicators in the probe output
-	// HDR videos typically have:
-	// - color_primaries: bt2020
-	// - color_trc: smpte2084 (PQ) or arib-std-b67 (HLG)
-	// - colorspace: bt2020nc or bt2020c
-	probeStr := strings.ToLower(probe)
-	
-	// Check for HDR transfer characteristics
-	hasHDRTransfer := strings.Contains(probeStr, "smpte2084") || 
-					 strings.Contains(probeStr, "arib-std-b67") ||
-					 strings.Contains(probeStr, "smpte-st-2084") ||
-					 strings.Contains(probeStr, "hlg")
-	
-	// Check for wide color gamut
-	hasWideGamut := strings.Contains(probeStr, "bt2020")
-	
-	// Consider it HDR if it has both HDR transfer and wide gamut
-	return hasHDRTransfer && hasWideGamut
+	// Report encoding progress, and bound the encoder threads
+	kwargs["progress"] = "pipe:1"
+	kwargs["stats"] = ""
+	applyVideoThreads(kwargs)
+	if isHDR {
+		logDebug("Processing HDR video (%s): %s", hdrTransfer, inputPath)
+	} else {
+		logDebug("Processing SDR video: %s", inputPath)
+	}
+
+	// Swap in the hardware encoder and its quality parameter if requested
+	if config.VideoHWAccel != "none" {
+		if err := applyHWAccelArgs(kwargs, isHDR); err != nil {
+			return "", "", err
+		}
+		logDebug("Using hardware encoder %s for %s", kwargs["c:v"], inputPath)
+	}
+
+	// Run the analysis pass first when two-pass encoding is requested
+	// Hardware encoders do their own rate control, so two-pass is software-only
+	if config.VideoTwoPass && config.VideoBitrate != "" && config.VideoHWAccel == "none" {
+		passDir, err := os.MkdirTemp("", "batchMedia-2pass-")
+		if err != nil {
+			return "", "", fmt.Errorf("failed to create two-pass log directory: %v", err)
+		}
+		defer os.RemoveAll(passDir)
+		passLogFile := filepath.Join(passDir, "ffmpeg2pass")
+
+		logDebug("Running two-pass encoding (pass 1/2): %s", inputPath)
+		pass1Kwargs := ffmpeg.KwArgs{}
+		for k, v := range kwargs {
+			pass1Kwargs[k] = v
+		}
+		applyTwoPassArgs(pass1Kwargs, 1, passLogFile)
+		pass1Kwargs["an"] = ""
+		pass1Kwargs["f"] = "null"
+		if err := runFFmpeg(ctx, ffmpeg.OutputContext(ctx, []*ffmpeg.Stream{output}, os.DevNull, pass1Kwargs).OverWriteOutput(), "Pass 1 of "+inputPath); err != nil {
+			return "", "", fmt.Errorf("two-pass encoding failed in pass 1: %v", err)
+		}
+
+		logDebug("Running two-pass encoding (pass 2/2): %s", inputPath)
+		applyTwoPassArgs(kwargs, 2, passLogFile)
+	}
+
+	// Carry over the subtitle tracks the output container can hold, and the chapters
+	subtitles, subtitleNote := mapSubtitles(input, probe, kwargs, inputPath, outputPath)
+
+	// Handle audio stream
+	var err error
+	hasAudio := probe.HasAudio() && !config.AudioDisable
+	audioHandling := "none"
+	if hasAudio {
+		kwargs["c:a"] = config.AudioCodec
+		if config.AudioCodec == "copy" {
+			// Copy audio stream without re-encoding
+			audioHandling = "copy"
+			logDebug("Audio stream detected in %s, will preserve audio", inputPath)
+		} else {
+			channels := applyAudioChannels(kwargs, probe.AudioChannels())
+			// Surround needs more than ffmpeg's stereo-sized default to survive re-encoding
+			audioBitrate := config.AudioBitrate
+			if audioBitrate == "" && channels > 2 {
+				audioBitrate = defaultAudioBitrate(channels)
+			}
+			if audioBitrate != "" {
+				kwargs["b:a"] = audioBitrate
+			}
+			audioHandling = describeAudioEncoding(config.AudioCodec, audioBitrate, channels)
+			logDebug("Audio stream detected in %s, will transcode audio to %s", inputPath, audioHandling)
+		}
+
+		// Map both video and audio streams
+		err = runFFmpeg(ctx, ffmpeg.OutputContext(ctx, append([]*ffmpeg.Stream{output, input.Audio()}, subtitles...), encodePath, kwargs).OverWriteOutput(), "Encoding "+inputPath)
+	} else {
+		if config.AudioDisable {
+			audioHandling = "stripped"
+			logDebug("Audio disabled, stripping audio from %s", inputPath)
+		} else {
+			// No audio stream, process video only
+			logDebug("No audio stream detected in %s, processing video only", inputPath)
+		}
+
+		// Map only video stream
+		err = runFFmpeg(ctx, ffmpeg.OutputContext(ctx, append([]*ffmpeg.Stream{output}, subtitles...), encodePath, kwargs).OverWriteOutput(), "Encoding "+inputPath)
+	}
+
+	// Run FFmpeg command
+	if err != nil {
+		// If copying the audio failed, try again with audio re-encoding (a timeout is final)
+		if hasAudio && config.AudioCodec == "copy" && config.AudioChannels != "copy" && ctx.Err() == nil {
+			logWarn("Audio copy failed for %s, trying with audio re-encoding...", inputPath)
+
+			// Remove the failed output file
+			os.Remove(encodePath)
+
+			// Retry with audio re-encoding, keeping surround unless --audio-channels stereo
+			channels := applyAudioChannels(kwargs, probe.AudioChannels())
+			audioBitrate := config.AudioBitrate
+			if audioBitrate == "" {
+				audioBitrate = defaultAudioBitrate(channels)
+			}
+			// WebM only accepts Opus/Vorbis audio
+			audioCodec := "aac"
+			if strings.ToLower(filepath.Ext(outputPath)) == ".webm" {
+				audioCodec = "libopus"
+			}
+			kwargs["c:a"] = audioCodec
+			kwargs["b:a"] = audioBitrate
+			delete(kwargs, "map") // Remove mapping that might cause issues
+
+			err = runFFmpeg(ctx, ffmpeg.OutputContext(ctx, append([]*ffmpeg.Stream{output, input.Audio()}, subtitles...), encodePath, kwargs).OverWriteOutput(), "Encoding "+inputPath)
+			if err != nil {
+				return "", "", fmt.Errorf("failed to process video even with audio re-encoding: %v", err)
+			}
+			audioHandling = describeAudioEncoding(audioCodec, audioBitrate, channels)
+			logInfo("Successfully processed %s with audio re-encoding", inputPath)
+		} else if hasAudio && config.AudioChannels == "copy" && ctx.Err() == nil {
+			return "", "", fmt.Errorf("failed to process video with its audio copied, which --audio-channels copy keeps from being re-encoded: %v", err)
+		} else {
+			return "", "", fmt.Errorf("failed to process video: %v", err)
+		}
+	}
+
+	return audioHandling, subtitleNote, nil
 }
 
-// hasAudioStream checks if the video file contains audio streams
-func hasAudioStream(inputPath string) bool {
-	probe, err := ffmpeg.Probe(inputPath)
+// runFFmpeg runs an ffmpeg command, retrying with backoff (-retries) when its stderr shows a
+// transient I/O failure such as a busy network mount. The stream must be built with ctx
+// (ffmpeg.OutputContext) so ffmpeg is killed when ctx is done.
+func runFFmpeg(ctx context.Context, stream *ffmpeg.Stream, what string) error {
+	return withRetry(what, func() error {
+		var stderr bytes.Buffer
+		err := stream.WithErrorOutput(&stderr).Run()
+		if ctxErr := contextError(ctx); err != nil && ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil && isTransientFFmpegOutput(stderr.String()) {
+			return &transientError{err}
+		}
+		return err
+	})
+}
+
+// applyVideoThreads bounds the encoder to -video-threads threads, so videos encoded in
+// parallel don't each start one thread per core
+func applyVideoThreads(kwargs ffmpeg.KwArgs) {
+	if config.VideoThreads <= 0 {
+		return
+	}
+	kwargs["threads"] = strconv.Itoa(config.VideoThreads)
+	// libx265 sizes its own thread pool and ignores -threads, so route it through x265-params
+	if config.VideoCodec == "libx265" {
+		pools := fmt.Sprintf("pools=%d", config.VideoThreads)
+		if existing, ok := kwargs["x265-params"].(string); ok && existing != "" {
+			pools = existing + ":" + pools
+		}
+		kwargs["x265-params"] = pools
+	}
+}
+
+// videoOutputPath adjusts the output extension to a container compatible with the codec
+func videoOutputPath(outputPath string) string {
+	ext := strings.ToLower(filepath.Ext(outputPath))
+	base := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+
+	switch config.VideoCodec {
+	case "libvpx-vp9":
+		return base + ".webm"
+	case "libaom-av1":
+		// AV1 is supported in both mkv and mp4, anything else goes to mkv
+		if ext != ".mp4" && ext != ".mkv" {
+			return base + ".mkv"
+		}
+	default:
+		if legacyVideoContainers[ext] {
+			return base + ".mp4"
+		}
+	}
+	return outputPath
+}
+
+// hwAccelCodec returns the ffmpeg hardware encoder matching the software codec and accel type
+func hwAccelCodec(codec, accel string) (string, error) {
+	switch accel {
+	case "videotoolbox", "nvenc", "qsv":
+	default:
+		return "", fmt.Errorf("unsupported hardware acceleration: %s", accel)
+	}
+
+	switch codec {
+	case "libx265", "hevc":
+		return "hevc_" + accel, nil
+	case "libx264", "h264":
+		return "h264_" + accel, nil
+	default:
+		return "", fmt.Errorf("hardware acceleration %s does not support codec %s", accel, codec)
+	}
+}
+
+// checkHWAccelAvailable verifies that ffmpeg was built with the requested hardware encoder
+func checkHWAccelAvailable() error {
+	encoder, err := hwAccelCodec(config.VideoCodec, config.VideoHWAccel)
 	if err != nil {
-		return false // Assume no audio if probe fails
+		return err
 	}
-	
-	// Check if probe result contains audio stream information
-	// This is a simplified check - in practice you'd parse the JSON output
-	return strings.Contains(probe, "audio") || strings.Contains(probe, "Audio")
+
+	out, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list ffmpeg encoders: %v", err)
+	}
+
+	// Each encoder line looks like " V....D hevc_nvenc  NVIDIA NVENC hevc encoder"
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == encoder {
+			return nil
+		}
+	}
+	return fmt.Errorf("hardware encoder %s is not available in this ffmpeg build (-video-hwaccel %s)", encoder, config.VideoHWAccel)
+}
+
+// applyHWAccelArgs rewrites software encoder kwargs for the configured hardware encoder
+func applyHWAccelArgs(kwargs ffmpeg.KwArgs, isHDR bool) error {
+	encoder, err := hwAccelCodec(config.VideoCodec, config.VideoHWAccel)
+	if err != nil {
+		return err
+	}
+	kwargs["c:v"] = encoder
+
+	// x265-specific tuning does not apply to hardware encoders
+	delete(kwargs, "x265-params")
+
+	// Hardware encoders don't take CRF, map it onto their own quality parameter
+	if _, hasCRF := kwargs["crf"]; hasCRF {
+		delete(kwargs, "crf")
+		switch config.VideoHWAccel {
+		case "videotoolbox":
+			// VideoToolbox quality runs 1-100 with higher being better
+			quality := 100 - config.VideoCRF*100/51
+			if quality < 1 {
+				quality = 1
+			}
+			kwargs["q:v"] = fmt.Sprintf("%d", quality)
+		case "nvenc":
+			kwargs["cq"] = fmt.Sprintf("%d", config.VideoCRF)
+		case "qsv":
+			kwargs["global_quality"] = fmt.Sprintf("%d", config.VideoCRF)
+		}
+	}
+
+	// VideoToolbox has no preset or level controls
+	if config.VideoHWAccel == "videotoolbox" {
+		delete(kwargs, "preset")
+		delete(kwargs, "level")
+	}
+
+	// Hardware encoders take 10-bit input as p010le rather than yuv420p10le
+	if isHDR {
+		kwargs["pix_fmt"] = "p010le"
+	}
+	return nil
+}
+
+// applyTwoPassArgs sets the pass number and stats log location on the encoder kwargs
+func applyTwoPassArgs(kwargs ffmpeg.KwArgs, pass int, passLogFile string) {
+	// libx265 ignores ffmpeg's generic -pass option, so route it through x265-params
+	if config.VideoCodec == "libx265" {
+		x265Params := fmt.Sprintf("pass=%d:stats=%s", pass, passLogFile)
+		if existing, ok := kwargs["x265-params"].(string); ok && existing != "" {
+			x265Params = existing + ":" + x265Params
+		}
+		kwargs["x265-params"] = x265Params
+		return
+	}
+
+	kwargs["pass"] = fmt.Sprintf("%d", pass)
+	kwargs["passlogfile"] = passLogFile
+}
+
+// videoPosterPath returns the poster frame path stored next to a video
+func videoPosterPath(videoPath string) string {
+	return strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + "_poster.jpg"
+}
+
+// parseTimestamp parses a timestamp given as seconds ("12.5") or [HH:]MM:SS[.ms]
+func parseTimestamp(value string) (float64, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp: %s", value)
+	}
+
+	seconds := 0.0
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid timestamp: %s", value)
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, nil
+}
+
+// extractVideoPoster saves a single JPEG frame from the video as its poster, timed from the
+// video's already parsed probe
+func extractVideoPoster(ctx context.Context, videoPath, posterPath string, probe *media.Probe) error {
+	duration, err := probe.Duration()
+	if err != nil {
+		return err
+	}
+
+	// Default to 10% into the video, which usually skips black intro frames
+	at := duration * 0.1
+	if config.ThumbnailAt != "" {
+		at, err = parseTimestamp(config.ThumbnailAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Clamp for very short videos where the requested timestamp is past the end
+	if at >= duration {
+		at = math.Max(0, duration-0.1)
+	}
+
+	input := ffmpeg.Input(videoPath, ffmpeg.KwArgs{"ss": fmt.Sprintf("%.3f", at)})
+	return ffmpeg.OutputContext(ctx, []*ffmpeg.Stream{input}, posterPath, ffmpeg.KwArgs{"frames:v": 1, "q:v": 3}).
+		OverWriteOutput().Run()
+}
+
+// applyAudioChannels downmixes surround to stereo for --audio-channels stereo and returns the
+// channel count of the re-encoded audio; keep leaves the source's channels (0 when unknown)
+func applyAudioChannels(kwargs ffmpeg.KwArgs, sourceChannels int) int {
+	if config.AudioChannels == "stereo" && sourceChannels > 2 {
+		kwargs["ac"] = 2
+		return 2
+	}
+	return sourceChannels
 }
 
-// getVideoInfo gets basic information about a video file
-func getVideoInfo(inputPath string) (map[string]interface{}, error) {
-	// This is a placeholder for video info extraction
-	// In a real implementation, you might use ffprobe or similar
-	return map[string]interface{}{
-		"format": filepath.Ext(inputPath),
-		"has_audio": hasAudioStream(inputPath),
-	}, nil
-}
\ No newline at end of file
+// defaultAudioBitrate is the re-encoding bitrate without --audio-bitrate: 128k up to stereo,
+// 64k per channel beyond, so 5.1 gets 384k
+func defaultAudioBitrate(channels int) string {
+	if channels <= 2 {
+		return "128k"
+	}
+	return fmt.Sprintf("%dk", 64*channels)
+}
+
+// describeAudioEncoding formats an audio codec, optional bitrate and channel layout for logs
+// and the report
+func describeAudioEncoding(codec, bitrate string, channels int) string {
+	description := codec
+	if bitrate != "" {
+		description += " " + bitrate
+	}
+	switch channels {
+	case 0:
+	case 1:
+		description += " mono"
+	case 2:
+		description += " stereo"
+	case 6:
+		description += " 5.1"
+	case 8:
+		description += " 7.1"
+	default:
+		description += fmt.Sprintf(" %dch", channels)
+	}
+	return description
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchPollInterval is how often files waiting to settle are checked
+const watchPollInterval = 500 * time.Millisecond
+
+// watchSettle is how long a new or changed file must keep its size and modification time
+// before it is processed (-watch-settle), so files still being copied aren't picked up
+var watchSettle time.Duration
+
+// watchChanges holds the inputs that appeared or changed while watching. processImages
+// replaces their outputs instead of skipping them as already processed.
+var watchChanges = make(map[string]bool)
+var watchChangesMutex sync.Mutex
+
+// pendingFile is a new or changed input waiting for its size to settle
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	changed time.Time // When size or modTime last changed
+}
+
+// inputWatcher follows the input tree for -watch. Watches are set up before the initial batch
+// so files arriving meanwhile aren't missed; they are processed once the batch is done.
+type inputWatcher struct {
+	watcher *fsnotify.Watcher
+	mu      sync.Mutex
+	watched map[string]bool        // Directories being watched
+	pending map[string]pendingFile // Files waiting to settle, by path
+}
+
+// validateWatch checks the settings of -watch
+func validateWatch() error {
+	switch {
+	case singleInput != "":
+		return fmt.Errorf("--watch needs an input directory, not a single file")
+	case config.OutputSameAsInput:
+		return fmt.Errorf("--watch cannot be combined with --in-place, whose outputs would be picked up as new inputs")
+	case config.FakeScan:
+		return fmt.Errorf("--watch cannot be combined with --fake-scan")
+	case config.Archive != "":
+		return fmt.Errorf("--watch cannot be combined with --archive, which is only complete once the run ends")
+	}
+	settle, err := time.ParseDuration(config.WatchSettle)
+	if err != nil || settle <= 0 {
+		return fmt.Errorf("--watch-settle must be a positive duration such as 2s")
+	}
+	watchSettle = settle
+	return nil
+}
+
+// watchChanged reports whether path appeared or changed while watching, forgetting it
+func watchChanged(path string) bool {
+	watchChangesMutex.Lock()
+	defer watchChangesMutex.Unlock()
+	changed := watchChanges[path]
+	delete(watchChanges, path)
+	return changed
+}
+
+// startWatching watches -inputdir and every directory the scan would process
+func startWatching() (*inputWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch %s: %v", config.InputDir, err)
+	}
+	w := &inputWatcher{
+		watcher: watcher,
+		watched: make(map[string]bool),
+		pending: make(map[string]pendingFile),
+	}
+	if err := w.addDirectories(false); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go w.collect()
+	return w, nil
+}
+
+// addDirectories watches directories that aren't watched yet. Directories appearing after the
+// start may have been moved in with their files, so with enqueue those files are queued too.
+func (w *inputWatcher) addDirectories(enqueue bool) error {
+	directories, err := scanDirectories(config.InputDir)
+	if err != nil {
+		return fmt.Errorf("failed to scan directories: %v", err)
+	}
+	directories = append(directories, config.InputDir)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, dir := range directories {
+		if w.watched[dir] {
+			continue
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			return fmt.Errorf("failed to watch %s: %v", dir, err)
+		}
+		w.watched[dir] = true
+		logDebug("Watching directory: %s", dir)
+		if !enqueue {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			logWarn("failed to read new directory %s: %v", dir, err)
+			continue
+		}
+		for _, entry := range entries {
+			w.enqueue(filepath.Join(dir, entry.Name()))
+		}
+	}
+	return nil
+}
+
+// collect turns filesystem events into pending files until the watcher is closed. Deleted
+// inputs only leave the queue: their outputs are kept.
+func (w *inputWatcher) collect() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.handleEvent(event)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			// On overflow events were lost; a rerun without -watch catches up
+			logWarn("watch error: %v", err)
+		}
+	}
+}
+
+func (w *inputWatcher) handleEvent(event fsnotify.Event) {
+	path := filepath.Clean(event.Name)
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		w.mu.Lock()
+		delete(w.pending, path)
+		delete(w.watched, path)
+		w.mu.Unlock()
+		logDebug("Input removed, keeping its output: %s", path)
+		return
+	}
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if info.IsDir() {
+		if event.Has(fsnotify.Create) {
+			if err := w.addDirectories(true); err != nil {
+				logWarn("%v", err)
+			}
+		}
+		return
+	}
+	w.mu.Lock()
+	w.enqueue(path)
+	w.mu.Unlock()
+}
+
+// enqueue adds a file to the pending files, or restarts its settle time; w.mu must be held
+func (w *inputWatcher) enqueue(path string) {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, "._") || hasOutputSuffix(name) || name == runLockName || !shouldProcessExtension(path) {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	w.pending[path] = pendingFile{size: info.Size(), modTime: info.ModTime(), changed: time.Now()}
+}
+
+// settledDirectories returns the directories whose pending files have all settled, and
+// takes those files off the queue. A directory with a file still being written waits for it.
+func (w *inputWatcher) settledDirectories() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	busy := make(map[string]bool)
+	settled := make(map[string][]string)
+	for path, file := range w.pending {
+		info, err := os.Stat(path)
+		if err != nil {
+			delete(w.pending, path) // Gone before it settled
+			continue
+		}
+		dir := filepath.Dir(path)
+		if info.Size() != file.size || !info.ModTime().Equal(file.modTime) {
+			w.pending[path] = pendingFile{size: info.Size(), modTime: info.ModTime(), changed: now}
+			busy[dir] = true
+		} else if now.Sub(file.changed) < watchSettle {
+			busy[dir] = true
+		} else {
+			settled[dir] = append(settled[dir], path)
+		}
+	}
+
+	var directories []string
+	for dir, paths := range settled {
+		if busy[dir] {
+			continue
+		}
+		watchChangesMutex.Lock()
+		for _, path := range paths {
+			delete(w.pending, path)
+			watchChanges[path] = true
+		}
+		watchChangesMutex.Unlock()
+		directories = append(directories, dir)
+	}
+	sort.Strings(directories)
+	return directories
+}
+
+// run processes settled files one directory at a time until SIGINT or SIGTERM, keeping
+// progress.json and the reports up to date as the batch loop does
+func (w *inputWatcher) run(ctx context.Context, tracker *ProgressTracker, progressFile string) {
+	defer w.watcher.Close()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	logInfo("Watching %s for new files (Ctrl-C to stop)", config.InputDir)
+	for {
+		select {
+		case <-stop:
+			logInfo("Stopped watching")
+			return
+		case <-ticker.C:
+			for _, dir := range w.settledDirectories() {
+				processWatchedDirectory(ctx, tracker, progressFile, dir)
+			}
+		}
+	}
+}
+
+// processWatchedDirectory processes the new and changed files of one directory. Files whose
+// outputs exist and that didn't change are skipped as on a rerun.
+func processWatchedDirectory(ctx context.Context, tracker *ProgressTracker, progressFile string, dirPath string) {
+	atomic.StoreInt64(&filesTotal, countAllFilesToProcess([]string{dirPath}))
+	atomic.StoreInt64(&filesDone, 0)
+	logInfo("Processing new files in directory: %s", dirPath)
+
+	if err := processImages(ctx, dirPath, 0); err != nil {
+		recordFileError(dirPath, dirPath, err)
+		return
+	}
+
+	// Directories created while watching join the progress file
+	known := false
+	for _, dir := range tracker.Directories {
+		if dir.Path == dirPath {
+			known = true
+			break
+		}
+	}
+	if !known {
+		tracker.Directories = append(tracker.Directories, DirectoryProgress{Path: dirPath})
+	}
+	if shouldMarkCompleted(dirPath) {
+		tracker.markDirectoryCompleted(dirPath)
+		if err := tracker.saveProgress(progressFile); err != nil {
+			logWarn("failed to save progress: %v", err)
+		}
+	}
+
+	if config.Extensions == "" {
+		for dir, dirStats := range stats.DirectoryStats {
+			if len(dirStats.Files) > 0 {
+				if err := generateDirectoryHTMLReport(dir, dirStats); err != nil {
+					logWarn("failed to generate HTML report for directory '%s': %v", dir, err)
+				}
+			}
+		}
+	}
+	collectReportFiles()
+	stats = ProcessStats{DirectoryStats: make(map[string]*DirectoryStats)}
+
+	storeDirectory(dirPath)
+	logInfo("Completed directory: %s", dirPath)
+}